@@ -0,0 +1,126 @@
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditRecord is one line of the audit log, describing a tart operation the
+// driver ran. Arguments and errors are redacted. AllocID and TaskID name the
+// task whose VM the operation ran on, and are empty for operations not run
+// for a task, such as pre-pulls.
+type auditRecord struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	VMName    string    `json:"vm_name"`
+	AllocID   string    `json:"alloc_id,omitempty"`
+	TaskID    string    `json:"task_id,omitempty"`
+	Args      []string  `json:"args,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// auditTask identifies the task a VM was set up for.
+type auditTask struct {
+	AllocID string
+	TaskID  string
+}
+
+// vmTasks records the task each VM was set up for, keyed by VM name, so the
+// audit records of every later operation on the VM name the task. Entries are
+// registered when a VM is set up and dropped once it is deleted.
+type vmTasks struct {
+	lock  sync.RWMutex
+	tasks map[string]auditTask
+}
+
+func newVMTasks() *vmTasks {
+	return &vmTasks{tasks: map[string]auditTask{}}
+}
+
+func (t *vmTasks) set(vmName string, task auditTask) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.tasks[vmName] = task
+}
+
+func (t *vmTasks) get(vmName string) auditTask {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.tasks[vmName]
+}
+
+func (t *vmTasks) remove(vmName string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.tasks, vmName)
+}
+
+// auditLog appends JSON lines records to a file shared by all tasks. Each
+// record names the allocation and task it belongs to, so one task's records
+// can be told apart from the others'.
+type auditLog struct {
+	lock sync.Mutex
+	path string
+	now  func() time.Time
+}
+
+// newAuditLog returns an audit log appending to path, or nil when path is
+// empty.
+func newAuditLog(path string) *auditLog {
+	if path == "" {
+		return nil
+	}
+	return &auditLog{path: path, now: time.Now}
+}
+
+// record appends a record of operation on task's VM vmName with args and its
+// outcome.
+func (a *auditLog) record(operation, vmName string, task auditTask, args []string, opErr error) error {
+	rec := auditRecord{
+		Time:      a.now().UTC(),
+		Operation: operation,
+		VMName:    vmName,
+		AllocID:   task.AllocID,
+		TaskID:    task.TaskID,
+	}
+	for _, arg := range redactArgs(args) {
+		rec.Args = append(rec.Args, redact(arg))
+	}
+	if opErr != nil {
+		rec.Error = redact(opErr.Error())
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log: %v", err)
+	}
+	return nil
+}
+
+// audit records a tart operation in the client's audit log, if any. A
+// failure to write the record is logged rather than failing the operation.
+func (c *TartClient) audit(operation, vmName string, args []string, err error) {
+	_, log := c.settings()
+	if log == nil {
+		return
+	}
+	if werr := log.record(operation, vmName, c.tasks.get(vmName), args, err); werr != nil {
+		c.logger.Warn("failed to record tart operation", "operation", operation, "name", vmName, "error", werr)
+	}
+}
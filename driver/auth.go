@@ -0,0 +1,61 @@
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// validateAuth checks that each registry credential is given either inline or
+// as a file in the task's secrets directory, not both.
+func validateAuth(auth Auth) error {
+	if auth == (Auth{}) {
+		return nil
+	}
+	for _, c := range []struct{ name, inline, file string }{
+		{"username", auth.Username, auth.UsernameFile},
+		{"password", auth.Password, auth.PasswordFile},
+	} {
+		switch {
+		case c.inline != "" && c.file != "":
+			return fmt.Errorf("auth %s and %s_file cannot both be set", c.name, c.name)
+		case c.inline == "" && c.file == "":
+			return fmt.Errorf("auth requires %s or %s_file", c.name, c.name)
+		case c.file != "" && !filepath.IsLocal(c.file):
+			return fmt.Errorf("auth %s_file must be a relative path within the task's secrets directory, got %q", c.name, c.file)
+		}
+	}
+	return nil
+}
+
+// resolveAuth returns auth with any credentials given as files read from the
+// task's secrets directory. The files are read at setup, so credentials
+// rendered by a template are picked up without being stored in the job.
+func resolveAuth(cfg *drivers.TaskConfig, auth Auth) (Auth, error) {
+	read := func(name string) (string, error) {
+		data, err := os.ReadFile(filepath.Join(cfg.TaskDir().SecretsDir, name))
+		if err != nil {
+			return "", fmt.Errorf("failed to read registry credentials: %v", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	var err error
+	if auth.UsernameFile != "" {
+		if auth.Username, err = read(auth.UsernameFile); err != nil {
+			return Auth{}, err
+		}
+	}
+	if auth.PasswordFile != "" {
+		if auth.Password, err = read(auth.PasswordFile); err != nil {
+			return Auth{}, err
+		}
+	}
+	if !auth.IsValid() {
+		return Auth{}, fmt.Errorf("registry credentials files must not be empty")
+	}
+	return auth, nil
+}
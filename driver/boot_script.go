@@ -0,0 +1,81 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// bootScriptFile is the file written to the task's secrets directory holding
+// the task's boot_script.
+const bootScriptFile = "boot.sh"
+
+// validateBootScript checks that boot_script, when set, has something to run.
+func validateBootScript(script *string) error {
+	if script != nil && strings.TrimSpace(*script) == "" {
+		return fmt.Errorf("boot_script must not be empty")
+	}
+	return nil
+}
+
+// writeBootScript writes the task's boot script into its secrets directory,
+// returning the file's path.
+func writeBootScript(cfg *drivers.TaskConfig, script string) (string, error) {
+	path := filepath.Join(cfg.TaskDir().SecretsDir, bootScriptFile)
+	if err := os.WriteFile(path, []byte(script), 0o600); err != nil {
+		return "", fmt.Errorf("failed to write boot script: %v", err)
+	}
+	return path, nil
+}
+
+// runBootScript feeds the task's boot script to bash in the guest over SSH,
+// appending its output to the task's logs, and ends the task with the
+// script's exit code once it finishes.
+func (d *Driver) runBootScript(ctx context.Context, h *taskHandle, vmConfig VMConfig) {
+	code, err := d.execBootScript(ctx, h.bootScriptPath, vmConfig)
+	if ctx.Err() != nil {
+		// The task was stopped while the script ran
+		return
+	}
+
+	result := &drivers.ExitResult{ExitCode: code}
+	if err != nil {
+		result.Err = fmt.Errorf("failed to run boot script: %v", err)
+	}
+	d.logger.Info("boot script finished, stopping task", "exit_code", code, "error", err)
+	d.endExitedVM(h, d.generateVMName(vmConfig.NomadConfig.AllocID), result)
+}
+
+// execBootScript runs the boot script at path in the guest, returning its exit
+// code.
+func (d *Driver) execBootScript(ctx context.Context, path string, vmConfig VMConfig) (int, error) {
+	script, err := os.Open(path)
+	if err != nil {
+		return -1, err
+	}
+	defer script.Close()
+
+	cfg := vmConfig.NomadConfig
+	stdout, err := os.OpenFile(cfg.StdoutPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return -1, fmt.Errorf("failed to open stdout file: %v", err)
+	}
+	defer stdout.Close()
+
+	stderr, err := os.OpenFile(cfg.StderrPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return -1, fmt.Errorf("failed to open stderr file: %v", err)
+	}
+	defer stderr.Close()
+
+	return d.client.Exec(ctx, vmConfig, ExecOptions{
+		Command: []string{"bash", "-s"},
+		Stdin:   script,
+		Stdout:  stdout,
+		Stderr:  stderr,
+	})
+}
@@ -0,0 +1,84 @@
+package driver
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+func TestValidateBootScript(t *testing.T) {
+	script := "set -e\nmake test\n"
+	if err := validateBootScript(&script); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := validateBootScript(nil); err != nil {
+		t.Fatalf("unexpected error when unset: %v", err)
+	}
+	blank := " \n\t"
+	if err := validateBootScript(&blank); err == nil {
+		t.Fatalf("expected an error for a blank script")
+	}
+}
+
+func TestWaitForReady_RunsBootScript(t *testing.T) {
+	var delivered string
+	client := &fakeClient{
+		execFn: func(ctx context.Context, config VMConfig, opts ExecOptions) (int, error) {
+			if !slices.Equal(opts.Command, []string{"bash", "-s"}) {
+				return 0, nil
+			}
+			data, err := io.ReadAll(opts.Stdin)
+			if err != nil {
+				return -1, err
+			}
+			delivered = string(data)
+			io.WriteString(opts.Stdout, "running tests\n")
+			io.WriteString(opts.Stderr, "1 failure\n")
+			return 3, nil
+		},
+	}
+	d := newTestDriver(t, client)
+
+	dir := t.TempDir()
+	cfg := &drivers.TaskConfig{
+		Name:       "vm",
+		AllocID:    "alloc-1",
+		AllocDir:   dir,
+		StdoutPath: filepath.Join(dir, "stdout"),
+		StderrPath: filepath.Join(dir, "stderr"),
+	}
+	if err := os.MkdirAll(cfg.TaskDir().SecretsDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	script := "set -e\ncd ~/src\nmake test\n"
+	path, err := writeBootScript(cfg, script)
+	if err != nil {
+		t.Fatalf("writeBootScript returned error: %v", err)
+	}
+
+	fe := newFakeExecutor()
+	h := &taskHandle{taskConfig: cfg, exec: fe, bootScriptPath: path, startedAt: time.Now()}
+	d.waitForReady(context.Background(), h, VMConfig{NomadConfig: cfg})
+
+	if delivered != script {
+		t.Fatalf("expected the script on bash's stdin, got %q", delivered)
+	}
+	if stdout, _ := os.ReadFile(cfg.StdoutPath); string(stdout) != "running tests\n" {
+		t.Fatalf("expected the script's output in the task's stdout, got %q", stdout)
+	}
+	if stderr, _ := os.ReadFile(cfg.StderrPath); string(stderr) != "1 failure\n" {
+		t.Fatalf("expected the script's errors in the task's stderr, got %q", stderr)
+	}
+	if h.vmExitResult == nil || h.vmExitResult.ExitCode != 3 {
+		t.Fatalf("expected the task to end with the script's exit code, got %+v", h.vmExitResult)
+	}
+	if len(fe.shutdowns) != 1 {
+		t.Fatalf("expected the executor to be shut down once, got %v", fe.shutdowns)
+	}
+}
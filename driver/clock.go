@@ -0,0 +1,65 @@
+package driver
+
+import (
+	"context"
+	"time"
+)
+
+// clock is the source of time for the driver's polling loops, so tests can
+// advance time deterministically instead of waiting on real timers.
+type clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// NewTicker returns a ticker firing every d.
+	NewTicker(d time.Duration) ticker
+
+	// Sleep blocks for d, returning early with the context's error when ctx
+	// is cancelled.
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// ticker matches the parts of time.Ticker the driver uses.
+type ticker interface {
+	// C returns the channel ticks are delivered on.
+	C() <-chan time.Time
+
+	// Reset stops the ticker and restarts it with period d, so the next tick
+	// arrives d from now.
+	Reset(d time.Duration)
+
+	// Stop turns the ticker off.
+	Stop()
+}
+
+// realClock is the clock backed by the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) ticker {
+	return realTicker{ticker: time.NewTicker(d)}
+}
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// realTicker adapts a time.Ticker to the ticker interface.
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time { return t.ticker.C }
+
+func (t realTicker) Reset(d time.Duration) { t.ticker.Reset(d) }
+
+func (t realTicker) Stop() { t.ticker.Stop() }
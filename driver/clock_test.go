@@ -0,0 +1,209 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/drivers/shared/executor"
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// fakeClock is a clock whose time only moves when advanced. Every time a
+// ticker is armed, by NewTicker or Reset, its period is sent on armed so tests
+// can wait for a loop to block before advancing past its next tick.
+type fakeClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+	armed   chan time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1000, 0), armed: make(chan time.Duration, 100)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) ticker {
+	c.lock.Lock()
+	t := &fakeTicker{clock: c, c: make(chan time.Time, 1), period: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+	c.lock.Unlock()
+
+	c.armed <- d
+	return t
+}
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	t := c.NewTicker(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C():
+		return nil
+	}
+}
+
+// Advance moves the clock forward by d, firing every ticker that comes due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.now = c.now.Add(d)
+	for _, t := range c.tickers {
+		if t.stopped || t.next.After(c.now) {
+			continue
+		}
+		select {
+		case t.c <- c.now:
+		default:
+		}
+		for !t.next.After(c.now) {
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
+
+// waitArmed waits for a ticker to be armed and returns its period.
+func (c *fakeClock) waitArmed(t *testing.T) time.Duration {
+	t.Helper()
+	select {
+	case d := <-c.armed:
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for a ticker to be armed")
+		return 0
+	}
+}
+
+type fakeTicker struct {
+	clock   *fakeClock
+	c       chan time.Time
+	period  time.Duration
+	next    time.Time
+	stopped bool
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.c }
+
+func (t *fakeTicker) Reset(d time.Duration) {
+	t.clock.lock.Lock()
+	t.period = d
+	t.next = t.clock.now.Add(d)
+	t.stopped = false
+	select {
+	case <-t.c:
+	default:
+	}
+	t.clock.lock.Unlock()
+
+	t.clock.armed <- d
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+	t.stopped = true
+}
+
+func TestRealClock_SleepReturnsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := (realClock{}).Sleep(ctx, time.Hour); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestMonitorVM_FakeClockPollCycles(t *testing.T) {
+	statuses := make(chan error, 1)
+	client := &fakeClient{
+		statusFn: func(ctx context.Context, vmName string) (VMState, error) {
+			if err := <-statuses; err != nil {
+				return "", err
+			}
+			return VMStateRunning, nil
+		},
+	}
+	d := newTestDriver(t, client)
+	clock := newFakeClock()
+	d.clock = clock
+	d.waitPollInterval = 10 * time.Second
+	d.waitFailureThreshold = 2
+
+	exec := newFakeExecutor()
+	h := &taskHandle{exec: exec, taskConfig: &drivers.TaskConfig{ID: "task-1"}, doneCh: make(chan struct{})}
+	go h.run()
+	go d.monitorVM(context.Background(), h, "nomad-alloc-1")
+
+	if got := clock.waitArmed(t); got != 10*time.Second {
+		t.Fatalf("expected the first poll after 10s, got %s", got)
+	}
+
+	// A running VM is polled again after the full interval.
+	statuses <- nil
+	clock.Advance(10 * time.Second)
+	if got := clock.waitArmed(t); got != 10*time.Second {
+		t.Fatalf("expected the next poll after 10s, got %s", got)
+	}
+
+	// A failed check is re-checked after a fifth of the interval.
+	statuses <- errors.New("tart list failed")
+	clock.Advance(10 * time.Second)
+	if got := clock.waitArmed(t); got != 2*time.Second {
+		t.Fatalf("expected a re-check after 2s, got %s", got)
+	}
+
+	// The second consecutive failure reaches the threshold and ends the task.
+	statuses <- errors.New("tart list failed")
+	clock.Advance(2 * time.Second)
+	if !waitForShutdown(exec, 5*time.Second) {
+		t.Fatalf("expected consecutive errors to end the task")
+	}
+	exec.exitCh <- &executor.ProcessState{ExitCode: 137, Time: time.Now()}
+	<-h.doneCh
+
+	if res := h.ExitResult(); res == nil || res.ExitCode != 1 {
+		t.Fatalf("expected an error exit result, got %#v", res)
+	}
+}
+
+func TestHandleFingerprint_FakeClockPeriods(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{})
+	clock := newFakeClock()
+	d.clock = clock
+	d.fingerprintPeriod = time.Minute
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := d.Fingerprint(ctx)
+	if err != nil {
+		t.Fatalf("Fingerprint returned error: %v", err)
+	}
+
+	// The initial fingerprint is sent without waiting for the period.
+	<-ch
+	clock.waitArmed(t)
+
+	clock.Advance(59 * time.Second)
+	select {
+	case <-ch:
+		t.Fatalf("fingerprint sent before the period elapsed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("fingerprint not sent once the period elapsed")
+	}
+}
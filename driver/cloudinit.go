@@ -0,0 +1,97 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// cloudInitSeedFile is the ISO written to the task's local directory and
+	// attached to the VM when network overrides need to reach the guest.
+	cloudInitSeedFile = "cloud-init-seed.iso"
+
+	// cloudInitVolumeName is the volume label cloud-init's NoCloud
+	// datasource looks for.
+	cloudInitVolumeName = "cidata"
+)
+
+// needsCloudInitSeed reports whether the network config carries overrides
+// that tart has no run flags for and must be delivered through cloud-init.
+func needsCloudInitSeed(cfg *NetworkConfig) bool {
+	return cfg != nil && (len(cfg.DNSServers) > 0 || cfg.Gateway != "")
+}
+
+// buildCloudInitNetworkConfig renders a cloud-init network config (version 2)
+// that keeps DHCP for addressing but replaces the DNS servers and default
+// route it hands out with the configured ones.
+func buildCloudInitNetworkConfig(cfg *NetworkConfig) string {
+	var b strings.Builder
+	b.WriteString("version: 2\n")
+	b.WriteString("ethernets:\n")
+	b.WriteString("  primary:\n")
+	b.WriteString("    match:\n")
+	b.WriteString("      name: \"e*\"\n")
+	b.WriteString("    dhcp4: true\n")
+
+	if len(cfg.DNSServers) > 0 || cfg.Gateway != "" {
+		b.WriteString("    dhcp4-overrides:\n")
+		if len(cfg.DNSServers) > 0 {
+			b.WriteString("      use-dns: false\n")
+		}
+		if cfg.Gateway != "" {
+			b.WriteString("      use-routes: false\n")
+		}
+	}
+
+	if len(cfg.DNSServers) > 0 {
+		b.WriteString("    nameservers:\n")
+		b.WriteString("      addresses:\n")
+		for _, server := range cfg.DNSServers {
+			fmt.Fprintf(&b, "        - %s\n", strings.TrimSpace(server))
+		}
+	}
+
+	if cfg.Gateway != "" {
+		b.WriteString("    routes:\n")
+		b.WriteString("      - to: default\n")
+		fmt.Fprintf(&b, "        via: %s\n", strings.TrimSpace(cfg.Gateway))
+	}
+
+	return b.String()
+}
+
+// writeCloudInitSeed writes a NoCloud seed carrying the network overrides into
+// dir and packs it into an ISO with hdiutil, returning the ISO's path.
+func writeCloudInitSeed(ctx context.Context, dir, instanceID string, cfg *NetworkConfig) (string, error) {
+	seedDir := filepath.Join(dir, cloudInitVolumeName)
+	if err := os.MkdirAll(seedDir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create cloud-init seed directory: %v", err)
+	}
+
+	files := map[string]string{
+		"meta-data":      fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n", instanceID, instanceID),
+		"user-data":      "#cloud-config\n",
+		"network-config": buildCloudInitNetworkConfig(cfg),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(seedDir, name), []byte(content), 0o644); err != nil {
+			return "", fmt.Errorf("failed to write cloud-init %s: %v", name, err)
+		}
+	}
+
+	isoPath := filepath.Join(dir, cloudInitSeedFile)
+	if err := os.Remove(isoPath); err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to remove stale cloud-init seed: %v", err)
+	}
+
+	cmd := execCommandContext(ctx, "hdiutil", "makehybrid", "-iso", "-joliet",
+		"-default-volume-name", cloudInitVolumeName, "-o", isoPath, seedDir)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to build cloud-init seed: %v (output: %s)", err, out)
+	}
+
+	return isoPath, nil
+}
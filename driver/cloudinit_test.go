@@ -0,0 +1,116 @@
+package driver
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+func TestBuildCloudInitNetworkConfig_DNSServers(t *testing.T) {
+	cfg := &NetworkConfig{DNSServers: []string{"10.0.0.53", " 10.0.1.53 "}}
+
+	want := `version: 2
+ethernets:
+  primary:
+    match:
+      name: "e*"
+    dhcp4: true
+    dhcp4-overrides:
+      use-dns: false
+    nameservers:
+      addresses:
+        - 10.0.0.53
+        - 10.0.1.53
+`
+	if got := buildCloudInitNetworkConfig(cfg); got != want {
+		t.Fatalf("unexpected network config:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestBuildCloudInitNetworkConfig_Gateway(t *testing.T) {
+	got := buildCloudInitNetworkConfig(&NetworkConfig{Gateway: "192.168.64.254"})
+	for _, want := range []string{"use-routes: false", "- to: default", "via: 192.168.64.254"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected network config to contain %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "nameservers") {
+		t.Fatalf("did not expect nameservers without dns_servers:\n%s", got)
+	}
+}
+
+func TestWriteCloudInitSeed(t *testing.T) {
+	var gotArgs []string
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		gotArgs = append([]string{name}, args...)
+		return exec.CommandContext(ctx, "true")
+	}
+	defer func() { execCommandContext = orig }()
+
+	dir := t.TempDir()
+	cfg := &NetworkConfig{DNSServers: []string{"10.0.0.53"}}
+	iso, err := writeCloudInitSeed(context.Background(), dir, "nomad-alloc", cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if iso != filepath.Join(dir, cloudInitSeedFile) {
+		t.Fatalf("unexpected seed path: %s", iso)
+	}
+
+	seedDir := filepath.Join(dir, cloudInitVolumeName)
+	data, err := os.ReadFile(filepath.Join(seedDir, "network-config"))
+	if err != nil {
+		t.Fatalf("reading network-config: %v", err)
+	}
+	if !strings.Contains(string(data), "- 10.0.0.53") {
+		t.Fatalf("network-config missing DNS server:\n%s", data)
+	}
+	if _, err := os.Stat(filepath.Join(seedDir, "meta-data")); err != nil {
+		t.Fatalf("expected meta-data to be written: %v", err)
+	}
+
+	want := []string{"hdiutil", "makehybrid", "-iso", "-joliet", "-default-volume-name", "cidata", "-o", iso, seedDir}
+	if !slices.Equal(gotArgs, want) {
+		t.Fatalf("got %v, want %v", gotArgs, want)
+	}
+}
+
+func TestBuildStartArgs_AttachesCloudInitSeedForDNS(t *testing.T) {
+	c := NewTartClient(testLogger(t))
+	cfg := VMConfig{
+		TaskConfig: TaskConfig{Network: &NetworkConfig{DNSServers: []string{"10.0.0.53"}}},
+		NomadConfig: &drivers.TaskConfig{
+			AllocID:  "alloc-1",
+			Name:     "vm",
+			AllocDir: "/allocs/alloc-1",
+		},
+	}
+
+	args, err := c.BuildStartArgs(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := "--disk=" + filepath.Join(cfg.NomadConfig.TaskDir().LocalDir, cloudInitSeedFile) + ":ro"
+	if !slices.Contains(args, want) {
+		t.Fatalf("expected %q in args: %v", want, args)
+	}
+
+	cfg.TaskConfig.Network = nil
+	args, err = c.BuildStartArgs(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--disk=") {
+			t.Fatalf("did not expect a seed disk without overrides: %v", args)
+		}
+	}
+}
@@ -1,11 +1,114 @@
 package driver
 
-import "github.com/hashicorp/nomad/plugins/shared/hclspec"
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+	"github.com/hashicorp/nomad/plugins/shared/hclspec"
+)
 
 // Config is the driver configuration set by the SetConfig RPC call
 type Config struct {
 	// Enabled is set to true to enable the tart driver
 	Enabled bool `codec:"enabled"`
+
+	// MaxConcurrentSetups bounds how many VM setups (image pulls and clones)
+	// may run at once. Zero means unlimited.
+	MaxConcurrentSetups int `codec:"max_concurrent_setups"`
+
+	// FingerprintInterval is how often the driver fingerprints the host, as a
+	// duration string (e.g. "30s"). Must be at least five seconds.
+	FingerprintInterval string `codec:"fingerprint_interval"`
+
+	// WaitPollInterval is how often the VM's status is polled while a task
+	// runs, as a duration string (e.g. "5s"). Must be at least one second.
+	WaitPollInterval string `codec:"wait_poll_interval"`
+
+	// WaitFailureThreshold is how many consecutive failed or non-running
+	// status observations are required before a VM is considered gone.
+	WaitFailureThreshold int `codec:"wait_failure_threshold"`
+
+	// AuditLog is an absolute path to append a JSON lines record of every
+	// tart operation run for tasks to.
+	AuditLog string `codec:"audit_log"`
+
+	// CommandTimeout bounds short tart commands, such as list, delete or ip,
+	// as a duration string (e.g. "2m"). Image pulls are not bounded.
+	CommandTimeout string `codec:"command_timeout"`
+
+	// HealthFailureThreshold and HealthSuccessThreshold are how many
+	// consecutive fingerprints must fail, or succeed, before the driver is
+	// reported unhealthy, or healthy again.
+	HealthFailureThreshold int `codec:"health_failure_threshold"`
+	HealthSuccessThreshold int `codec:"health_success_threshold"`
+
+	// VMNamePrefix is prepended to the allocation ID to name each VM,
+	// letting agents that share a tart store keep their VMs apart.
+	VMNamePrefix string `codec:"vm_name_prefix"`
+
+	// ReplaceExistingVMs lets setup delete and re-clone a leftover VM with
+	// the target name when it was cloned from a different image.
+	ReplaceExistingVMs bool `codec:"replace_existing_vms"`
+
+	// ExecutorLogLevel is the log level of each task's executor process.
+	ExecutorLogLevel string `codec:"executor_log_level"`
+
+	// ExecutorLogDir overrides where executor logs are written. By default
+	// each task's executor logs to executor.out in its task directory.
+	ExecutorLogDir string `codec:"executor_log_dir"`
+
+	// TartHome is the tart home used for every task's VMs and images unless
+	// the task sets its own. Defaults to the agent's TART_HOME or ~/.tart.
+	TartHome string `codec:"tart_home"`
+
+	// EnvDenylist lists environment variable names, or globs such as
+	// "AWS_*", that are removed from the environment tart runs with.
+	EnvDenylist []string `codec:"env_denylist"`
+
+	// MaxImageStoreGB caps the size of the tart home. Over it, no slots are
+	// advertised and tasks needing an image pull are refused. Zero means
+	// unlimited.
+	MaxImageStoreGB int `codec:"max_image_store_gb"`
+
+	// PullConcurrency is how many image layers tart downloads in parallel
+	// when cloning a task's image, unless the task sets its own. Zero leaves
+	// tart's default.
+	PullConcurrency int `codec:"pull_concurrency"`
+
+	// RegistryMirrors maps registry hosts to mirrors that every image from
+	// them is pulled through instead, e.g. docker.io to an internal mirror.
+	RegistryMirrors map[string]string `codec:"registry_mirrors"`
+
+	// PrewarmImages are pulled into the tart cache in the background when the
+	// driver is configured, so the first task using them starts sooner.
+	PrewarmImages []string `codec:"prewarm_images"`
+
+	// ReservedSlots is how many of the host's VM slots are kept free for
+	// manual use and never advertised as available.
+	ReservedSlots int `codec:"reserved_slots"`
+
+	// PrestartHook is a host command run before each task's VM is set up.
+	PrestartHook *HostHookConfig `codec:"prestart_hook"`
+
+	// PoststopHook is a host command run once each task has stopped.
+	PoststopHook *HostHookConfig `codec:"poststop_hook"`
+
+	// VerboseInspect adds the VM's full tart list record to the attributes
+	// InspectTask reports, for debugging.
+	VerboseInspect bool `codec:"verbose_inspect"`
+}
+
+// HostHookConfig configures a command run on the host around a task's VM,
+// e.g. to create a bridge or mount a share the VM needs and tear it down
+// again.
+type HostHookConfig struct {
+	// Command is the program and its arguments.
+	Command []string `codec:"command"`
+
+	// Timeout bounds how long the command may run, as a duration string.
+	Timeout string `codec:"timeout"`
 }
 
 // TaskConfig is the driver configuration of a task within a job
@@ -19,19 +122,166 @@ type TaskConfig struct {
 	DiskSize int  `codec:"disk_size"`
 	Auth     Auth `codec:"auth"`
 
+	// DiskIOPSLimit is the most disk operations per second the VM should
+	// issue. Neither tart nor Virtualization.framework can throttle a VM's
+	// disk, so it is validated but not enforced.
+	DiskIOPSLimit int `codec:"disk_iops_limit"`
+
+	// AnonymousPull pulls the task's images without credentials, even when
+	// the agent's environment sets tart registry credentials.
+	AnonymousPull bool `codec:"anonymous_pull"`
+
 	// Network contains networking options for the VM
 	Network *NetworkConfig `codec:"network"`
 
 	// Root disk options on how to configure the VM
 	RootDisk *RootDiskOptions `codec:"root_disk"`
 
+	// ScratchDisk is an empty disk created for each run of the task and
+	// deleted once it is destroyed.
+	ScratchDisk *ScratchDiskConfig `codec:"scratch_disk"`
+
 	// Directories is a blocklist of host directories to mount into the VM
 	Directories []DirectoryMount `codec:"directory"`
+
+	// ShutdownExitCode is the exit code reported when the VM powers off
+	// cleanly and no exit marker was written by the guest.
+	ShutdownExitCode int `codec:"shutdown_exit_code"`
+
+	// ExitCodeMarker mounts the task's local directory into the VM so the
+	// guest can write its real exit code to an exit marker file.
+	ExitCodeMarker bool `codec:"exit_code_marker"`
+
+	// Hostname is set inside the guest once it is reachable over SSH,
+	// defaulting to the short allocation ID.
+	Hostname string `codec:"hostname"`
+
+	// InjectNomadEnv writes the task's NOMAD_* variables to a file in the
+	// secrets directory shared with the guest.
+	InjectNomadEnv bool `codec:"inject_nomad_env"`
+
+	// CPUAffinity lists the host cores the VM is meant to run on. macOS
+	// cannot pin threads, so it is validated and sets the vCPU count.
+	CPUAffinity []int `codec:"cpu_affinity"`
+
+	// TartHome isolates the task's VM and images in its own tart home,
+	// overriding the plugin's tart_home and the task user's tart home.
+	TartHome string `codec:"tart_home"`
+
+	// URLFromFile names a file, relative to the task directory, whose
+	// contents replace URL when the task starts.
+	URLFromFile string `codec:"url_from_file"`
+
+	// ImageFile is an absolute host path to an exported tart image archive,
+	// optionally gzip or zstd compressed, imported instead of cloning URL.
+	ImageFile string `codec:"image_file"`
+
+	// MountSecrets shares the task's secrets directory read-only with the
+	// guest. It defaults to true.
+	MountSecrets bool `codec:"mount_secrets"`
+
+	// ImageDigest is the expected sha256 digest of the image manifest. Setup
+	// fails when the image tart cloned from has a different digest.
+	ImageDigest string `codec:"image_digest"`
+
+	// Platform is the os/arch the image must be for, e.g. "linux/arm64".
+	// Setup fails when the cloned VM is for another platform.
+	Platform string `codec:"platform"`
+
+	// MemoryMin and MemoryMax, in MB, give the range the guest's memory may
+	// balloon within. The VM is sized to MemoryMax rather than the memory
+	// Nomad allocated, so memory the guest is not using can be oversubscribed.
+	MemoryMin int `codec:"memory_min"`
+	MemoryMax int `codec:"memory_max"`
+
+	// ExtraSetArgs are passed to tart set after the resource flags, to
+	// configure anything the driver does not model, e.g. a display size.
+	ExtraSetArgs []string `codec:"extra_set_args"`
+
+	// SkipResourceConfig leaves the image's CPU, memory and disk as they
+	// are, for images whose sealed configuration tart set would invalidate.
+	SkipResourceConfig bool `codec:"skip_resource_config"`
+
+	// RestartVMOnCrash relaunches tart, up to MaxVMRestarts times, when it
+	// exits unexpectedly, keeping the task running. A clean power-off from
+	// inside the guest still ends the task.
+	RestartVMOnCrash bool `codec:"restart_vm_on_crash"`
+	MaxVMRestarts    int  `codec:"max_vm_restarts"`
+
+	// BaseURL is an alias of URL naming the image the VM boots from when the
+	// task also attaches ExtraDisks.
+	BaseURL string `codec:"base_url"`
+
+	// ExtraDisks are image references whose cached disks are attached to the
+	// VM as additional read-only disks, in order, for the guest to mount.
+	ExtraDisks []string `codec:"extra_disks"`
+
+	// PullConcurrency is how many image layers tart downloads in parallel
+	// when cloning the image, overriding the plugin's pull_concurrency.
+	PullConcurrency int `codec:"pull_concurrency"`
+
+	// PullPolicy is when an image already in tart's cache is pulled again:
+	// "if-not-present" (the default), "always" or "if-older-than", which
+	// re-pulls once the cached copy is older than PullMaxAge, a duration
+	// string.
+	PullPolicy string `codec:"pull_policy"`
+	PullMaxAge string `codec:"pull_max_age"`
+
+	// HTTPProxy, HTTPSProxy and NoProxy route the task's image pulls through
+	// a proxy. They are only set for the tart commands pulling the image.
+	HTTPProxy  string `codec:"http_proxy"`
+	HTTPSProxy string `codec:"https_proxy"`
+	NoProxy    string `codec:"no_proxy"`
+
+	// GuestStats adds the usage reported by ps inside the guest to the
+	// task's stats, alongside the usage measured on the host.
+	GuestStats bool `codec:"guest_stats"`
+
+	// SSHAgentForward forwards the SSH agent of the Nomad client into exec
+	// sessions, so commands in the guest can use the host's keys.
+	SSHAgentForward bool `codec:"ssh_agent_forward"`
+
+	// BootScript is run in the guest with bash once it is reachable over
+	// SSH. The task ends with the script's exit code.
+	BootScript *string `codec:"boot_script"`
+
+	// ConsoleLog captures the VM's serial console in the task's local
+	// directory and copies it to the task's stdout until SSH is up.
+	ConsoleLog bool `codec:"console_log"`
+
+	// ReadyWhen gates the task's start on a command passing in the guest.
+	ReadyWhen *ReadyWhenConfig `codec:"ready_when"`
+
+	// Labels are attached to every event the driver emits for the task and
+	// to its driver attributes, e.g. to tag VMs with a team or pipeline.
+	Labels map[string]string `codec:"labels"`
+}
+
+// ReadyWhenConfig is a command run in the guest over SSH until it exits with
+// ExitCode, e.g. to wait for a service the task needs, before the task is
+// reported started.
+type ReadyWhenConfig struct {
+	// Command is the program and its arguments.
+	Command []string `codec:"command"`
+
+	// ExitCode is the exit code that marks the guest ready.
+	ExitCode int `codec:"exit_code"`
+
+	// Timeout bounds how long the guest may take to become ready, and
+	// Interval is how often the command is retried, as duration strings.
+	Timeout  string `codec:"timeout"`
+	Interval string `codec:"interval"`
 }
 
 type Auth struct {
 	Username string `codec:"username"`
 	Password string `codec:"password"`
+
+	// UsernameFile and PasswordFile name files in the task's secrets
+	// directory to read the username and password from instead, such as
+	// ones rendered by a template from Vault or Nomad variables.
+	UsernameFile string `codec:"username_file"`
+	PasswordFile string `codec:"password_file"`
 }
 
 func (a Auth) IsValid() bool {
@@ -46,29 +296,88 @@ var (
 			hclspec.NewAttr("enabled", "bool", false),
 			hclspec.NewLiteral("true"),
 		),
+		"max_concurrent_setups": hclspec.NewAttr("max_concurrent_setups", "number", false),
+		"max_image_store_gb":    hclspec.NewAttr("max_image_store_gb", "number", false),
+		"pull_concurrency":      hclspec.NewAttr("pull_concurrency", "number", false),
+		"registry_mirrors":      hclspec.NewAttr("registry_mirrors", "map(string)", false),
+		"prewarm_images":        hclspec.NewAttr("prewarm_images", "list(string)", false),
+		"fingerprint_interval": hclspec.NewDefault(
+			hclspec.NewAttr("fingerprint_interval", "string", false),
+			hclspec.NewLiteral(`"30s"`),
+		),
+		"wait_poll_interval": hclspec.NewDefault(
+			hclspec.NewAttr("wait_poll_interval", "string", false),
+			hclspec.NewLiteral(`"5s"`),
+		),
+		"wait_failure_threshold": hclspec.NewDefault(
+			hclspec.NewAttr("wait_failure_threshold", "number", false),
+			hclspec.NewLiteral("3"),
+		),
+		"command_timeout": hclspec.NewDefault(
+			hclspec.NewAttr("command_timeout", "string", false),
+			hclspec.NewLiteral(`"2m"`),
+		),
+		"health_failure_threshold": hclspec.NewDefault(
+			hclspec.NewAttr("health_failure_threshold", "number", false),
+			hclspec.NewLiteral("3"),
+		),
+		"health_success_threshold": hclspec.NewDefault(
+			hclspec.NewAttr("health_success_threshold", "number", false),
+			hclspec.NewLiteral("2"),
+		),
+		"vm_name_prefix": hclspec.NewDefault(
+			hclspec.NewAttr("vm_name_prefix", "string", false),
+			hclspec.NewLiteral(`"nomad"`),
+		),
+		"replace_existing_vms": hclspec.NewDefault(
+			hclspec.NewAttr("replace_existing_vms", "bool", false),
+			hclspec.NewLiteral("false"),
+		),
+		"verbose_inspect": hclspec.NewDefault(
+			hclspec.NewAttr("verbose_inspect", "bool", false),
+			hclspec.NewLiteral("false"),
+		),
+		"executor_log_level": hclspec.NewDefault(
+			hclspec.NewAttr("executor_log_level", "string", false),
+			hclspec.NewLiteral(`"info"`),
+		),
+		"executor_log_dir": hclspec.NewAttr("executor_log_dir", "string", false),
+		"tart_home":        hclspec.NewAttr("tart_home", "string", false),
+		"env_denylist":     hclspec.NewAttr("env_denylist", "list(string)", false),
+		"audit_log":        hclspec.NewAttr("audit_log", "string", false),
+		"reserved_slots":   hclspec.NewAttr("reserved_slots", "number", false),
+		"prestart_hook":    hostHookSpec("prestart_hook"),
+		"poststop_hook":    hostHookSpec("poststop_hook"),
 	})
 
 	// taskConfigSpec is the hcl specification for the driver config section of
 	// a task within a job. It is returned in the TaskConfigSchema RPC
 	taskConfigSpec = hclspec.NewObject(map[string]*hclspec.Spec{
-		"url":          hclspec.NewAttr("url", "string", true),
-		"ssh_user":     hclspec.NewAttr("ssh_user", "string", true),
-		"ssh_password": hclspec.NewAttr("ssh_password", "string", true),
+		"url":          hclspec.NewAttr("url", "string", false),
+		"ssh_user":     hclspec.NewAttr("ssh_user", "string", false),
+		"ssh_password": hclspec.NewAttr("ssh_password", "string", false),
 		"show_ui":      hclspec.NewDefault(hclspec.NewAttr("show_ui", "bool", false), hclspec.NewLiteral("false")),
 		"disk_size":    hclspec.NewAttr("disk_size", "number", false),
 		"auth": hclspec.NewBlock("auth", false, hclspec.NewObject(map[string]*hclspec.Spec{
-			"username": hclspec.NewAttr("username", "string", true),
-			"password": hclspec.NewAttr("password", "string", true),
+			"username":      hclspec.NewAttr("username", "string", false),
+			"password":      hclspec.NewAttr("password", "string", false),
+			"username_file": hclspec.NewAttr("username_file", "string", false),
+			"password_file": hclspec.NewAttr("password_file", "string", false),
 		})),
+		"anonymous_pull":  hclspec.NewDefault(hclspec.NewAttr("anonymous_pull", "bool", false), hclspec.NewLiteral("false")),
+		"disk_iops_limit": hclspec.NewAttr("disk_iops_limit", "number", false),
 
 		// Networking options block
 		// mode: "host" | "bridged" | "softnet" | "shared" (default)
 		// softnet_allow/expose imply softnet when mode is not specified
 		"network": hclspec.NewBlock("network", false, hclspec.NewObject(map[string]*hclspec.Spec{
-			"mode":              hclspec.NewAttr("mode", "string", false),
-			"bridged_interface": hclspec.NewAttr("bridged_interface", "string", false),
-			"softnet_allow":     hclspec.NewAttr("softnet_allow", "list(string)", false),
-			"softnet_expose":    hclspec.NewAttr("softnet_expose", "list(string)", false),
+			"mode":               hclspec.NewAttr("mode", "string", false),
+			"bridged_interface":  hclspec.NewAttr("bridged_interface", "string", false),
+			"bridged_interfaces": hclspec.NewAttr("bridged_interfaces", "list(string)", false),
+			"softnet_allow":      hclspec.NewAttr("softnet_allow", "list(string)", false),
+			"softnet_expose":     hclspec.NewAttr("softnet_expose", "list(string)", false),
+			"dns_servers":        hclspec.NewAttr("dns_servers", "list(string)", false),
+			"gateway":            hclspec.NewAttr("gateway", "string", false),
 		})),
 
 		// Root disk options block
@@ -77,6 +386,51 @@ var (
 			"caching_mode": hclspec.NewAttr("caching_mode", "string", false),
 			"sync_mode":    hclspec.NewAttr("sync_mode", "string", false),
 		})),
+		"scratch_disk": hclspec.NewBlock("scratch_disk", false, hclspec.NewObject(map[string]*hclspec.Spec{
+			"size_gb": hclspec.NewAttr("size_gb", "number", true),
+		})),
+
+		"shutdown_exit_code": hclspec.NewAttr("shutdown_exit_code", "number", false),
+		"exit_code_marker":   hclspec.NewDefault(hclspec.NewAttr("exit_code_marker", "bool", false), hclspec.NewLiteral("false")),
+		"hostname":           hclspec.NewAttr("hostname", "string", false),
+		"cpu_affinity":       hclspec.NewAttr("cpu_affinity", "list(number)", false),
+		"inject_nomad_env":   hclspec.NewDefault(hclspec.NewAttr("inject_nomad_env", "bool", false), hclspec.NewLiteral("false")),
+		"tart_home":          hclspec.NewAttr("tart_home", "string", false),
+		"url_from_file":      hclspec.NewAttr("url_from_file", "string", false),
+		"image_file":         hclspec.NewAttr("image_file", "string", false),
+		"mount_secrets":      hclspec.NewDefault(hclspec.NewAttr("mount_secrets", "bool", false), hclspec.NewLiteral("true")),
+		"image_digest":       hclspec.NewAttr("image_digest", "string", false),
+		"platform":           hclspec.NewAttr("platform", "string", false),
+		"memory_min":         hclspec.NewAttr("memory_min", "number", false),
+		"memory_max":         hclspec.NewAttr("memory_max", "number", false),
+		"extra_set_args":     hclspec.NewAttr("extra_set_args", "list(string)", false),
+		"base_url":           hclspec.NewAttr("base_url", "string", false),
+		"extra_disks":        hclspec.NewAttr("extra_disks", "list(string)", false),
+		"pull_concurrency":   hclspec.NewAttr("pull_concurrency", "number", false),
+		"pull_policy":        hclspec.NewAttr("pull_policy", "string", false),
+		"pull_max_age":       hclspec.NewAttr("pull_max_age", "string", false),
+		"labels":             hclspec.NewAttr("labels", "map(string)", false),
+		"guest_stats":        hclspec.NewDefault(hclspec.NewAttr("guest_stats", "bool", false), hclspec.NewLiteral("false")),
+		"http_proxy":         hclspec.NewAttr("http_proxy", "string", false),
+		"https_proxy":        hclspec.NewAttr("https_proxy", "string", false),
+		"no_proxy":           hclspec.NewAttr("no_proxy", "string", false),
+		"console_log":        hclspec.NewDefault(hclspec.NewAttr("console_log", "bool", false), hclspec.NewLiteral("false")),
+		"ssh_agent_forward":  hclspec.NewDefault(hclspec.NewAttr("ssh_agent_forward", "bool", false), hclspec.NewLiteral("false")),
+		"boot_script":        hclspec.NewAttr("boot_script", "string", false),
+
+		// Leaves the image's sealed CPU, memory and disk configuration alone
+		"skip_resource_config": hclspec.NewDefault(hclspec.NewAttr("skip_resource_config", "bool", false), hclspec.NewLiteral("false")),
+
+		// Relaunch tart when it crashes, for long-lived service VMs
+		"restart_vm_on_crash": hclspec.NewDefault(hclspec.NewAttr("restart_vm_on_crash", "bool", false), hclspec.NewLiteral("false")),
+		"max_vm_restarts":     hclspec.NewDefault(hclspec.NewAttr("max_vm_restarts", "number", false), hclspec.NewLiteral("3")),
+
+		"ready_when": hclspec.NewBlock("ready_when", false, hclspec.NewObject(map[string]*hclspec.Spec{
+			"command":   hclspec.NewAttr("command", "list(string)", true),
+			"exit_code": hclspec.NewDefault(hclspec.NewAttr("exit_code", "number", false), hclspec.NewLiteral("0")),
+			"timeout":   hclspec.NewDefault(hclspec.NewAttr("timeout", "string", false), hclspec.NewLiteral(`"5m"`)),
+			"interval":  hclspec.NewDefault(hclspec.NewAttr("interval", "string", false), hclspec.NewLiteral(`"5s"`)),
+		})),
 
 		"directory": hclspec.NewBlockList("directory", hclspec.NewObject(map[string]*hclspec.Spec{
 			"name": hclspec.NewAttr("name", "string", true),
@@ -95,10 +449,17 @@ type NetworkConfig struct {
 	Mode string `codec:"mode"`
 	// BridgedInterface is used when Mode == "bridged" to select the interface
 	BridgedInterface string `codec:"bridged_interface"`
+	// BridgedInterfaces is a preference list of interfaces for bridged mode;
+	// the first one present on the host is used
+	BridgedInterfaces []string `codec:"bridged_interfaces"`
 	// SoftnetAllow CIDRs when using Softnet; implies Softnet if Mode unspecified
 	SoftnetAllow []string `codec:"softnet_allow"`
 	// SoftnetExpose EXTERNAL:INTERNAL TCP port forward specs when using Softnet; implies Softnet
 	SoftnetExpose []string `codec:"softnet_expose"`
+	// DNSServers overrides the DNS servers handed to the guest by DHCP
+	DNSServers []string `codec:"dns_servers"`
+	// Gateway overrides the default route handed to the guest by DHCP
+	Gateway string `codec:"gateway"`
 }
 
 // Options to specify how the root disk of the VM should be
@@ -109,6 +470,11 @@ type RootDiskOptions struct {
 	CachingMode *string `codec:"caching_mode"`
 }
 
+// ScratchDiskConfig sizes the task's scratch disk.
+type ScratchDiskConfig struct {
+	SizeGB int `codec:"size_gb"`
+}
+
 // DirectoryMount represents a single directory block item from the config
 // with an optional name (purely descriptive), required host path, and
 // optional options.
@@ -125,3 +491,138 @@ type DirectoryOptions struct {
 	ReadOnly bool   `codec:"readonly"`
 	Tag      string `codec:"tag"`
 }
+
+// hostHookSpec is the hcl specification of a host hook block.
+func hostHookSpec(name string) *hclspec.Spec {
+	return hclspec.NewBlock(name, false, hclspec.NewObject(map[string]*hclspec.Spec{
+		"command": hclspec.NewAttr("command", "list(string)", true),
+		"timeout": hclspec.NewDefault(
+			hclspec.NewAttr("timeout", "string", false),
+			hclspec.NewLiteral(`"1m"`),
+		),
+	}))
+}
+
+// validateTaskConfig checks a task's driver config before anything is done for
+// the task, so a bad config fails it without an image being pulled or a VM
+// created. Checks needing the task's directory, its resources or the host are
+// left to StartTask and setup.
+func validateTaskConfig(tc TaskConfig) error {
+	if err := validateImageSource(tc); err != nil {
+		return err
+	}
+	if tc.DiskSize < 0 {
+		return fmt.Errorf("disk_size must not be negative, got %d", tc.DiskSize)
+	}
+	if err := tc.Network.Validate(); err != nil {
+		return fmt.Errorf("invalid network config: %v", err)
+	}
+	if err := validateDirectories(tc.Directories); err != nil {
+		return err
+	}
+	if err := validateSSHCredentials(tc); err != nil {
+		return err
+	}
+	if tc.Hostname != "" {
+		if err := validateHostname(tc.Hostname); err != nil {
+			return err
+		}
+	}
+	if tc.TartHome != "" && !filepath.IsAbs(tc.TartHome) {
+		return fmt.Errorf("tart_home must be an absolute path, got %q", tc.TartHome)
+	}
+	if tc.PullConcurrency < 0 {
+		return fmt.Errorf("pull_concurrency must be a positive integer, got %d", tc.PullConcurrency)
+	}
+	if err := validatePullPolicy(tc); err != nil {
+		return err
+	}
+	if tc.AnonymousPull && tc.Auth != (Auth{}) {
+		return fmt.Errorf("auth cannot be used with anonymous_pull")
+	}
+	if err := validateAuth(tc.Auth); err != nil {
+		return err
+	}
+	if err := validateMemoryRange(tc.MemoryMin, tc.MemoryMax); err != nil {
+		return err
+	}
+	if err := validateDiskIOPSLimit(tc.DiskIOPSLimit); err != nil {
+		return err
+	}
+	if err := validateBootScript(tc.BootScript); err != nil {
+		return err
+	}
+	if err := validateScratchDisk(tc.ScratchDisk); err != nil {
+		return err
+	}
+	if err := validatePlatform(tc.Platform); err != nil {
+		return err
+	}
+	if tc.MaxVMRestarts < 0 {
+		return fmt.Errorf("max_vm_restarts must not be negative, got %d", tc.MaxVMRestarts)
+	}
+	if tc.InjectNomadEnv && !tc.MountSecrets {
+		return fmt.Errorf("inject_nomad_env requires mount_secrets, since nomad.env is written to the secrets share")
+	}
+	if tc.SkipResourceConfig && len(tc.ExtraSetArgs) > 0 {
+		return fmt.Errorf("extra_set_args cannot be used with skip_resource_config, which skips tart set")
+	}
+	return nil
+}
+
+// validateTaskResources checks the parts of a task's driver config that
+// depend on the resources Nomad allocated to it, so a cpu_affinity or memory
+// range the allocation cannot satisfy fails the task before its VM is set up.
+func validateTaskResources(tc TaskConfig, cfg *drivers.TaskConfig) error {
+	cpuset, _, memoryMB := allocatedResources(cfg)
+	if len(tc.CPUAffinity) > 0 {
+		if err := validateCPUAffinity(tc.CPUAffinity, cpuset); err != nil {
+			return err
+		}
+	}
+	if _, err := vmMemoryMB(memoryMB, tc.MemoryMin, tc.MemoryMax); err != nil {
+		return err
+	}
+	return nil
+}
+
+// allocatedResources returns the cpuset, number of cores and memory, in MB,
+// Nomad allocated to a task, defaulting to 4 cores and 4GB of memory when it
+// allocated none.
+func allocatedResources(cfg *drivers.TaskConfig) (cpuset string, cpuCores, memoryMB int) {
+	cpuCores, memoryMB = 4, 4096
+	if cfg != nil && cfg.Resources != nil && cfg.Resources.LinuxResources != nil {
+		// TODO: See if there's a better way of getting the number of cores
+		cpuset = cfg.Resources.LinuxResources.CpusetCpus
+		cpuCores = len(strings.Split(cpuset, ","))
+		memoryMB = int(cfg.Resources.LinuxResources.MemoryLimitBytes / 1024 / 1024)
+	}
+	return cpuset, cpuCores, memoryMB
+}
+
+// validateImageSource checks that a task names exactly one image source: url
+// (or its alias base_url), url_from_file or image_file.
+func validateImageSource(tc TaskConfig) error {
+	var sources []string
+	switch {
+	case tc.URL != "":
+		sources = append(sources, "url")
+	case tc.BaseURL != "":
+		sources = append(sources, "base_url")
+	}
+	if tc.URLFromFile != "" {
+		sources = append(sources, "url_from_file")
+	}
+	if tc.ImageFile != "" {
+		sources = append(sources, "image_file")
+	}
+
+	switch len(sources) {
+	case 0:
+		return fmt.Errorf("no image source set: one of url, base_url, url_from_file or image_file is required")
+	case 1:
+		return nil
+	default:
+		return fmt.Errorf("only one image source may be set, got %s", strings.Join(sources, ", "))
+	}
+}
@@ -0,0 +1,79 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+const (
+	// consoleLogFile is the file within the task's local directory tart
+	// writes the VM's serial console to when console_log is enabled.
+	consoleLogFile = "console.log"
+
+	// consolePollInterval is how often the console log is checked for new
+	// output while it is copied to the task's stdout.
+	consolePollInterval = 500 * time.Millisecond
+)
+
+// createConsoleLog creates the file tart writes the VM's serial console to,
+// returning its path. tart only opens an existing file, so it is created up
+// front and handed to the user tart runs as.
+func createConsoleLog(cfg *drivers.TaskConfig, runAs *user.User) (string, error) {
+	dir := cfg.TaskDir().LocalDir
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create task local dir: %v", err)
+	}
+
+	path := filepath.Join(dir, consoleLogFile)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to create console log: %v", err)
+	}
+	f.Close()
+
+	if runAs != nil {
+		if err := chownToUser(path, runAs); err != nil {
+			return "", fmt.Errorf("failed to hand console log to %s: %v", runAs.Username, err)
+		}
+	}
+	return path, nil
+}
+
+// streamConsoleLog copies the VM's console output from path to the task's
+// stdout file until ctx is cancelled, which happens once the guest accepts
+// SSH and its syslog takes over. The console log itself keeps the full
+// output in the task directory.
+func (d *Driver) streamConsoleLog(ctx context.Context, path, stdoutPath string) {
+	console, err := os.Open(path)
+	if err != nil {
+		d.logger.Warn("failed to open console log", "path", path, "error", err)
+		return
+	}
+	defer console.Close()
+
+	stdout, err := os.OpenFile(stdoutPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		d.logger.Warn("failed to open stdout file for console output", "path", stdoutPath, "error", err)
+		return
+	}
+	defer stdout.Close()
+
+	for {
+		if _, err := io.Copy(stdout, console); err != nil {
+			d.logger.Warn("failed to copy console output", "error", err)
+			return
+		}
+		if err := d.clock.Sleep(ctx, consolePollInterval); err != nil {
+			// Pick up whatever was written since the last poll.
+			io.Copy(stdout, console)
+			return
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package driver
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+func TestStreamConsoleLog_CopiesToStdout(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{})
+	dir := t.TempDir()
+	cfg := &drivers.TaskConfig{Name: "vm", AllocDir: dir, StdoutPath: filepath.Join(dir, "stdout")}
+
+	path, err := createConsoleLog(cfg, nil)
+	if err != nil {
+		t.Fatalf("createConsoleLog returned error: %v", err)
+	}
+	if want := filepath.Join(cfg.TaskDir().LocalDir, consoleLogFile); path != want {
+		t.Fatalf("expected the console log at %s, got %s", want, path)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.streamConsoleLog(ctx, path, cfg.StdoutPath)
+		close(done)
+	}()
+
+	// Stand in for tart run writing the guest's early boot output.
+	run := exec.Command("sh", "-c", `printf 'EFI stub: booting\nreached target multi-user\n' >> "$0"`, path)
+	if out, err := run.CombinedOutput(); err != nil {
+		t.Fatalf("fake run process failed: %v: %s", err, out)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := os.ReadFile(cfg.StdoutPath)
+		if strings.Contains(string(data), "reached target multi-user") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("console output did not reach stdout, got %q", data)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("console streaming did not stop when cancelled")
+	}
+}
+
+func TestBuildStartArgs_ConsolePath(t *testing.T) {
+	c := NewTartClient(testLogger(t))
+	args, err := c.BuildStartArgs(VMConfig{
+		NomadConfig: &drivers.TaskConfig{AllocID: "alloc-1"},
+		ConsolePath: "/alloc/vm/local/console.log",
+	})
+	if err != nil {
+		t.Fatalf("BuildStartArgs returned error: %v", err)
+	}
+	if i := slices.Index(args, "--serial-path"); i < 0 || i+1 >= len(args) || args[i+1] != "/alloc/vm/local/console.log" {
+		t.Fatalf("expected --serial-path with the console log, got %v", args)
+	}
+}
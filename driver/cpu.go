@@ -0,0 +1,93 @@
+package driver
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// hostCPUCount is a package-level indirection to allow tests to fix the
+// number of host cores. In production it points to runtime.NumCPU.
+var hostCPUCount = runtime.NumCPU
+
+// parseCPUSet parses a Linux style cpuset list such as "0-3,6" into core
+// indices.
+func parseCPUSet(s string) ([]int, error) {
+	var cores []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		lo, hi, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpuset entry %q", part)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(hi); err != nil || end < start {
+				return nil, fmt.Errorf("invalid cpuset entry %q", part)
+			}
+		}
+		for core := start; core <= end; core++ {
+			cores = append(cores, core)
+		}
+	}
+	return cores, nil
+}
+
+// validateCPUAffinity checks that every core in affinity exists on the host,
+// is listed once, and falls within the cpuset Nomad reserved for the task
+// when there is one.
+func validateCPUAffinity(affinity []int, cpuset string) error {
+	numCPU := hostCPUCount()
+
+	var reserved map[int]struct{}
+	if strings.TrimSpace(cpuset) != "" {
+		cores, err := parseCPUSet(cpuset)
+		if err != nil {
+			return err
+		}
+		reserved = make(map[int]struct{}, len(cores))
+		for _, core := range cores {
+			reserved[core] = struct{}{}
+		}
+	}
+
+	seen := make(map[int]struct{}, len(affinity))
+	for _, core := range affinity {
+		if core < 0 || core >= numCPU {
+			return fmt.Errorf("cpu_affinity core %d is out of range: host has cores 0-%d", core, numCPU-1)
+		}
+		if _, ok := seen[core]; ok {
+			return fmt.Errorf("cpu_affinity core %d is listed more than once", core)
+		}
+		seen[core] = struct{}{}
+
+		if reserved != nil {
+			if _, ok := reserved[core]; !ok {
+				return fmt.Errorf("cpu_affinity core %d is not in the task's reserved cpuset %q", core, cpuset)
+			}
+		}
+	}
+	return nil
+}
+
+// buildSetResourcesArgs computes the `tart set` arguments for the given
+// resources, omitting any that are unset.
+func buildSetResourcesArgs(vmName string, cpu, memoryMB, diskGB int) []string {
+	args := []string{"set", vmName}
+	if cpu > 0 {
+		args = append(args, "--cpu", fmt.Sprintf("%d", cpu))
+	}
+	if memoryMB > 0 {
+		args = append(args, "--memory", fmt.Sprintf("%d", memoryMB))
+	}
+	if diskGB > 0 {
+		args = append(args, "--disk-size", fmt.Sprintf("%d", diskGB))
+	}
+	return args
+}
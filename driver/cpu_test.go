@@ -0,0 +1,99 @@
+package driver
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+func TestParseCPUSet(t *testing.T) {
+	got, err := parseCPUSet("0-2, 5,7-8")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []int{0, 1, 2, 5, 7, 8}; !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	for _, bad := range []string{"a", "3-1", "1-x"} {
+		if _, err := parseCPUSet(bad); err == nil {
+			t.Fatalf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestValidateCPUAffinity(t *testing.T) {
+	orig := hostCPUCount
+	hostCPUCount = func() int { return 8 }
+	defer func() { hostCPUCount = orig }()
+
+	if err := validateCPUAffinity([]int{0, 3, 7}, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := validateCPUAffinity([]int{2, 3}, "0-3"); err != nil {
+		t.Fatalf("unexpected error within cpuset: %v", err)
+	}
+
+	cases := map[string]struct {
+		affinity []int
+		cpuset   string
+	}{
+		"beyond host cores": {[]int{0, 8}, ""},
+		"negative core":     {[]int{-1}, ""},
+		"duplicate core":    {[]int{1, 1}, ""},
+		"outside cpuset":    {[]int{4}, "0-3"},
+	}
+	for name, tc := range cases {
+		if err := validateCPUAffinity(tc.affinity, tc.cpuset); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func TestBuildSetResourcesArgs(t *testing.T) {
+	got := buildSetResourcesArgs("nomad-a", 3, 8192, 0)
+	want := []string{"set", "nomad-a", "--cpu", "3", "--memory", "8192"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	if got := buildSetResourcesArgs("nomad-a", 0, 0, 0); len(got) != 2 {
+		t.Fatalf("expected no resource flags, got %v", got)
+	}
+}
+
+func TestStartTask_RejectsUnsatisfiableResourcesBeforeSetup(t *testing.T) {
+	orig := hostCPUCount
+	hostCPUCount = func() int { return 8 }
+	defer func() { hostCPUCount = orig }()
+
+	client := &fakeClient{
+		setupFn: func(ctx context.Context, config VMConfig) (SetupResult, error) {
+			t.Fatalf("setup should not run for a task its resources cannot satisfy")
+			return SetupResult{}, nil
+		},
+	}
+	d := newTestDriver(t, client)
+
+	cases := map[string]TaskConfig{
+		"affinity outside cpuset": {URL: "ghcr.io/org/img:latest", CPUAffinity: []int{4}},
+		"memory_max above memory": {URL: "ghcr.io/org/img:latest", MemoryMax: 8192},
+	}
+	for name, tc := range cases {
+		cfg := &drivers.TaskConfig{
+			ID:      "task-" + name,
+			AllocID: "alloc-1",
+			Resources: &drivers.Resources{
+				LinuxResources: &drivers.LinuxResources{CpusetCpus: "0,1,2,3", MemoryLimitBytes: 4096 * 1024 * 1024},
+			},
+		}
+		if err := cfg.EncodeConcreteDriverConfig(&tc); err != nil {
+			t.Fatalf("%s: failed to encode task config: %v", name, err)
+		}
+		if _, _, err := d.StartTask(cfg); err == nil {
+			t.Fatalf("%s: expected StartTask to fail", name)
+		}
+	}
+}
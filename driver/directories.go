@@ -5,6 +5,16 @@ import (
 	"strings"
 )
 
+// validateDirectories checks that every directory mount names a host path.
+func validateDirectories(dirs []DirectoryMount) error {
+	for _, d := range dirs {
+		if strings.TrimSpace(d.Path) == "" {
+			return fmt.Errorf("directory.path is required for directory mounts")
+		}
+	}
+	return nil
+}
+
 // buildDirectoryArgs converts directory mount config into tart --dir flags.
 // For each mount we emit a single arg using equals form:
 //
@@ -15,14 +25,14 @@ func buildDirectoryArgs(dirs []DirectoryMount) ([]string, error) {
 	if len(dirs) == 0 {
 		return []string{}, nil
 	}
+	if err := validateDirectories(dirs); err != nil {
+		return nil, err
+	}
 
 	// Each mount adds one arg: "--dir=<spec>"
 	args := make([]string, 0, len(dirs))
 	for _, d := range dirs {
 		path := strings.TrimSpace(d.Path)
-		if path == "" {
-			return nil, fmt.Errorf("directory.path is required for directory mounts")
-		}
 
 		// Start with optional name prefix
 		var specBuilder strings.Builder
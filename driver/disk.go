@@ -5,6 +5,14 @@ import (
 	"strings"
 )
 
+// validateDiskIOPSLimit checks that disk_iops_limit, when set, is positive.
+func validateDiskIOPSLimit(limit int) error {
+	if limit < 0 {
+		return fmt.Errorf("disk_iops_limit must be a positive integer, got %d", limit)
+	}
+	return nil
+}
+
 func buildRootDiskArgs(cfg *RootDiskOptions) ([]string, error) {
 	args := []string{}
 	if cfg == nil {
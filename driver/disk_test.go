@@ -3,6 +3,8 @@ package driver
 import (
 	"reflect"
 	"testing"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
 )
 
 func TestBuildRootDiskArgs_Nil(t *testing.T) {
@@ -80,3 +82,35 @@ func TestBuildRootDiskArgs_AllOptions(t *testing.T) {
 		t.Fatalf("got %v, want %v", got, want)
 	}
 }
+
+func TestValidateDiskIOPSLimit(t *testing.T) {
+	for _, limit := range []int{0, 1, 5000} {
+		if err := validateDiskIOPSLimit(limit); err != nil {
+			t.Fatalf("%d: unexpected error: %v", limit, err)
+		}
+	}
+	if err := validateDiskIOPSLimit(-1); err == nil {
+		t.Fatalf("expected an error for a negative limit")
+	}
+}
+
+func TestBuildStartArgs_DiskIOPSLimitAddsNoFlags(t *testing.T) {
+	c := NewTartClient(testLogger(t))
+	base := VMConfig{NomadConfig: &drivers.TaskConfig{AllocID: "alloc-1"}}
+	want, err := c.BuildStartArgs(base)
+	if err != nil {
+		t.Fatalf("BuildStartArgs returned error: %v", err)
+	}
+
+	// tart has no flag to throttle disk I/O, so the limit must not produce
+	// one tart would reject.
+	limited := base
+	limited.TaskConfig.DiskIOPSLimit = 500
+	got, err := c.BuildStartArgs(limited)
+	if err != nil {
+		t.Fatalf("BuildStartArgs returned error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
@@ -1,31 +1,82 @@
 package driver
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"os"
+	"os/user"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
+	metrics "github.com/hashicorp/go-metrics/compat"
+	plugin "github.com/hashicorp/go-plugin"
+	"github.com/hashicorp/nomad/client/lib/cpustats"
 	"github.com/hashicorp/nomad/drivers/shared/eventer"
 	"github.com/hashicorp/nomad/drivers/shared/executor"
 	"github.com/hashicorp/nomad/plugins/base"
 	"github.com/hashicorp/nomad/plugins/drivers"
 	"github.com/hashicorp/nomad/plugins/shared/hclspec"
+	pstructs "github.com/hashicorp/nomad/plugins/shared/structs"
 )
 
 const (
 	// pluginName is the name of the plugin
 	pluginName = "tart"
 
-	// fingerprintPeriod is the interval at which the driver will send fingerprint responses
-	fingerprintPeriod = 30 * time.Second
+	// defaultFingerprintPeriod is the interval at which the driver will send
+	// fingerprint responses when fingerprint_interval is not configured
+	defaultFingerprintPeriod = 30 * time.Second
+
+	// minFingerprintPeriod is the smallest accepted fingerprint_interval, as
+	// each fingerprint spawns tart processes
+	minFingerprintPeriod = 5 * time.Second
+
+	// defaultHealthFailureThreshold and defaultHealthSuccessThreshold are how
+	// many consecutive fingerprints must disagree with the reported health
+	// before it changes, when the thresholds are unset.
+	defaultHealthFailureThreshold = 3
+	defaultHealthSuccessThreshold = 2
 
 	// taskHandleVersion is the version of task handle which this driver sets
 	// and understands how to decode driver state
 	taskHandleVersion = 1
+
+	// signalPause and signalResume are special signals accepted by SignalTask
+	// that freeze and continue the VM instead of signaling the guest.
+	signalPause  = "PAUSE"
+	signalResume = "CONT"
+
+	// defaultVMNamePrefix is prepended to allocation IDs to name VMs when no
+	// vm_name_prefix is configured.
+	defaultVMNamePrefix = "nomad"
+
+	// defaultExecutorLogLevel is the executor log level used when
+	// executor_log_level is not configured.
+	defaultExecutorLogLevel = "info"
+
+	// vmStateMissing is reported by ListTasks for tasks whose VM no longer
+	// exists.
+	vmStateMissing = "missing"
+
+	// gracefulStopShare is the share of StopTask's timeout given to tart to
+	// shut the guest down cleanly. The rest is left for the executor to force
+	// the tart process down.
+	gracefulStopShare = 0.7
+
+	// forceDestroyStopTimeout bounds how long a forced DestroyTask waits for
+	// tart to stop a VM that was never stopped through StopTask.
+	forceDestroyStopTimeout = 5 * time.Second
 )
 
 var (
@@ -44,6 +95,10 @@ var (
 		Exec:        true,
 		FSIsolation: drivers.FSIsolationImage,
 	}
+
+	// vmNamePrefixPattern restricts vm_name_prefix to characters that are
+	// safe in tart VM names and on-disk paths.
+	vmNamePrefixPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
 )
 
 // Driver is a driver for running Tart VM containers
@@ -58,9 +113,12 @@ type Driver struct {
 	// nomadConfig is the client config from nomad
 	nomadConfig *base.ClientDriverConfig
 
-	// tasks is the in memory datastore mapping taskIDs to rawExecDriverHandles
+	// tasks is the in memory datastore mapping taskIDs to taskHandles
 	tasks *taskStore
 
+	// starting holds the tasks whose VM is still being set up by StartTask
+	starting *startingTasks
+
 	// ctx is the context for the driver. It is passed to other subsystems to
 	// coordinate shutdown
 	ctx context.Context
@@ -74,6 +132,65 @@ type Driver struct {
 
 	// client is the interface for interacting with virtual machines
 	client VirtualizationClient
+
+	// setupSem bounds the number of concurrent VM setups when
+	// max_concurrent_setups is configured. A nil channel means unlimited.
+	setupSem chan struct{}
+
+	// fingerprintPeriod is how often a fingerprint is sent to Nomad
+	fingerprintPeriod time.Duration
+
+	// waitPollInterval is how often running VMs are polled for their status
+	waitPollInterval time.Duration
+
+	// waitFailureThreshold is how many consecutive failed status checks are
+	// needed before a VM is considered gone
+	waitFailureThreshold int
+
+	// vmNamePrefix is prepended to allocation IDs to name VMs
+	vmNamePrefix string
+
+	// health damps flapping of the fingerprinted health
+	health *healthHysteresis
+
+	// createExecutor launches the executor plugin for a task. It is
+	// executor.CreateExecutor outside of tests.
+	createExecutor executorFactory
+
+	// reattachExecutor reconnects to the executor of a task recovered after
+	// the plugin restarted. It is reattachToExecutor outside of tests.
+	reattachExecutor executorReattacher
+
+	// prestartHook and poststopHook are the operator's host commands run
+	// before each VM is set up and after each task stops, if any.
+	prestartHook *hostHook
+	poststopHook *hostHook
+
+	// syslogRetry is the backoff used to re-establish the syslog stream
+	syslogRetry retryBackoff
+
+	// clock drives the fingerprint, VM monitor and syslog retry loops
+	clock clock
+
+	// processInfoWarning warns once that host process information could
+	// not be read for VM stats
+	processInfoWarning sync.Once
+}
+
+// executorFactory matches executor.CreateExecutor.
+type executorFactory func(hclog.Logger, *base.ClientDriverConfig, *executor.ExecutorConfig) (executor.Executor, *plugin.Client, error)
+
+// executorReattacher matches reattachToExecutor.
+type executorReattacher func(*pstructs.ReattachConfig, hclog.Logger, cpustats.Compute) (executor.Executor, *plugin.Client, error)
+
+// reattachToExecutor reconnects to the executor described by rc, as recorded
+// in a task's driver state.
+func reattachToExecutor(rc *pstructs.ReattachConfig, logger hclog.Logger, compute cpustats.Compute) (executor.Executor, *plugin.Client, error) {
+	plugRC, err := pstructs.ReattachConfigToGoPlugin(rc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build reattach config: %v", err)
+	}
+	return executor.ReattachToExecutor(plugRC, logger, compute)
 }
 
 // TaskState is the state which is encoded in the handle returned in
@@ -84,24 +201,82 @@ type TaskState struct {
 	StartedAt   time.Time
 	CompletedAt time.Time
 	ExitResult  *drivers.ExitResult
+
+	// ReattachConfig and Pid identify the executor running tart and tart's
+	// process, so a recovered task reattaches to the running VM.
+	ReattachConfig *pstructs.ReattachConfig
+	Pid            int
+
+	// TartHome is the tart home holding the VM, empty for the agent user's
+	// own.
+	TartHome string
+
+	// StartArgs are the arguments tart was run with, kept to relaunch a
+	// recovered VM that later crashes.
+	StartArgs []string
+
+	// The values below were gathered while the VM was set up and are
+	// surfaced in the task's status.
+	PullDuration time.Duration
+	MACAddress   string
+	VMResources  VMResources
 }
 
 // NewTartDriver returns a new driver plugin implementation
 func NewTartDriver(logger hclog.Logger) drivers.DriverPlugin {
-	ctx, cancel := context.WithCancel(context.Background())
 	logger = logger.Named(pluginName)
 
 	// Create a TartClient as our default virtualizer implementation
-	client := NewTartClient(logger)
+	return newDriver(logger, NewTartClient(logger))
+}
+
+// NewTartDriverWithClient returns a new driver plugin implementation that
+// manages VMs through client instead of the tart CLI, e.g. a fake in tests.
+func NewTartDriverWithClient(logger hclog.Logger, client VirtualizationClient) drivers.DriverPlugin {
+	return newDriver(logger.Named(pluginName), client)
+}
+
+// newDriver returns a Driver using client, logging to logger as is.
+func newDriver(logger hclog.Logger, client VirtualizationClient) *Driver {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Driver{
+		eventer:              eventer.NewEventer(ctx, logger),
+		config:               &Config{},
+		tasks:                newTaskStore(),
+		starting:             newStartingTasks(),
+		ctx:                  ctx,
+		signalShutdown:       cancel,
+		logger:               logger,
+		client:               client,
+		fingerprintPeriod:    defaultFingerprintPeriod,
+		waitPollInterval:     defaultWaitPollInterval,
+		waitFailureThreshold: defaultWaitFailureThreshold,
+		vmNamePrefix:         defaultVMNamePrefix,
+		health:               newHealthHysteresis(defaultHealthFailureThreshold, defaultHealthSuccessThreshold),
+		createExecutor:       executor.CreateExecutor,
+		reattachExecutor:     reattachToExecutor,
+		syslogRetry:          defaultSyslogRetry,
+		clock:                realClock{},
+	}
+	go d.handleShutdown()
+	return d
+}
+
+// Shutdown stops the driver's background work. It is equivalent to the
+// plugin host cancelling the driver context.
+func (d *Driver) Shutdown() {
+	d.signalShutdown()
+}
 
-	return &Driver{
-		eventer:        eventer.NewEventer(ctx, logger),
-		config:         &Config{},
-		tasks:          newTaskStore(),
-		ctx:            ctx,
-		signalShutdown: cancel,
-		logger:         logger,
-		client:         client,
+// handleShutdown waits for the driver context to be cancelled and then stops
+// the syslog stream of every task. Cancelling a stream closes its SSH
+// connection, and the eventer drains and stops on the same context.
+func (d *Driver) handleShutdown() {
+	<-d.ctx.Done()
+	for _, h := range d.tasks.List() {
+		if h.syslogCancel != nil {
+			h.syslogCancel()
+		}
 	}
 }
 
@@ -124,11 +299,136 @@ func (d *Driver) SetConfig(cfg *base.Config) error {
 		}
 	}
 
+	if config.MaxConcurrentSetups < 0 {
+		return fmt.Errorf("max_concurrent_setups must not be negative, got %d", config.MaxConcurrentSetups)
+	}
+	if config.MaxImageStoreGB < 0 {
+		return fmt.Errorf("max_image_store_gb must not be negative, got %d", config.MaxImageStoreGB)
+	}
+	if config.PullConcurrency < 0 {
+		return fmt.Errorf("pull_concurrency must be a positive integer, got %d", config.PullConcurrency)
+	}
+	if config.ReservedSlots < 0 || config.ReservedSlots >= maxVMSlots {
+		return fmt.Errorf("reserved_slots must be between 0 and %d, got %d", maxVMSlots-1, config.ReservedSlots)
+	}
+
+	pollInterval := defaultWaitPollInterval
+	if config.WaitPollInterval != "" {
+		interval, err := time.ParseDuration(config.WaitPollInterval)
+		if err != nil {
+			return fmt.Errorf("invalid wait_poll_interval %q: %v", config.WaitPollInterval, err)
+		}
+		if interval < minWaitPollInterval {
+			return fmt.Errorf("wait_poll_interval must be at least %s, got %s", minWaitPollInterval, interval)
+		}
+		pollInterval = interval
+	}
+
+	fingerprintPeriod := defaultFingerprintPeriod
+	if config.FingerprintInterval != "" {
+		interval, err := time.ParseDuration(config.FingerprintInterval)
+		if err != nil {
+			return fmt.Errorf("invalid fingerprint_interval %q: %v", config.FingerprintInterval, err)
+		}
+		if interval < minFingerprintPeriod {
+			return fmt.Errorf("fingerprint_interval must be at least %s, got %s", minFingerprintPeriod, interval)
+		}
+		fingerprintPeriod = interval
+	}
+
+	failureThreshold := defaultWaitFailureThreshold
+	if config.WaitFailureThreshold < 0 {
+		return fmt.Errorf("wait_failure_threshold must not be negative, got %d", config.WaitFailureThreshold)
+	} else if config.WaitFailureThreshold > 0 {
+		failureThreshold = config.WaitFailureThreshold
+	}
+
+	commandTimeout := defaultCommandTimeout
+	if config.CommandTimeout != "" {
+		timeout, err := time.ParseDuration(config.CommandTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid command_timeout %q: %v", config.CommandTimeout, err)
+		}
+		if timeout <= 0 {
+			return fmt.Errorf("command_timeout must be positive, got %s", timeout)
+		}
+		commandTimeout = timeout
+	}
+
+	healthFailures, healthSuccesses := defaultHealthFailureThreshold, defaultHealthSuccessThreshold
+	if config.HealthFailureThreshold < 0 || config.HealthSuccessThreshold < 0 {
+		return fmt.Errorf("health_failure_threshold and health_success_threshold must not be negative")
+	}
+	if config.HealthFailureThreshold > 0 {
+		healthFailures = config.HealthFailureThreshold
+	}
+	if config.HealthSuccessThreshold > 0 {
+		healthSuccesses = config.HealthSuccessThreshold
+	}
+
+	vmNamePrefix := defaultVMNamePrefix
+	if config.VMNamePrefix != "" {
+		if !vmNamePrefixPattern.MatchString(config.VMNamePrefix) {
+			return fmt.Errorf("invalid vm_name_prefix %q: must start with a letter or digit and contain only letters, digits, '.', '_' or '-'", config.VMNamePrefix)
+		}
+		vmNamePrefix = config.VMNamePrefix
+	}
+
+	if config.ExecutorLogLevel != "" && hclog.LevelFromString(config.ExecutorLogLevel) == hclog.NoLevel {
+		return fmt.Errorf("invalid executor_log_level %q: must be one of trace, debug, info, warn or error", config.ExecutorLogLevel)
+	}
+	if config.ExecutorLogDir != "" && !filepath.IsAbs(config.ExecutorLogDir) {
+		return fmt.Errorf("executor_log_dir must be an absolute path, got %q", config.ExecutorLogDir)
+	}
+	if config.TartHome != "" && !filepath.IsAbs(config.TartHome) {
+		return fmt.Errorf("tart_home must be an absolute path, got %q", config.TartHome)
+	}
+	if config.AuditLog != "" && !filepath.IsAbs(config.AuditLog) {
+		return fmt.Errorf("audit_log must be an absolute path, got %q", config.AuditLog)
+	}
+	if err := validateEnvDenylist(config.EnvDenylist); err != nil {
+		return err
+	}
+	if err := validateRegistryMirrors(config.RegistryMirrors); err != nil {
+		return err
+	}
+	if slices.Contains(config.PrewarmImages, "") {
+		return fmt.Errorf("prewarm_images must not contain empty entries")
+	}
+	prestartHook, err := newHostHook("prestart_hook", config.PrestartHook)
+	if err != nil {
+		return err
+	}
+	poststopHook, err := newHostHook("poststop_hook", config.PoststopHook)
+	if err != nil {
+		return err
+	}
+
 	d.config = &config
+	d.vmNamePrefix = vmNamePrefix
+	d.fingerprintPeriod = fingerprintPeriod
+	d.waitPollInterval = pollInterval
+	d.waitFailureThreshold = failureThreshold
+	d.health = newHealthHysteresis(healthFailures, healthSuccesses)
+	if client, ok := d.client.(*TartClient); ok {
+		client.configure(commandTimeout, newAuditLog(config.AuditLog))
+	}
+	d.prestartHook = prestartHook
+	d.poststopHook = poststopHook
+	if config.MaxConcurrentSetups > 0 {
+		d.setupSem = make(chan struct{}, config.MaxConcurrentSetups)
+	} else {
+		d.setupSem = nil
+	}
+
 	if cfg.AgentConfig != nil {
 		d.nomadConfig = cfg.AgentConfig.Driver
 	}
 
+	if len(config.PrewarmImages) > 0 {
+		go d.prewarmImages(&config)
+	}
+
 	return nil
 }
 
@@ -160,90 +460,170 @@ func (d *Driver) StartTask(cfg *drivers.TaskConfig) (*drivers.TaskHandle, *drive
 	if err := cfg.DecodeDriverConfig(&taskConfig); err != nil {
 		return nil, nil, fmt.Errorf("failed to decode driver config: %v", err)
 	}
+	if err := validateTaskConfig(taskConfig); err != nil {
+		return nil, nil, err
+	}
+	if err := validateTaskResources(taskConfig, cfg); err != nil {
+		return nil, nil, err
+	}
+	if err := resolveImageURL(cfg, &taskConfig); err != nil {
+		return nil, nil, err
+	}
+	d.applyRegistryMirrors(&taskConfig)
+	readyGate, err := newReadinessGate(taskConfig.ReadyWhen)
+	if err != nil {
+		return nil, nil, err
+	}
+	var runAs *user.User
+	if cfg.User != "" {
+		u, err := resolveRunAsUser(cfg.User)
+		if err != nil {
+			return nil, nil, err
+		}
+		runAs = u
+	}
 
-	d.logger.Info("starting tart task", "task_cfg", hclog.Fmt("%+v", taskConfig))
+	d.logger.Info("starting tart task", "task_cfg", hclog.Fmt("%+v", taskConfig.redacted()))
 	handle := drivers.NewTaskHandle(taskHandleVersion)
 	handle.Config = cfg
 
 	vmConfig := VMConfig{
-		TaskConfig:  taskConfig,
-		NomadConfig: cfg,
+		TaskConfig:        taskConfig,
+		NomadConfig:       cfg,
+		VMNamePrefix:      d.vmNamePrefix,
+		ReplaceExistingVM: d.config.ReplaceExistingVMs,
+		RunAs:             runAs,
+		TartHome:          d.tartHomeFor(taskConfig, runAs),
+		EnvDenylist:       d.config.EnvDenylist,
+		PullConcurrency:   d.pullConcurrencyFor(taskConfig),
 	}
 
-	needsDownload, err := d.client.NeedsImageDownload(d.ctx, vmConfig)
+	// The VM is set up under a context of the task's own, so stopping the
+	// task while its image is still being pulled aborts the pull.
+	startCtx, cancelStart := context.WithCancel(d.ctx)
+	defer cancelStart()
+	d.starting.Add(cfg.ID, cancelStart)
+	defer d.starting.Delete(cfg.ID)
+
+	if err := d.ensureVMSlot(startCtx, d.generateVMName(cfg.AllocID)); err != nil {
+		return nil, nil, err
+	}
+
+	if d.prestartHook != nil {
+		env := hookEnv(cfg, d.generateVMName(cfg.AllocID), d.config.EnvDenylist)
+		if err := d.prestartHook.run(startCtx, env); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	needsDownload, err := d.client.NeedsImageDownload(startCtx, vmConfig)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to check image availability: %v", err)
 	}
 	if needsDownload {
-		d.logger.Info("VM image not found locally, downloading", "url", taskConfig.URL)
+		overQuota, used, err := d.imageStoreOverQuota()
+		if err != nil {
+			d.logger.Warn("failed to measure the image store", "error", err)
+		} else if overQuota {
+			return nil, nil, fmt.Errorf("refusing to pull %s: the image store uses %.1f GB, over max_image_store_gb (%d GB)",
+				redact(taskConfig.URL), float64(used)/bytesPerGB, d.config.MaxImageStoreGB)
+		}
+
+		d.logger.Info("VM image not found locally, downloading", "url", redact(taskConfig.URL))
 		d.eventer.EmitEvent(&drivers.TaskEvent{
 			TaskID:    cfg.ID,
 			TaskName:  cfg.Name,
 			AllocID:   cfg.AllocID,
 			Timestamp: time.Now(),
 			Message:   "Downloading VM image",
-			Annotations: map[string]string{
-				"url": taskConfig.URL,
-			},
+			Annotations: labelAnnotations(cfg, map[string]string{
+				"url": redact(taskConfig.URL),
+			}),
 		})
 	}
 
-	if _, err := d.client.Setup(d.ctx, vmConfig); err != nil {
+	setup, err := d.setupVM(startCtx, vmConfig)
+	if err != nil {
+		if startCtx.Err() != nil && d.ctx.Err() == nil {
+			return nil, nil, fmt.Errorf("task stopped while setting up its VM: %v", err)
+		}
 		return nil, nil, fmt.Errorf("failed to setup VM: %v", err)
 	}
+	if err := d.abortStoppedStart(startCtx, cfg); err != nil {
+		return nil, nil, err
+	}
 
 	if needsDownload {
-		d.eventer.EmitEvent(&drivers.TaskEvent{
-			TaskID:    cfg.ID,
-			TaskName:  cfg.Name,
-			AllocID:   cfg.AllocID,
-			Timestamp: time.Now(),
-			Message:   "VM image download complete",
-			Annotations: map[string]string{
-				"url": taskConfig.URL,
-			},
-		})
+		d.emitDownloadComplete(cfg, taskConfig.URL, setup.PullDuration)
+		emitPullDuration(setup.PullDuration)
 	}
 
-	pluginLogFile := filepath.Join(cfg.TaskDir().Dir, "executor.out")
-	execConfig := &executor.ExecutorConfig{
-		LogFile:  pluginLogFile,
-		LogLevel: "debug",
+	// The MAC address only helps DHCP reservation workflows, so a VM whose
+	// address cannot be read still starts.
+	macAddress, err := d.client.MACAddress(startCtx, d.generateVMName(cfg.AllocID))
+	if err != nil {
+		d.logger.Warn("failed to determine VM MAC address", "error", err)
 	}
 
-	logger := d.logger.With("task_name", handle.Config.Name, "alloc_id", handle.Config.AllocID)
-	execImpl, pluginClient, err := executor.CreateExecutor(logger, d.nomadConfig, execConfig)
+	// The resources the VM ended up with are informational, so failing to
+	// read them does not stop the task either.
+	vmResources, err := d.client.VMResources(startCtx, d.generateVMName(cfg.AllocID))
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create executor: %v", err)
+		d.logger.Warn("failed to determine VM resources", "error", err)
+	}
+
+	if taskConfig.InjectNomadEnv {
+		if _, err := writeNomadEnvFile(cfg); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var bootScriptPath string
+	if taskConfig.BootScript != nil {
+		if bootScriptPath, err = writeBootScript(cfg, *taskConfig.BootScript); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if taskConfig.ScratchDisk != nil {
+		if err := createScratchDisk(cfg, taskConfig.ScratchDisk.SizeGB, runAs); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if taskConfig.ConsoleLog {
+		if vmConfig.ConsolePath, err = createConsoleLog(cfg, runAs); err != nil {
+			return nil, nil, err
+		}
 	}
 
 	args, err := d.client.BuildStartArgs(vmConfig)
 	if err != nil {
-		pluginClient.Kill()
 		return nil, nil, err
 	}
+	launchVM := d.vmLauncher(cfg, vmConfig, args)
 
-	execCmd := &executor.ExecCommand{
-		Cmd:              "tart",
-		Args:             args,
-		Env:              d.TartEnvList(cfg),
-		User:             cfg.User,
-		TaskDir:          cfg.TaskDir().Dir,
-		StdoutPath:       cfg.StdoutPath,
-		StderrPath:       cfg.StderrPath,
-		NetworkIsolation: cfg.NetworkIsolation,
+	if err := d.abortStoppedStart(startCtx, cfg); err != nil {
+		return nil, nil, err
 	}
-
-	ps, err := execImpl.Launch(execCmd)
+	execImpl, pluginClient, pid, err := launchVM()
 	if err != nil {
-		pluginClient.Kill()
-		return nil, nil, fmt.Errorf("failed to launch VM: %v", err)
+		return nil, nil, err
 	}
 
+	startedAt := time.Now().Round(time.Millisecond)
+
 	// Store the driver state on the handle
 	state := TaskState{
-		TaskConfig: cfg,
-		StartedAt:  time.Now(),
+		TaskConfig:     cfg,
+		StartedAt:      startedAt,
+		ReattachConfig: pstructs.ReattachConfigFromGoPlugin(pluginClient.ReattachConfig()),
+		Pid:            pid,
+		TartHome:       vmConfig.TartHome,
+		StartArgs:      args,
+		PullDuration:   setup.PullDuration,
+		MACAddress:     macAddress,
+		VMResources:    vmResources,
 	}
 
 	handle.State = drivers.TaskStateRunning
@@ -256,70 +636,209 @@ func (d *Driver) StartTask(cfg *drivers.TaskConfig) (*drivers.TaskHandle, *drive
 	}
 
 	h := &taskHandle{
-		exec:         execImpl,
-		pluginClient: pluginClient,
-		pid:          ps.Pid,
-		taskConfig:   cfg,
-		state:        drivers.TaskStateRunning,
-		startedAt:    time.Now().Round(time.Millisecond),
-		logger:       d.logger,
-		doneCh:       make(chan struct{}),
+		clock:            d.clock,
+		exec:             execImpl,
+		pluginClient:     pluginClient,
+		pid:              pid,
+		taskConfig:       cfg,
+		state:            drivers.TaskStateRunning,
+		startedAt:        startedAt,
+		logger:           d.logger,
+		doneCh:           make(chan struct{}),
+		shutdownExitCode: taskConfig.ShutdownExitCode,
+		pullDuration:     setup.PullDuration,
+		macAddress:       macAddress,
+		vmResources:      vmResources,
+		labels:           taskConfig.Labels,
+		tartHome:         vmConfig.TartHome,
 	}
-
-	stdoutFile, err := os.OpenFile(cfg.StdoutPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to open stdout file: %v", err)
+	if taskConfig.RestartVMOnCrash {
+		h.relaunch = launchVM
+		h.maxRestarts = taskConfig.MaxVMRestarts
 	}
-
-	stderrFile, err := os.OpenFile(cfg.StderrPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to open stderr file: %v", err)
+	h.bootScriptPath = bootScriptPath
+	if taskConfig.ExitCodeMarker {
+		h.exitMarkerPath = filepath.Join(cfg.TaskDir().LocalDir, exitMarkerFile)
+		// Clear any marker left behind by a previous run of this task
+		if err := os.Remove(h.exitMarkerPath); err != nil && !os.IsNotExist(err) {
+			d.logger.Warn("failed to remove stale exit marker", "path", h.exitMarkerPath, "error", err)
+		}
 	}
 
 	syslogCtx, cancel := context.WithCancel(d.ctx)
 	h.syslogCancel = cancel
-	d.logger.Trace("Starting log streaming", "stdout_path", cfg.StdoutPath, "stderr_path", cfg.StderrPath)
-
-	// Start the streaming in a goroutine that handles file closing
-	go func() {
-		defer stdoutFile.Close()
-		defer stderrFile.Close()
-
-		// Run syslog streaming with retry/backoff until it connects or context cancels
-		d.streamSyslogWithRetry(syslogCtx, vmConfig, stdoutFile, stderrFile)
-	}()
+	if taskConfig.hasSSH() {
+		if err := d.startLogStreaming(syslogCtx, cfg, vmConfig); err != nil {
+			cancel()
+			return nil, nil, err
+		}
+	}
+	if vmConfig.ConsolePath != "" {
+		consoleCtx, consoleCancel := context.WithCancel(syslogCtx)
+		h.consoleCancel = consoleCancel
+		go d.streamConsoleLog(consoleCtx, vmConfig.ConsolePath, cfg.StdoutPath)
+	}
 	d.tasks.Set(cfg.ID, h)
 	go h.run()
+	if taskConfig.hasSSH() {
+		go d.waitForReady(syslogCtx, h, vmConfig)
+	}
+	go d.monitorVM(syslogCtx, h, d.generateVMName(cfg.AllocID))
+
+	// The task is only reported started once the guest passes ready_when. A
+	// guest that never does is torn down, as Nomad has no handle to destroy.
+	if readyGate != nil {
+		if err := d.waitUntilReady(syslogCtx, readyGate, vmConfig); err != nil {
+			if destroyErr := d.DestroyTask(cfg.ID, true); destroyErr != nil {
+				d.logger.Warn("failed to destroy task that did not become ready", "error", destroyErr)
+			}
+			return nil, nil, err
+		}
+	}
+
+	metrics.IncrCounter(metricVMsStarted, 1)
+	d.emitActiveTasks()
 
 	// Return a driver handle
 	return handle, nil, nil
 }
 
 // RecoverTask recreates the in-memory state of a task from a TaskHandle.
-func (d *Driver) RecoverTask(h *drivers.TaskHandle) error {
-	if h == nil {
+func (d *Driver) RecoverTask(handle *drivers.TaskHandle) error {
+	if handle == nil {
 		return fmt.Errorf("error: handle cannot be nil")
 	}
 
-	if h.Version != taskHandleVersion {
-		return fmt.Errorf("error: incompatible handle version of %d", h.Version)
+	if handle.Version != taskHandleVersion {
+		return fmt.Errorf("error: incompatible handle version of %d", handle.Version)
 	}
 
 	var taskState TaskState
-	if err := h.GetDriverState(&taskState); err != nil {
+	if err := handle.GetDriverState(&taskState); err != nil {
 		return fmt.Errorf("failed to decode task state from handle: %v", err)
 	}
 
-	// Start the task with the previous configuration.
-	d.logger.Info("recovered tart task", "task_id", h.Config.ID)
-	_, _, err := d.StartTask(taskState.TaskConfig)
+	// The task config kept in the driver state loses its driver config when
+	// encoded, so use the one Nomad passes back in the handle.
+	cfg := handle.Config
+	if cfg == nil {
+		cfg = taskState.TaskConfig
+	}
+	if _, ok := d.tasks.Get(cfg.ID); ok {
+		return nil
+	}
+
+	var taskConfig TaskConfig
+	if err := cfg.DecodeDriverConfig(&taskConfig); err != nil {
+		return fmt.Errorf("failed to decode driver config: %v", err)
+	}
+	var runAs *user.User
+	if cfg.User != "" {
+		u, err := resolveRunAsUser(cfg.User)
+		if err != nil {
+			return err
+		}
+		runAs = u
+	}
+
+	// The VM is still running under the executor started for it, so reattach
+	// to that rather than setting the VM up again.
+	logger := d.logger.With("task_name", cfg.Name, "alloc_id", cfg.AllocID)
+	execImpl, pluginClient, err := d.reattachExecutor(taskState.ReattachConfig, logger, d.hostCompute())
 	if err != nil {
-		return fmt.Errorf("failed to start task: %v", err)
+		return fmt.Errorf("failed to reattach to executor: %v", err)
 	}
 
+	vmName := d.generateVMName(cfg.AllocID)
+	vmConfig := VMConfig{
+		TaskConfig:  taskConfig,
+		NomadConfig: cfg,
+		RunAs:       runAs,
+		TartHome:    taskState.TartHome,
+		EnvDenylist: d.config.EnvDenylist,
+	}
+	// Commands on the VM must find it in its tart home again, and be
+	// audited as the task's.
+	if client, ok := d.client.(*TartClient); ok {
+		if taskState.TartHome != "" {
+			client.homes.set(vmName, taskState.TartHome)
+		}
+		client.tasks.set(vmName, auditTask{AllocID: cfg.AllocID, TaskID: cfg.ID})
+	}
+
+	h := &taskHandle{
+		clock:            d.clock,
+		exec:             execImpl,
+		pluginClient:     pluginClient,
+		pid:              taskState.Pid,
+		taskConfig:       cfg,
+		state:            drivers.TaskStateRunning,
+		startedAt:        taskState.StartedAt,
+		logger:           d.logger,
+		doneCh:           make(chan struct{}),
+		shutdownExitCode: taskConfig.ShutdownExitCode,
+		pullDuration:     taskState.PullDuration,
+		macAddress:       taskState.MACAddress,
+		vmResources:      taskState.VMResources,
+		labels:           taskConfig.Labels,
+		tartHome:         taskState.TartHome,
+	}
+	if taskConfig.RestartVMOnCrash && len(taskState.StartArgs) > 0 {
+		h.relaunch = d.vmLauncher(cfg, vmConfig, taskState.StartArgs)
+		h.maxRestarts = taskConfig.MaxVMRestarts
+	}
+	if taskConfig.ExitCodeMarker {
+		h.exitMarkerPath = filepath.Join(cfg.TaskDir().LocalDir, exitMarkerFile)
+	}
+
+	syslogCtx, cancel := context.WithCancel(d.ctx)
+	h.syslogCancel = cancel
+	if taskConfig.hasSSH() {
+		if err := d.startLogStreaming(syslogCtx, cfg, vmConfig); err != nil {
+			cancel()
+			execImpl.Shutdown("", 0)
+			pluginClient.Kill()
+			return err
+		}
+	}
+	d.tasks.Set(cfg.ID, h)
+	go h.run()
+	go d.monitorVM(syslogCtx, h, vmName)
+
+	d.emitActiveTasks()
+	d.logger.Info("recovered tart task", "task_id", cfg.ID)
 	return nil
 }
 
+// vmLauncher returns a function running tart with args under a new executor.
+// It is kept on the handle to relaunch tart when restart_vm_on_crash is set.
+func (d *Driver) vmLauncher(cfg *drivers.TaskConfig, vmConfig VMConfig, args []string) func() (executor.Executor, *plugin.Client, int, error) {
+	execCmd := &executor.ExecCommand{
+		Cmd:              "tart",
+		Args:             args,
+		Env:              d.TartEnvList(vmConfig),
+		User:             cfg.User,
+		TaskDir:          cfg.TaskDir().Dir,
+		StdoutPath:       cfg.StdoutPath,
+		StderrPath:       cfg.StderrPath,
+		NetworkIsolation: cfg.NetworkIsolation,
+	}
+
+	logger := d.logger.With("task_name", cfg.Name, "alloc_id", cfg.AllocID)
+	return func() (executor.Executor, *plugin.Client, int, error) {
+		execImpl, pluginClient, err := d.createExecutor(logger, d.nomadConfig, d.executorConfig(cfg))
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("failed to create executor: %v", err)
+		}
+		ps, err := execImpl.Launch(execCmd)
+		if err != nil {
+			pluginClient.Kill()
+			return nil, nil, 0, fmt.Errorf("failed to launch VM: %v", err)
+		}
+		return execImpl, pluginClient, ps.Pid, nil
+	}
+}
+
 // WaitTask returns a channel used to notify Nomad when a task exits.
 func (d *Driver) WaitTask(ctx context.Context, taskID string) (<-chan *drivers.ExitResult, error) {
 	handle, ok := d.tasks.Get(taskID)
@@ -336,37 +855,182 @@ func (d *Driver) WaitTask(ctx context.Context, taskID string) (<-chan *drivers.E
 func (d *Driver) StopTask(taskID string, timeout time.Duration, signal string) error {
 	handle, ok := d.tasks.Get(taskID)
 	if !ok {
-		return drivers.ErrTaskNotFound
+		// A task still being set up has no VM to stop yet; cancelling its
+		// start aborts any image pull and StartTask then fails.
+		done, starting := d.starting.Cancel(taskID)
+		if !starting {
+			return drivers.ErrTaskNotFound
+		}
+		d.logger.Info("cancelled starting tart task", "task_id", taskID)
+
+		// StartTask may have launched the VM before it saw the
+		// cancellation, in which case the VM is stopped as usual.
+		<-done
+		if handle, ok = d.tasks.Get(taskID); !ok {
+			return nil
+		}
 	}
 
+	handle.markStopping()
 	allocVMName := d.generateVMName(handle.taskConfig.AllocID)
+	defer d.runPoststopHook(handle, allocVMName)
+
+	// The VM's processes are found up front as they can no longer be found
+	// by its disk image once the VM is deleted.
+	pids := d.vmProcesses(handle, allocVMName)
+	defer d.killLingeringProcesses(pids, allocVMName)
+
+	deadline := time.Now().Add(timeout)
+	graceful, force := splitStopTimeout(timeout)
 
 	// Attempt to gracefully stop the VM via the virtualizer
 	var taskConfig TaskConfig
 	if err := handle.taskConfig.DecodeDriverConfig(&taskConfig); err == nil {
-		if err := d.client.Stop(d.ctx, allocVMName, timeout); err != nil {
-			d.logger.Warn("failed to stop VM via virtualizer", "error", err)
-		}
+		d.teardownVM(handle.taskConfig, allocVMName, graceful)
+	}
 
-		if err := d.client.Delete(d.ctx, allocVMName); err != nil {
-			d.logger.Warn("failed to delete VM via virtualizer", "error", err)
-		}
+	// Never run past the overall deadline, however long the graceful stop took.
+	if remaining := time.Until(deadline); remaining < force {
+		force = max(remaining, 0)
 	}
 
-	if err := handle.exec.Shutdown(signal, timeout); err != nil {
-		if handle.pluginClient != nil && handle.pluginClient.Exited() {
+	exec, pluginClient, _ := handle.process()
+	if err := exec.Shutdown(signal, force); err != nil {
+		if pluginClient != nil && pluginClient.Exited() {
 			return nil
 		}
 		return fmt.Errorf("executor Shutdown failed: %v", err)
 	}
 
 	<-handle.doneCh
-	handle.pluginClient.Kill()
+	// The task can no longer be restarted, so this is the final plugin client.
+	_, pluginClient, _ = handle.process()
+	pluginClient.Kill()
 
+	metrics.IncrCounter(metricVMsStopped, 1)
+	d.emitActiveTasks()
 	d.logger.Info("stopped tart task", "task_id", taskID)
 	return nil
 }
 
+// abortStoppedStart fails a StartTask whose task was stopped while its VM was
+// being set up, deleting the VM cloned for it so none is left behind for a
+// task Nomad considers stopped.
+func (d *Driver) abortStoppedStart(startCtx context.Context, cfg *drivers.TaskConfig) error {
+	if startCtx.Err() == nil || d.ctx.Err() != nil {
+		return nil
+	}
+	vmName := d.generateVMName(cfg.AllocID)
+	if err := d.client.Delete(d.ctx, vmName); err != nil && !errors.Is(err, errVMNotFound) {
+		d.logger.Warn("failed to delete VM of stopped task", "name", vmName, "error", err)
+	}
+	return fmt.Errorf("task stopped while setting up its VM")
+}
+
+// teardownVM stops and deletes the task's VM, emitting a task event before
+// stopping and once the VM is deleted. Failures are logged rather than
+// returned so the executor is always shut down afterwards. A VM that is
+// already stopped or gone is what teardown is after, so those errors are only
+// logged at debug level.
+func (d *Driver) teardownVM(cfg *drivers.TaskConfig, vmName string, timeout time.Duration) {
+	d.emitVMEvent(cfg, "Stopping VM", vmName)
+	if err := d.client.Stop(d.ctx, vmName, timeout); errors.Is(err, errVMNotRunning) || errors.Is(err, errVMNotFound) {
+		d.logger.Debug("VM was already stopped", "name", vmName, "error", err)
+	} else if err != nil {
+		d.logger.Warn("failed to stop VM via virtualizer", "error", err)
+	}
+
+	if err := d.client.Delete(d.ctx, vmName); errors.Is(err, errVMNotFound) {
+		d.logger.Debug("VM was already deleted", "name", vmName, "error", err)
+	} else if err != nil {
+		d.logger.Warn("failed to delete VM via virtualizer", "error", err)
+		return
+	}
+	d.emitVMEvent(cfg, "VM deleted", vmName)
+}
+
+// vmProcesses returns the host processes backing the task's VM: tart itself
+// while the task runs, and those holding the VM's disk image open. The tart
+// PID of a task that already exited is left out as it may have been reused.
+func (d *Driver) vmProcesses(handle *taskHandle, vmName string) []int {
+	vmDir := vmPath(handle.tartHome, vmName)
+	if _, _, pid := handle.process(); handle.IsRunning() && pid > 0 {
+		return relatedPIDs(d.ctx, pid, vmDir)
+	}
+	return vmProcessPIDs(d.ctx, vmDir)
+}
+
+// killLingeringProcesses kills those of pids that survived stopping the VM
+// and shutting down the executor, such as a tart run or its
+// Virtualization.framework helper that would otherwise hold a VM slot.
+func (d *Driver) killLingeringProcesses(pids []int, vmName string) {
+	for _, pid := range pids {
+		// Signal 0 only checks that the process still exists.
+		if pid <= 0 || signalProcess(pid, 0) != nil {
+			continue
+		}
+		d.logger.Warn("killing process left running after the VM stopped", "vm_name", vmName, "pid", pid)
+		if err := signalProcess(pid, syscall.SIGKILL); err != nil && !errors.Is(err, syscall.ESRCH) {
+			d.logger.Warn("failed to kill lingering VM process", "vm_name", vmName, "pid", pid, "error", err)
+		}
+	}
+}
+
+// runPoststopHook runs the operator's poststop hook, if any, the first time
+// the task is stopped or destroyed. It runs whether or not the VM could be
+// stopped, and failures are only logged as the task is already gone.
+func (d *Driver) runPoststopHook(handle *taskHandle, vmName string) {
+	if d.poststopHook == nil {
+		return
+	}
+	handle.poststopOnce.Do(func() {
+		env := hookEnv(handle.taskConfig, vmName, d.config.EnvDenylist)
+		if err := d.poststopHook.run(d.ctx, env); err != nil {
+			handle.logger.Warn("poststop hook failed", "vm_name", vmName, "error", err)
+		}
+	})
+}
+
+// emitVMEvent emits a task event about the task's VM.
+func (d *Driver) emitVMEvent(cfg *drivers.TaskConfig, message, vmName string) {
+	d.eventer.EmitEvent(&drivers.TaskEvent{
+		TaskID:    cfg.ID,
+		TaskName:  cfg.Name,
+		AllocID:   cfg.AllocID,
+		Timestamp: time.Now(),
+		Message:   message,
+		Annotations: labelAnnotations(cfg, map[string]string{
+			"vm_name": vmName,
+		}),
+	})
+}
+
+// labelAnnotations adds the labels of the task's driver config to the
+// annotations of an event about it. Annotations set by the driver take
+// precedence over labels of the same name.
+func labelAnnotations(cfg *drivers.TaskConfig, annotations map[string]string) map[string]string {
+	var taskConfig TaskConfig
+	if err := cfg.DecodeDriverConfig(&taskConfig); err != nil {
+		return annotations
+	}
+	for k, v := range taskConfig.Labels {
+		if _, ok := annotations[k]; !ok {
+			annotations[k] = v
+		}
+	}
+	return annotations
+}
+
+// splitStopTimeout divides a stop timeout between the graceful VM shutdown and
+// the forced executor shutdown so that together they never exceed it.
+func splitStopTimeout(timeout time.Duration) (graceful, force time.Duration) {
+	if timeout <= 0 {
+		return 0, 0
+	}
+	graceful = time.Duration(float64(timeout) * gracefulStopShare)
+	return graceful, timeout - graceful
+}
+
 // DestroyTask cleans up and removes a task that has terminated.
 func (d *Driver) DestroyTask(taskID string, force bool) error {
 	handle, ok := d.tasks.Get(taskID)
@@ -378,14 +1042,33 @@ func (d *Driver) DestroyTask(taskID string, force bool) error {
 		return fmt.Errorf("cannot destroy running task")
 	}
 
-	if !handle.pluginClient.Exited() {
-		if err := handle.exec.Shutdown("", 0); err != nil {
+	handle.markStopping()
+	vmName := d.generateVMName(handle.taskConfig.AllocID)
+	pids := d.vmProcesses(handle, vmName)
+
+	// A forced destroy skips StopTask, so the VM would otherwise be left behind.
+	if handle.IsRunning() {
+		d.teardownVM(handle.taskConfig, vmName, forceDestroyStopTimeout)
+	}
+
+	if exec, pluginClient, _ := handle.process(); !pluginClient.Exited() {
+		if err := exec.Shutdown("", 0); err != nil {
 			handle.logger.Error("destroying executor failed", "error", err)
 		}
-		handle.pluginClient.Kill()
+		pluginClient.Kill()
 	}
 
+	handle.guestStats().close()
+	d.killLingeringProcesses(pids, vmName)
+	// The VM is normally forgotten once deleted; drop it here too so a VM
+	// that could not be deleted does not keep its tart home listed forever.
+	if client, ok := d.client.(*TartClient); ok {
+		client.forget(vmName)
+	}
+	removeScratchDisk(handle.taskConfig, d.logger)
+	d.runPoststopHook(handle, vmName)
 	d.tasks.Delete(taskID)
+	d.emitActiveTasks()
 	d.logger.Info("destroyed tart task", "task_id", taskID)
 	return nil
 }
@@ -397,7 +1080,85 @@ func (d *Driver) InspectTask(taskID string) (*drivers.TaskStatus, error) {
 		return nil, drivers.ErrTaskNotFound
 	}
 
-	return handle.TaskStatus(), nil
+	status := handle.TaskStatus()
+	if d.config != nil && d.config.VerboseInspect {
+		if raw, err := d.vmRecord(d.generateVMName(handle.taskConfig.AllocID)); err != nil {
+			d.logger.Warn("failed to read the VM's tart list record", "task_id", taskID, "error", err)
+		} else {
+			status.DriverAttributes["tart_raw"] = raw
+		}
+	}
+	return status, nil
+}
+
+// vmRecord returns the JSON tart list reports for vmName, compacted to a
+// single line.
+func (d *Driver) vmRecord(vmName string) (string, error) {
+	vms, err := d.client.List(d.ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, vm := range vms {
+		if vm.Name != vmName {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, vm.Raw); err != nil {
+			return "", fmt.Errorf("failed to compact VM record: %v", err)
+		}
+		return buf.String(), nil
+	}
+	return "", fmt.Errorf("VM %s is not listed", vmName)
+}
+
+// ListTasks returns the status of every task managed by the driver, combining
+// each handle's state with the VM state from a single `tart list`. The VM
+// state is reported in the "vm_state" driver attribute, or "missing" when the
+// VM no longer exists. Running tasks whose VM has disappeared or stopped are
+// ended the same way the VM monitor would end them.
+func (d *Driver) ListTasks(ctx context.Context) ([]*drivers.TaskStatus, error) {
+	vms, err := d.client.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list VMs: %v", err)
+	}
+
+	vmStates := make(map[string]VMState, len(vms))
+	for _, vm := range vms {
+		vmStates[vm.Name] = vm.Status
+	}
+
+	handles := d.tasks.List()
+	statuses := make([]*drivers.TaskStatus, 0, len(handles))
+	for _, h := range handles {
+		vmName := d.generateVMName(h.taskConfig.AllocID)
+		state, ok := vmStates[vmName]
+
+		if h.IsRunning() && (!ok || state == VMStateStopped) {
+			result := &drivers.ExitResult{}
+			if !ok {
+				result.ExitCode = 1
+				result.Err = fmt.Errorf("VM %s no longer exists", vmName)
+			}
+			d.endExitedVM(h, vmName, result)
+		}
+
+		// The status is built once the task is reconciled, so a task whose
+		// VM is gone is already reported as exited.
+		status := h.TaskStatus()
+		if ok {
+			// tart reports a frozen VM as running, so keep the handle's
+			// paused state in that case.
+			if !(h.IsPaused() && state == VMStateRunning) {
+				status.DriverAttributes["vm_state"] = string(state)
+			}
+		} else {
+			status.DriverAttributes["vm_state"] = vmStateMissing
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
 }
 
 // TaskStats returns a channel which the driver should send stats to at the given interval.
@@ -406,7 +1167,52 @@ func (d *Driver) TaskStats(ctx context.Context, taskID string, interval time.Dur
 	if !ok {
 		return nil, drivers.ErrTaskNotFound
 	}
-	return h.exec.Stats(ctx, interval)
+
+	exec, _, _ := h.process()
+	execCh, err := exec.Stats(ctx, interval)
+	if err != nil {
+		return nil, err
+	}
+
+	var taskConfig TaskConfig
+	if err := h.taskConfig.DecodeDriverConfig(&taskConfig); err != nil {
+		return nil, fmt.Errorf("failed to decode driver config: %v", err)
+	}
+
+	var sources statsSources
+	if taskConfig.GuestStats {
+		sources.guest = &VMConfig{
+			TaskConfig:   taskConfig,
+			NomadConfig:  h.taskConfig,
+			VMNamePrefix: d.vmNamePrefix,
+		}
+	}
+
+	ch := make(chan *drivers.TaskResourceUsage)
+	go d.collectStats(ctx, h, sources, execCh, ch)
+	return ch, nil
+}
+
+// TaskStatsSnapshot returns a single sample of the task's resource usage,
+// collected as TaskStats collects each of its samples, for one-off queries.
+func (d *Driver) TaskStatsSnapshot(taskID string) (*drivers.TaskResourceUsage, error) {
+	ctx, cancel := context.WithTimeout(d.ctx, statsSnapshotTimeout)
+	defer cancel()
+
+	ch, err := d.TaskStats(ctx, taskID, time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case usage, ok := <-ch:
+		if !ok || usage == nil {
+			return nil, fmt.Errorf("no stats received for task %s", taskID)
+		}
+		return usage, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("timed out waiting for stats of task %s", taskID)
+	}
 }
 
 // TaskEvents returns a channel that the plugin can use to emit task related events.
@@ -416,11 +1222,29 @@ func (d *Driver) TaskEvents(ctx context.Context) (<-chan *drivers.TaskEvent, err
 
 // SignalTask forwards a signal to a task.
 func (d *Driver) SignalTask(taskID string, signal string) error {
-	_, ok := d.tasks.Get(taskID)
+	handle, ok := d.tasks.Get(taskID)
 	if !ok {
 		return drivers.ErrTaskNotFound
 	}
 
+	vmName := d.generateVMName(handle.taskConfig.AllocID)
+	switch strings.ToUpper(strings.TrimSpace(signal)) {
+	case signalPause:
+		if err := d.client.Pause(d.ctx, vmName); err != nil {
+			return fmt.Errorf("failed to pause VM: %v", err)
+		}
+		handle.setPaused(true)
+		d.logger.Info("paused tart task", "task_id", taskID, "vm", vmName)
+		return nil
+	case signalResume:
+		if err := d.client.Resume(d.ctx, vmName); err != nil {
+			return fmt.Errorf("failed to resume VM: %v", err)
+		}
+		handle.setPaused(false)
+		d.logger.Info("resumed tart task", "task_id", taskID, "vm", vmName)
+		return nil
+	}
+
 	// TODO: Implement actual VM signaling logic
 	d.logger.Info("signaling tart task", "task_id", taskID, "signal", signal)
 	return nil
@@ -455,19 +1279,24 @@ func (d *Driver) ExecTaskStreaming(ctx context.Context, taskID string, opts *dri
 	if err := handle.taskConfig.DecodeDriverConfig(&taskCfg); err != nil {
 		return nil, fmt.Errorf("failed to decode driver config: %v", err)
 	}
+	if !taskCfg.hasSSH() {
+		return nil, fmt.Errorf("exec requires ssh_user and ssh_password in the task's config")
+	}
 
 	execOptions := ExecOptions{
-		Command:  opts.Command,
-		Tty:      opts.Tty,
-		Stdin:    opts.Stdin,
-		Stdout:   opts.Stdout,
-		Stderr:   opts.Stderr,
-		ResizeCh: opts.ResizeCh,
+		Command:      opts.Command,
+		Tty:          opts.Tty,
+		Stdin:        opts.Stdin,
+		Stdout:       opts.Stdout,
+		Stderr:       opts.Stderr,
+		ResizeCh:     opts.ResizeCh,
+		AgentForward: taskCfg.SSHAgentForward,
 	}
 
 	vmConfig := VMConfig{
-		TaskConfig:  taskCfg,
-		NomadConfig: handle.taskConfig,
+		TaskConfig:   taskCfg,
+		NomadConfig:  handle.taskConfig,
+		VMNamePrefix: d.vmNamePrefix,
 	}
 
 	exitCode, err := d.client.Exec(ctx, vmConfig, execOptions)
@@ -478,17 +1307,169 @@ func (d *Driver) ExecTaskStreaming(ctx context.Context, taskID string, opts *dri
 	return &drivers.ExitResult{ExitCode: exitCode}, nil
 }
 
+// executorConfig returns the configuration for the task's executor plugin,
+// honoring the configured log level and log directory.
+func (d *Driver) executorConfig(cfg *drivers.TaskConfig) *executor.ExecutorConfig {
+	logFile := filepath.Join(cfg.TaskDir().Dir, "executor.out")
+	if d.config.ExecutorLogDir != "" {
+		logFile = filepath.Join(d.config.ExecutorLogDir, fmt.Sprintf("%s-%s-executor.out", cfg.AllocID, cfg.Name))
+	}
+
+	logLevel := d.config.ExecutorLogLevel
+	if logLevel == "" {
+		logLevel = defaultExecutorLogLevel
+	}
+
+	return &executor.ExecutorConfig{
+		LogFile:  logFile,
+		LogLevel: logLevel,
+	}
+}
+
+// emitDownloadComplete emits the task event marking the end of an image pull,
+// annotated with how long the pull took.
+func (d *Driver) emitDownloadComplete(cfg *drivers.TaskConfig, url string, pullDuration time.Duration) {
+	d.eventer.EmitEvent(&drivers.TaskEvent{
+		TaskID:    cfg.ID,
+		TaskName:  cfg.Name,
+		AllocID:   cfg.AllocID,
+		Timestamp: time.Now(),
+		Message:   "VM image download complete",
+		Annotations: labelAnnotations(cfg, map[string]string{
+			"url":              redact(url),
+			"pull_duration_ms": fmt.Sprintf("%d", pullDuration.Milliseconds()),
+		}),
+	})
+}
+
+// setupVM runs the virtualizer Setup while holding a slot from the setup
+// semaphore, queuing behind other in-flight setups when the configured limit
+// has been reached.
+func (d *Driver) setupVM(ctx context.Context, vmConfig VMConfig) (SetupResult, error) {
+	release, err := d.acquireSetupSlot(ctx)
+	if err != nil {
+		return SetupResult{}, err
+	}
+	defer release()
+
+	result, err := d.client.Setup(ctx, vmConfig)
+	if err != nil {
+		metrics.IncrCounter(metricSetupFailures, 1)
+	}
+	return result, err
+}
+
+// acquireSetupSlot blocks until fewer than max_concurrent_setups image pulls
+// and setups are running, returning a function that frees the slot again.
+func (d *Driver) acquireSetupSlot(ctx context.Context) (func(), error) {
+	sem := d.setupSem
+	if sem == nil {
+		return func() {}, nil
+	}
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// waitForReady records when the VM first becomes reachable over SSH so the
+// boot duration can be reported in the task status, then sets the guest's
+// hostname and runs its boot script.
+func (d *Driver) waitForReady(ctx context.Context, h *taskHandle, vmConfig VMConfig) {
+	if err := d.client.WaitForSSH(ctx, vmConfig); err != nil {
+		d.logger.Debug("VM did not become reachable over SSH", "error", err)
+		return
+	}
+	h.setReady(time.Now())
+
+	// The guest's syslog covers everything from here on.
+	if h.consoleCancel != nil {
+		h.consoleCancel()
+	}
+
+	if err := d.setHostname(ctx, vmConfig); err != nil {
+		d.logger.Warn("failed to set VM hostname", "error", err)
+	}
+
+	if h.bootScriptPath != "" {
+		d.runBootScript(ctx, h, vmConfig)
+	}
+}
+
+// generateVMName returns the name of the VM backing the allocation.
 func (d *Driver) generateVMName(allocationID string) string {
-	return fmt.Sprintf("nomad-%s", allocationID)
+	return vmNameFor(d.vmNamePrefix, allocationID)
+}
+
+// retryBackoff bounds an exponential backoff between reconnection attempts.
+type retryBackoff struct {
+	// initial and max bound the delay before the next attempt
+	initial time.Duration
+	max     time.Duration
+
+	// stable is how long a connection must have lasted before the delay
+	// starts over from initial
+	stable time.Duration
+}
+
+// next returns how long to wait before the next attempt when the backoff is
+// cur, along with the backoff for the attempt after that. The wait is drawn
+// at random between half of cur and cur, so streams that dropped together,
+// e.g. for many allocations started at once, do not reconnect in lockstep.
+func (b retryBackoff) next(cur time.Duration) (wait, following time.Duration) {
+	wait = cur
+	if half := cur / 2; half > 0 {
+		wait = half + rand.N(cur-half+1)
+	}
+
+	following = cur * 2
+	if following > b.max {
+		following = b.max
+	}
+	return wait, following
+}
+
+// startLogStreaming streams the guest's syslog into the task's stdout and
+// stderr files until ctx is cancelled. Recovered tasks go through StartTask,
+// so their VM's logs are streamed again after an agent restart.
+func (d *Driver) startLogStreaming(ctx context.Context, cfg *drivers.TaskConfig, vmConfig VMConfig) error {
+	stdoutFile, err := os.OpenFile(cfg.StdoutPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open stdout file: %v", err)
+	}
+
+	stderrFile, err := os.OpenFile(cfg.StderrPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		stdoutFile.Close()
+		return fmt.Errorf("failed to open stderr file: %v", err)
+	}
+
+	d.logger.Trace("Starting log streaming", "stdout_path", cfg.StdoutPath, "stderr_path", cfg.StderrPath)
+
+	// Start the streaming in a goroutine that handles file closing
+	go func() {
+		defer stdoutFile.Close()
+		defer stderrFile.Close()
+
+		// Run syslog streaming with retry/backoff until it connects or context cancels
+		d.streamSyslogWithRetry(ctx, vmConfig, stdoutFile, stderrFile)
+	}()
+	return nil
 }
 
-// streamSyslogWithRetry attempts to start syslog streaming inside the VM using
-// SSH and retries with exponential backoff until it succeeds or the context is
-// cancelled. It can take a little while for the VM to become responsive so retrying
-// is critical to making this reliant.
+// defaultSyslogRetry is the backoff used to re-establish the syslog stream.
+var defaultSyslogRetry = retryBackoff{initial: 1 * time.Second, max: 10 * time.Second, stable: 30 * time.Second}
+
+// streamSyslogWithRetry streams syslog from inside the VM over SSH until the
+// context is cancelled. It can take a little while for the VM to become
+// responsive, and the stream drops whenever the VM reboots or the network
+// blips, so it is re-established with jittered exponential backoff each time
+// it ends.
+// The backoff starts over once a stream has stayed up for a while.
 func (d *Driver) streamSyslogWithRetry(ctx context.Context, vmConfig VMConfig, stdout, stderr io.WriteCloser) {
-	backoff := 1 * time.Second
-	maxBackoff := 10 * time.Second
+	backoff := d.syslogRetry.initial
 
 	for {
 		// allow cancellation between attempts
@@ -499,42 +1480,84 @@ func (d *Driver) streamSyslogWithRetry(ctx context.Context, vmConfig VMConfig, s
 		}
 
 		// Attempt to start log streaming over SSH
-		_, err := d.client.Exec(ctx, vmConfig, ExecOptions{
+		started := d.clock.Now()
+		exitCode, err := d.client.Exec(ctx, vmConfig, ExecOptions{
 			Command: []string{"/usr/bin/log", "stream", "--style", "syslog", "--level=info"},
 			Stdout:  stdout,
 			Stderr:  stderr,
 			Tty:     false,
 		})
 
-		if err != nil {
-			// Check if we should give up due to cancellation
-			select {
-			case <-ctx.Done():
-				return
-			default:
-			}
+		// Check if we should give up due to cancellation
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
 
-			d.logger.Warn("Log streaming failed; will retry", "error", err)
-			time.Sleep(backoff)
-			if backoff < maxBackoff {
-				backoff *= 2
-				if backoff > maxBackoff {
-					backoff = maxBackoff
-				}
-			}
-			continue
+		if d.clock.Now().Sub(started) >= d.syslogRetry.stable {
+			backoff = d.syslogRetry.initial
 		}
 
-		// Exec returned without error; streaming ended or succeeded then exited.
-		return
+		var wait time.Duration
+		wait, backoff = d.syslogRetry.next(backoff)
+
+		if err != nil {
+			d.logger.Warn("Log streaming failed; will retry", "error", err, "backoff", wait)
+		} else {
+			// The stream ended without an SSH error, e.g. log was killed when
+			// the guest rebooted.
+			d.logger.Debug("Log streaming ended; reconnecting", "exit_code", exitCode, "backoff", wait)
+		}
+
+		if err := d.clock.Sleep(ctx, wait); err != nil {
+			return
+		}
 	}
 }
 
-func (d *Driver) TartEnvList(tc *drivers.TaskConfig) []string {
+// TartEnvList returns the environment tart runs with for the VM described by
+// vmConfig.
+func (d *Driver) TartEnvList(vmConfig VMConfig) []string {
 	// Patch the env list to include the homebrew paths to help tart
 	// find other binaries (like softnet) as needed.
-	list := tc.EnvList()
+	list := filterEnv(vmConfig.NomadConfig.EnvList(), vmConfig.EnvDenylist)
 	list = append(list, "PATH=/opt/homebrew/bin:/opt/homebrew/sbin")
 
+	if vmConfig.RunAs != nil {
+		list = append(list, "HOME="+vmConfig.RunAs.HomeDir)
+	}
+	if vmConfig.TartHome != "" {
+		list = append(list, "TART_HOME="+vmConfig.TartHome)
+	}
+
 	return list
 }
+
+// pullConcurrencyFor picks how many layers tart downloads in parallel for a
+// task: the task's pull_concurrency, then the plugin's. Zero leaves tart's
+// default.
+func (d *Driver) pullConcurrencyFor(taskConfig TaskConfig) int {
+	if taskConfig.PullConcurrency > 0 {
+		return taskConfig.PullConcurrency
+	}
+	if d.config != nil {
+		return d.config.PullConcurrency
+	}
+	return 0
+}
+
+// tartHomeFor picks the tart home of a task's VM: the task's tart_home, then
+// the tart home of the user the task runs as, then the plugin's tart_home. It
+// returns an empty string when tart should use the agent's own home.
+func (d *Driver) tartHomeFor(taskConfig TaskConfig, runAs *user.User) string {
+	switch {
+	case taskConfig.TartHome != "":
+		return taskConfig.TartHome
+	case runAs != nil:
+		return userTartHome(runAs)
+	case d.config != nil:
+		return d.config.TartHome
+	}
+	return ""
+}
@@ -0,0 +1,1871 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	osexec "os/exec"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	plugin "github.com/hashicorp/go-plugin"
+	"github.com/hashicorp/nomad/client/lib/cpustats"
+	"github.com/hashicorp/nomad/drivers/shared/executor"
+	"github.com/hashicorp/nomad/plugins/base"
+	"github.com/hashicorp/nomad/plugins/drivers"
+	pstructs "github.com/hashicorp/nomad/plugins/shared/structs"
+)
+
+// fakeClient is a VirtualizationClient whose behavior is customized per test
+// through the function fields. Methods without a configured function succeed
+// and return zero values.
+type fakeClient struct {
+	availableFn          func(ctx context.Context) (string, error)
+	setupFn              func(ctx context.Context, config VMConfig) (SetupResult, error)
+	startFn              func(ctx context.Context, vmName string, headless bool) (int, error)
+	stopFn               func(ctx context.Context, vmName string, timeout time.Duration) error
+	pauseFn              func(ctx context.Context, vmName string) error
+	resumeFn             func(ctx context.Context, vmName string) error
+	statusFn             func(ctx context.Context, vmName string) (VMState, error)
+	deleteFn             func(ctx context.Context, vmName string) error
+	renameFn             func(ctx context.Context, oldName, newName string) error
+	pullFn               func(ctx context.Context, image, tartHome string, concurrency int) error
+	listFn               func(ctx context.Context) ([]VMInfo, error)
+	execFn               func(ctx context.Context, config VMConfig, opts ExecOptions) (int, error)
+	dialGuestFn          func(ctx context.Context, config VMConfig) (GuestConn, error)
+	waitForSSHFn         func(ctx context.Context, config VMConfig) error
+	buildStartArgsFn     func(config VMConfig) ([]string, error)
+	needsImageDownloadFn func(ctx context.Context, config VMConfig) (bool, error)
+	macAddressFn         func(ctx context.Context, vmName string) (string, error)
+	listImagesFn         func(ctx context.Context) ([]ImageInfo, error)
+	vmResourcesFn        func(ctx context.Context, vmName string) (VMResources, error)
+}
+
+func (f *fakeClient) Available(ctx context.Context) (string, error) {
+	if f.availableFn != nil {
+		return f.availableFn(ctx)
+	}
+	return "", nil
+}
+
+func (f *fakeClient) Setup(ctx context.Context, config VMConfig) (SetupResult, error) {
+	if f.setupFn != nil {
+		return f.setupFn(ctx, config)
+	}
+	return SetupResult{}, nil
+}
+
+func (f *fakeClient) Start(ctx context.Context, vmName string, headless bool) (int, error) {
+	if f.startFn != nil {
+		return f.startFn(ctx, vmName, headless)
+	}
+	return 0, nil
+}
+
+func (f *fakeClient) Stop(ctx context.Context, vmName string, timeout time.Duration) error {
+	if f.stopFn != nil {
+		return f.stopFn(ctx, vmName, timeout)
+	}
+	return nil
+}
+
+func (f *fakeClient) Pause(ctx context.Context, vmName string) error {
+	if f.pauseFn != nil {
+		return f.pauseFn(ctx, vmName)
+	}
+	return nil
+}
+
+func (f *fakeClient) Resume(ctx context.Context, vmName string) error {
+	if f.resumeFn != nil {
+		return f.resumeFn(ctx, vmName)
+	}
+	return nil
+}
+
+func (f *fakeClient) Status(ctx context.Context, vmName string) (VMState, error) {
+	if f.statusFn != nil {
+		return f.statusFn(ctx, vmName)
+	}
+	return VMStateRunning, nil
+}
+
+func (f *fakeClient) Delete(ctx context.Context, vmName string) error {
+	if f.deleteFn != nil {
+		return f.deleteFn(ctx, vmName)
+	}
+	return nil
+}
+
+func (f *fakeClient) Rename(ctx context.Context, oldName, newName string) error {
+	if f.renameFn != nil {
+		return f.renameFn(ctx, oldName, newName)
+	}
+	return nil
+}
+
+func (f *fakeClient) Pull(ctx context.Context, image, tartHome string, concurrency int) error {
+	if f.pullFn != nil {
+		return f.pullFn(ctx, image, tartHome, concurrency)
+	}
+	return nil
+}
+
+func (f *fakeClient) List(ctx context.Context) ([]VMInfo, error) {
+	if f.listFn != nil {
+		return f.listFn(ctx)
+	}
+	return nil, nil
+}
+
+func (f *fakeClient) Exec(ctx context.Context, config VMConfig, opts ExecOptions) (int, error) {
+	if f.execFn != nil {
+		return f.execFn(ctx, config, opts)
+	}
+	return 0, nil
+}
+
+func (f *fakeClient) DialGuest(ctx context.Context, config VMConfig) (GuestConn, error) {
+	if f.dialGuestFn != nil {
+		return f.dialGuestFn(ctx, config)
+	}
+	return nil, errors.New("guest connections are not faked")
+}
+
+func (f *fakeClient) WaitForSSH(ctx context.Context, config VMConfig) error {
+	if f.waitForSSHFn != nil {
+		return f.waitForSSHFn(ctx, config)
+	}
+	return nil
+}
+
+func (f *fakeClient) BuildStartArgs(config VMConfig) ([]string, error) {
+	if f.buildStartArgsFn != nil {
+		return f.buildStartArgsFn(config)
+	}
+	return []string{"run", "vm"}, nil
+}
+
+func (f *fakeClient) NeedsImageDownload(ctx context.Context, config VMConfig) (bool, error) {
+	if f.needsImageDownloadFn != nil {
+		return f.needsImageDownloadFn(ctx, config)
+	}
+	return false, nil
+}
+
+func (f *fakeClient) MACAddress(ctx context.Context, vmName string) (string, error) {
+	if f.macAddressFn != nil {
+		return f.macAddressFn(ctx, vmName)
+	}
+	return "", nil
+}
+
+func (f *fakeClient) VMResources(ctx context.Context, vmName string) (VMResources, error) {
+	if f.vmResourcesFn != nil {
+		return f.vmResourcesFn(ctx, vmName)
+	}
+	return VMResources{}, nil
+}
+
+func (f *fakeClient) ListImages(ctx context.Context) ([]ImageInfo, error) {
+	if f.listImagesFn != nil {
+		return f.listImagesFn(ctx)
+	}
+	return nil, nil
+}
+
+// nopWriteCloser adds a no-op Close to an io.Writer.
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// newTestDriver returns a Driver wired to the provided client with a no-op
+// logger, suitable for exercising driver logic without tart installed.
+func newTestDriver(t *testing.T, client VirtualizationClient) *Driver {
+	t.Helper()
+	d := NewTartDriverWithClient(testLogger(t), client).(*Driver)
+	t.Cleanup(d.signalShutdown)
+	return d
+}
+
+// pluginConfig encodes cfg the way Nomad passes it to SetConfig.
+func pluginConfig(t *testing.T, cfg *Config) *base.Config {
+	t.Helper()
+	var data []byte
+	if err := base.MsgPackEncode(&data, cfg); err != nil {
+		t.Fatalf("failed to encode plugin config: %v", err)
+	}
+	return &base.Config{PluginConfig: data}
+}
+
+func TestSetupVM_ConcurrencyLimitSerializesSetups(t *testing.T) {
+	var mu sync.Mutex
+	var inFlight, maxInFlight int
+
+	client := &fakeClient{
+		setupFn: func(ctx context.Context, config VMConfig) (SetupResult, error) {
+			mu.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			mu.Unlock()
+
+			time.Sleep(50 * time.Millisecond)
+
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+			return SetupResult{VMName: "vm"}, nil
+		},
+	}
+
+	d := newTestDriver(t, client)
+	d.setupSem = make(chan struct{}, 1)
+
+	var wg sync.WaitGroup
+	for _, id := range []string{"alloc-1", "alloc-2"} {
+		wg.Add(1)
+		go func(allocID string) {
+			defer wg.Done()
+			vmc := VMConfig{NomadConfig: &drivers.TaskConfig{AllocID: allocID}}
+			if _, err := d.setupVM(context.Background(), vmc); err != nil {
+				t.Errorf("setupVM returned error: %v", err)
+			}
+		}(id)
+	}
+	wg.Wait()
+
+	if maxInFlight != 1 {
+		t.Fatalf("expected setups to be serialized, saw %d in flight", maxInFlight)
+	}
+}
+
+func TestSetupVM_HonorsContextWhileQueued(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{})
+	d.setupSem = make(chan struct{}, 1)
+	d.setupSem <- struct{}{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := d.setupVM(ctx, VMConfig{}); err == nil {
+		t.Fatalf("expected an error when the context expires while queued")
+	}
+}
+
+func TestSignalTask_PauseAndResume(t *testing.T) {
+	var paused, resumed []string
+	client := &fakeClient{
+		pauseFn: func(ctx context.Context, vmName string) error {
+			paused = append(paused, vmName)
+			return nil
+		},
+		resumeFn: func(ctx context.Context, vmName string) error {
+			resumed = append(resumed, vmName)
+			return nil
+		},
+	}
+	d := newTestDriver(t, client)
+
+	h := &taskHandle{
+		taskConfig: &drivers.TaskConfig{ID: "task-1", AllocID: "alloc-1"},
+		state:      drivers.TaskStateRunning,
+	}
+	d.tasks.Set("task-1", h)
+
+	if err := d.SignalTask("task-1", "PAUSE"); err != nil {
+		t.Fatalf("SignalTask(PAUSE) returned error: %v", err)
+	}
+	if len(paused) != 1 || paused[0] != "nomad-alloc-1" {
+		t.Fatalf("expected the VM to be paused, got %v", paused)
+	}
+	if !h.IsPaused() {
+		t.Fatalf("expected the handle to be paused")
+	}
+	if got := h.TaskStatus().DriverAttributes["vm_state"]; got != string(VMStatePaused) {
+		t.Fatalf("unexpected vm_state: %q", got)
+	}
+
+	if err := d.SignalTask("task-1", "CONT"); err != nil {
+		t.Fatalf("SignalTask(CONT) returned error: %v", err)
+	}
+	if len(resumed) != 1 || h.IsPaused() {
+		t.Fatalf("expected the VM to be resumed, resumed=%v paused=%v", resumed, h.IsPaused())
+	}
+}
+
+func TestSignalTask_PauseFailureLeavesHandleRunning(t *testing.T) {
+	client := &fakeClient{
+		pauseFn: func(ctx context.Context, vmName string) error {
+			return errors.New("no running processes")
+		},
+	}
+	d := newTestDriver(t, client)
+
+	h := &taskHandle{
+		taskConfig: &drivers.TaskConfig{ID: "task-1", AllocID: "alloc-1"},
+		state:      drivers.TaskStateRunning,
+	}
+	d.tasks.Set("task-1", h)
+
+	if err := d.SignalTask("task-1", "PAUSE"); err == nil {
+		t.Fatalf("expected an error when pausing fails")
+	}
+	if h.IsPaused() {
+		t.Fatalf("handle should not be marked paused when pausing fails")
+	}
+}
+
+func TestStartTask_RejectsInvalidSoftnetConfig(t *testing.T) {
+	client := &fakeClient{
+		setupFn: func(ctx context.Context, config VMConfig) (SetupResult, error) {
+			t.Fatalf("setup should not run with an invalid network config")
+			return SetupResult{}, nil
+		},
+	}
+	d := newTestDriver(t, client)
+
+	cfg := &drivers.TaskConfig{ID: "task-1", Name: "vm", AllocID: "alloc-1"}
+	taskConfig := TaskConfig{
+		URL:     "ghcr.io/org/img:latest",
+		Network: &NetworkConfig{SoftnetAllow: []string{"192.168.0/24"}},
+	}
+	if err := cfg.EncodeConcreteDriverConfig(&taskConfig); err != nil {
+		t.Fatalf("failed to encode task config: %v", err)
+	}
+
+	_, _, err := d.StartTask(cfg)
+	if err == nil || !strings.Contains(err.Error(), "192.168.0/24") {
+		t.Fatalf("expected an error naming the bad CIDR, got: %v", err)
+	}
+}
+
+func TestStartTask_InvalidConfigFailsBeforeAnyWork(t *testing.T) {
+	client := &fakeClient{
+		listFn: func(ctx context.Context) ([]VMInfo, error) {
+			t.Fatalf("VMs should not be listed for an invalid config")
+			return nil, nil
+		},
+		needsImageDownloadFn: func(ctx context.Context, config VMConfig) (bool, error) {
+			t.Fatalf("the image should not be looked up for an invalid config")
+			return false, nil
+		},
+		setupFn: func(ctx context.Context, config VMConfig) (SetupResult, error) {
+			t.Fatalf("setup should not run for an invalid config")
+			return SetupResult{}, nil
+		},
+	}
+	d := newTestDriver(t, client)
+	if err := d.SetConfig(pluginConfig(t, &Config{})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+	d.createExecutor = func(hclog.Logger, *base.ClientDriverConfig, *executor.ExecutorConfig) (executor.Executor, *plugin.Client, error) {
+		t.Fatalf("no executor should be created for an invalid config")
+		return nil, nil, nil
+	}
+
+	url := "ghcr.io/org/img:latest"
+	cases := map[string]TaskConfig{
+		"no image":                  {},
+		"negative disk size":        {URL: url, DiskSize: -10},
+		"bridged without interface": {URL: url, Network: &NetworkConfig{Mode: "bridged"}},
+		"directory without path":    {URL: url, Directories: []DirectoryMount{{Name: "src"}}},
+		"hostname without ssh":      {URL: url, Hostname: "builder"},
+	}
+	for name, taskConfig := range cases {
+		t.Run(name, func(t *testing.T) {
+			cfg := &drivers.TaskConfig{ID: "task-1", Name: "vm", AllocID: "alloc-1"}
+			if err := cfg.EncodeConcreteDriverConfig(&taskConfig); err != nil {
+				t.Fatalf("failed to encode task config: %v", err)
+			}
+			if _, _, err := d.StartTask(cfg); err == nil {
+				t.Fatalf("expected StartTask to reject the config")
+			}
+		})
+	}
+}
+
+func TestStopTask_CancelsInFlightSetup(t *testing.T) {
+	cloning := make(chan struct{})
+	aborted := make(chan struct{})
+	client := &fakeClient{
+		setupFn: func(ctx context.Context, config VMConfig) (SetupResult, error) {
+			// Stand in for a clone of a huge image that only ends when
+			// its context is cancelled.
+			close(cloning)
+			<-ctx.Done()
+			close(aborted)
+			return SetupResult{}, ctx.Err()
+		},
+	}
+	d := newTestDriver(t, client)
+	if err := d.SetConfig(pluginConfig(t, &Config{})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+
+	cfg := &drivers.TaskConfig{ID: "task-1", Name: "vm", AllocID: "alloc-1"}
+	if err := cfg.EncodeConcreteDriverConfig(&TaskConfig{URL: "ghcr.io/org/img:latest"}); err != nil {
+		t.Fatalf("failed to encode task config: %v", err)
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		_, _, err := d.StartTask(cfg)
+		errCh <- err
+	}()
+
+	select {
+	case <-cloning:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("setup did not start")
+	}
+	if err := d.StopTask(cfg.ID, time.Second, "SIGINT"); err != nil {
+		t.Fatalf("StopTask returned error: %v", err)
+	}
+
+	select {
+	case <-aborted:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("stopping the task did not abort the clone")
+	}
+	select {
+	case err := <-errCh:
+		if err == nil || !strings.Contains(err.Error(), "task stopped while setting up its VM") {
+			t.Fatalf("expected StartTask to fail as stopped, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("StartTask did not return after the task was stopped")
+	}
+	if err := d.StopTask(cfg.ID, time.Second, "SIGINT"); err != drivers.ErrTaskNotFound {
+		t.Fatalf("expected the task to be forgotten once StartTask returned, got %v", err)
+	}
+}
+
+func TestInspectTask_VerboseIncludesTartRecord(t *testing.T) {
+	listed, err := parseVMList([]byte(`[
+		{"Name": "nomad-alloc-1", "State": "running", "Source": "local", "Disk": 50, "Size": 21, "SizeOnDisk": 9, "Accessed": "2025-01-01T00:00:00Z"},
+		{"Name": "nomad-alloc-2", "State": "stopped", "Source": "local"}
+	]`))
+	if err != nil {
+		t.Fatalf("parseVMList returned error: %v", err)
+	}
+	var listCalls int
+	client := &fakeClient{
+		listFn: func(ctx context.Context) ([]VMInfo, error) {
+			listCalls++
+			vms := make([]VMInfo, len(listed))
+			for i, vm := range listed {
+				vms[i] = VMInfo{Name: vm.Name, Status: convertTartStatus(vm.State), Raw: vm.Raw}
+			}
+			return vms, nil
+		},
+	}
+	d := newTestDriver(t, client)
+	h := &taskHandle{
+		exec:       newFakeExecutor(),
+		taskConfig: &drivers.TaskConfig{ID: "task-1", AllocID: "alloc-1"},
+		state:      drivers.TaskStateRunning,
+		logger:     d.logger,
+	}
+	d.tasks.Set(h.taskConfig.ID, h)
+
+	status, err := d.InspectTask("task-1")
+	if err != nil {
+		t.Fatalf("InspectTask returned error: %v", err)
+	}
+	if _, ok := status.DriverAttributes["tart_raw"]; ok || listCalls != 0 {
+		t.Fatalf("expected no tart list record without verbose_inspect, got %q after %d lists", status.DriverAttributes["tart_raw"], listCalls)
+	}
+
+	if err := d.SetConfig(pluginConfig(t, &Config{VerboseInspect: true})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+	status, err = d.InspectTask("task-1")
+	if err != nil {
+		t.Fatalf("InspectTask returned error: %v", err)
+	}
+	want := `{"Name":"nomad-alloc-1","State":"running","Source":"local","Disk":50,"Size":21,"SizeOnDisk":9,"Accessed":"2025-01-01T00:00:00Z"}`
+	if got := status.DriverAttributes["tart_raw"]; got != want {
+		t.Fatalf("expected tart_raw %s, got %s", want, got)
+	}
+}
+
+func TestStopTask_AfterSetupDeletesVMInsteadOfLaunching(t *testing.T) {
+	var d *Driver
+	stopErr := make(chan error, 1)
+	var deleted []string
+	client := &fakeClient{
+		macAddressFn: func(ctx context.Context, vmName string) (string, error) {
+			// The stop arrives once the VM is cloned but before it is
+			// launched.
+			go func() { stopErr <- d.StopTask("task-1", time.Second, "SIGINT") }()
+			<-ctx.Done()
+			return "", ctx.Err()
+		},
+		deleteFn: func(ctx context.Context, vmName string) error {
+			deleted = append(deleted, vmName)
+			return nil
+		},
+	}
+	d = newTestDriver(t, client)
+	if err := d.SetConfig(pluginConfig(t, &Config{})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+	d.createExecutor = func(hclog.Logger, *base.ClientDriverConfig, *executor.ExecutorConfig) (executor.Executor, *plugin.Client, error) {
+		t.Fatalf("no executor should be created for a stopped task")
+		return nil, nil, nil
+	}
+
+	cfg := &drivers.TaskConfig{ID: "task-1", Name: "vm", AllocID: "alloc-1"}
+	if err := cfg.EncodeConcreteDriverConfig(&TaskConfig{URL: "ghcr.io/org/img:latest"}); err != nil {
+		t.Fatalf("failed to encode task config: %v", err)
+	}
+	if _, _, err := d.StartTask(cfg); err == nil || !strings.Contains(err.Error(), "task stopped while setting up its VM") {
+		t.Fatalf("expected StartTask to fail as stopped, got %v", err)
+	}
+	select {
+	case err := <-stopErr:
+		if err != nil {
+			t.Fatalf("StopTask returned error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("StopTask did not return once StartTask had")
+	}
+	if len(deleted) != 1 || deleted[0] != "nomad-alloc-1" {
+		t.Fatalf("expected the cloned VM to be deleted, got %v", deleted)
+	}
+	if _, ok := d.tasks.Get(cfg.ID); ok {
+		t.Fatalf("expected no handle for the stopped task")
+	}
+}
+
+func TestListTasks_ReconcilesMissingVMs(t *testing.T) {
+	var listCalls int
+	client := &fakeClient{
+		listFn: func(ctx context.Context) ([]VMInfo, error) {
+			listCalls++
+			return []VMInfo{
+				{Name: "nomad-alloc-running", Status: VMStateRunning},
+				{Name: "nomad-alloc-paused", Status: VMStateRunning},
+				{Name: "nomad-alloc-stopped", Status: VMStateStopped},
+			}, nil
+		},
+		statusFn: func(ctx context.Context, vmName string) (VMState, error) {
+			t.Fatalf("ListTasks should not query VMs individually")
+			return "", nil
+		},
+	}
+	d := newTestDriver(t, client)
+
+	handles := map[string]*taskHandle{}
+	for _, allocID := range []string{"alloc-running", "alloc-paused", "alloc-stopped", "alloc-missing"} {
+		h := &taskHandle{
+			exec:       newFakeExecutor(),
+			taskConfig: &drivers.TaskConfig{ID: allocID + "-task", AllocID: allocID},
+			state:      drivers.TaskStateRunning,
+			logger:     d.logger,
+		}
+		handles[allocID] = h
+		d.tasks.Set(h.taskConfig.ID, h)
+	}
+	handles["alloc-paused"].setPaused(true)
+
+	statuses, err := d.ListTasks(context.Background())
+	if err != nil {
+		t.Fatalf("ListTasks returned error: %v", err)
+	}
+	if listCalls != 1 {
+		t.Fatalf("expected a single tart list, got %d", listCalls)
+	}
+	if len(statuses) != 4 {
+		t.Fatalf("expected 4 statuses, got %d", len(statuses))
+	}
+
+	got := map[string]string{}
+	byID := map[string]*drivers.TaskStatus{}
+	for _, st := range statuses {
+		got[st.ID] = st.DriverAttributes["vm_state"]
+		byID[st.ID] = st
+	}
+	want := map[string]string{
+		"alloc-running-task": "running",
+		"alloc-paused-task":  "paused",
+		"alloc-stopped-task": "stopped",
+		"alloc-missing-task": vmStateMissing,
+	}
+	for id, state := range want {
+		if got[id] != state {
+			t.Fatalf("%s: expected vm_state %q, got %q", id, state, got[id])
+		}
+	}
+
+	// Tasks whose VM is gone are ended; the others are left alone.
+	for allocID, h := range handles {
+		ended := len(h.exec.(*fakeExecutor).shutdowns) > 0
+		shouldEnd := allocID == "alloc-stopped" || allocID == "alloc-missing"
+		if ended != shouldEnd {
+			t.Fatalf("%s: expected ended=%v, got %v", allocID, shouldEnd, ended)
+		}
+	}
+	if res := handles["alloc-missing"].vmExitResult; res == nil || res.ExitCode != 1 {
+		t.Fatalf("expected a failed exit result for the missing VM, got %+v", res)
+	}
+	if res := handles["alloc-stopped"].vmExitResult; res == nil || res.ExitCode != 0 {
+		t.Fatalf("expected a clean exit result for the stopped VM, got %+v", res)
+	}
+
+	// The returned statuses already reflect the reconciled tasks.
+	for id, wantState := range map[string]drivers.TaskState{
+		"alloc-running-task": drivers.TaskStateRunning,
+		"alloc-paused-task":  drivers.TaskStateRunning,
+		"alloc-stopped-task": drivers.TaskStateExited,
+		"alloc-missing-task": drivers.TaskStateExited,
+	} {
+		if st := byID[id]; st.State != wantState {
+			t.Fatalf("%s: expected state %q, got %q", id, wantState, st.State)
+		}
+	}
+	if res := byID["alloc-missing-task"].ExitResult; res == nil || res.ExitCode != 1 {
+		t.Fatalf("expected the missing VM's status to carry its failed exit result, got %+v", res)
+	}
+}
+
+func TestSetConfig_VMNamePrefix(t *testing.T) {
+	var gotPrefix string
+	client := &fakeClient{
+		execFn: func(ctx context.Context, config VMConfig, opts ExecOptions) (int, error) {
+			gotPrefix = config.VMNamePrefix
+			return 0, nil
+		},
+	}
+	d := newTestDriver(t, client)
+
+	if got := d.generateVMName("alloc-1"); got != "nomad-alloc-1" {
+		t.Fatalf("unexpected default VM name: %s", got)
+	}
+
+	if err := d.SetConfig(pluginConfig(t, &Config{VMNamePrefix: "ci-east"})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+	if got := d.generateVMName("alloc-1"); got != "ci-east-alloc-1" {
+		t.Fatalf("unexpected VM name: %s", got)
+	}
+
+	// The prefix is handed to the client so it names VMs the same way.
+	cfg := &drivers.TaskConfig{ID: "task-1", AllocID: "alloc-1"}
+	if err := cfg.EncodeConcreteDriverConfig(&TaskConfig{SSHUser: "admin", SSHPassword: "admin"}); err != nil {
+		t.Fatalf("failed to encode task config: %v", err)
+	}
+	d.tasks.Set("task-1", &taskHandle{taskConfig: cfg})
+	opts := &drivers.ExecOptions{
+		Command: []string{"true"},
+		Stdin:   io.NopCloser(strings.NewReader("")),
+		Stdout:  nopWriteCloser{io.Discard},
+		Stderr:  nopWriteCloser{io.Discard},
+	}
+	if _, err := d.ExecTaskStreaming(context.Background(), "task-1", opts); err != nil {
+		t.Fatalf("ExecTaskStreaming returned error: %v", err)
+	}
+	if gotPrefix != "ci-east" {
+		t.Fatalf("expected client to receive prefix ci-east, got %q", gotPrefix)
+	}
+
+	c := NewTartClient(testLogger(t))
+	args, err := c.BuildStartArgs(VMConfig{NomadConfig: &drivers.TaskConfig{AllocID: "alloc-1"}, VMNamePrefix: "ci-east"})
+	if err != nil {
+		t.Fatalf("BuildStartArgs returned error: %v", err)
+	}
+	if args[1] != "ci-east-alloc-1" {
+		t.Fatalf("expected client VM name ci-east-alloc-1, got %s", args[1])
+	}
+}
+
+func TestSetConfig_RejectsInvalidVMNamePrefix(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{})
+	for _, prefix := range []string{"-lead", "has space", "a/b"} {
+		if err := d.SetConfig(pluginConfig(t, &Config{VMNamePrefix: prefix})); err == nil {
+			t.Fatalf("%q: expected error", prefix)
+		}
+	}
+}
+
+func TestVMName_DriverAndClientAgree(t *testing.T) {
+	c := NewTartClient(testLogger(t))
+	for _, prefix := range []string{"", defaultVMNamePrefix, "ci-east"} {
+		d := newTestDriver(t, c)
+		if prefix != "" {
+			if err := d.SetConfig(pluginConfig(t, &Config{VMNamePrefix: prefix})); err != nil {
+				t.Fatalf("SetConfig returned error: %v", err)
+			}
+		}
+
+		allocID := "0f4e6a9b-alloc"
+		driverName := d.generateVMName(allocID)
+		clientName := c.generateVMName(VMConfig{
+			NomadConfig:  &drivers.TaskConfig{AllocID: allocID},
+			VMNamePrefix: d.vmNamePrefix,
+		})
+		if driverName != clientName {
+			t.Fatalf("prefix %q: driver named %s but client named %s", prefix, driverName, clientName)
+		}
+		if want := vmNameFor(prefix, allocID); driverName != want {
+			t.Fatalf("prefix %q: expected %s, got %s", prefix, want, driverName)
+		}
+	}
+}
+
+func TestStartTask_ExecutorConfigHonorsPluginConfig(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{})
+	logDir := t.TempDir()
+	if err := d.SetConfig(pluginConfig(t, &Config{ExecutorLogLevel: "warn", ExecutorLogDir: logDir})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+
+	var got *executor.ExecutorConfig
+	d.createExecutor = func(logger hclog.Logger, _ *base.ClientDriverConfig, cfg *executor.ExecutorConfig) (executor.Executor, *plugin.Client, error) {
+		got = cfg
+		return nil, nil, errors.New("no executor in tests")
+	}
+
+	cfg := &drivers.TaskConfig{ID: "task-1", Name: "vm", AllocID: "alloc-1", AllocDir: t.TempDir()}
+	if err := cfg.EncodeConcreteDriverConfig(&TaskConfig{URL: "ghcr.io/org/img:latest"}); err != nil {
+		t.Fatalf("failed to encode task config: %v", err)
+	}
+	if _, _, err := d.StartTask(cfg); err == nil {
+		t.Fatalf("expected StartTask to fail when the executor cannot be created")
+	}
+
+	if got == nil {
+		t.Fatalf("executor factory was not called")
+	}
+	if got.LogLevel != "warn" {
+		t.Fatalf("expected log level warn, got %q", got.LogLevel)
+	}
+	if want := filepath.Join(logDir, "alloc-1-vm-executor.out"); got.LogFile != want {
+		t.Fatalf("expected log file %s, got %s", want, got.LogFile)
+	}
+}
+
+func TestExecutorConfig_Defaults(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{})
+	if err := d.SetConfig(pluginConfig(t, &Config{})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+
+	cfg := &drivers.TaskConfig{Name: "vm", AllocDir: "/allocs/alloc-1"}
+	got := d.executorConfig(cfg)
+	if got.LogLevel != defaultExecutorLogLevel {
+		t.Fatalf("expected default log level %q, got %q", defaultExecutorLogLevel, got.LogLevel)
+	}
+	if want := filepath.Join(cfg.TaskDir().Dir, "executor.out"); got.LogFile != want {
+		t.Fatalf("expected log file %s, got %s", want, got.LogFile)
+	}
+}
+
+func TestSetConfig_RejectsInvalidExecutorLogging(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{})
+	for _, cfg := range []*Config{
+		{ExecutorLogLevel: "loud"},
+		{ExecutorLogDir: "relative/logs"},
+	} {
+		if err := d.SetConfig(pluginConfig(t, cfg)); err == nil {
+			t.Fatalf("expected error for %+v", cfg)
+		}
+	}
+}
+
+// unstartedPluginClient returns a plugin client that is never started, so
+// killing it is a no-op.
+func unstartedPluginClient() *plugin.Client {
+	return plugin.NewClient(&plugin.ClientConfig{
+		HandshakeConfig: base.Handshake,
+		Plugins:         map[string]plugin.Plugin{},
+		Cmd:             osexec.Command("true"),
+		Logger:          hclog.NewNullLogger(),
+	})
+}
+
+// fakeExecutorFactory returns an executorFactory that hands out exec along
+// with an unstarted plugin client.
+func fakeExecutorFactory(exec executor.Executor) executorFactory {
+	return func(hclog.Logger, *base.ClientDriverConfig, *executor.ExecutorConfig) (executor.Executor, *plugin.Client, error) {
+		return exec, unstartedPluginClient(), nil
+	}
+}
+
+func TestStartTask_LaunchesVMThroughExecutor(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{
+		buildStartArgsFn: func(config VMConfig) ([]string, error) {
+			return []string{"run", "--no-graphics", vmNameFor(config.VMNamePrefix, config.NomadConfig.AllocID)}, nil
+		},
+	})
+	if err := d.SetConfig(pluginConfig(t, &Config{})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+	fe := newFakeExecutor()
+	d.createExecutor = fakeExecutorFactory(fe)
+
+	dir := t.TempDir()
+	cfg := &drivers.TaskConfig{
+		ID:         "task-1",
+		Name:       "vm",
+		AllocID:    "alloc-1",
+		AllocDir:   dir,
+		StdoutPath: filepath.Join(dir, "stdout"),
+		StderrPath: filepath.Join(dir, "stderr"),
+	}
+	if err := cfg.EncodeConcreteDriverConfig(&TaskConfig{URL: "ghcr.io/org/img:latest"}); err != nil {
+		t.Fatalf("failed to encode task config: %v", err)
+	}
+
+	handle, _, err := d.StartTask(cfg)
+	if err != nil {
+		t.Fatalf("StartTask returned error: %v", err)
+	}
+	if handle.State != drivers.TaskStateRunning {
+		t.Fatalf("expected running handle, got %s", handle.State)
+	}
+
+	var state TaskState
+	if err := handle.GetDriverState(&state); err != nil {
+		t.Fatalf("failed to decode driver state: %v", err)
+	}
+	if state.TaskConfig == nil || state.TaskConfig.ID != cfg.ID {
+		t.Fatalf("driver state does not carry the task config: %+v", state)
+	}
+	if state.Pid != 4242 || strings.Join(state.StartArgs, " ") != "run --no-graphics nomad-alloc-1" {
+		t.Fatalf("driver state does not carry what recovery needs: %+v", state)
+	}
+
+	h, ok := d.tasks.Get(cfg.ID)
+	if !ok {
+		t.Fatalf("task was not registered")
+	}
+	if h.pid != 4242 {
+		t.Fatalf("expected pid 4242, got %d", h.pid)
+	}
+
+	fe.lock.Lock()
+	launched := fe.launched
+	fe.lock.Unlock()
+	if launched == nil || launched.Cmd != "tart" {
+		t.Fatalf("expected tart to be launched, got %+v", launched)
+	}
+	if want := []string{"run", "--no-graphics", "nomad-alloc-1"}; strings.Join(launched.Args, " ") != strings.Join(want, " ") {
+		t.Fatalf("expected args %v, got %v", want, launched.Args)
+	}
+
+	// Let the VM exit and make sure the task can be torn down.
+	fe.exitCh <- &executor.ProcessState{Pid: 4242, ExitCode: 0}
+	select {
+	case <-h.doneCh:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("task did not exit")
+	}
+	if err := d.DestroyTask(cfg.ID, false); err != nil {
+		t.Fatalf("DestroyTask returned error: %v", err)
+	}
+	if _, ok := d.tasks.Get(cfg.ID); ok {
+		t.Fatalf("task still registered after DestroyTask")
+	}
+}
+
+func TestStartTask_RestartsCrashedVMUpToLimit(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{
+		execFn: func(ctx context.Context, config VMConfig, opts ExecOptions) (int, error) {
+			<-ctx.Done()
+			return -1, ctx.Err()
+		},
+	})
+	if err := d.SetConfig(pluginConfig(t, &Config{})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+
+	// Every launch of tart gets its own executor.
+	launches := make(chan *fakeExecutor, 10)
+	d.createExecutor = func(hclog.Logger, *base.ClientDriverConfig, *executor.ExecutorConfig) (executor.Executor, *plugin.Client, error) {
+		fe := newFakeExecutor()
+		launches <- fe
+		return fe, unstartedPluginClient(), nil
+	}
+
+	dir := t.TempDir()
+	cfg := &drivers.TaskConfig{
+		ID:         "task-1",
+		Name:       "vm",
+		AllocID:    "alloc-1",
+		AllocDir:   dir,
+		StdoutPath: filepath.Join(dir, "stdout"),
+		StderrPath: filepath.Join(dir, "stderr"),
+	}
+	taskConfig := TaskConfig{URL: "ghcr.io/org/img:latest", RestartVMOnCrash: true, MaxVMRestarts: 2}
+	if err := cfg.EncodeConcreteDriverConfig(&taskConfig); err != nil {
+		t.Fatalf("failed to encode task config: %v", err)
+	}
+	if _, _, err := d.StartTask(cfg); err != nil {
+		t.Fatalf("StartTask returned error: %v", err)
+	}
+	h, _ := d.tasks.Get(cfg.ID)
+
+	// The first launch and both restarts crash.
+	var crashed []*fakeExecutor
+	for i := 0; i < 3; i++ {
+		select {
+		case fe := <-launches:
+			crashed = append(crashed, fe)
+			fe.exitCh <- &executor.ProcessState{Pid: 4242, ExitCode: 1, Time: time.Now()}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("tart was not launched %d times", i+1)
+		}
+	}
+
+	select {
+	case <-h.doneCh:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("task did not exit once the restarts were used up")
+	}
+	if len(launches) != 0 {
+		t.Fatalf("expected no more than 2 restarts")
+	}
+	if res := h.ExitResult(); res == nil || res.ExitCode != 1 {
+		t.Fatalf("expected the last crash as the exit result, got %#v", res)
+	}
+	if got := h.TaskStatus().DriverAttributes["vm_restarts"]; got != "2" {
+		t.Fatalf("expected 2 restarts to be reported, got %q", got)
+	}
+
+	// Each executor replaced by a restart was shut down.
+	for i, fe := range crashed[:2] {
+		fe.lock.Lock()
+		shutdowns := len(fe.shutdowns)
+		fe.lock.Unlock()
+		if shutdowns != 1 {
+			t.Fatalf("expected the executor of launch %d to be shut down once, got %d", i+1, shutdowns)
+		}
+	}
+}
+
+// noRelaunch fails the test if a recovered task's VM is set up or
+// launched again rather than reattached to.
+func noRelaunch(t *testing.T, d *Driver, fe executor.Executor) {
+	t.Helper()
+	d.createExecutor = func(hclog.Logger, *base.ClientDriverConfig, *executor.ExecutorConfig) (executor.Executor, *plugin.Client, error) {
+		t.Errorf("recovery must not launch tart again")
+		return nil, nil, fmt.Errorf("unexpected launch")
+	}
+	d.reattachExecutor = func(*pstructs.ReattachConfig, hclog.Logger, cpustats.Compute) (executor.Executor, *plugin.Client, error) {
+		return fe, unstartedPluginClient(), nil
+	}
+}
+
+func TestRecoverTask_ReattachesToRunningVM(t *testing.T) {
+	client := &fakeClient{
+		needsImageDownloadFn: func(ctx context.Context, config VMConfig) (bool, error) {
+			t.Errorf("recovery must not check for the image again")
+			return false, nil
+		},
+		setupFn: func(ctx context.Context, config VMConfig) (SetupResult, error) {
+			t.Errorf("recovery must not clone the VM again")
+			return SetupResult{}, nil
+		},
+	}
+	d := newTestDriver(t, client)
+	if err := d.SetConfig(pluginConfig(t, &Config{})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+	fe := newFakeExecutor()
+	noRelaunch(t, d, fe)
+	var reattachedTo *pstructs.ReattachConfig
+	d.reattachExecutor = func(rc *pstructs.ReattachConfig, _ hclog.Logger, _ cpustats.Compute) (executor.Executor, *plugin.Client, error) {
+		reattachedTo = rc
+		return fe, unstartedPluginClient(), nil
+	}
+
+	cfg := &drivers.TaskConfig{ID: "task-1", Name: "vm", AllocID: "alloc-1", AllocDir: t.TempDir()}
+	if err := cfg.EncodeConcreteDriverConfig(&TaskConfig{URL: "ghcr.io/org/img:latest"}); err != nil {
+		t.Fatalf("failed to encode task config: %v", err)
+	}
+	startedAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	rc := &pstructs.ReattachConfig{Network: "unix", Addr: "/tmp/executor.sock", Pid: 99}
+	handle := drivers.NewTaskHandle(taskHandleVersion)
+	handle.Config = cfg
+	if err := handle.SetDriverState(&TaskState{
+		TaskConfig:     cfg,
+		StartedAt:      startedAt,
+		ReattachConfig: rc,
+		Pid:            5151,
+		MACAddress:     "aa:bb:cc:dd:ee:ff",
+	}); err != nil {
+		t.Fatalf("failed to encode driver state: %v", err)
+	}
+
+	if err := d.RecoverTask(handle); err != nil {
+		t.Fatalf("RecoverTask returned error: %v", err)
+	}
+
+	if reattachedTo == nil || *reattachedTo != *rc {
+		t.Fatalf("expected to reattach to %+v, got %+v", rc, reattachedTo)
+	}
+	h, ok := d.tasks.Get(cfg.ID)
+	if !ok {
+		t.Fatalf("recovered task was not registered")
+	}
+	if exec, _, pid := h.process(); exec != fe || pid != 5151 {
+		t.Fatalf("expected the reattached executor and tart pid 5151, got %v/%d", exec, pid)
+	}
+	if !h.startedAt.Equal(startedAt) || h.macAddress != "aa:bb:cc:dd:ee:ff" {
+		t.Fatalf("recovered handle lost its start details: %v %q", h.startedAt, h.macAddress)
+	}
+
+	fe.exitCh <- &executor.ProcessState{Pid: 5151, ExitCode: 0}
+	select {
+	case <-h.doneCh:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("recovered task did not exit with its executor")
+	}
+}
+
+func TestRecoverTask_RestartsLogStreaming(t *testing.T) {
+	streamed := make(chan VMConfig, 1)
+	d := newTestDriver(t, &fakeClient{
+		execFn: func(ctx context.Context, config VMConfig, opts ExecOptions) (int, error) {
+			if len(opts.Command) > 0 && opts.Command[0] == "/usr/bin/log" {
+				select {
+				case streamed <- config:
+				default:
+				}
+			}
+			<-ctx.Done()
+			return -1, ctx.Err()
+		},
+	})
+	if err := d.SetConfig(pluginConfig(t, &Config{})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+	fe := newFakeExecutor()
+	noRelaunch(t, d, fe)
+
+	dir := t.TempDir()
+	cfg := &drivers.TaskConfig{
+		ID:         "task-1",
+		Name:       "vm",
+		AllocID:    "alloc-1",
+		AllocDir:   dir,
+		StdoutPath: filepath.Join(dir, "stdout"),
+		StderrPath: filepath.Join(dir, "stderr"),
+	}
+	if err := cfg.EncodeConcreteDriverConfig(&TaskConfig{URL: "ghcr.io/org/img:latest", SSHUser: "admin", SSHPassword: "admin"}); err != nil {
+		t.Fatalf("failed to encode task config: %v", err)
+	}
+	handle := drivers.NewTaskHandle(taskHandleVersion)
+	handle.Config = cfg
+	if err := handle.SetDriverState(&TaskState{TaskConfig: cfg}); err != nil {
+		t.Fatalf("failed to encode driver state: %v", err)
+	}
+
+	if err := d.RecoverTask(handle); err != nil {
+		t.Fatalf("RecoverTask returned error: %v", err)
+	}
+
+	select {
+	case config := <-streamed:
+		if got := d.generateVMName(config.NomadConfig.AllocID); got != "nomad-alloc-1" {
+			t.Fatalf("streamed logs of %s, want nomad-alloc-1", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("log streaming was not restarted for the recovered task")
+	}
+	fe.exitCh <- &executor.ProcessState{Pid: 4242, ExitCode: 0}
+}
+
+// registerExitedTask registers a task backed by exec whose process has
+// already exited, so stopping it does not block.
+func registerExitedTask(t *testing.T, d *Driver, exec executor.Executor) *drivers.TaskConfig {
+	t.Helper()
+	cfg := &drivers.TaskConfig{ID: "task-1", Name: "vm", AllocID: "alloc-1"}
+	if err := cfg.EncodeConcreteDriverConfig(&TaskConfig{URL: "ghcr.io/org/img:latest"}); err != nil {
+		t.Fatalf("failed to encode task config: %v", err)
+	}
+	h := &taskHandle{
+		exec:         exec,
+		pluginClient: unstartedPluginClient(),
+		taskConfig:   cfg,
+		logger:       d.logger,
+		doneCh:       make(chan struct{}),
+	}
+	close(h.doneCh)
+	d.tasks.Set(cfg.ID, h)
+	return cfg
+}
+
+func TestSplitStopTimeout(t *testing.T) {
+	for _, timeout := range []time.Duration{0, time.Nanosecond, 7 * time.Millisecond, 5 * time.Second, 30 * time.Second} {
+		graceful, force := splitStopTimeout(timeout)
+		if graceful < 0 || force < 0 {
+			t.Fatalf("%s: negative split %s/%s", timeout, graceful, force)
+		}
+		if graceful+force > timeout {
+			t.Fatalf("%s: split %s + %s exceeds the timeout", timeout, graceful, force)
+		}
+	}
+
+	if graceful, force := splitStopTimeout(10 * time.Second); graceful != 7*time.Second || force != 3*time.Second {
+		t.Fatalf("expected a 7s/3s split, got %s/%s", graceful, force)
+	}
+}
+
+func TestStopTask_SharesTimeoutBetweenStopAndShutdown(t *testing.T) {
+	var stopTimeout time.Duration
+	d := newTestDriver(t, &fakeClient{
+		stopFn: func(ctx context.Context, vmName string, timeout time.Duration) error {
+			stopTimeout = timeout
+			return nil
+		},
+	})
+
+	fe := newFakeExecutor()
+	cfg := registerExitedTask(t, d, fe)
+
+	timeout := 10 * time.Second
+	if err := d.StopTask(cfg.ID, timeout, "SIGINT"); err != nil {
+		t.Fatalf("StopTask returned error: %v", err)
+	}
+
+	if len(fe.graces) != 1 {
+		t.Fatalf("expected one executor shutdown, got %d", len(fe.graces))
+	}
+	if stopTimeout != 7*time.Second {
+		t.Fatalf("expected tart stop to get 7s, got %s", stopTimeout)
+	}
+	if stopTimeout+fe.graces[0] > timeout {
+		t.Fatalf("stop (%s) and shutdown (%s) exceed the %s timeout", stopTimeout, fe.graces[0], timeout)
+	}
+}
+
+// nextEventMessages reads n events and returns their messages, failing the
+// test if they do not all carry the expected VM name.
+func nextEventMessages(t *testing.T, events <-chan *drivers.TaskEvent, n int, vmName string) []string {
+	t.Helper()
+	var messages []string
+	for len(messages) < n {
+		select {
+		case ev := <-events:
+			if got := ev.Annotations["vm_name"]; got != vmName {
+				t.Fatalf("event %q annotated with vm_name %q, want %q", ev.Message, got, vmName)
+			}
+			messages = append(messages, ev.Message)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for events, got %v", messages)
+		}
+	}
+	return messages
+}
+
+func TestStopTask_EmitsVMEvents(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := d.TaskEvents(ctx)
+	if err != nil {
+		t.Fatalf("TaskEvents returned error: %v", err)
+	}
+
+	// The eventer delivers events one at a time, so consume them while the
+	// task stops.
+	cfg := registerExitedTask(t, d, newFakeExecutor())
+	errCh := make(chan error, 1)
+	go func() { errCh <- d.StopTask(cfg.ID, time.Second, "SIGINT") }()
+
+	got := nextEventMessages(t, events, 2, "nomad-alloc-1")
+	if strings.Join(got, ",") != "Stopping VM,VM deleted" {
+		t.Fatalf("unexpected events: %v", got)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("StopTask returned error: %v", err)
+	}
+}
+
+func TestStopTask_NoDeletedEventWhenDeleteFails(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{
+		deleteFn: func(ctx context.Context, vmName string) error {
+			return errors.New("delete failed")
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := d.TaskEvents(ctx)
+	if err != nil {
+		t.Fatalf("TaskEvents returned error: %v", err)
+	}
+
+	cfg := registerExitedTask(t, d, newFakeExecutor())
+	errCh := make(chan error, 1)
+	go func() { errCh <- d.StopTask(cfg.ID, time.Second, "SIGINT") }()
+
+	if got := nextEventMessages(t, events, 1, "nomad-alloc-1"); got[0] != "Stopping VM" {
+		t.Fatalf("unexpected event: %v", got)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("StopTask returned error: %v", err)
+	}
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected event after failed delete: %q", ev.Message)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDestroyTask_ForceTearsDownRunningVM(t *testing.T) {
+	var deleted []string
+	d := newTestDriver(t, &fakeClient{
+		deleteFn: func(ctx context.Context, vmName string) error {
+			deleted = append(deleted, vmName)
+			return nil
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := d.TaskEvents(ctx)
+	if err != nil {
+		t.Fatalf("TaskEvents returned error: %v", err)
+	}
+
+	cfg := registerExitedTask(t, d, newFakeExecutor())
+	h, _ := d.tasks.Get(cfg.ID)
+	h.state = drivers.TaskStateRunning
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- d.DestroyTask(cfg.ID, true) }()
+
+	got := nextEventMessages(t, events, 2, "nomad-alloc-1")
+	if strings.Join(got, ",") != "Stopping VM,VM deleted" {
+		t.Fatalf("unexpected events: %v", got)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("DestroyTask returned error: %v", err)
+	}
+	if len(deleted) != 1 || deleted[0] != "nomad-alloc-1" {
+		t.Fatalf("expected the VM to be deleted, got %v", deleted)
+	}
+}
+
+func TestDestroyTask_VMAlreadyGoneCountsAsDeleted(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{
+		stopFn: func(ctx context.Context, vmName string, timeout time.Duration) error {
+			return fmt.Errorf("failed to stop VM %s: %w", vmName, errVMNotFound)
+		},
+		deleteFn: func(ctx context.Context, vmName string) error {
+			return fmt.Errorf("failed to delete VM %s: %w", vmName, errVMNotFound)
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := d.TaskEvents(ctx)
+	if err != nil {
+		t.Fatalf("TaskEvents returned error: %v", err)
+	}
+
+	cfg := registerExitedTask(t, d, newFakeExecutor())
+	h, _ := d.tasks.Get(cfg.ID)
+	h.state = drivers.TaskStateRunning
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- d.DestroyTask(cfg.ID, true) }()
+
+	got := nextEventMessages(t, events, 2, "nomad-alloc-1")
+	if strings.Join(got, ",") != "Stopping VM,VM deleted" {
+		t.Fatalf("unexpected events: %v", got)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("DestroyTask returned error: %v", err)
+	}
+}
+
+func TestStartTask_RunsTartAsTaskUser(t *testing.T) {
+	u := fakeUser(t, "alice", "/Users/alice")
+
+	var setupRunAs *user.User
+	d := newTestDriver(t, &fakeClient{
+		setupFn: func(ctx context.Context, config VMConfig) (SetupResult, error) {
+			setupRunAs = config.RunAs
+			return SetupResult{}, nil
+		},
+	})
+	if err := d.SetConfig(pluginConfig(t, &Config{})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+	fe := newFakeExecutor()
+	d.createExecutor = fakeExecutorFactory(fe)
+
+	dir := t.TempDir()
+	cfg := &drivers.TaskConfig{
+		ID:         "task-1",
+		Name:       "vm",
+		AllocID:    "alloc-1",
+		User:       "alice",
+		AllocDir:   dir,
+		StdoutPath: filepath.Join(dir, "stdout"),
+		StderrPath: filepath.Join(dir, "stderr"),
+	}
+	if err := cfg.EncodeConcreteDriverConfig(&TaskConfig{URL: "ghcr.io/org/img:latest"}); err != nil {
+		t.Fatalf("failed to encode task config: %v", err)
+	}
+
+	if _, _, err := d.StartTask(cfg); err != nil {
+		t.Fatalf("StartTask returned error: %v", err)
+	}
+	t.Cleanup(func() { fe.exitCh <- &executor.ProcessState{} })
+
+	if setupRunAs != u {
+		t.Fatalf("expected the VM to be set up for alice, got %+v", setupRunAs)
+	}
+
+	fe.lock.Lock()
+	launched := fe.launched
+	fe.lock.Unlock()
+	if launched.User != "alice" {
+		t.Fatalf("expected tart to run as alice, got %q", launched.User)
+	}
+	for _, want := range []string{"HOME=/Users/alice", "TART_HOME=" + filepath.Join("/Users/alice", ".tart")} {
+		if !slices.Contains(launched.Env, want) {
+			t.Fatalf("expected %s in the tart environment, got %v", want, launched.Env)
+		}
+	}
+}
+
+func TestStartTask_RejectsUnknownUser(t *testing.T) {
+	fakeUser(t, "alice", "/Users/alice")
+	d := newTestDriver(t, &fakeClient{
+		setupFn: func(ctx context.Context, config VMConfig) (SetupResult, error) {
+			t.Fatalf("setup should not run for an unknown user")
+			return SetupResult{}, nil
+		},
+	})
+
+	cfg := &drivers.TaskConfig{ID: "task-1", Name: "vm", AllocID: "alloc-1", User: "mallory"}
+	if err := cfg.EncodeConcreteDriverConfig(&TaskConfig{URL: "ghcr.io/org/img:latest"}); err != nil {
+		t.Fatalf("failed to encode task config: %v", err)
+	}
+
+	if _, _, err := d.StartTask(cfg); err == nil || !strings.Contains(err.Error(), "mallory") {
+		t.Fatalf("expected an error naming the unknown user, got: %v", err)
+	}
+}
+
+func TestTartHomeFor_Precedence(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{})
+	if err := d.SetConfig(pluginConfig(t, &Config{TartHome: "/srv/tart"})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+	alice := &user.User{Username: "alice", HomeDir: "/Users/alice"}
+
+	cases := []struct {
+		name       string
+		taskConfig TaskConfig
+		runAs      *user.User
+		want       string
+	}{
+		{"plugin", TaskConfig{}, nil, "/srv/tart"},
+		{"user", TaskConfig{}, alice, "/Users/alice/.tart"},
+		{"task", TaskConfig{TartHome: "/srv/jobs/a"}, alice, "/srv/jobs/a"},
+	}
+	for _, tc := range cases {
+		if got := d.tartHomeFor(tc.taskConfig, tc.runAs); got != tc.want {
+			t.Fatalf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestTartEnvList_IncludesTartHome(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{})
+	vmConfig := VMConfig{
+		NomadConfig: &drivers.TaskConfig{Env: map[string]string{"FOO": "bar"}},
+		TartHome:    "/srv/jobs/a",
+	}
+
+	env := d.TartEnvList(vmConfig)
+	for _, want := range []string{"FOO=bar", "TART_HOME=/srv/jobs/a"} {
+		if !slices.Contains(env, want) {
+			t.Fatalf("expected %s in %v", want, env)
+		}
+	}
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "HOME=") {
+			t.Fatalf("did not expect HOME to change without a task user: %v", env)
+		}
+	}
+}
+
+func TestSetConfig_RejectsRelativeTartHome(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{})
+	if err := d.SetConfig(pluginConfig(t, &Config{TartHome: "tart"})); err == nil {
+		t.Fatalf("expected an error for a relative tart_home")
+	}
+}
+
+func TestTartEnvList_StripsDeniedVariables(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{})
+	vmConfig := VMConfig{
+		NomadConfig: &drivers.TaskConfig{Env: map[string]string{
+			"AWS_SECRET_ACCESS_KEY": "secret",
+			"FOO":                   "bar",
+		}},
+		TartHome:    "/srv/jobs/a",
+		EnvDenylist: []string{"AWS_*", "TART_*"},
+	}
+
+	env := d.TartEnvList(vmConfig)
+	if !slices.Contains(env, "FOO=bar") {
+		t.Fatalf("expected FOO to pass through, got %v", env)
+	}
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "AWS_") {
+			t.Fatalf("denied variable leaked: %v", env)
+		}
+	}
+	if !slices.Contains(env, "TART_HOME=/srv/jobs/a") {
+		t.Fatalf("expected the driver's own TART_HOME to be kept, got %v", env)
+	}
+}
+
+func TestStreamSyslogWithRetry_ReconnectsAfterDisconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The first stream ends cleanly (e.g. the guest rebooted), the second
+	// fails to connect, and the third stays up until the task stops.
+	var calls int
+	reconnected := make(chan struct{})
+	client := &fakeClient{
+		execFn: func(ctx context.Context, config VMConfig, opts ExecOptions) (int, error) {
+			calls++
+			switch calls {
+			case 1:
+				return 0, nil
+			case 2:
+				return -1, errors.New("ssh: connection refused")
+			default:
+				close(reconnected)
+				<-ctx.Done()
+				return -1, ctx.Err()
+			}
+		},
+	}
+	d := newTestDriver(t, client)
+	d.syslogRetry = retryBackoff{initial: time.Millisecond, max: 4 * time.Millisecond, stable: time.Hour}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.streamSyslogWithRetry(ctx, VMConfig{}, nopWriteCloser{io.Discard}, nopWriteCloser{io.Discard})
+	}()
+
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("stream was not re-established, %d attempts", calls)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("streaming did not stop after cancellation")
+	}
+}
+
+func TestEmitDownloadComplete_AnnotatesLabels(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := d.TaskEvents(ctx)
+	if err != nil {
+		t.Fatalf("TaskEvents returned error: %v", err)
+	}
+
+	cfg := &drivers.TaskConfig{ID: "task-1", AllocID: "alloc-1"}
+	taskConfig := TaskConfig{
+		URL:    "ghcr.io/org/img:latest",
+		Labels: map[string]string{"team": "mobile", "url": "spoofed"},
+	}
+	if err := cfg.EncodeConcreteDriverConfig(&taskConfig); err != nil {
+		t.Fatalf("failed to encode task config: %v", err)
+	}
+
+	d.emitDownloadComplete(cfg, taskConfig.URL, time.Second)
+
+	select {
+	case ev := <-events:
+		if got := ev.Annotations["team"]; got != "mobile" {
+			t.Fatalf("expected team=mobile, got %q", got)
+		}
+		if got := ev.Annotations["url"]; got != taskConfig.URL {
+			t.Fatalf("a label overrode the url annotation: %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for download event")
+	}
+}
+
+func TestRetryBackoff_NextIsJittered(t *testing.T) {
+	b := retryBackoff{initial: time.Second, max: 10 * time.Second}
+	ranges := []struct{ min, max time.Duration }{
+		{500 * time.Millisecond, time.Second},
+		{time.Second, 2 * time.Second},
+		{2 * time.Second, 4 * time.Second},
+		{4 * time.Second, 8 * time.Second},
+		{5 * time.Second, 10 * time.Second},
+		{5 * time.Second, 10 * time.Second},
+	}
+
+	for run := 0; run < 100; run++ {
+		cur := b.initial
+		for i, r := range ranges {
+			var wait time.Duration
+			wait, cur = b.next(cur)
+			if wait < r.min || wait > r.max {
+				t.Fatalf("attempt %d waited %s, want between %s and %s", i, wait, r.min, r.max)
+			}
+		}
+	}
+}
+
+func TestShutdown_StopsSyslogStreaming(t *testing.T) {
+	streaming := make(chan struct{})
+	client := &fakeClient{
+		execFn: func(ctx context.Context, config VMConfig, opts ExecOptions) (int, error) {
+			close(streaming)
+			<-ctx.Done()
+			return -1, ctx.Err()
+		},
+	}
+	d := newTestDriver(t, client)
+
+	// The stream's context is not derived from the driver's, so only the
+	// shutdown path cancelling the task's stream can stop it.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.tasks.Set("task-1", &taskHandle{syslogCancel: cancel})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.streamSyslogWithRetry(ctx, VMConfig{}, nopWriteCloser{io.Discard}, nopWriteCloser{io.Discard})
+	}()
+	<-streaming
+
+	d.Shutdown()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("streaming did not stop after the driver shut down")
+	}
+}
+
+func TestStartTask_RunsPrestartHookBeforeSetup(t *testing.T) {
+	var order []string
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *osexec.Cmd {
+		order = append(order, "hook")
+		return osexec.CommandContext(ctx, name, args...)
+	}
+	defer func() { execCommandContext = orig }()
+
+	d := newTestDriver(t, &fakeClient{
+		setupFn: func(ctx context.Context, config VMConfig) (SetupResult, error) {
+			order = append(order, "setup")
+			return SetupResult{}, nil
+		},
+	})
+	hook := &HostHookConfig{Command: []string{"sh", "-c", `test "$NOMAD_ALLOC_ID" = alloc-1`}}
+	if err := d.SetConfig(pluginConfig(t, &Config{PrestartHook: hook})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+	fe := newFakeExecutor()
+	d.createExecutor = fakeExecutorFactory(fe)
+
+	dir := t.TempDir()
+	cfg := &drivers.TaskConfig{
+		ID:         "task-1",
+		Name:       "vm",
+		AllocID:    "alloc-1",
+		AllocDir:   dir,
+		Env:        map[string]string{"NOMAD_ALLOC_ID": "alloc-1"},
+		StdoutPath: filepath.Join(dir, "stdout"),
+		StderrPath: filepath.Join(dir, "stderr"),
+	}
+	if err := cfg.EncodeConcreteDriverConfig(&TaskConfig{URL: "ghcr.io/org/img:latest"}); err != nil {
+		t.Fatalf("failed to encode task config: %v", err)
+	}
+
+	if _, _, err := d.StartTask(cfg); err != nil {
+		t.Fatalf("StartTask returned error: %v", err)
+	}
+	t.Cleanup(func() { fe.exitCh <- &executor.ProcessState{} })
+
+	if !slices.Equal(order, []string{"hook", "setup"}) {
+		t.Fatalf("expected the hook to run before setup, got %v", order)
+	}
+}
+
+func TestStartTask_PrestartHookFailureAbortsStart(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{
+		setupFn: func(ctx context.Context, config VMConfig) (SetupResult, error) {
+			t.Fatalf("setup should not run after the prestart hook fails")
+			return SetupResult{}, nil
+		},
+	})
+	hook := &HostHookConfig{Command: []string{"sh", "-c", "echo no bridge; exit 3"}}
+	if err := d.SetConfig(pluginConfig(t, &Config{PrestartHook: hook})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+
+	cfg := &drivers.TaskConfig{ID: "task-1", Name: "vm", AllocID: "alloc-1"}
+	if err := cfg.EncodeConcreteDriverConfig(&TaskConfig{URL: "ghcr.io/org/img:latest"}); err != nil {
+		t.Fatalf("failed to encode task config: %v", err)
+	}
+
+	_, _, err := d.StartTask(cfg)
+	if err == nil || !strings.Contains(err.Error(), "no bridge") {
+		t.Fatalf("expected the hook failure with its output, got: %v", err)
+	}
+}
+
+func TestSetConfig_RejectsInvalidPrestartHook(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{})
+	for _, hook := range []*HostHookConfig{
+		{},
+		{Command: []string{"true"}, Timeout: "soon"},
+		{Command: []string{"true"}, Timeout: "-1s"},
+	} {
+		if err := d.SetConfig(pluginConfig(t, &Config{PrestartHook: hook})); err == nil {
+			t.Fatalf("expected error for %+v", hook)
+		}
+	}
+}
+
+func TestStopTask_RunsPoststopHookAfterDelete(t *testing.T) {
+	var order []string
+	var hookCmd *osexec.Cmd
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *osexec.Cmd {
+		if name == "lsof" {
+			return osexec.CommandContext(ctx, "false")
+		}
+		order = append(order, "hook")
+		hookCmd = osexec.CommandContext(ctx, name, args...)
+		return hookCmd
+	}
+	defer func() { execCommandContext = orig }()
+
+	d := newTestDriver(t, &fakeClient{
+		stopFn: func(ctx context.Context, vmName string, timeout time.Duration) error {
+			return errors.New("stop failed")
+		},
+		deleteFn: func(ctx context.Context, vmName string) error {
+			order = append(order, "delete")
+			return nil
+		},
+	})
+	hook := &HostHookConfig{Command: []string{"true"}}
+	if err := d.SetConfig(pluginConfig(t, &Config{PoststopHook: hook})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+
+	cfg := registerExitedTask(t, d, newFakeExecutor())
+	if err := d.StopTask(cfg.ID, time.Second, "SIGINT"); err != nil {
+		t.Fatalf("StopTask returned error: %v", err)
+	}
+	if err := d.DestroyTask(cfg.ID, false); err != nil {
+		t.Fatalf("DestroyTask returned error: %v", err)
+	}
+
+	if !slices.Equal(order, []string{"delete", "hook"}) {
+		t.Fatalf("expected the hook to run once after the VM was deleted, got %v", order)
+	}
+	if !slices.Contains(hookCmd.Env, "TART_VM_NAME=nomad-alloc-1") {
+		t.Fatalf("expected TART_VM_NAME in the hook environment, got %v", hookCmd.Env)
+	}
+}
+
+func TestSetConfig_CommandTimeout(t *testing.T) {
+	d := NewTartDriver(testLogger(t)).(*Driver)
+	t.Cleanup(d.signalShutdown)
+
+	if err := d.SetConfig(pluginConfig(t, &Config{CommandTimeout: "30s"})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+	if got, _ := d.client.(*TartClient).settings(); got != 30*time.Second {
+		t.Fatalf("expected a 30s command timeout, got %s", got)
+	}
+
+	for _, timeout := range []string{"soon", "0s"} {
+		if err := d.SetConfig(pluginConfig(t, &Config{CommandTimeout: timeout})); err == nil {
+			t.Fatalf("expected error for command_timeout %q", timeout)
+		}
+	}
+}
+
+func TestStopTask_KillsLingeringVMProcesses(t *testing.T) {
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *osexec.Cmd {
+		return osexec.CommandContext(ctx, "echo", "4243\n4244")
+	}
+	defer func() { execCommandContext = orig }()
+
+	var lock sync.Mutex
+	killed := map[int]bool{}
+	origSignal := signalProcess
+	signalProcess = func(pid int, sig syscall.Signal) error {
+		lock.Lock()
+		defer lock.Unlock()
+		if sig == syscall.SIGKILL {
+			killed[pid] = true
+		} else if killed[pid] {
+			return syscall.ESRCH
+		}
+		return nil
+	}
+	defer func() { signalProcess = origSignal }()
+
+	d := newTestDriver(t, &fakeClient{})
+	cfg := registerExitedTask(t, d, newFakeExecutor())
+	h, _ := d.tasks.Get(cfg.ID)
+	h.state = drivers.TaskStateRunning
+	h.pid = 4242
+
+	if err := d.StopTask(cfg.ID, time.Second, "SIGINT"); err != nil {
+		t.Fatalf("StopTask returned error: %v", err)
+	}
+
+	for _, pid := range []int{4242, 4243, 4244} {
+		if !killed[pid] {
+			t.Fatalf("expected pid %d to be killed, got %v", pid, killed)
+		}
+	}
+}
+
+func TestStartTask_RefusesPullWhenImageStoreOverQuota(t *testing.T) {
+	orig := tartStoreUsage
+	tartStoreUsage = func(home string) (int64, error) { return 60 * bytesPerGB, nil }
+	defer func() { tartStoreUsage = orig }()
+
+	d := newTestDriver(t, &fakeClient{
+		needsImageDownloadFn: func(ctx context.Context, config VMConfig) (bool, error) {
+			return true, nil
+		},
+		setupFn: func(ctx context.Context, config VMConfig) (SetupResult, error) {
+			t.Fatalf("setup should not pull into a full image store")
+			return SetupResult{}, nil
+		},
+	})
+	if err := d.SetConfig(pluginConfig(t, &Config{MaxImageStoreGB: 50})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+
+	cfg := &drivers.TaskConfig{ID: "task-1", Name: "vm", AllocID: "alloc-1"}
+	if err := cfg.EncodeConcreteDriverConfig(&TaskConfig{URL: "ghcr.io/org/img:latest"}); err != nil {
+		t.Fatalf("failed to encode task config: %v", err)
+	}
+
+	if _, _, err := d.StartTask(cfg); err == nil || !strings.Contains(err.Error(), "max_image_store_gb") {
+		t.Fatalf("expected the pull to be refused, got: %v", err)
+	}
+}
+
+func TestDriver_StartStopLifecycleWithInjectedClient(t *testing.T) {
+	origSignal := signalProcess
+	signalProcess = func(pid int, sig syscall.Signal) error { return syscall.ESRCH }
+	defer func() { signalProcess = origSignal }()
+
+	fe := newFakeExecutor()
+	var lock sync.Mutex
+	var calls []string
+	record := func(call string) {
+		lock.Lock()
+		defer lock.Unlock()
+		calls = append(calls, call)
+	}
+	client := &fakeClient{
+		setupFn: func(ctx context.Context, config VMConfig) (SetupResult, error) {
+			record("setup " + config.TaskConfig.URL)
+			return SetupResult{VMName: "nomad-alloc-1"}, nil
+		},
+		stopFn: func(ctx context.Context, vmName string, timeout time.Duration) error {
+			record("stop " + vmName)
+			return nil
+		},
+		deleteFn: func(ctx context.Context, vmName string) error {
+			record("delete " + vmName)
+			// tart run exits once its VM is gone.
+			fe.exitCh <- &executor.ProcessState{Pid: 4242, ExitCode: 0}
+			return nil
+		},
+	}
+
+	d := NewTartDriverWithClient(testLogger(t), client).(*Driver)
+	t.Cleanup(d.signalShutdown)
+	if err := d.SetConfig(pluginConfig(t, &Config{Enabled: true})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+	d.createExecutor = fakeExecutorFactory(fe)
+
+	if health := d.buildFingerprint().Health; health != drivers.HealthStateHealthy {
+		t.Fatalf("expected the injected client to fingerprint healthy, got %s", health)
+	}
+
+	dir := t.TempDir()
+	cfg := &drivers.TaskConfig{
+		ID:         "task-1",
+		Name:       "vm",
+		AllocID:    "alloc-1",
+		AllocDir:   dir,
+		StdoutPath: filepath.Join(dir, "stdout"),
+		StderrPath: filepath.Join(dir, "stderr"),
+	}
+	if err := cfg.EncodeConcreteDriverConfig(&TaskConfig{URL: "ghcr.io/org/img:latest"}); err != nil {
+		t.Fatalf("failed to encode task config: %v", err)
+	}
+
+	if _, _, err := d.StartTask(cfg); err != nil {
+		t.Fatalf("StartTask returned error: %v", err)
+	}
+	waitCh, err := d.WaitTask(context.Background(), cfg.ID)
+	if err != nil {
+		t.Fatalf("WaitTask returned error: %v", err)
+	}
+
+	if err := d.StopTask(cfg.ID, time.Second, "SIGINT"); err != nil {
+		t.Fatalf("StopTask returned error: %v", err)
+	}
+	select {
+	case res := <-waitCh:
+		if res.ExitCode != 0 {
+			t.Fatalf("unexpected exit result: %+v", res)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the task to exit")
+	}
+
+	if err := d.DestroyTask(cfg.ID, false); err != nil {
+		t.Fatalf("DestroyTask returned error: %v", err)
+	}
+	if _, ok := d.tasks.Get(cfg.ID); ok {
+		t.Fatal("task still registered after DestroyTask")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	want := []string{"setup ghcr.io/org/img:latest", "stop nomad-alloc-1", "delete nomad-alloc-1"}
+	if !slices.Equal(calls, want) {
+		t.Fatalf("got client calls %v, want %v", calls, want)
+	}
+}
@@ -0,0 +1,49 @@
+package driver
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// validateEnvDenylist checks that every env_denylist entry is a valid glob
+// pattern.
+func validateEnvDenylist(denylist []string) error {
+	for _, pattern := range denylist {
+		if pattern == "" {
+			return fmt.Errorf("env_denylist entries must not be empty")
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid env_denylist pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// envDenied reports whether key matches one of the denylist patterns.
+// Patterns are exact names or globs such as "AWS_*".
+func envDenied(key string, denylist []string) bool {
+	for _, pattern := range denylist {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// filterEnv returns the KEY=VALUE entries of env whose key is not denied.
+func filterEnv(env []string, denylist []string) []string {
+	if len(denylist) == 0 {
+		return env
+	}
+
+	filtered := make([]string, 0, len(env))
+	for _, kv := range env {
+		key, _, _ := strings.Cut(kv, "=")
+		if envDenied(key, denylist) {
+			continue
+		}
+		filtered = append(filtered, kv)
+	}
+	return filtered
+}
@@ -0,0 +1,38 @@
+package driver
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFilterEnv(t *testing.T) {
+	env := []string{
+		"AWS_ACCESS_KEY_ID=AKIA",
+		"AWS_SECRET_ACCESS_KEY=secret",
+		"GITHUB_TOKEN=ghp",
+		"HOME=/Users/agent",
+		"NOMAD_ALLOC_ID=alloc-1",
+		"MALFORMED",
+	}
+
+	got := filterEnv(env, []string{"AWS_*", "GITHUB_TOKEN"})
+	want := []string{"HOME=/Users/agent", "NOMAD_ALLOC_ID=alloc-1", "MALFORMED"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	if got := filterEnv(env, nil); !slices.Equal(got, env) {
+		t.Fatalf("expected env to pass through without a denylist, got %v", got)
+	}
+}
+
+func TestValidateEnvDenylist(t *testing.T) {
+	if err := validateEnvDenylist([]string{"AWS_*", "TOKEN"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, bad := range [][]string{{""}, {"AWS_["}} {
+		if err := validateEnvDenylist(bad); err == nil {
+			t.Fatalf("expected an error for %q", bad)
+		}
+	}
+}
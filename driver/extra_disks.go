@@ -0,0 +1,49 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+)
+
+// validateExtraDisks checks that every extra disk is a well-formed image
+// reference.
+func validateExtraDisks(images []string) error {
+	for i, image := range images {
+		if err := validateImageURL(image); err != nil {
+			return fmt.Errorf("invalid extra disk %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// pullExtraDisks pulls each of the task's extra disk images into tart's
+// cache, in order, the same way the base image was pulled: with the task's
+// environment and pull concurrency.
+func (c *TartClient) pullExtraDisks(ctx context.Context, config VMConfig, env []string) error {
+	vmName := c.generateVMName(config)
+	for _, image := range config.TaskConfig.ExtraDisks {
+		c.logger.Debug("Pulling extra disk image", "name", vmName, "url", redact(image))
+		if err := c.pullImage(ctx, image, vmName, config.PullConcurrency, env); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildExtraDiskArgs attaches the disk of each extra disk image in tart's
+// cache to the VM read-only, in the order they are listed. The disks are
+// separate devices; nothing is layered over the base image's disk.
+func buildExtraDiskArgs(config VMConfig) []string {
+	home := config.TartHome
+	if home == "" {
+		home = tartHome()
+	}
+
+	var args []string
+	for _, image := range config.TaskConfig.ExtraDisks {
+		disk := filepath.Join(cachedImageDir(home, image), vmDiskImage)
+		args = append(args, fmt.Sprintf("--disk=%s:ro", disk))
+	}
+	return args
+}
@@ -0,0 +1,99 @@
+package driver
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+func TestSetup_AttachesExtraDisksInOrder(t *testing.T) {
+	home := t.TempDir()
+
+	var calls []string
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		if args[0] != "set" {
+			calls = append(calls, strings.Join(args, " "))
+		}
+		return exec.CommandContext(ctx, "true")
+	}
+	defer func() { execCommandContext = orig }()
+
+	taskConfig := TaskConfig{
+		BaseURL:    "ghcr.io/org/macos:15",
+		ExtraDisks: []string{"ghcr.io/org/xcode:16", "ghcr.io/org/tools@sha256:abc"},
+	}
+	if err := resolveImageURL(&drivers.TaskConfig{}, &taskConfig); err != nil {
+		t.Fatalf("resolveImageURL returned error: %v", err)
+	}
+
+	c := NewTartClient(testLogger(t))
+	vmc := VMConfig{
+		TaskConfig:      taskConfig,
+		NomadConfig:     &drivers.TaskConfig{AllocID: "alloc-disks"},
+		TartHome:        home,
+		PullConcurrency: 4,
+	}
+	if _, err := c.Setup(context.Background(), vmc); err != nil {
+		t.Fatalf("Setup returned error: %v", err)
+	}
+
+	want := []string{
+		"clone --concurrency 4 ghcr.io/org/macos:15 nomad-alloc-disks",
+		"pull --concurrency 4 ghcr.io/org/xcode:16",
+		"pull --concurrency 4 ghcr.io/org/tools@sha256:abc",
+	}
+	if !slices.Equal(calls, want) {
+		t.Fatalf("got tart calls %v, want %v", calls, want)
+	}
+
+	args, err := c.BuildStartArgs(vmc)
+	if err != nil {
+		t.Fatalf("BuildStartArgs returned error: %v", err)
+	}
+	var disks []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--disk=") {
+			disks = append(disks, arg)
+		}
+	}
+	wantDisks := []string{
+		"--disk=" + filepath.Join(home, "cache", "OCIs", "ghcr.io", "org", "xcode", "16", vmDiskImage) + ":ro",
+		"--disk=" + filepath.Join(home, "cache", "OCIs", "ghcr.io", "org", "tools", "sha256:abc", vmDiskImage) + ":ro",
+	}
+	if !slices.Equal(disks, wantDisks) {
+		t.Fatalf("got extra disks %v, want %v", disks, wantDisks)
+	}
+}
+
+func TestResolveImageURL_BaseURL(t *testing.T) {
+	cases := []struct {
+		name    string
+		config  TaskConfig
+		wantURL string
+		wantErr bool
+	}{
+		{"base_url alone", TaskConfig{BaseURL: "ghcr.io/org/macos:15"}, "ghcr.io/org/macos:15", false},
+		{"same url and base_url", TaskConfig{URL: "ghcr.io/org/macos:15", BaseURL: "ghcr.io/org/macos:15"}, "ghcr.io/org/macos:15", false},
+		{"conflicting url", TaskConfig{URL: "ghcr.io/org/linux:1", BaseURL: "ghcr.io/org/macos:15"}, "", true},
+		{"extra disks without a base", TaskConfig{ExtraDisks: []string{"ghcr.io/org/tools:1"}}, "", true},
+		{"invalid extra disk", TaskConfig{BaseURL: "ghcr.io/org/macos:15", ExtraDisks: []string{"not an image"}}, "", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := tc.config
+			err := resolveImageURL(&drivers.TaskConfig{}, &config)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tc.wantErr)
+			}
+			if err == nil && config.URL != tc.wantURL {
+				t.Fatalf("got url %q, want %q", config.URL, tc.wantURL)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package driver
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/nomad/plugins/drivers"
@@ -17,6 +18,15 @@ var (
 	maxVMSlots        = 2
 	availableSlotsKey = "driver.tart.available_slots"
 	versionKey        = "driver.tart.version"
+
+	// availableSlotCountKey reports how many VMs may still be started,
+	// excluding any reserved_slots, for operators wanting the number rather
+	// than whether it is zero.
+	availableSlotCountKey = "driver.tart.available_slot_count"
+
+	// runningVMsKey reports how many VMs are running on the host, whether
+	// Nomad started them or not.
+	runningVMsKey = "driver.tart.running_vms"
 )
 
 // handleFingerprint runs an infinite loop that sends the driver's fingerprint
@@ -25,19 +35,83 @@ var (
 func (d *Driver) handleFingerprint(ctx context.Context, ch chan<- *drivers.Fingerprint) {
 	defer close(ch)
 
+	ticker := d.clock.NewTicker(d.fingerprintPeriod)
+	defer ticker.Stop()
+
 	// Nomad expects the initial fingerprint to be sent immediately
-	ticker := time.NewTimer(0)
+	ch <- d.nextFingerprint()
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-d.ctx.Done():
 			return
-		case <-ticker.C:
-			ticker.Reset(fingerprintPeriod)
-			ch <- d.buildFingerprint()
+		case <-ticker.C():
+			ch <- d.nextFingerprint()
+		}
+	}
+}
+
+// nextFingerprint builds the driver's fingerprint, holding back a change
+// between healthy and unhealthy until it has been seen enough times in a row.
+func (d *Driver) nextFingerprint() *drivers.Fingerprint {
+	return d.health.observe(d.buildFingerprint())
+}
+
+// healthHysteresis damps flapping between the healthy and unhealthy
+// fingerprint states: the driver is only reported unhealthy after
+// failureThreshold consecutive unhealthy fingerprints, and healthy again after
+// successThreshold consecutive healthy ones. Until then the last reported
+// fingerprint is repeated. Other states, such as undetected, are reported
+// immediately.
+type healthHysteresis struct {
+	lock             sync.Mutex
+	failureThreshold int
+	successThreshold int
+
+	failures  int
+	successes int
+	reported  *drivers.Fingerprint
+}
+
+// newHealthHysteresis returns a healthHysteresis that reports the first
+// fingerprint it observes as is.
+func newHealthHysteresis(failureThreshold, successThreshold int) *healthHysteresis {
+	return &healthHysteresis{
+		failureThreshold: failureThreshold,
+		successThreshold: successThreshold,
+	}
+}
+
+// observe records fp and returns the fingerprint to report.
+func (h *healthHysteresis) observe(fp *drivers.Fingerprint) *drivers.Fingerprint {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	switch fp.Health {
+	case drivers.HealthStateHealthy:
+		h.successes++
+		h.failures = 0
+	case drivers.HealthStateUnhealthy:
+		h.failures++
+		h.successes = 0
+	default:
+		h.failures, h.successes = 0, 0
+		h.reported = fp
+		return fp
+	}
+
+	if h.reported != nil && h.reported.Health != fp.Health {
+		switch {
+		case h.reported.Health == drivers.HealthStateHealthy && h.failures < h.failureThreshold:
+			return h.reported
+		case h.reported.Health == drivers.HealthStateUnhealthy && h.successes < h.successThreshold:
+			return h.reported
 		}
 	}
+
+	h.reported = fp
+	return fp
 }
 
 // buildFingerprint returns the driver's fingerprint data
@@ -69,7 +143,7 @@ func (d *Driver) buildFingerprint() *drivers.Fingerprint {
 		d.logger.Warn("failed to find virtualization software", "error", err)
 		fp.Health = drivers.HealthStateUndetected
 		fp.HealthDescription = "virtualization software not found"
-		fp.Attributes[availableSlotsKey] = structs.NewIntAttribute(0, "virtualization software not found")
+		fp.Attributes[availableSlotsKey] = structs.NewBoolAttribute(false)
 		return fp
 	} else {
 		fp.Attributes[versionKey] = structs.NewStringAttribute(version)
@@ -92,15 +166,30 @@ func (d *Driver) buildFingerprint() *drivers.Fingerprint {
 			runningVMsCount++
 		}
 	}
-	availableSlots := maxVMSlots - runningVMsCount
+	fp.Attributes[runningVMsKey] = structs.NewIntAttribute(int64(runningVMsCount), "")
+
+	// Slots reserved by the operator for manual use are never advertised.
+	availableSlots := maxVMSlots - d.config.ReservedSlots - runningVMsCount
 	if availableSlots < 0 {
 		// This case implies more VMs are running than maxVMSlots, which might indicate an issue
-		// or that VMs were started outside of Nomad's management for this driver.
+		// or that VMs were started outside of Nomad's management for this driver, possibly
+		// in reserved slots.
 		// For now, report 0 available slots.
-		d.logger.Warn("calculated negative available slots", "running_vms", runningVMsCount, "max_slots", maxVMSlots)
+		if runningVMsCount > maxVMSlots {
+			d.logger.Warn("calculated negative available slots", "running_vms", runningVMsCount, "max_slots", maxVMSlots)
+		}
+		availableSlots = 0
+	}
+	// A full image store takes no more work until space is freed.
+	if overQuota, used, err := d.imageStoreOverQuota(); err != nil {
+		d.logger.Warn("failed to measure the image store", "error", err)
+	} else if overQuota {
+		d.logger.Warn("image store is over quota, advertising no slots", "used_gb", float64(used)/bytesPerGB, "max_gb", d.config.MaxImageStoreGB)
+		fp.HealthDescription = fmt.Sprintf("image store over quota (%.1f GB of %d GB)", float64(used)/bytesPerGB, d.config.MaxImageStoreGB)
 		availableSlots = 0
 	}
 	fp.Attributes[availableSlotsKey] = structs.NewBoolAttribute(int64(availableSlots) > 0)
+	fp.Attributes[availableSlotCountKey] = structs.NewIntAttribute(int64(availableSlots), "")
 
 	return fp
 }
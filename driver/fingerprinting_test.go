@@ -0,0 +1,241 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+func TestBuildFingerprint_ReservedSlotsReduceAvailability(t *testing.T) {
+	cases := []struct {
+		reserved  int
+		wantCount int64
+		wantAny   bool
+	}{
+		{0, 1, true},
+		{1, 0, false},
+	}
+	for _, tc := range cases {
+		d := newTestDriver(t, &fakeClient{
+			listFn: func(ctx context.Context) ([]VMInfo, error) {
+				return []VMInfo{{Name: "nomad-a", Status: VMStateRunning}, {Name: "base", Status: VMStateStopped}}, nil
+			},
+		})
+		if err := d.SetConfig(pluginConfig(t, &Config{Enabled: true, ReservedSlots: tc.reserved})); err != nil {
+			t.Fatalf("SetConfig returned error: %v", err)
+		}
+
+		fp := d.buildFingerprint()
+		count, ok := fp.Attributes[availableSlotCountKey].GetInt()
+		if !ok || count != tc.wantCount {
+			t.Fatalf("reserved %d: expected %d available slots, got %v", tc.reserved, tc.wantCount, fp.Attributes[availableSlotCountKey])
+		}
+		if any, _ := fp.Attributes[availableSlotsKey].GetBool(); any != tc.wantAny {
+			t.Fatalf("reserved %d: expected available_slots %v, got %v", tc.reserved, tc.wantAny, any)
+		}
+	}
+}
+
+func TestBuildFingerprint_RunningVMs(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{
+		listFn: func(ctx context.Context) ([]VMInfo, error) {
+			return []VMInfo{
+				{Name: "nomad-a", Status: VMStateRunning},
+				{Name: "manual", Status: VMStateRunning},
+				{Name: "base", Status: VMStateStopped},
+			}, nil
+		},
+	})
+	if err := d.SetConfig(pluginConfig(t, &Config{Enabled: true})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+
+	fp := d.buildFingerprint()
+	if running, ok := fp.Attributes[runningVMsKey].GetInt(); !ok || running != 2 {
+		t.Fatalf("expected 2 running VMs, got %v", fp.Attributes[runningVMsKey])
+	}
+}
+
+func TestSetConfig_RejectsInvalidReservedSlots(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{})
+	for _, reserved := range []int{-1, maxVMSlots} {
+		if err := d.SetConfig(pluginConfig(t, &Config{ReservedSlots: reserved})); err == nil {
+			t.Fatalf("expected error for reserved_slots = %d", reserved)
+		}
+	}
+}
+
+func TestSetConfig_FingerprintInterval(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{})
+
+	if err := d.SetConfig(pluginConfig(t, &Config{Enabled: true, FingerprintInterval: "2m"})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+	if d.fingerprintPeriod != 2*time.Minute {
+		t.Fatalf("unexpected fingerprint period: %v", d.fingerprintPeriod)
+	}
+
+	if err := d.SetConfig(pluginConfig(t, &Config{Enabled: true, FingerprintInterval: "1s"})); err == nil {
+		t.Fatalf("expected an error for a fingerprint interval below the minimum")
+	}
+	if err := d.SetConfig(pluginConfig(t, &Config{Enabled: true, FingerprintInterval: "often"})); err == nil {
+		t.Fatalf("expected an error for an unparseable fingerprint interval")
+	}
+}
+
+func TestHandleFingerprint_HonorsPeriod(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{})
+	// Shorter than the floor SetConfig allows, to keep the test fast.
+	d.fingerprintPeriod = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := d.Fingerprint(ctx)
+	if err != nil {
+		t.Fatalf("Fingerprint returned error: %v", err)
+	}
+
+	// With the default period only the initial fingerprint would arrive.
+	for i := 0; i < 3; i++ {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatalf("received %d fingerprints, want 3", i)
+		}
+	}
+}
+
+func TestNextFingerprint_HealthHysteresis(t *testing.T) {
+	// Each entry is whether the next tart list fails.
+	var failures []bool
+	d := newTestDriver(t, &fakeClient{
+		listFn: func(ctx context.Context) ([]VMInfo, error) {
+			fail := failures[0]
+			failures = failures[1:]
+			if fail {
+				return nil, errors.New("tart list failed")
+			}
+			return nil, nil
+		},
+	})
+	if err := d.SetConfig(pluginConfig(t, &Config{Enabled: true})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+
+	steps := []struct {
+		fail bool
+		want drivers.HealthState
+	}{
+		{false, drivers.HealthStateHealthy},
+		// A single failure among successes is not reported.
+		{true, drivers.HealthStateHealthy},
+		{false, drivers.HealthStateHealthy},
+		// Three failures in a row are.
+		{true, drivers.HealthStateHealthy},
+		{true, drivers.HealthStateHealthy},
+		{true, drivers.HealthStateUnhealthy},
+		// Recovery needs two successes in a row.
+		{false, drivers.HealthStateUnhealthy},
+		{true, drivers.HealthStateUnhealthy},
+		{false, drivers.HealthStateUnhealthy},
+		{false, drivers.HealthStateHealthy},
+	}
+	for i, step := range steps {
+		failures = append(failures, step.fail)
+		if got := d.nextFingerprint().Health; got != step.want {
+			t.Fatalf("step %d: got %s, want %s", i, got, step.want)
+		}
+	}
+}
+
+func TestNextFingerprint_UndetectedIsReportedImmediately(t *testing.T) {
+	available := true
+	d := newTestDriver(t, &fakeClient{
+		availableFn: func(ctx context.Context) (string, error) {
+			if !available {
+				return "", errors.New("tart not found")
+			}
+			return "2.0.0", nil
+		},
+	})
+	if err := d.SetConfig(pluginConfig(t, &Config{Enabled: true})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+
+	if got := d.nextFingerprint().Health; got != drivers.HealthStateHealthy {
+		t.Fatalf("expected healthy, got %s", got)
+	}
+	available = false
+	if got := d.nextFingerprint().Health; got != drivers.HealthStateUndetected {
+		t.Fatalf("expected undetected, got %s", got)
+	}
+}
+
+func TestBuildFingerprint_ImageStoreOverQuotaZeroesSlots(t *testing.T) {
+	orig := tartStoreUsage
+	tartStoreUsage = func(home string) (int64, error) { return 101 * bytesPerGB, nil }
+	defer func() { tartStoreUsage = orig }()
+
+	d := newTestDriver(t, &fakeClient{})
+	if err := d.SetConfig(pluginConfig(t, &Config{Enabled: true, MaxImageStoreGB: 100})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+
+	fp := d.buildFingerprint()
+	if count, _ := fp.Attributes[availableSlotCountKey].GetInt(); count != 0 {
+		t.Fatalf("expected no available slots over quota, got %d", count)
+	}
+	if any, _ := fp.Attributes[availableSlotsKey].GetBool(); any {
+		t.Fatal("expected available_slots to be false over quota")
+	}
+
+	tartStoreUsage = func(home string) (int64, error) { return 99 * bytesPerGB, nil }
+	fp = d.buildFingerprint()
+	if count, _ := fp.Attributes[availableSlotCountKey].GetInt(); count != int64(maxVMSlots) {
+		t.Fatalf("expected %d available slots under quota, got %d", maxVMSlots, count)
+	}
+}
+
+func TestBuildFingerprint_AvailableSlotsIsAlwaysBool(t *testing.T) {
+	running := []VMInfo{{Name: "nomad-a", Status: VMStateRunning}, {Name: "nomad-b", Status: VMStateRunning}}
+	cases := []struct {
+		name    string
+		enabled bool
+		client  *fakeClient
+		want    bool
+	}{
+		{"healthy", true, &fakeClient{}, true},
+		{"at capacity", true, &fakeClient{
+			listFn: func(ctx context.Context) ([]VMInfo, error) { return running, nil },
+		}, false},
+		{"disabled", false, &fakeClient{}, false},
+		{"tart not found", true, &fakeClient{
+			availableFn: func(ctx context.Context) (string, error) { return "", errors.New("tart: not found") },
+		}, false},
+		{"list failed", true, &fakeClient{
+			listFn: func(ctx context.Context) ([]VMInfo, error) { return nil, errors.New("tart list failed") },
+		}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newTestDriver(t, tc.client)
+			if err := d.SetConfig(pluginConfig(t, &Config{Enabled: tc.enabled})); err != nil {
+				t.Fatalf("SetConfig returned error: %v", err)
+			}
+
+			attr := d.buildFingerprint().Attributes[availableSlotsKey]
+			if attr == nil {
+				t.Fatalf("expected %s to be set", availableSlotsKey)
+			}
+			if attr.Int != nil || attr.Float != nil || attr.String != nil {
+				t.Fatalf("expected %s to be a bool, got %v", availableSlotsKey, attr)
+			}
+			if got, ok := attr.GetBool(); !ok || got != tc.want {
+				t.Fatalf("expected %s = %v, got %v", availableSlotsKey, tc.want, attr)
+			}
+		})
+	}
+}
@@ -3,6 +3,9 @@ package driver
 import (
 	"context"
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,6 +15,16 @@ import (
 	"github.com/hashicorp/nomad/plugins/drivers"
 )
 
+const (
+	// exitMarkerMountName is the name of the shared directory the guest
+	// writes its exit marker to when exit_code_marker is enabled.
+	exitMarkerMountName = "nomad-local"
+
+	// exitMarkerFile is the file within the task's local directory holding
+	// the exit code reported by the guest.
+	exitMarkerFile = "exit_code"
+)
+
 // taskHandle is a handle to a running task
 type taskHandle struct {
 	// stateLock syncs access to all fields below
@@ -38,17 +51,103 @@ type taskHandle struct {
 	// completedAt is when the task exited
 	completedAt time.Time
 
+	// readyAt is when the VM first accepted an SSH connection
+	readyAt time.Time
+
+	// paused is true while the VM is frozen via a PAUSE signal
+	paused bool
+
+	// stopping is set once the driver starts stopping or destroying the
+	// task, so the kills it sends are not mistaken for the host's
+	stopping bool
+
+	// labels are the task's labels, surfaced in its driver attributes
+	labels map[string]string
+
 	// syslogCancel cancels the syslog streaming goroutine
 	syslogCancel context.CancelFunc
 
+	// consoleCancel stops copying the VM's console output to the task's
+	// stdout, nil when console_log is disabled
+	consoleCancel context.CancelFunc
+
 	// exitResult is the result of the task
 	exitResult *drivers.ExitResult
 
+	// vmExitResult is recorded when the VM monitor concludes the VM has gone
+	// away and takes precedence over the executor's exit status
+	vmExitResult *drivers.ExitResult
+
 	// logger is the logger for the task
 	logger hclog.Logger
 
+	// poststopOnce ensures the poststop hook runs once, whether the task is
+	// stopped, destroyed, or both
+	poststopOnce sync.Once
+
 	// doneCh is closed when the task has finished executing
 	doneCh chan struct{}
+
+	// shutdownExitCode is reported when the VM powers off cleanly and no exit
+	// marker is present
+	shutdownExitCode int
+
+	// pullDuration is how long cloning the VM image took during setup.
+	pullDuration time.Duration
+
+	// macAddress is the MAC address of the VM's network interface, empty
+	// when it could not be determined.
+	macAddress string
+
+	// vmResources is the CPU, memory and disk the VM is configured with. It
+	// is zero when they could not be read.
+	vmResources VMResources
+
+	// tartHome is the tart home the VM is stored in, empty for the agent
+	// user's own
+	tartHome string
+
+	// bootScriptPath holds the task's boot script, empty when it has none
+	bootScriptPath string
+
+	// exitMarkerPath is where the guest may write its exit code, empty when
+	// exit markers are disabled
+	exitMarkerPath string
+
+	// relaunch runs tart again under a new executor when it crashes, nil
+	// unless restart_vm_on_crash is set. restarts counts the relaunches, up
+	// to maxRestarts.
+	relaunch    func() (executor.Executor, *plugin.Client, int, error)
+	maxRestarts int
+	restarts    int
+
+	// guest samples the guest's processes for guest_stats, nil until the
+	// task's stats are first collected
+	guest *guestSampler
+
+	// clock is the driver's clock, used to report the uptime of a running
+	// task; the time package is used when nil
+	clock clock
+}
+
+// now returns the current time according to the handle's clock.
+func (h *taskHandle) now() time.Time {
+	if h.clock == nil {
+		return time.Now()
+	}
+	return h.clock.Now()
+}
+
+// guestStats returns the task's guest sampler, creating it on first use so
+// every stats stream of the task shares one connection to the guest.
+func (h *taskHandle) guestStats() *guestSampler {
+	h.stateLock.Lock()
+	defer h.stateLock.Unlock()
+
+	if h.guest == nil {
+		h.guest = &guestSampler{}
+	}
+	return h.guest
 }
 
 // TaskStatus returns the current status of the task
@@ -57,21 +156,166 @@ func (h *taskHandle) TaskStatus() *drivers.TaskStatus {
 	defer h.stateLock.RUnlock()
 
 	status := &drivers.TaskStatus{
-		ID:          h.taskConfig.ID,
-		Name:        h.taskConfig.Name,
-		State:       h.state,
-		StartedAt:   h.startedAt,
-		CompletedAt: h.completedAt,
-		ExitResult:  h.exitResult,
-		DriverAttributes: map[string]string{
-			// No custom attributes for now, but something like the task PID could be useful.
-			"pid": fmt.Sprintf("%d", h.pid),
-		},
+		ID:               h.taskConfig.ID,
+		Name:             h.taskConfig.Name,
+		State:            h.state,
+		StartedAt:        h.startedAt,
+		CompletedAt:      h.completedAt,
+		ExitResult:       h.exitResult,
+		DriverAttributes: map[string]string{},
+	}
+
+	// Once the VM is known to be gone the task is reported as exited, even
+	// before its executor has been shut down and run has recorded it.
+	if h.state == drivers.TaskStateRunning && h.vmExitResult != nil {
+		status.State = drivers.TaskStateExited
+		status.ExitResult = h.vmExitResult
+	}
+
+	// Attributes set by the driver below take precedence over labels of the
+	// same name.
+	for k, v := range h.labels {
+		status.DriverAttributes[k] = v
+	}
+	status.DriverAttributes["pid"] = fmt.Sprintf("%d", h.pid)
+	if h.restarts > 0 {
+		status.DriverAttributes["vm_restarts"] = strconv.Itoa(h.restarts)
+	}
+
+	if status.State == drivers.TaskStateRunning {
+		vmState := VMStateRunning
+		if h.paused {
+			vmState = VMStatePaused
+		}
+		status.DriverAttributes["vm_state"] = string(vmState)
+	}
+
+	if !h.startedAt.IsZero() {
+		end := h.completedAt
+		if end.IsZero() {
+			end = h.now()
+		}
+		status.DriverAttributes["uptime_s"] = fmt.Sprintf("%d", int64(end.Sub(h.startedAt).Seconds()))
+	}
+
+	if h.pullDuration > 0 {
+		status.DriverAttributes["pull_duration_ms"] = fmt.Sprintf("%d", h.pullDuration.Milliseconds())
+	}
+
+	if h.macAddress != "" {
+		status.DriverAttributes["mac_address"] = h.macAddress
+	}
+
+	if h.vmResources.CPU > 0 {
+		status.DriverAttributes["cpu_count"] = fmt.Sprintf("%d", h.vmResources.CPU)
+		status.DriverAttributes["memory_mb"] = fmt.Sprintf("%d", h.vmResources.MemoryMB)
+		status.DriverAttributes["disk_gb"] = fmt.Sprintf("%d", h.vmResources.DiskGB)
+	}
+
+	if !h.readyAt.IsZero() {
+		status.DriverAttributes["boot_duration_ms"] = fmt.Sprintf("%d", h.readyAt.Sub(h.startedAt).Milliseconds())
 	}
 
 	return status
 }
 
+// setPaused records whether the VM is currently frozen.
+func (h *taskHandle) setPaused(paused bool) {
+	h.stateLock.Lock()
+	defer h.stateLock.Unlock()
+	h.paused = paused
+}
+
+// IsPaused returns whether the VM is currently frozen.
+func (h *taskHandle) IsPaused() bool {
+	h.stateLock.RLock()
+	defer h.stateLock.RUnlock()
+	return h.paused
+}
+
+// restartAfterCrash relaunches tart when it exited unexpectedly and the task
+// set restart_vm_on_crash, returning whether it did. Clean power-offs, exits
+// while the driver stops the task and VMs the monitor found gone are final.
+func (h *taskHandle) restartAfterCrash(ps *executor.ProcessState) bool {
+	if h.relaunch == nil || (ps.ExitCode == 0 && ps.Signal == 0) {
+		return false
+	}
+
+	h.stateLock.RLock()
+	final := h.stopping || h.vmExitResult != nil
+	exhausted := h.restarts >= h.maxRestarts
+	h.stateLock.RUnlock()
+	if final {
+		return false
+	}
+	if exhausted {
+		h.logger.Error("VM crashed too many times, giving up", "task_id", h.taskConfig.ID, "restarts", h.restarts, "exit_code", ps.ExitCode, "signal", ps.Signal)
+		return false
+	}
+
+	h.logger.Warn("VM crashed, restarting it", "task_id", h.taskConfig.ID, "exit_code", ps.ExitCode, "signal", ps.Signal, "restart", h.restarts+1, "max_restarts", h.maxRestarts)
+
+	// tart has exited but its executor is still running, so shut it down
+	// before tart is launched under a new one.
+	oldExec, oldPluginClient, _ := h.process()
+	if err := oldExec.Shutdown("", 0); err != nil {
+		h.logger.Warn("failed to shut down the crashed VM's executor", "task_id", h.taskConfig.ID, "error", err)
+	}
+	if oldPluginClient != nil {
+		oldPluginClient.Kill()
+	}
+
+	exec, pluginClient, pid, err := h.relaunch()
+	if err != nil {
+		h.logger.Error("failed to restart crashed VM", "task_id", h.taskConfig.ID, "error", err)
+		return false
+	}
+
+	h.stateLock.Lock()
+	defer h.stateLock.Unlock()
+
+	// The task may have been stopped while tart was relaunched.
+	if h.stopping {
+		exec.Shutdown("", 0)
+		pluginClient.Kill()
+		return false
+	}
+	h.exec, h.pluginClient, h.pid = exec, pluginClient, pid
+	h.restarts++
+	return true
+}
+
+// process returns the executor running tart, its plugin client and tart's
+// PID, which all change when a crashed VM is restarted.
+func (h *taskHandle) process() (executor.Executor, *plugin.Client, int) {
+	h.stateLock.RLock()
+	defer h.stateLock.RUnlock()
+	return h.exec, h.pluginClient, h.pid
+}
+
+// markStopping records that the driver is stopping the task.
+func (h *taskHandle) markStopping() {
+	h.stateLock.Lock()
+	defer h.stateLock.Unlock()
+	h.stopping = true
+}
+
+func (h *taskHandle) isStopping() bool {
+	h.stateLock.RLock()
+	defer h.stateLock.RUnlock()
+	return h.stopping
+}
+
+// setReady records the time the VM first became reachable over SSH. Only the
+// first call has an effect.
+func (h *taskHandle) setReady(t time.Time) {
+	h.stateLock.Lock()
+	defer h.stateLock.Unlock()
+	if h.readyAt.IsZero() {
+		h.readyAt = t
+	}
+}
+
 // IsRunning returns whether the task is running
 func (h *taskHandle) IsRunning() bool {
 	h.stateLock.RLock()
@@ -79,6 +323,27 @@ func (h *taskHandle) IsRunning() bool {
 	return h.state == drivers.TaskStateRunning
 }
 
+// ExitResult returns a copy of the task's exit result, or nil if the task has
+// not exited.
+func (h *taskHandle) ExitResult() *drivers.ExitResult {
+	h.stateLock.RLock()
+	defer h.stateLock.RUnlock()
+	if h.exitResult == nil {
+		return nil
+	}
+	return h.exitResult.Copy()
+}
+
+// markVMExited records the exit result determined by the VM monitor. The
+// executor is expected to be shut down afterwards so run can complete.
+func (h *taskHandle) markVMExited(result *drivers.ExitResult) {
+	h.stateLock.Lock()
+	defer h.stateLock.Unlock()
+	if h.vmExitResult == nil {
+		h.vmExitResult = result
+	}
+}
+
 // run waits on the executor and updates the task state when the process exits.
 func (h *taskHandle) run() {
 	defer close(h.doneCh)
@@ -92,13 +357,28 @@ func (h *taskHandle) run() {
 	}
 	h.stateLock.Unlock()
 
-	ps, err := h.exec.Wait(context.Background())
+	// A restart replaces the executor, so wait on whichever runs tart now.
+	exec, _, _ := h.process()
+	ps, err := exec.Wait(context.Background())
+	for err == nil && h.restartAfterCrash(ps) {
+		exec, _, _ = h.process()
+		ps, err = exec.Wait(context.Background())
+	}
+	oomKilled := err == nil && h.killedForMemory(ps.Signal)
 
 	h.stateLock.Lock()
 	defer h.stateLock.Unlock()
 
+	if h.vmExitResult != nil {
+		h.state = drivers.TaskStateExited
+		h.exitResult = h.vmExitResult
+		h.completedAt = time.Now()
+		h.resolveCleanShutdown()
+		return
+	}
+
 	if err != nil {
-		h.exitResult.Err = err
+		h.exitResult.Err = fmt.Errorf("executor: error waiting on process: %v", err)
 		h.state = drivers.TaskStateUnknown
 		h.completedAt = time.Now()
 		return
@@ -107,5 +387,47 @@ func (h *taskHandle) run() {
 	h.state = drivers.TaskStateExited
 	h.exitResult.ExitCode = ps.ExitCode
 	h.exitResult.Signal = ps.Signal
+	if oomKilled {
+		h.exitResult.OOMKilled = true
+		h.exitResult.Err = fmt.Errorf("VM was killed by the host under memory pressure")
+	}
 	h.completedAt = ps.Time
+	h.resolveCleanShutdown()
+}
+
+// resolveCleanShutdown replaces the exit code of a clean VM power-off with the
+// code from the guest's exit marker when present, or the configured shutdown
+// exit code otherwise. stateLock must be held.
+func (h *taskHandle) resolveCleanShutdown() {
+	res := h.exitResult
+	if res == nil || res.Err != nil || res.Signal != 0 || res.ExitCode != 0 {
+		return
+	}
+
+	if h.exitMarkerPath != "" {
+		code, err := readExitMarker(h.exitMarkerPath)
+		if err == nil {
+			res.ExitCode = code
+			return
+		}
+		if !os.IsNotExist(err) && h.logger != nil {
+			h.logger.Warn("failed to read exit marker", "path", h.exitMarkerPath, "error", err)
+		}
+	}
+
+	res.ExitCode = h.shutdownExitCode
+}
+
+// readExitMarker parses the exit code written by the guest to path.
+func readExitMarker(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	code, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid exit marker %q: %v", strings.TrimSpace(string(data)), err)
+	}
+	return code, nil
 }
@@ -1,12 +1,102 @@
 package driver
 
 import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/hashicorp/nomad/drivers/shared/executor"
 	"github.com/hashicorp/nomad/plugins/drivers"
 )
 
+// fakeExecutor is an executor.Executor whose process "exits" when a
+// ProcessState (or error) is sent on exitCh.
+type fakeExecutor struct {
+	exitCh  chan *executor.ProcessState
+	waitErr error
+
+	// sample, when set, is the first usage sent by Stats
+	sample *drivers.TaskResourceUsage
+
+	lock      sync.Mutex
+	launched  *executor.ExecCommand
+	shutdowns []string
+	graces    []time.Duration
+	signals   []os.Signal
+}
+
+func newFakeExecutor() *fakeExecutor {
+	return &fakeExecutor{exitCh: make(chan *executor.ProcessState, 1)}
+}
+
+func (f *fakeExecutor) Launch(cmd *executor.ExecCommand) (*executor.ProcessState, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.launched = cmd
+	return &executor.ProcessState{Pid: 4242}, nil
+}
+
+func (f *fakeExecutor) Wait(ctx context.Context) (*executor.ProcessState, error) {
+	select {
+	case ps := <-f.exitCh:
+		if f.waitErr != nil {
+			return nil, f.waitErr
+		}
+		return ps, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (f *fakeExecutor) Shutdown(signal string, gracePeriod time.Duration) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.shutdowns = append(f.shutdowns, signal)
+	f.graces = append(f.graces, gracePeriod)
+	return nil
+}
+
+func (f *fakeExecutor) UpdateResources(*drivers.Resources) error { return nil }
+
+func (f *fakeExecutor) Version() (*executor.ExecutorVersion, error) {
+	return &executor.ExecutorVersion{Version: "test"}, nil
+}
+
+func (f *fakeExecutor) Stats(ctx context.Context, interval time.Duration) (<-chan *drivers.TaskResourceUsage, error) {
+	ch := make(chan *drivers.TaskResourceUsage)
+	go func() {
+		defer close(ch)
+		if f.sample != nil {
+			select {
+			case ch <- f.sample:
+			case <-ctx.Done():
+				return
+			}
+		}
+		<-ctx.Done()
+	}()
+	return ch, nil
+}
+
+func (f *fakeExecutor) Signal(sig os.Signal) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.signals = append(f.signals, sig)
+	return nil
+}
+
+func (f *fakeExecutor) Exec(deadline time.Time, cmd string, args []string) ([]byte, int, error) {
+	return nil, 0, nil
+}
+
+func (f *fakeExecutor) ExecStreaming(ctx context.Context, cmd []string, tty bool, stream drivers.ExecTaskStream) error {
+	return nil
+}
+
 func TestTaskHandleTaskStatus(t *testing.T) {
 	t.Parallel()
 	h := &taskHandle{
@@ -40,3 +130,307 @@ func TestTaskHandleIsRunning(t *testing.T) {
 		t.Fatalf("expected not running")
 	}
 }
+
+func TestTaskHandleTaskStatus_UptimeAndBootDuration(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	started := clock.Now()
+	clock.Advance(90 * time.Second)
+	h := &taskHandle{
+		taskConfig: &drivers.TaskConfig{ID: "id", Name: "name"},
+		state:      drivers.TaskStateRunning,
+		startedAt:  started,
+		clock:      clock,
+	}
+
+	st := h.TaskStatus()
+	if st.DriverAttributes["uptime_s"] != "90" {
+		t.Fatalf("unexpected uptime_s: %q", st.DriverAttributes["uptime_s"])
+	}
+	if _, ok := st.DriverAttributes["boot_duration_ms"]; ok {
+		t.Fatalf("boot_duration_ms should be absent before the VM is ready")
+	}
+
+	h.setReady(started.Add(1500 * time.Millisecond))
+	h.setReady(started.Add(time.Hour)) // only the first readiness counts
+
+	st = h.TaskStatus()
+	if st.DriverAttributes["boot_duration_ms"] != "1500" {
+		t.Fatalf("unexpected boot_duration_ms: %q", st.DriverAttributes["boot_duration_ms"])
+	}
+}
+
+func TestTaskHandleTaskStatus_PullDuration(t *testing.T) {
+	t.Parallel()
+	h := &taskHandle{
+		taskConfig: &drivers.TaskConfig{ID: "id", Name: "name"},
+		state:      drivers.TaskStateRunning,
+	}
+	if _, ok := h.TaskStatus().DriverAttributes["pull_duration_ms"]; ok {
+		t.Fatalf("pull_duration_ms should be absent when unknown")
+	}
+
+	h.pullDuration = 2500 * time.Millisecond
+	if got := h.TaskStatus().DriverAttributes["pull_duration_ms"]; got != "2500" {
+		t.Fatalf("unexpected pull_duration_ms: %q", got)
+	}
+}
+
+func TestTaskHandleTaskStatus_VMResources(t *testing.T) {
+	t.Parallel()
+	h := &taskHandle{
+		taskConfig: &drivers.TaskConfig{ID: "id", Name: "name"},
+		state:      drivers.TaskStateRunning,
+	}
+	if _, ok := h.TaskStatus().DriverAttributes["cpu_count"]; ok {
+		t.Fatalf("cpu_count should be absent when unknown")
+	}
+
+	h.vmResources = VMResources{CPU: 4, MemoryMB: 8192, DiskGB: 50}
+	attrs := h.TaskStatus().DriverAttributes
+	if attrs["cpu_count"] != "4" || attrs["memory_mb"] != "8192" || attrs["disk_gb"] != "50" {
+		t.Fatalf("unexpected resource attributes: %v", attrs)
+	}
+}
+
+func TestTaskHandleTaskStatus_Labels(t *testing.T) {
+	t.Parallel()
+	h := &taskHandle{
+		taskConfig: &drivers.TaskConfig{ID: "id", Name: "name"},
+		state:      drivers.TaskStateRunning,
+		pid:        4242,
+		labels:     map[string]string{"team": "mobile", "pid": "1"},
+	}
+	attrs := h.TaskStatus().DriverAttributes
+	if got := attrs["team"]; got != "mobile" {
+		t.Fatalf("unexpected team label: %q", got)
+	}
+	if got := attrs["pid"]; got != "4242" {
+		t.Fatalf("a label overrode the pid attribute: %q", got)
+	}
+}
+
+func TestTaskHandleTaskStatus_MACAddress(t *testing.T) {
+	t.Parallel()
+	h := &taskHandle{
+		taskConfig: &drivers.TaskConfig{ID: "id", Name: "name"},
+		state:      drivers.TaskStateRunning,
+	}
+	if _, ok := h.TaskStatus().DriverAttributes["mac_address"]; ok {
+		t.Fatalf("mac_address should be absent when unknown")
+	}
+
+	h.macAddress = "7e:a1:2b:3c:4d:5e"
+	if got := h.TaskStatus().DriverAttributes["mac_address"]; got != "7e:a1:2b:3c:4d:5e" {
+		t.Fatalf("unexpected mac_address: %q", got)
+	}
+}
+
+func TestTaskHandleTaskStatus_UptimeStopsAtCompletion(t *testing.T) {
+	t.Parallel()
+	started := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	h := &taskHandle{
+		taskConfig:  &drivers.TaskConfig{ID: "id", Name: "name"},
+		state:       drivers.TaskStateExited,
+		startedAt:   started,
+		completedAt: started.Add(42 * time.Second),
+	}
+
+	if got := h.TaskStatus().DriverAttributes["uptime_s"]; got != "42" {
+		t.Fatalf("unexpected uptime_s: %q", got)
+	}
+}
+
+func TestTaskHandle_UnifiedFields(t *testing.T) {
+	t.Parallel()
+	exec := newFakeExecutor()
+	syslogCancelled := false
+	h := &taskHandle{
+		exec:         exec,
+		pid:          4242,
+		taskConfig:   &drivers.TaskConfig{ID: "id", Name: "name"},
+		state:        drivers.TaskStateRunning,
+		startedAt:    time.Now(),
+		logger:       testLogger(t),
+		doneCh:       make(chan struct{}),
+		syslogCancel: func() { syslogCancelled = true },
+	}
+
+	if !h.IsRunning() {
+		t.Fatalf("expected running")
+	}
+	if got := h.TaskStatus().DriverAttributes["pid"]; got != "4242" {
+		t.Fatalf("unexpected pid attribute: %v", got)
+	}
+
+	go h.run()
+	exec.exitCh <- &executor.ProcessState{ExitCode: 0, Time: time.Now()}
+	<-h.doneCh
+
+	if h.IsRunning() {
+		t.Fatalf("expected not running after exit")
+	}
+	if !syslogCancelled {
+		t.Fatalf("expected syslog streaming to be cancelled on exit")
+	}
+	if st := h.TaskStatus(); st.State != drivers.TaskStateExited {
+		t.Fatalf("unexpected state: %v", st.State)
+	}
+}
+
+func TestTaskHandleRun_RecordsExitResult(t *testing.T) {
+	t.Parallel()
+	exec := newFakeExecutor()
+	h := &taskHandle{
+		exec:       exec,
+		taskConfig: &drivers.TaskConfig{ID: "id"},
+		state:      drivers.TaskStateRunning,
+		doneCh:     make(chan struct{}),
+	}
+
+	if h.ExitResult() != nil {
+		t.Fatalf("expected no exit result before the task exits")
+	}
+
+	exitedAt := time.Now().Round(time.Millisecond)
+	go h.run()
+	exec.exitCh <- &executor.ProcessState{ExitCode: 3, Signal: 9, Time: exitedAt}
+	<-h.doneCh
+
+	res := h.ExitResult()
+	if res == nil || res.ExitCode != 3 || res.Signal != 9 || res.Err != nil {
+		t.Fatalf("unexpected exit result: %#v", res)
+	}
+	if st := h.TaskStatus(); !st.CompletedAt.Equal(exitedAt) {
+		t.Fatalf("unexpected completedAt: %v", st.CompletedAt)
+	}
+}
+
+func TestTaskHandleRun_FlagsKillUnderMemoryPressure(t *testing.T) {
+	orig := hostUnderMemoryPressure
+	hostUnderMemoryPressure = func() (bool, error) { return true, nil }
+	defer func() { hostUnderMemoryPressure = orig }()
+
+	cases := []struct {
+		name     string
+		signal   int
+		stopping bool
+		wantOOM  bool
+	}{
+		{"killed by the host", 9, false, true},
+		{"killed while stopping", 9, true, false},
+		{"terminated", 15, false, false},
+	}
+	for _, tc := range cases {
+		exec := newFakeExecutor()
+		h := &taskHandle{
+			exec:       exec,
+			taskConfig: &drivers.TaskConfig{ID: "id"},
+			state:      drivers.TaskStateRunning,
+			doneCh:     make(chan struct{}),
+			stopping:   tc.stopping,
+		}
+
+		go h.run()
+		exec.exitCh <- &executor.ProcessState{ExitCode: 137, Signal: tc.signal, Time: time.Now()}
+		<-h.doneCh
+
+		res := h.ExitResult()
+		if res.OOMKilled != tc.wantOOM {
+			t.Fatalf("%s: expected oom killed %v, got %#v", tc.name, tc.wantOOM, res)
+		}
+		if tc.wantOOM && (res.Err == nil || !strings.Contains(res.Err.Error(), "memory pressure")) {
+			t.Fatalf("%s: expected a memory pressure error, got %v", tc.name, res.Err)
+		}
+		if !tc.wantOOM && res.Err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, res.Err)
+		}
+	}
+}
+
+func TestTaskHandleRun_RecordsWaitError(t *testing.T) {
+	t.Parallel()
+	exec := newFakeExecutor()
+	exec.waitErr = context.DeadlineExceeded
+	h := &taskHandle{
+		exec:       exec,
+		taskConfig: &drivers.TaskConfig{ID: "id"},
+		state:      drivers.TaskStateRunning,
+		doneCh:     make(chan struct{}),
+	}
+
+	go h.run()
+	exec.exitCh <- nil
+	<-h.doneCh
+
+	if res := h.ExitResult(); res == nil || res.Err == nil {
+		t.Fatalf("expected exit result to carry the wait error, got %#v", res)
+	}
+	if st := h.TaskStatus(); st.State != drivers.TaskStateUnknown {
+		t.Fatalf("unexpected state: %v", st.State)
+	}
+}
+
+func TestTaskHandleRun_ExitMarkerPresent(t *testing.T) {
+	t.Parallel()
+	marker := filepath.Join(t.TempDir(), exitMarkerFile)
+	if err := os.WriteFile(marker, []byte("42\n"), 0o644); err != nil {
+		t.Fatalf("writing marker: %v", err)
+	}
+
+	exec := newFakeExecutor()
+	h := &taskHandle{
+		exec:             exec,
+		taskConfig:       &drivers.TaskConfig{ID: "id"},
+		doneCh:           make(chan struct{}),
+		shutdownExitCode: 3,
+		exitMarkerPath:   marker,
+	}
+
+	go h.run()
+	exec.exitCh <- &executor.ProcessState{ExitCode: 0, Time: time.Now()}
+	<-h.doneCh
+
+	if res := h.ExitResult(); res.ExitCode != 42 {
+		t.Fatalf("expected exit code from marker, got %d", res.ExitCode)
+	}
+}
+
+func TestTaskHandleRun_ExitMarkerAbsent(t *testing.T) {
+	t.Parallel()
+	exec := newFakeExecutor()
+	h := &taskHandle{
+		exec:             exec,
+		taskConfig:       &drivers.TaskConfig{ID: "id"},
+		doneCh:           make(chan struct{}),
+		shutdownExitCode: 3,
+		exitMarkerPath:   filepath.Join(t.TempDir(), exitMarkerFile),
+	}
+
+	go h.run()
+	exec.exitCh <- &executor.ProcessState{ExitCode: 0, Time: time.Now()}
+	<-h.doneCh
+
+	if res := h.ExitResult(); res.ExitCode != 3 {
+		t.Fatalf("expected configured shutdown exit code, got %d", res.ExitCode)
+	}
+}
+
+func TestTaskHandleRun_FailedExitIgnoresShutdownCode(t *testing.T) {
+	t.Parallel()
+	exec := newFakeExecutor()
+	h := &taskHandle{
+		exec:             exec,
+		taskConfig:       &drivers.TaskConfig{ID: "id"},
+		doneCh:           make(chan struct{}),
+		shutdownExitCode: 3,
+	}
+
+	go h.run()
+	exec.exitCh <- &executor.ProcessState{ExitCode: 1, Time: time.Now()}
+	<-h.doneCh
+
+	if res := h.ExitResult(); res.ExitCode != 1 {
+		t.Fatalf("expected the tart exit code to be preserved, got %d", res.ExitCode)
+	}
+}
@@ -0,0 +1,76 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// defaultHookTimeout bounds a host hook without a configured timeout.
+const defaultHookTimeout = time.Minute
+
+// hostHook is a validated prestart_hook or poststop_hook plugin setting.
+type hostHook struct {
+	name    string
+	command []string
+	timeout time.Duration
+}
+
+// newHostHook validates the hook block called name, returning nil when none
+// is configured.
+func newHostHook(name string, config *HostHookConfig) (*hostHook, error) {
+	if config == nil {
+		return nil, nil
+	}
+	if len(config.Command) == 0 || config.Command[0] == "" {
+		return nil, fmt.Errorf("%s command must not be empty", name)
+	}
+
+	timeout := defaultHookTimeout
+	if config.Timeout != "" {
+		parsed, err := time.ParseDuration(config.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s timeout %q: %v", name, config.Timeout, err)
+		}
+		if parsed <= 0 {
+			return nil, fmt.Errorf("%s timeout must be positive, got %s", name, parsed)
+		}
+		timeout = parsed
+	}
+
+	return &hostHook{name: name, command: config.Command, timeout: timeout}, nil
+}
+
+// hookEnv is the environment a host hook runs with: the task's environment,
+// less any denied variables, so the hook can tell which allocation it is for,
+// and TART_VM_NAME naming the task's VM.
+func hookEnv(cfg *drivers.TaskConfig, vmName string, denylist []string) []string {
+	env := filterEnv(append(os.Environ(), cfg.EnvList()...), denylist)
+	return append(env, "TART_VM_NAME="+vmName)
+}
+
+// run executes the hook on the host with env.
+func (h *hostHook) run(ctx context.Context, env []string) error {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	cmd := execCommandContext(ctx, h.command[0], h.command[1:]...)
+	cmd.Env = env
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("%s timed out after %s (output: %s)", h.name, h.timeout, strings.TrimSpace(output.String()))
+		}
+		return fmt.Errorf("%s failed: %v (output: %s)", h.name, err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
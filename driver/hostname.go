@@ -0,0 +1,64 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// maxHostnameLength and maxHostnameLabelLength are the RFC 1123 limits
+	// for a full hostname and each dot separated label.
+	maxHostnameLength      = 253
+	maxHostnameLabelLength = 63
+
+	// defaultHostnameIDLength is how many characters of the allocation ID
+	// make up the default hostname, matching Nomad's short alloc IDs.
+	defaultHostnameIDLength = 8
+)
+
+// hostnameLabelPattern matches a single RFC 1123 hostname label.
+var hostnameLabelPattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?$`)
+
+// validateHostname checks that name is a legal RFC 1123 hostname.
+func validateHostname(name string) error {
+	if name == "" || len(name) > maxHostnameLength {
+		return fmt.Errorf("hostname must be between 1 and %d characters", maxHostnameLength)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) > maxHostnameLabelLength || !hostnameLabelPattern.MatchString(label) {
+			return fmt.Errorf("invalid hostname %q: labels must be 1-%d letters, digits or hyphens and may not start or end with a hyphen",
+				name, maxHostnameLabelLength)
+		}
+	}
+	return nil
+}
+
+// vmHostname returns the hostname to give the guest: the configured one, or
+// the short allocation ID when none is set.
+func vmHostname(cfg TaskConfig, allocID string) string {
+	if cfg.Hostname != "" {
+		return cfg.Hostname
+	}
+	if len(allocID) > defaultHostnameIDLength {
+		return allocID[:defaultHostnameIDLength]
+	}
+	return allocID
+}
+
+// setHostname sets the guest's hostname over SSH so VMs cloned from the same
+// image don't all share the base image's hostname.
+func (d *Driver) setHostname(ctx context.Context, vmConfig VMConfig) error {
+	name := vmHostname(vmConfig.TaskConfig, vmConfig.NomadConfig.AllocID)
+	code, err := d.client.Exec(ctx, vmConfig, ExecOptions{
+		Command: []string{"sudo", "scutil", "--set", "HostName", name},
+	})
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("scutil exited with code %d", code)
+	}
+	return nil
+}
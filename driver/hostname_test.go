@@ -0,0 +1,59 @@
+package driver
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+func TestValidateHostname(t *testing.T) {
+	valid := []string{"build-01", "ci.example.com", "a", "A1", strings.Repeat("a", 63)}
+	for _, name := range valid {
+		if err := validateHostname(name); err != nil {
+			t.Fatalf("%q: unexpected error: %v", name, err)
+		}
+	}
+
+	invalid := []string{"", "-build", "build-", "bad_name", "two..dots", "space name", strings.Repeat("a", 64), strings.Repeat("a.", 127) + "a"}
+	for _, name := range invalid {
+		if err := validateHostname(name); err == nil {
+			t.Fatalf("%q: expected error", name)
+		}
+	}
+}
+
+func TestVMHostname_DefaultsToShortAllocID(t *testing.T) {
+	if got := vmHostname(TaskConfig{}, "0123456789abcdef"); got != "01234567" {
+		t.Fatalf("unexpected default hostname: %s", got)
+	}
+	if got := vmHostname(TaskConfig{Hostname: "builder"}, "0123456789abcdef"); got != "builder" {
+		t.Fatalf("unexpected configured hostname: %s", got)
+	}
+}
+
+func TestWaitForReady_SetsHostname(t *testing.T) {
+	var got []string
+	client := &fakeClient{
+		execFn: func(ctx context.Context, config VMConfig, opts ExecOptions) (int, error) {
+			got = opts.Command
+			return 0, nil
+		},
+	}
+	d := newTestDriver(t, client)
+
+	h := &taskHandle{taskConfig: &drivers.TaskConfig{ID: "task-1"}, startedAt: time.Now()}
+	vmc := VMConfig{
+		TaskConfig:  TaskConfig{Hostname: "builder-7"},
+		NomadConfig: &drivers.TaskConfig{AllocID: "alloc-1"},
+	}
+	d.waitForReady(context.Background(), h, vmc)
+
+	want := []string{"sudo", "scutil", "--set", "HostName", "builder-7"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,79 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// imageDigestPattern matches the sha256 manifest digests tart pulls by.
+var imageDigestPattern = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// validateImageDigest checks that digest, when set, is a sha256 digest and
+// agrees with any digest the image URL is pinned to.
+func validateImageDigest(digest, url string) error {
+	if digest == "" {
+		return nil
+	}
+	if !imageDigestPattern.MatchString(digest) {
+		return fmt.Errorf("image_digest %q must be of the form sha256:<64 hex characters>", digest)
+	}
+	if _, pinned := splitDigest(normalizeImageRef(url)); pinned != "" && pinned != digest {
+		return fmt.Errorf("image_digest %s does not match the digest in the image URL (%s)", digest, pinned)
+	}
+	return nil
+}
+
+// cachedImageDigest returns the digest of the image tart has cached for url
+// in home. tart stores each pulled image under its digest and records a tag
+// as a symlink to it, so the digest of a tagged image is the symlink's
+// target.
+func cachedImageDigest(home, url string) (string, error) {
+	name, digest := splitDigest(normalizeImageRef(url))
+	if digest != "" {
+		return digest, nil
+	}
+
+	target, err := os.Readlink(cachedImageDir(home, url))
+	if err != nil {
+		return "", fmt.Errorf("failed to find cached image %s: %v", name, err)
+	}
+	return filepath.Base(target), nil
+}
+
+// cachedImageDir returns the directory tart caches the image url in under
+// home: the image's repository path followed by its tag or digest.
+func cachedImageDir(home, url string) string {
+	name, digest := splitDigest(normalizeImageRef(url))
+	repo, tag := splitTag(name)
+	if digest != "" {
+		repo, tag = name, digest
+	}
+	return filepath.Join(append([]string{home, "cache", "OCIs"}, append(strings.Split(repo, "/"), tag)...)...)
+}
+
+// verifyImageDigest fails when the image vmName was cloned from does not have
+// the expected digest. The VM is deleted so that a retry clones it afresh.
+func (c *TartClient) verifyImageDigest(ctx context.Context, config VMConfig, vmName, expected string) error {
+	home := config.TartHome
+	if home == "" {
+		home = tartHome()
+	}
+
+	actual, err := cachedImageDigest(home, config.TaskConfig.URL)
+	if err == nil && actual == expected {
+		c.logger.Debug("Verified image digest", "name", vmName, "digest", actual)
+		return nil
+	}
+
+	if delErr := c.Delete(ctx, vmName); delErr != nil {
+		c.logger.Warn("failed to delete VM after digest mismatch", "name", vmName, "error", delErr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to verify digest of image %s: %v", config.TaskConfig.URL, err)
+	}
+	return fmt.Errorf("image %s has digest %s, expected %s", config.TaskConfig.URL, actual, expected)
+}
@@ -0,0 +1,120 @@
+package driver
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+var (
+	testDigestA = "sha256:" + strings.Repeat("a", 64)
+	testDigestB = "sha256:" + strings.Repeat("b", 64)
+)
+
+// cacheTaggedImage lays out tart's OCI cache in home with tag pointing at
+// digest, as tart does after pulling an image by tag.
+func cacheTaggedImage(t *testing.T, home, repo, tag, digest string) {
+	t.Helper()
+	dir := filepath.Join(home, "cache", "OCIs", filepath.FromSlash(repo))
+	if err := os.MkdirAll(filepath.Join(dir, digest), 0o755); err != nil {
+		t.Fatalf("failed to create cache dir: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(dir, digest), filepath.Join(dir, tag)); err != nil {
+		t.Fatalf("failed to link tag: %v", err)
+	}
+}
+
+func TestValidateImageDigest(t *testing.T) {
+	cases := []struct {
+		name    string
+		digest  string
+		url     string
+		wantErr bool
+	}{
+		{"unset", "", "ghcr.io/org/img:latest", false},
+		{"valid", testDigestA, "ghcr.io/org/img:latest", false},
+		{"matches pinned URL", testDigestA, "ghcr.io/org/img@" + testDigestA, false},
+		{"differs from pinned URL", testDigestA, "ghcr.io/org/img@" + testDigestB, true},
+		{"not sha256", "md5:abc", "ghcr.io/org/img:latest", true},
+		{"uppercase", "sha256:" + strings.Repeat("A", 64), "ghcr.io/org/img:latest", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateImageDigest(tc.digest, tc.url); (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestCachedImageDigest_FollowsTagLink(t *testing.T) {
+	home := t.TempDir()
+	cacheTaggedImage(t, home, "ghcr.io/org/img", "1.0", testDigestA)
+
+	got, err := cachedImageDigest(home, "ghcr.io/org/img:1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != testDigestA {
+		t.Fatalf("got %s, want %s", got, testDigestA)
+	}
+
+	if _, err := cachedImageDigest(home, "ghcr.io/org/img:2.0"); err == nil {
+		t.Fatal("expected an error for an image that is not cached")
+	}
+}
+
+func TestSetup_VerifiesImageDigest(t *testing.T) {
+	cases := []struct {
+		name     string
+		expected string
+		wantErr  bool
+	}{
+		{"matching digest proceeds", testDigestA, false},
+		{"mismatched digest aborts", testDigestB, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			home := t.TempDir()
+			cacheTaggedImage(t, home, "ghcr.io/org/img", "latest", testDigestA)
+
+			var calls []string
+			orig := execCommandContext
+			execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+				calls = append(calls, args[0])
+				return exec.CommandContext(ctx, "true")
+			}
+			defer func() { execCommandContext = orig }()
+
+			c := NewTartClient(testLogger(t))
+			vmc := VMConfig{
+				TaskConfig:  TaskConfig{URL: "ghcr.io/org/img:latest", ImageDigest: tc.expected},
+				NomadConfig: &drivers.TaskConfig{AllocID: "alloc-digest"},
+				TartHome:    home,
+			}
+
+			_, err := c.Setup(context.Background(), vmc)
+			if tc.wantErr {
+				if err == nil || !strings.Contains(err.Error(), tc.expected) {
+					t.Fatalf("expected a digest mismatch error, got %v", err)
+				}
+				if !slices.Contains(calls, "delete") || slices.Contains(calls, "set") {
+					t.Fatalf("expected the VM to be deleted before being configured, got %v", calls)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Setup returned error: %v", err)
+			}
+			if !slices.Contains(calls, "set") || slices.Contains(calls, "delete") {
+				t.Fatalf("expected setup to continue to configuring the VM, got %v", calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package driver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// gzipMagic and zstdMagic are the leading bytes of gzip and zstd streams.
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// imageSourceRef returns the reference recorded as a VM's source image: the
+// image_file as a file:// URL when set, otherwise the url.
+func imageSourceRef(taskConfig TaskConfig) string {
+	if taskConfig.ImageFile != "" {
+		return "file://" + taskConfig.ImageFile
+	}
+	return taskConfig.URL
+}
+
+// validateImageFile checks that image_file names an existing host file.
+func validateImageFile(path string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("image_file must be an absolute path, got %q", path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("image_file %s is not accessible: %v", path, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("image_file %s is a directory", path)
+	}
+	return nil
+}
+
+// decompressImageArchive returns the path of an archive tart can import from
+// path. Archives wrapped in gzip or zstd are decompressed into scratchDir and
+// the returned cleanup removes the copy; other files are returned as is.
+func decompressImageArchive(ctx context.Context, path, scratchDir string) (string, func(), error) {
+	noop := func() {}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", noop, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(zstdMagic))
+	n, err := io.ReadFull(f, magic)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", noop, err
+	}
+	magic = magic[:n]
+
+	isGzip, isZstd := bytes.HasPrefix(magic, gzipMagic), bytes.HasPrefix(magic, zstdMagic)
+	if !isGzip && !isZstd {
+		return path, noop, nil
+	}
+
+	out, err := os.CreateTemp(scratchDir, strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))+"-*.tvm")
+	if err != nil {
+		return "", noop, fmt.Errorf("failed to create scratch file for %s: %v", path, err)
+	}
+	cleanup := func() { os.Remove(out.Name()) }
+
+	if isGzip {
+		err = gunzipTo(f, out)
+	} else {
+		// The standard library has no zstd decoder, so defer to the zstd CLI
+		// like other host tools the driver relies on.
+		out.Close()
+		cmd := execCommandContext(ctx, "zstd", "--decompress", "--quiet", "--force", "-o", out.Name(), path)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if runErr := cmd.Run(); runErr != nil {
+			err = fmt.Errorf("zstd failed: %v (stderr: %s)", runErr, stderr.String())
+		}
+	}
+	if err != nil {
+		cleanup()
+		return "", noop, fmt.Errorf("failed to decompress %s: %v", path, err)
+	}
+	return out.Name(), cleanup, nil
+}
+
+// gunzipTo decompresses the gzip stream in src, from its start, into dst and
+// closes dst.
+func gunzipTo(src *os.File, dst *os.File) error {
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		dst.Close()
+		return err
+	}
+	zr, err := gzip.NewReader(src)
+	if err != nil {
+		dst.Close()
+		return err
+	}
+	defer zr.Close()
+
+	if _, err := io.Copy(dst, zr); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
@@ -0,0 +1,126 @@
+package driver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+func TestDecompressImageArchive_Gzip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "base.tvm.gz")
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("tart archive"))
+	zw.Close()
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+
+	scratch := t.TempDir()
+	got, cleanup, err := decompressImageArchive(context.Background(), path, scratch)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if filepath.Dir(got) != scratch {
+		t.Fatalf("expected the archive to be decompressed into %s, got %s", scratch, got)
+	}
+	data, err := os.ReadFile(got)
+	if err != nil || string(data) != "tart archive" {
+		t.Fatalf("unexpected decompressed contents %q (err %v)", data, err)
+	}
+
+	cleanup()
+	if _, err := os.Stat(got); !os.IsNotExist(err) {
+		t.Fatalf("expected cleanup to remove %s", got)
+	}
+}
+
+func TestDecompressImageArchive_Zstd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "base.tvm.zst")
+	if err := os.WriteFile(path, append(append([]byte{}, zstdMagic...), "frame"...), 0o644); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+
+	var argv []string
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		argv = append([]string{name}, args...)
+		return exec.CommandContext(ctx, "true")
+	}
+	defer func() { execCommandContext = orig }()
+
+	got, cleanup, err := decompressImageArchive(context.Background(), path, t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer cleanup()
+
+	if len(argv) == 0 || argv[0] != "zstd" || argv[len(argv)-1] != path || !slices.Contains(argv, got) {
+		t.Fatalf("unexpected zstd invocation %v for output %s", argv, got)
+	}
+}
+
+func TestDecompressImageArchive_UncompressedPassesThrough(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "base.tvm")
+	if err := os.WriteFile(path, []byte("AA01 archive"), 0o644); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+
+	got, cleanup, err := decompressImageArchive(context.Background(), path, t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer cleanup()
+	if got != path {
+		t.Fatalf("expected %s to be imported as is, got %s", path, got)
+	}
+}
+
+func TestSetup_ImportsImageFileInsteadOfCloning(t *testing.T) {
+	t.Setenv("TART_HOME", t.TempDir())
+	image := filepath.Join(t.TempDir(), "base.tvm")
+	if err := os.WriteFile(image, []byte("AA01 archive"), 0o644); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+
+	var calls []string
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		calls = append(calls, strings.Join(args, " "))
+		return exec.CommandContext(ctx, "true")
+	}
+	defer func() { execCommandContext = orig }()
+
+	c := NewTartClient(testLogger(t))
+	vmc := VMConfig{
+		TaskConfig:  TaskConfig{ImageFile: image},
+		NomadConfig: &drivers.TaskConfig{AllocID: "alloc-1"},
+	}
+	if _, err := c.Setup(context.Background(), vmc); err != nil {
+		t.Fatalf("Setup returned error: %v", err)
+	}
+
+	if !slices.Contains(calls, "import "+image+" nomad-alloc-1") {
+		t.Fatalf("expected tart import, got %v", calls)
+	}
+	for _, call := range calls {
+		if strings.HasPrefix(call, "clone") {
+			t.Fatalf("did not expect tart clone: %v", calls)
+		}
+	}
+
+	needs, err := c.NeedsImageDownload(context.Background(), vmc)
+	if err != nil || needs {
+		t.Fatalf("expected no download for an imported image, got %v (err %v)", needs, err)
+	}
+}
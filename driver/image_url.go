@@ -0,0 +1,83 @@
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// resolveImageURL sets taskConfig.URL from the file named by url_from_file,
+// when set, so that a prestart task can compute the image to run. The path is
+// relative to the task directory. Without url_from_file the url attribute,
+// as interpolated by Nomad, is used as is. Tasks importing an image_file only
+// have the file checked.
+func resolveImageURL(cfg *drivers.TaskConfig, taskConfig *TaskConfig) error {
+	if err := validateExtraDisks(taskConfig.ExtraDisks); err != nil {
+		return err
+	}
+	if taskConfig.BaseURL != "" {
+		if taskConfig.URL != "" && taskConfig.URL != taskConfig.BaseURL {
+			return fmt.Errorf("url and base_url name different images; set only one")
+		}
+		taskConfig.URL = taskConfig.BaseURL
+	}
+
+	// Images imported from a file need no URL.
+	if taskConfig.ImageFile != "" {
+		if taskConfig.ImageDigest != "" {
+			return fmt.Errorf("image_digest cannot be used with image_file")
+		}
+		return validateImageFile(taskConfig.ImageFile)
+	}
+
+	if taskConfig.URLFromFile == "" {
+		if taskConfig.URL == "" {
+			return fmt.Errorf("one of url, base_url, url_from_file or image_file must be set")
+		}
+		if err := validateImageURL(taskConfig.URL); err != nil {
+			return err
+		}
+		if err := validateLocalVMSource(*taskConfig); err != nil {
+			return err
+		}
+		return validateImageDigest(taskConfig.ImageDigest, taskConfig.URL)
+	}
+
+	if !filepath.IsLocal(taskConfig.URLFromFile) {
+		return fmt.Errorf("url_from_file must be a path within the task directory, got %q", taskConfig.URLFromFile)
+	}
+
+	path := filepath.Join(cfg.TaskDir().Dir, taskConfig.URLFromFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read image URL from %s: %v", path, err)
+	}
+
+	url := strings.TrimSpace(string(data))
+	if err := validateImageURL(url); err != nil {
+		return fmt.Errorf("invalid image URL in %s: %v", path, err)
+	}
+	taskConfig.URL = url
+	if err := validateLocalVMSource(*taskConfig); err != nil {
+		return err
+	}
+	return validateImageDigest(taskConfig.ImageDigest, url)
+}
+
+// validateImageURL checks that url is a single, well-formed image reference.
+func validateImageURL(url string) error {
+	if url == "" {
+		return fmt.Errorf("image URL is empty")
+	}
+	if strings.IndexFunc(url, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+		return fmt.Errorf("image URL %q must not contain whitespace", redact(url))
+	}
+	if _, err := registryHost(url); err != nil {
+		return fmt.Errorf("image URL %q is invalid: %v", redact(url), err)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+func TestResolveImageURL_ReadsURLFromFile(t *testing.T) {
+	cfg := &drivers.TaskConfig{Name: "vm", AllocDir: t.TempDir()}
+	localDir := cfg.TaskDir().LocalDir
+	if err := os.MkdirAll(localDir, 0o755); err != nil {
+		t.Fatalf("failed to create local dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(localDir, "image"), []byte("ghcr.io/org/img:1.2.3\n"), 0o644); err != nil {
+		t.Fatalf("failed to write URL file: %v", err)
+	}
+
+	taskConfig := TaskConfig{URLFromFile: "local/image"}
+	if err := resolveImageURL(cfg, &taskConfig); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if taskConfig.URL != "ghcr.io/org/img:1.2.3" {
+		t.Fatalf("expected the URL from the file, got %q", taskConfig.URL)
+	}
+}
+
+func TestResolveImageURL_Errors(t *testing.T) {
+	cfg := &drivers.TaskConfig{Name: "vm", AllocDir: t.TempDir()}
+	taskDir := cfg.TaskDir().Dir
+	if err := os.MkdirAll(taskDir, 0o755); err != nil {
+		t.Fatalf("failed to create task dir: %v", err)
+	}
+	for name, contents := range map[string]string{
+		"empty":     "\n",
+		"two-lines": "ghcr.io/org/a:1\nghcr.io/org/b:1\n",
+	} {
+		if err := os.WriteFile(filepath.Join(taskDir, name), []byte(contents), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	cases := []struct {
+		name       string
+		taskConfig TaskConfig
+		want       string
+	}{
+		{"neither set", TaskConfig{}, "must be set"},
+		{"relative image file", TaskConfig{ImageFile: "images/base.tvm"}, "absolute"},
+		{"missing image file", TaskConfig{ImageFile: "/nonexistent/base.tvm"}, "not accessible"},
+		{"missing file", TaskConfig{URLFromFile: "nope"}, "failed to read"},
+		{"escapes task dir", TaskConfig{URLFromFile: "../other/image"}, "within the task directory"},
+		{"absolute path", TaskConfig{URLFromFile: "/etc/passwd"}, "within the task directory"},
+		{"empty file", TaskConfig{URLFromFile: "empty"}, "empty"},
+		{"several URLs", TaskConfig{URLFromFile: "two-lines"}, "whitespace"},
+		{"invalid url", TaskConfig{URL: "/img"}, "invalid"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			taskConfig := tc.taskConfig
+			err := resolveImageURL(cfg, &taskConfig)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected an error containing %q, got: %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestValidateImageSource(t *testing.T) {
+	valid := []TaskConfig{
+		{URL: "ghcr.io/org/img:latest"},
+		{BaseURL: "ghcr.io/org/img:latest", ExtraDisks: []string{"ghcr.io/org/tools:1"}},
+		{URL: "ghcr.io/org/img:latest", BaseURL: "ghcr.io/org/img:latest"},
+		{URLFromFile: "local/image"},
+		{ImageFile: "/images/base.tvm"},
+	}
+	for _, tc := range valid {
+		if err := validateImageSource(tc); err != nil {
+			t.Fatalf("%+v: unexpected error: %v", tc, err)
+		}
+	}
+
+	cases := []struct {
+		name string
+		tc   TaskConfig
+		want string
+	}{
+		{"none", TaskConfig{}, "no image source set"},
+		{"url and url_from_file", TaskConfig{URL: "ghcr.io/org/img:latest", URLFromFile: "local/image"}, "url, url_from_file"},
+		{"url and image_file", TaskConfig{URL: "ghcr.io/org/img:latest", ImageFile: "/images/base.tvm"}, "url, image_file"},
+		{"base_url and image_file", TaskConfig{BaseURL: "ghcr.io/org/img:latest", ImageFile: "/images/base.tvm"}, "base_url, image_file"},
+		{"all three", TaskConfig{URL: "ghcr.io/org/img:latest", URLFromFile: "local/image", ImageFile: "/images/base.tvm"}, "url, url_from_file, image_file"},
+	}
+	for _, tc := range cases {
+		if err := validateImageSource(tc.tc); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: expected an error containing %q, got: %v", tc.name, tc.want, err)
+		}
+	}
+}
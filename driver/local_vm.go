@@ -0,0 +1,60 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// localVMScheme prefixes a url naming a VM on the host to clone instead of
+// an image from a registry, e.g. local://postgres-base.
+const localVMScheme = "local://"
+
+// localVMSource returns the name of the VM a local:// url refers to.
+func localVMSource(url string) (string, bool) {
+	return strings.CutPrefix(url, localVMScheme)
+}
+
+// validateLocalVMSource checks the VM name in a local:// url and that the
+// task sets none of the options that only apply to registry images.
+func validateLocalVMSource(tc TaskConfig) error {
+	name, ok := localVMSource(tc.URL)
+	if !ok {
+		return nil
+	}
+	if name == "" || strings.ContainsAny(name, "/:@") {
+		return fmt.Errorf("%s must be followed by the name of a VM on the host, got %q", localVMScheme, tc.URL)
+	}
+	switch {
+	case tc.ImageDigest != "":
+		return fmt.Errorf("image_digest cannot be used with a %s url", localVMScheme)
+	case tc.Auth != (Auth{}):
+		return fmt.Errorf("auth cannot be used with a %s url", localVMScheme)
+	case tc.PullPolicy != "" && tc.PullPolicy != pullPolicyIfNotPresent:
+		return fmt.Errorf("pull_policy %q cannot be used with a %s url, which is never pulled", tc.PullPolicy, localVMScheme)
+	}
+	return nil
+}
+
+// cloneLocalVM creates vmName from the VM source on the host. tart only
+// clones VMs within one tart home, so source must be in the task's.
+func (c *TartClient) cloneLocalVM(ctx context.Context, config VMConfig, source, vmName string) error {
+	listCtx, cancel := c.withCommandTimeout(ctx)
+	vms, err := c.listIn(listCtx, config.TartHome)
+	cancel()
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(vms, func(vm VMInfo) bool { return vm.Name == source }) {
+		return fmt.Errorf("local VM %s to clone does not exist", source)
+	}
+
+	if err := c.CloneVM(ctx, source, vmName); err != nil {
+		return err
+	}
+	if err := c.writeVMSource(vmName, config.TaskConfig.URL); err != nil {
+		c.logger.Warn("failed to record VM source image", "name", vmName, "error", err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package driver
+
+import (
+	"context"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+func TestValidateLocalVMSource(t *testing.T) {
+	valid := []TaskConfig{
+		{URL: "ghcr.io/org/img:latest", ImageDigest: "sha256:abc"},
+		{URL: "local://postgres-base"},
+		{URL: "local://postgres-base", PullPolicy: pullPolicyIfNotPresent},
+	}
+	for _, tc := range valid {
+		if err := validateLocalVMSource(tc); err != nil {
+			t.Fatalf("%+v: unexpected error: %v", tc, err)
+		}
+	}
+	invalid := []TaskConfig{
+		{URL: "local://"},
+		{URL: "local://ghcr.io/org/img"},
+		{URL: "local://postgres-base", ImageDigest: "sha256:" + strings.Repeat("a", 64)},
+		{URL: "local://postgres-base", Auth: Auth{Username: "u", Password: "p"}},
+		{URL: "local://postgres-base", PullPolicy: pullPolicyAlways},
+	}
+	for _, tc := range invalid {
+		if err := validateLocalVMSource(tc); err == nil {
+			t.Fatalf("%+v: expected an error", tc)
+		}
+	}
+}
+
+func TestSetup_LocalURLClonesLocalVM(t *testing.T) {
+	cases := []struct {
+		name    string
+		listed  string
+		wantErr string
+	}{
+		{"existing source is cloned", `[{"Name": "postgres-base", "State": "stopped", "Source": "local"}]`, ""},
+		{"missing source is refused", `[{"Name": "other", "State": "stopped", "Source": "local"}]`, "local VM postgres-base to clone does not exist"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls [][]string
+			orig := execCommandContext
+			execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+				calls = append(calls, args)
+				if args[0] == "list" {
+					return exec.CommandContext(ctx, "echo", tc.listed)
+				}
+				return exec.CommandContext(ctx, "true")
+			}
+			defer func() { execCommandContext = orig }()
+
+			c := NewTartClient(testLogger(t))
+			vmc := VMConfig{
+				TaskConfig:      TaskConfig{URL: "local://postgres-base"},
+				NomadConfig:     &drivers.TaskConfig{AllocID: "alloc-local"},
+				TartHome:        t.TempDir(),
+				PullConcurrency: 8,
+			}
+
+			needsDownload, err := c.NeedsImageDownload(context.Background(), vmc)
+			if err != nil || needsDownload {
+				t.Fatalf("expected a local VM to never need a download, got %v, %v", needsDownload, err)
+			}
+
+			_, err = c.Setup(context.Background(), vmc)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected %q, got %v", tc.wantErr, err)
+				}
+				for _, args := range calls {
+					if args[0] == "clone" {
+						t.Fatalf("did not expect a clone, got %v", args)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Setup returned error: %v", err)
+			}
+			var clones [][]string
+			for _, args := range calls {
+				if args[0] == "clone" || args[0] == "pull" {
+					clones = append(clones, args)
+				}
+			}
+			if len(clones) != 1 || !slices.Equal(clones[0], []string{"clone", "postgres-base", "nomad-alloc-local"}) {
+				t.Fatalf("expected a single local clone of postgres-base, got %v", clones)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package driver
+
+import "fmt"
+
+// validateMemoryRange checks the task's memory_min and memory_max against each
+// other. Whether they fit the task's allocated memory is only known once
+// Nomad hands the task its resources, which vmMemoryMB checks.
+func validateMemoryRange(minMB, maxMB int) error {
+	if minMB < 0 || maxMB < 0 {
+		return fmt.Errorf("memory_min and memory_max must not be negative")
+	}
+	if minMB > 0 && maxMB > 0 && minMB > maxMB {
+		return fmt.Errorf("memory_min (%d MB) must not exceed memory_max (%d MB)", minMB, maxMB)
+	}
+	return nil
+}
+
+// vmMemoryMB returns the memory, in MB, the VM is configured with given the
+// memory Nomad allocated and the task's memory_min and memory_max. memory_max
+// may shrink the VM below its allocation but never grow it past it, since
+// Nomad only reserved the allocated memory on the host; memory_min must fit
+// within whatever the VM ends up with.
+func vmMemoryMB(allocatedMB, minMB, maxMB int) (int, error) {
+	if err := validateMemoryRange(minMB, maxMB); err != nil {
+		return 0, err
+	}
+
+	memoryMB := allocatedMB
+	if maxMB > 0 {
+		if maxMB > allocatedMB {
+			return 0, fmt.Errorf("memory_max (%d MB) must not exceed the task's allocated memory (%d MB)", maxMB, allocatedMB)
+		}
+		memoryMB = maxMB
+	}
+	if minMB > memoryMB {
+		return 0, fmt.Errorf("memory_min (%d MB) must not exceed the task's memory (%d MB)", minMB, memoryMB)
+	}
+	return memoryMB, nil
+}
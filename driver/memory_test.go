@@ -0,0 +1,71 @@
+package driver
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestBuildSetResourcesArgs_MemoryRange(t *testing.T) {
+	memoryMB, err := vmMemoryMB(8192, 2048, 6144)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := buildSetResourcesArgs("nomad-a", 2, memoryMB, 0)
+	want := []string{"set", "nomad-a", "--cpu", "2", "--memory", "6144"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestVMMemoryMB(t *testing.T) {
+	cases := []struct {
+		name                string
+		allocated, min, max int
+		want                int
+		wantErr             bool
+	}{
+		{"no range uses allocation", 4096, 0, 0, 4096, false},
+		{"max sizes the VM", 8192, 0, 4096, 4096, false},
+		{"max equal to allocation", 4096, 0, 4096, 4096, false},
+		{"max above allocation", 4096, 0, 8192, 0, true},
+		{"min within allocation", 4096, 1024, 0, 4096, false},
+		{"min equal to max", 4096, 2048, 2048, 2048, false},
+		{"min above max", 8192, 4096, 2048, 0, true},
+		{"min above allocation", 4096, 8192, 0, 0, true},
+		{"negative", 4096, -1, 0, 0, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := vmMemoryMB(tc.allocated, tc.min, tc.max)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Fatalf("got %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateTaskConfig_MemoryRange(t *testing.T) {
+	base := TaskConfig{URL: "ghcr.io/org/img:latest"}
+
+	valid := base
+	valid.MemoryMin, valid.MemoryMax = 1024, 2048
+	if err := validateTaskConfig(valid); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	inverted := base
+	inverted.MemoryMin, inverted.MemoryMax = 4096, 2048
+	if err := validateTaskConfig(inverted); err == nil {
+		t.Fatalf("expected memory_min above memory_max to be rejected")
+	}
+
+	negative := base
+	negative.MemoryMax = -1
+	if err := validateTaskConfig(negative); err == nil {
+		t.Fatalf("expected a negative memory_max to be rejected")
+	}
+}
@@ -0,0 +1,41 @@
+package driver
+
+import (
+	"time"
+
+	metrics "github.com/hashicorp/go-metrics/compat"
+)
+
+// Metrics emitted through go-metrics, all under the tart prefix.
+var (
+	// metricVMsStarted and metricVMsStopped count tasks whose VM was
+	// started, and stopped through StopTask.
+	metricVMsStarted = []string{"tart", "vm", "started"}
+	metricVMsStopped = []string{"tart", "vm", "stopped"}
+
+	// metricSetupFailures counts VM setups, the image pull and clone and the
+	// VM's configuration, that failed.
+	metricSetupFailures = []string{"tart", "setup", "failures"}
+
+	// metricPullDuration samples how long image pulls took, in milliseconds.
+	metricPullDuration = []string{"tart", "image", "pull_duration"}
+
+	// metricActiveTasks is how many of the driver's tasks are running.
+	metricActiveTasks = []string{"tart", "tasks", "active"}
+)
+
+// emitPullDuration records how long pulling a task's image took.
+func emitPullDuration(d time.Duration) {
+	metrics.AddSample(metricPullDuration, float32(d.Milliseconds()))
+}
+
+// emitActiveTasks reports how many of the driver's tasks are running.
+func (d *Driver) emitActiveTasks() {
+	active := 0
+	for _, h := range d.tasks.List() {
+		if h.IsRunning() {
+			active++
+		}
+	}
+	metrics.SetGauge(metricActiveTasks, float32(active))
+}
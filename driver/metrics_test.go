@@ -0,0 +1,61 @@
+package driver
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	metrics "github.com/hashicorp/go-metrics/compat"
+	"github.com/hashicorp/nomad/drivers/shared/executor"
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+func TestStartTask_CountsStartedVMs(t *testing.T) {
+	sink := metrics.NewInmemSink(time.Hour, time.Hour)
+	cfg := metrics.DefaultConfig("")
+	cfg.EnableHostname = false
+	cfg.EnableRuntimeMetrics = false
+	if _, err := metrics.NewGlobal(cfg, sink); err != nil {
+		t.Fatal(err)
+	}
+	defer metrics.NewGlobal(cfg, &metrics.BlackholeSink{})
+
+	d := newTestDriver(t, &fakeClient{})
+	if err := d.SetConfig(pluginConfig(t, &Config{})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+	fe := newFakeExecutor()
+	d.createExecutor = fakeExecutorFactory(fe)
+
+	dir := t.TempDir()
+	taskCfg := &drivers.TaskConfig{
+		ID:         "task-1",
+		Name:       "vm",
+		AllocID:    "alloc-1",
+		AllocDir:   dir,
+		StdoutPath: filepath.Join(dir, "stdout"),
+		StderrPath: filepath.Join(dir, "stderr"),
+	}
+	if err := taskCfg.EncodeConcreteDriverConfig(&TaskConfig{URL: "ghcr.io/org/img:latest"}); err != nil {
+		t.Fatalf("failed to encode task config: %v", err)
+	}
+	if _, _, err := d.StartTask(taskCfg); err != nil {
+		t.Fatalf("StartTask returned error: %v", err)
+	}
+	defer func() {
+		fe.exitCh <- &executor.ProcessState{Pid: 4242}
+		h, _ := d.tasks.Get(taskCfg.ID)
+		<-h.doneCh
+	}()
+
+	data := sink.Data()
+	if len(data) == 0 {
+		t.Fatalf("no metrics were recorded")
+	}
+	if started := data[0].Counters["tart.vm.started"]; started.Count != 1 {
+		t.Fatalf("expected one started VM, got %+v", started)
+	}
+	if active := data[0].Gauges["tart.tasks.active"]; active.Value != 1 {
+		t.Fatalf("expected one active task, got %+v", active)
+	}
+}
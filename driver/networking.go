@@ -1,21 +1,168 @@
 package driver
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"strconv"
 	"strings"
 )
 
+// hostInterfaceNames is a package-level indirection to allow tests to control
+// which network interfaces appear to be present. In production it lists the
+// host's interfaces via net.Interfaces.
+var hostInterfaceNames = func() ([]string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(ifaces))
+	for i, iface := range ifaces {
+		names[i] = iface.Name
+	}
+	return names, nil
+}
+
+// bridgedInterfaceCandidates returns the bridged interfaces configured for the
+// task in order of preference. bridged_interface may hold a comma separated
+// list, and any bridged_interfaces entries follow it.
+func bridgedInterfaceCandidates(cfg *NetworkConfig) []string {
+	var candidates []string
+	for _, name := range append(strings.Split(cfg.BridgedInterface, ","), cfg.BridgedInterfaces...) {
+		if name = strings.TrimSpace(name); name != "" {
+			candidates = append(candidates, name)
+		}
+	}
+	return candidates
+}
+
+// selectBridgedInterface picks the first candidate present on the host. A
+// single candidate is passed through untouched so tart can resolve display
+// names such as "Wi-Fi" that net.Interfaces does not report.
+func selectBridgedInterface(candidates []string) (string, error) {
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+
+	names, err := hostInterfaceNames()
+	if err != nil {
+		return "", fmt.Errorf("failed to list host network interfaces: %v", err)
+	}
+
+	present := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		present[name] = struct{}{}
+	}
+	for _, candidate := range candidates {
+		if _, ok := present[candidate]; ok {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("none of the configured bridged interfaces are available on this host: %s",
+		strings.Join(candidates, ", "))
+}
+
+// Validate checks the networking mode, the Softnet allow and expose lists and
+// the DNS and gateway overrides so malformed entries are rejected when the
+// task config is decoded rather than by tart at start. All bad entries are
+// reported together.
+func (cfg *NetworkConfig) Validate() error {
+	if cfg == nil {
+		return nil
+	}
+
+	var errs []error
+	for _, cidr := range cfg.SoftnetAllow {
+		if _, _, err := net.ParseCIDR(strings.TrimSpace(cidr)); err != nil {
+			errs = append(errs, fmt.Errorf("invalid softnet_allow entry %q: must be a CIDR such as 192.168.0.0/24", cidr))
+		}
+	}
+	for _, spec := range cfg.SoftnetExpose {
+		if err := validateExposeSpec(strings.TrimSpace(spec)); err != nil {
+			errs = append(errs, fmt.Errorf("invalid softnet_expose entry %q: %v", spec, err))
+		}
+	}
+	for _, server := range cfg.DNSServers {
+		if net.ParseIP(strings.TrimSpace(server)) == nil {
+			errs = append(errs, fmt.Errorf("invalid dns_servers entry %q: must be an IP address", server))
+		}
+	}
+	if cfg.Gateway != "" && net.ParseIP(strings.TrimSpace(cfg.Gateway)) == nil {
+		errs = append(errs, fmt.Errorf("invalid gateway %q: must be an IP address", cfg.Gateway))
+	}
+	if err := cfg.validateMode(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// validateMode checks that the networking mode is known and that it is not
+// combined with the options of another mode. Host, bridged and softnet modes
+// are mutually exclusive, and softnet is implied when allow or expose lists
+// are set without a mode.
+func (cfg *NetworkConfig) validateMode() error {
+	mode := strings.ToLower(strings.TrimSpace(cfg.Mode))
+	bridged := len(bridgedInterfaceCandidates(cfg)) > 0
+	softnet := len(cfg.SoftnetAllow) > 0 || len(cfg.SoftnetExpose) > 0
+
+	switch mode {
+	case "host":
+		if bridged || softnet {
+			return fmt.Errorf("networking options conflict: host mode cannot be combined with bridged_interface or softnet options")
+		}
+	case "bridged":
+		if !bridged {
+			return fmt.Errorf("bridged mode requires 'bridged_interface' or 'bridged_interfaces'")
+		}
+		if softnet {
+			return fmt.Errorf("networking options conflict: bridged mode cannot be combined with softnet options")
+		}
+	case "softnet", "", "default", "shared", "nat":
+		if bridged && (mode == "softnet" || softnet) {
+			return fmt.Errorf("networking options conflict: softnet mode cannot be combined with bridged_interface")
+		}
+	default:
+		return fmt.Errorf("unknown networking mode: %s", mode)
+	}
+	return nil
+}
+
+// validateExposeSpec checks a Softnet port forward of the form
+// EXTERNAL:INTERNAL with an optional /tcp or /udp suffix.
+func validateExposeSpec(spec string) error {
+	ports, proto, hasProto := strings.Cut(spec, "/")
+	if hasProto && proto != "tcp" && proto != "udp" {
+		return fmt.Errorf("protocol must be tcp or udp, got %q", proto)
+	}
+
+	external, internal, ok := strings.Cut(ports, ":")
+	if !ok {
+		return fmt.Errorf("must be EXTERNAL:INTERNAL[/proto]")
+	}
+	for _, port := range []string{external, internal} {
+		n, err := strconv.Atoi(port)
+		if err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("port %q must be a number between 1 and 65535", port)
+		}
+	}
+	return nil
+}
+
 // buildTartNetworkArgs computes the appropriate tart networking flags from NetworkConfig.
-// It enforces mutual exclusivity among host, bridged, and softnet modes. Softnet is
-// implicitly enabled when allow or expose lists are provided.
+// The config is validated first, so host, bridged, and softnet modes are mutually
+// exclusive. Softnet is implicitly enabled when allow or expose lists are provided.
 func buildTartNetworkArgs(cfg *NetworkConfig) ([]string, error) {
 	args := []string{}
 	if cfg == nil {
 		return args, nil
 	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 
 	mode := strings.ToLower(strings.TrimSpace(cfg.Mode))
-	bridgedIf := strings.TrimSpace(cfg.BridgedInterface)
+	bridged := bridgedInterfaceCandidates(cfg)
 	allow := cfg.SoftnetAllow
 	expose := cfg.SoftnetExpose
 
@@ -28,22 +175,16 @@ func buildTartNetworkArgs(cfg *NetworkConfig) ([]string, error) {
 	// If no mode specified but allow/expose are set, we imply softnet.
 	impliedSoftnet := isDefault && (len(allow) > 0 || len(expose) > 0)
 
-	// Validate combinations
 	if isHost {
-		if bridgedIf != "" || len(allow) > 0 || len(expose) > 0 {
-			return nil, fmt.Errorf("networking options conflict: host mode cannot be combined with bridged_interface or softnet options")
-		}
 		return []string{"--net-host"}, nil
 	}
 
 	if isBridged {
-		if bridgedIf == "" {
-			return nil, fmt.Errorf("bridged mode requires 'bridged_interface'")
-		}
-		if len(allow) > 0 || len(expose) > 0 {
-			return nil, fmt.Errorf("networking options conflict: bridged mode cannot be combined with softnet options")
+		iface, err := selectBridgedInterface(bridged)
+		if err != nil {
+			return nil, err
 		}
-		return []string{"--net-bridged", bridgedIf}, nil
+		return []string{"--net-bridged", iface}, nil
 	}
 
 	if isSoftnet || impliedSoftnet {
@@ -54,17 +195,9 @@ func buildTartNetworkArgs(cfg *NetworkConfig) ([]string, error) {
 		if len(expose) > 0 {
 			n = append(n, "--net-softnet-expose", strings.Join(expose, ","))
 		}
-		if bridgedIf != "" {
-			return nil, fmt.Errorf("networking options conflict: softnet mode cannot be combined with bridged_interface")
-		}
 		return n, nil
 	}
 
-	// Unknown mode?
-	if !isDefault {
-		return nil, fmt.Errorf("unknown networking mode: %s", mode)
-	}
-
 	// Default shared (NAT) networking: no specific flags needed
 	return args, nil
 }
@@ -2,7 +2,10 @@ package driver
 
 import (
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/hashicorp/nomad/helper/pluginutils/hclutils"
 )
 
 func TestBuildTartNetworkArgs_Default(t *testing.T) {
@@ -102,3 +105,150 @@ func TestBuildTartNetworkArgs_Conflicts(t *testing.T) {
 		}
 	}
 }
+
+func TestBridgedInterfaceCandidates(t *testing.T) {
+	cfg := &NetworkConfig{
+		BridgedInterface:  " en0, en1 ,,",
+		BridgedInterfaces: []string{"bond0", " "},
+	}
+	want := []string{"en0", "en1", "bond0"}
+	if got := bridgedInterfaceCandidates(cfg); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestBuildTartNetworkArgs_BridgedPicksFirstAvailable(t *testing.T) {
+	orig := hostInterfaceNames
+	hostInterfaceNames = func() ([]string, error) {
+		return []string{"lo0", "en1", "bond0"}, nil
+	}
+	defer func() { hostInterfaceNames = orig }()
+
+	cases := []*NetworkConfig{
+		{Mode: "bridged", BridgedInterface: "en0,en1"},
+		{Mode: "bridged", BridgedInterfaces: []string{"en0", "en1", "bond0"}},
+		{Mode: "bridged", BridgedInterface: "en0", BridgedInterfaces: []string{"en1"}},
+	}
+	for i, cfg := range cases {
+		got, err := buildTartNetworkArgs(cfg)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		want := []string{"--net-bridged", "en1"}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("case %d: got %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestBuildTartNetworkArgs_BridgedNoInterfaceAvailable(t *testing.T) {
+	orig := hostInterfaceNames
+	hostInterfaceNames = func() ([]string, error) {
+		return []string{"lo0", "en0"}, nil
+	}
+	defer func() { hostInterfaceNames = orig }()
+
+	cfg := &NetworkConfig{Mode: "bridged", BridgedInterfaces: []string{"en5", "bond1"}}
+	_, err := buildTartNetworkArgs(cfg)
+	if err == nil {
+		t.Fatalf("expected error when no configured interface is available")
+	}
+	if !strings.Contains(err.Error(), "en5, bond1") {
+		t.Fatalf("expected error to name the configured interfaces, got: %v", err)
+	}
+}
+
+func TestBuildTartNetworkArgs_SingleBridgedInterfacePassesThrough(t *testing.T) {
+	orig := hostInterfaceNames
+	hostInterfaceNames = func() ([]string, error) {
+		t.Fatalf("host interfaces should not be consulted for a single interface")
+		return nil, nil
+	}
+	defer func() { hostInterfaceNames = orig }()
+
+	got, err := buildTartNetworkArgs(&NetworkConfig{Mode: "bridged", BridgedInterface: "Wi-Fi"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"--net-bridged", "Wi-Fi"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestNetworkConfigValidate_SoftnetEntries(t *testing.T) {
+	cfg := &NetworkConfig{
+		SoftnetAllow:  []string{"192.168.0.0/24", "192.168.0/24", "10.0.0.0/8", "not-a-cidr"},
+		SoftnetExpose: []string{"2222:22", "8080:80/tcp", "5353:53/udp", "80", "70000:80", "22:ssh", "1:2/icmp"},
+	}
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatalf("expected validation error")
+	}
+
+	msg := err.Error()
+	for _, bad := range []string{`"192.168.0/24"`, `"not-a-cidr"`, `"80"`, `"70000:80"`, `"22:ssh"`, `"1:2/icmp"`} {
+		if !strings.Contains(msg, bad) {
+			t.Fatalf("expected error to name %s, got: %v", bad, msg)
+		}
+	}
+	for _, good := range []string{`"192.168.0.0/24"`, `"10.0.0.0/8"`, `"2222:22"`, `"8080:80/tcp"`, `"5353:53/udp"`} {
+		if strings.Contains(msg, good) {
+			t.Fatalf("did not expect error to name valid entry %s, got: %v", good, msg)
+		}
+	}
+
+	if _, err := buildTartNetworkArgs(cfg); err == nil {
+		t.Fatalf("expected buildTartNetworkArgs to reject invalid softnet entries")
+	}
+}
+
+func TestNetworkConfigValidate_Valid(t *testing.T) {
+	var nilCfg *NetworkConfig
+	if err := nilCfg.Validate(); err != nil {
+		t.Fatalf("unexpected error for nil config: %v", err)
+	}
+
+	cfg := &NetworkConfig{SoftnetAllow: []string{"0.0.0.0/0"}, SoftnetExpose: []string{"2222:22"}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestNetworkConfigValidate_DNSAndGateway(t *testing.T) {
+	cfg := &NetworkConfig{DNSServers: []string{"10.0.0.53", "dns.local", "fd00::53"}, Gateway: "10.0.0"}
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatalf("expected validation error")
+	}
+	for _, bad := range []string{`"dns.local"`, `"10.0.0"`} {
+		if !strings.Contains(err.Error(), bad) {
+			t.Fatalf("expected error to name %s, got: %v", bad, err)
+		}
+	}
+	if strings.Contains(err.Error(), "fd00::53") {
+		t.Fatalf("did not expect IPv6 DNS server to be rejected: %v", err)
+	}
+}
+
+func TestTaskConfigSpec_ParsesNetworkDNSAndGateway(t *testing.T) {
+	var taskConfig TaskConfig
+	hclutils.NewConfigParser(taskConfigSpec).ParseHCL(t, `
+config {
+  url = "ghcr.io/org/img:latest"
+  network {
+    mode        = "nat"
+    dns_servers = ["10.0.0.53", "10.0.1.53"]
+    gateway     = "10.0.0.1"
+  }
+}`, &taskConfig)
+	if taskConfig.Network == nil {
+		t.Fatalf("expected network block to be parsed")
+	}
+	if !reflect.DeepEqual(taskConfig.Network.DNSServers, []string{"10.0.0.53", "10.0.1.53"}) {
+		t.Fatalf("unexpected dns_servers: %v", taskConfig.Network.DNSServers)
+	}
+	if taskConfig.Network.Gateway != "10.0.0.1" {
+		t.Fatalf("unexpected gateway: %q", taskConfig.Network.Gateway)
+	}
+}
@@ -0,0 +1,48 @@
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// nomadEnvFile is the file written to the task's secrets directory, which is
+// shared read-only with the guest, holding the task's Nomad variables.
+const nomadEnvFile = "nomad.env"
+
+// nomadEnvFileContents renders the NOMAD_* variables from env as sorted
+// export statements that a shell in the guest can source.
+func nomadEnvFileContents(env map[string]string) string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		if strings.HasPrefix(k, "NOMAD_") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "export %s=%s\n", k, shellQuote(env[k]))
+	}
+	return b.String()
+}
+
+// shellQuote single-quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// writeNomadEnvFile writes the task's Nomad variables into its secrets
+// directory so they are visible inside the guest, returning the file's path.
+func writeNomadEnvFile(cfg *drivers.TaskConfig) (string, error) {
+	path := filepath.Join(cfg.TaskDir().SecretsDir, nomadEnvFile)
+	if err := os.WriteFile(path, []byte(nomadEnvFileContents(cfg.Env)), 0o600); err != nil {
+		return "", fmt.Errorf("failed to write Nomad env file: %v", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,71 @@
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+func TestNomadEnvFileContents(t *testing.T) {
+	env := map[string]string{
+		"NOMAD_JOB_NAME":  "build",
+		"NOMAD_ALLOC_ID":  "alloc-1",
+		"NOMAD_META_note": "it's fine",
+		"PATH":            "/usr/bin",
+	}
+
+	want := `export NOMAD_ALLOC_ID='alloc-1'
+export NOMAD_JOB_NAME='build'
+export NOMAD_META_note='it'\''s fine'
+`
+	if got := nomadEnvFileContents(env); got != want {
+		t.Fatalf("unexpected env file:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteNomadEnvFile(t *testing.T) {
+	allocDir := t.TempDir()
+	cfg := &drivers.TaskConfig{
+		Name:     "vm",
+		AllocDir: allocDir,
+		Env: map[string]string{
+			"NOMAD_ALLOC_ID":  "alloc-1",
+			"NOMAD_JOB_NAME":  "build",
+			"NOMAD_TASK_NAME": "vm",
+		},
+	}
+	if err := os.MkdirAll(cfg.TaskDir().SecretsDir, 0o755); err != nil {
+		t.Fatalf("failed to create secrets dir: %v", err)
+	}
+
+	path, err := writeNomadEnvFile(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != filepath.Join(cfg.TaskDir().SecretsDir, nomadEnvFile) {
+		t.Fatalf("unexpected path: %s", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading env file: %v", err)
+	}
+	want := "export NOMAD_ALLOC_ID='alloc-1'\nexport NOMAD_JOB_NAME='build'\nexport NOMAD_TASK_NAME='vm'\n"
+	if string(data) != want {
+		t.Fatalf("unexpected env file:\n%s", data)
+	}
+}
+
+func TestValidateTaskConfig_InjectNomadEnvRequiresMountSecrets(t *testing.T) {
+	tc := TaskConfig{URL: "ghcr.io/org/img:latest", InjectNomadEnv: true}
+	if err := validateTaskConfig(tc); err == nil {
+		t.Fatalf("expected inject_nomad_env without mount_secrets to be rejected")
+	}
+
+	tc.MountSecrets = true
+	if err := validateTaskConfig(tc); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
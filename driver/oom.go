@@ -0,0 +1,56 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// memoryPressureSysctl reports the host's memory pressure level: 1 when
+	// normal, 2 at warning level and 4 when critical.
+	memoryPressureSysctl = "kern.memorystatus_vm_pressure_level"
+
+	// memoryPressureWarn is the level from which macOS starts killing
+	// processes to reclaim memory.
+	memoryPressureWarn = 2
+)
+
+// hostUnderMemoryPressure reports whether the host is short of memory. It is
+// a package-level indirection to allow tests to simulate memory pressure.
+var hostUnderMemoryPressure = func() (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	out, err := execCommandContext(ctx, "sysctl", "-n", memoryPressureSysctl).Output()
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %v", memoryPressureSysctl, err)
+	}
+	level, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: %v", memoryPressureSysctl, strings.TrimSpace(string(out)), err)
+	}
+	return level >= memoryPressureWarn, nil
+}
+
+// killedForMemory reports whether a tart process the driver did not stop,
+// ending with SIGKILL, was most likely killed by macOS to reclaim memory. The
+// kernel does not say why it killed a process, so the host's memory pressure
+// right after the exit is taken as the cause.
+func (h *taskHandle) killedForMemory(signal int) bool {
+	if signal != int(syscall.SIGKILL) || h.isStopping() {
+		return false
+	}
+
+	pressure, err := hostUnderMemoryPressure()
+	if err != nil {
+		if h.logger != nil {
+			h.logger.Debug("failed to check host memory pressure", "error", err)
+		}
+		return false
+	}
+	return pressure
+}
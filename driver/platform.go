@@ -0,0 +1,64 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// supportedPlatforms are the guest platforms a task may ask for. tart only
+// runs VMs on Apple Silicon, so every image is an arm64 macOS or Linux guest;
+// x86 binaries run under Rosetta inside a linux/arm64 guest.
+var supportedPlatforms = []string{"darwin/arm64", "linux/arm64"}
+
+// validatePlatform checks that platform, when set, is one tart can run.
+func validatePlatform(platform string) error {
+	if platform == "" || slices.Contains(supportedPlatforms, platform) {
+		return nil
+	}
+	return fmt.Errorf("platform %q is not supported, must be one of: %s", platform, strings.Join(supportedPlatforms, ", "))
+}
+
+// parseVMPlatform returns the os/arch platform recorded in a tart VM
+// config.json.
+func parseVMPlatform(data []byte) (string, error) {
+	var config struct {
+		OS   string `json:"os"`
+		Arch string `json:"arch"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return "", fmt.Errorf("failed to parse VM config: %v", err)
+	}
+	if config.OS == "" || config.Arch == "" {
+		return "", fmt.Errorf("VM config has no os or arch")
+	}
+	return config.OS + "/" + config.Arch, nil
+}
+
+// verifyPlatform fails when vmName was not cloned from an image for the
+// expected platform. tart clone has no way to pick an entry of a multi-arch
+// manifest, so the platform is checked once the image has been pulled. The VM
+// is deleted so that a retry clones it afresh.
+func (c *TartClient) verifyPlatform(ctx context.Context, vmName, expected string) error {
+	data, err := os.ReadFile(filepath.Join(c.vmPathFor(vmName), vmConfigFile))
+	var actual string
+	if err == nil {
+		actual, err = parseVMPlatform(data)
+	}
+	if err == nil && actual == expected {
+		c.logger.Debug("Verified image platform", "name", vmName, "platform", actual)
+		return nil
+	}
+
+	if delErr := c.Delete(ctx, vmName); delErr != nil {
+		c.logger.Warn("failed to delete VM after platform mismatch", "name", vmName, "error", delErr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to verify platform of VM %s: %v", vmName, err)
+	}
+	return fmt.Errorf("VM %s was cloned from a %s image, expected %s", vmName, actual, expected)
+}
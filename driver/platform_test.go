@@ -0,0 +1,82 @@
+package driver
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+func TestValidatePlatform(t *testing.T) {
+	for _, platform := range []string{"", "darwin/arm64", "linux/arm64"} {
+		if err := validatePlatform(platform); err != nil {
+			t.Fatalf("%q: unexpected error: %v", platform, err)
+		}
+	}
+	for _, platform := range []string{"linux/amd64", "arm64", "Linux/ARM64"} {
+		if err := validatePlatform(platform); err == nil {
+			t.Fatalf("%q: expected an error", platform)
+		}
+	}
+}
+
+func TestSetup_VerifiesPlatform(t *testing.T) {
+	cases := []struct {
+		name     string
+		expected string
+		wantErr  bool
+	}{
+		{"matching platform proceeds", "linux/arm64", false},
+		{"mismatched platform aborts", "darwin/arm64", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			home := t.TempDir()
+			vmDir := filepath.Join(home, "vms", "nomad-alloc-platform")
+			if err := os.MkdirAll(vmDir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			config := `{"version": 1, "os": "linux", "arch": "arm64", "cpuCount": 4, "memorySize": 4294967296}`
+			if err := os.WriteFile(filepath.Join(vmDir, vmConfigFile), []byte(config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			var calls []string
+			orig := execCommandContext
+			execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+				calls = append(calls, args[0])
+				return exec.CommandContext(ctx, "true")
+			}
+			defer func() { execCommandContext = orig }()
+
+			c := NewTartClient(testLogger(t))
+			vmc := VMConfig{
+				TaskConfig:  TaskConfig{URL: "ghcr.io/org/img:latest", Platform: tc.expected, DiskSize: 50},
+				NomadConfig: &drivers.TaskConfig{AllocID: "alloc-platform"},
+				TartHome:    home,
+			}
+
+			_, err := c.Setup(context.Background(), vmc)
+			if tc.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "linux/arm64 image, expected darwin/arm64") {
+					t.Fatalf("expected a platform mismatch error, got %v", err)
+				}
+				if !slices.Contains(calls, "delete") || slices.Contains(calls, "set") {
+					t.Fatalf("expected the VM to be deleted before being configured, got %v", calls)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Setup returned error: %v", err)
+			}
+			if !slices.Contains(calls, "set") || slices.Contains(calls, "delete") {
+				t.Fatalf("expected setup to continue to configuring the VM, got %v", calls)
+			}
+		})
+	}
+}
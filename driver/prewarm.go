@@ -0,0 +1,22 @@
+package driver
+
+// prewarmImages pulls the plugin's prewarm_images into the tart cache so the
+// first task using each does not wait on the download. Images are pulled one
+// at a time, each holding a max_concurrent_setups slot so task setups are not
+// starved, and failures are only logged.
+func (d *Driver) prewarmImages(config *Config) {
+	for _, image := range config.PrewarmImages {
+		image = mirrorImageRef(image, config.RegistryMirrors)
+
+		release, err := d.acquireSetupSlot(d.ctx)
+		if err != nil {
+			return
+		}
+		d.logger.Info("prewarming image", "url", redact(image))
+		err = d.client.Pull(d.ctx, image, config.TartHome, config.PullConcurrency)
+		release()
+		if err != nil {
+			d.logger.Warn("failed to prewarm image", "url", redact(image), "error", err)
+		}
+	}
+}
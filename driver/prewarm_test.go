@@ -0,0 +1,70 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSetConfig_PrewarmImages(t *testing.T) {
+	type pull struct {
+		image, tartHome string
+		concurrency     int
+	}
+	pulls := make(chan pull, 3)
+	d := newTestDriver(t, &fakeClient{
+		pullFn: func(ctx context.Context, image, tartHome string, concurrency int) error {
+			pulls <- pull{image, tartHome, concurrency}
+			if image == "ghcr.io/org/missing:latest" {
+				return errors.New("manifest unknown")
+			}
+			return nil
+		},
+	})
+
+	err := d.SetConfig(pluginConfig(t, &Config{
+		PrewarmImages: []string{
+			"ghcr.io/org/missing:latest",
+			"ghcr.io/cirruslabs/macos-sequoia-base:latest",
+			"docker.io/org/ubuntu:24.04",
+		},
+		RegistryMirrors:     map[string]string{"docker.io": "mirror.internal"},
+		TartHome:            "/srv/tart",
+		PullConcurrency:     8,
+		MaxConcurrentSetups: 1,
+	}))
+	if err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+
+	// A failed pull does not stop the rest from being prewarmed.
+	var got []string
+	for range 3 {
+		select {
+		case p := <-pulls:
+			if p.tartHome != "/srv/tart" || p.concurrency != 8 {
+				t.Fatalf("expected the plugin's tart home and pull concurrency, got %+v", p)
+			}
+			got = append(got, p.image)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for prewarm pulls, got %v", got)
+		}
+	}
+	want := []string{
+		"ghcr.io/org/missing:latest",
+		"ghcr.io/cirruslabs/macos-sequoia-base:latest",
+		"mirror.internal/org/ubuntu:24.04",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("expected pulls of %v, got %v", want, got)
+	}
+}
+
+func TestSetConfig_RejectsEmptyPrewarmImage(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{})
+	if err := d.SetConfig(pluginConfig(t, &Config{PrewarmImages: []string{""}})); err == nil {
+		t.Fatalf("expected an error for an empty prewarm image")
+	}
+}
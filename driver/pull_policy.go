@@ -0,0 +1,122 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// pullPolicyIfNotPresent only pulls images missing from tart's cache.
+	pullPolicyIfNotPresent = "if-not-present"
+
+	// pullPolicyAlways pulls the image again before every clone.
+	pullPolicyAlways = "always"
+
+	// pullPolicyIfOlderThan pulls the image again once the cached copy is
+	// older than the task's pull_max_age.
+	pullPolicyIfOlderThan = "if-older-than"
+)
+
+// pullPolicies are the values pull_policy accepts.
+var pullPolicies = []string{pullPolicyIfNotPresent, pullPolicyAlways, pullPolicyIfOlderThan}
+
+// validatePullPolicy checks pull_policy and that pull_max_age is a positive
+// duration set exactly when the policy needs one.
+func validatePullPolicy(tc TaskConfig) error {
+	switch tc.PullPolicy {
+	case "", pullPolicyIfNotPresent, pullPolicyAlways:
+		if tc.PullMaxAge != "" {
+			return fmt.Errorf("pull_max_age requires pull_policy = %q", pullPolicyIfOlderThan)
+		}
+	case pullPolicyIfOlderThan:
+		if maxAge, err := time.ParseDuration(tc.PullMaxAge); err != nil || maxAge <= 0 {
+			return fmt.Errorf("pull_policy %q requires pull_max_age to be a positive duration, got %q", pullPolicyIfOlderThan, tc.PullMaxAge)
+		}
+	default:
+		return fmt.Errorf("pull_policy must be one of: %s, got %q", strings.Join(pullPolicies, ", "), tc.PullPolicy)
+	}
+	if tc.ImageFile != "" && tc.PullPolicy != "" && tc.PullPolicy != pullPolicyIfNotPresent {
+		return fmt.Errorf("pull_policy %q cannot be used with image_file, which is never pulled", tc.PullPolicy)
+	}
+	return nil
+}
+
+// needsPull decides whether the task's image must be pulled under its
+// pull_policy, given whether it is in tart's cache and how old the cached
+// copy is.
+func needsPull(tc TaskConfig, cached bool, age time.Duration) bool {
+	if !cached {
+		return true
+	}
+	switch tc.PullPolicy {
+	case pullPolicyAlways:
+		return true
+	case pullPolicyIfOlderThan:
+		maxAge, err := time.ParseDuration(tc.PullMaxAge)
+		return err != nil || age > maxAge
+	default:
+		return false
+	}
+}
+
+// cachedImageAge returns how long ago url was pulled into the cache of the
+// tart home, from the modification time of its cache entry, which tart
+// replaces whenever a pull fetches a new image for the reference. It is a
+// package-level indirection to allow tests to age cached images.
+var cachedImageAge = func(home, url string) (time.Duration, error) {
+	info, err := os.Lstat(cachedImageDir(home, url))
+	if err != nil {
+		return 0, err
+	}
+	return time.Since(info.ModTime()), nil
+}
+
+// cachedImageStale reports whether the task's cached image must be pulled
+// again under its pull_policy. An image whose age cannot be determined is
+// treated as stale.
+func (c *TartClient) cachedImageStale(config VMConfig) bool {
+	switch config.TaskConfig.PullPolicy {
+	case "", pullPolicyIfNotPresent:
+		return false
+	}
+
+	home := config.TartHome
+	if home == "" {
+		home = tartHome()
+	}
+	age, err := cachedImageAge(home, config.TaskConfig.URL)
+	if err != nil {
+		c.logger.Debug("failed to determine the cached image's age", "url", redact(config.TaskConfig.URL), "error", err)
+		return true
+	}
+	return needsPull(config.TaskConfig, true, age)
+}
+
+// refreshImage pulls url into tart's cache again ahead of cloning vmName from
+// it, using the task's environment so its registry credentials and proxy
+// apply.
+func (c *TartClient) refreshImage(ctx context.Context, url, vmName string, concurrency int, env []string) error {
+	c.logger.Debug("Pulling image again under the task's pull_policy", "name", vmName, "url", redact(url))
+	return c.pullImage(ctx, url, vmName, concurrency, env)
+}
+
+// pullImage pulls image into tart's cache for vmName's task with the task's
+// environment, downloading up to concurrency layers at once when positive.
+func (c *TartClient) pullImage(ctx context.Context, image, vmName string, concurrency int, env []string) error {
+	cmd := c.command(ctx, pullArgs(image, concurrency)...)
+	cmd.Env = env
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	c.audit("pull", vmName, []string{image}, err)
+	if err != nil {
+		return fmt.Errorf("failed to pull image %s: %v (stderr: %s)", redact(image), err, stderr.String())
+	}
+	return nil
+}
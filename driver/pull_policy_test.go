@@ -0,0 +1,116 @@
+package driver
+
+import (
+	"context"
+	"os/exec"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+func TestValidatePullPolicy(t *testing.T) {
+	valid := []TaskConfig{
+		{},
+		{PullPolicy: pullPolicyIfNotPresent},
+		{PullPolicy: pullPolicyAlways},
+		{PullPolicy: pullPolicyIfOlderThan, PullMaxAge: "24h"},
+		{ImageFile: "/images/vm.tar", PullPolicy: pullPolicyIfNotPresent},
+	}
+	for _, tc := range valid {
+		if err := validatePullPolicy(tc); err != nil {
+			t.Fatalf("%+v: unexpected error: %v", tc, err)
+		}
+	}
+	invalid := []TaskConfig{
+		{PullPolicy: "never"},
+		{PullPolicy: pullPolicyIfOlderThan},
+		{PullPolicy: pullPolicyIfOlderThan, PullMaxAge: "a day"},
+		{PullPolicy: pullPolicyIfOlderThan, PullMaxAge: "-1h"},
+		{PullPolicy: pullPolicyAlways, PullMaxAge: "24h"},
+		{PullMaxAge: "24h"},
+		{ImageFile: "/images/vm.tar", PullPolicy: pullPolicyAlways},
+	}
+	for _, tc := range invalid {
+		if err := validatePullPolicy(tc); err == nil {
+			t.Fatalf("%+v: expected an error", tc)
+		}
+	}
+}
+
+func TestNeedsPull(t *testing.T) {
+	ifOlderThanDay := TaskConfig{PullPolicy: pullPolicyIfOlderThan, PullMaxAge: "24h"}
+	cases := []struct {
+		name   string
+		tc     TaskConfig
+		cached bool
+		age    time.Duration
+		want   bool
+	}{
+		{"default pulls missing image", TaskConfig{}, false, 0, true},
+		{"default keeps cached image", TaskConfig{}, true, 365 * 24 * time.Hour, false},
+		{"if-not-present keeps cached image", TaskConfig{PullPolicy: pullPolicyIfNotPresent}, true, 365 * 24 * time.Hour, false},
+		{"always pulls missing image", TaskConfig{PullPolicy: pullPolicyAlways}, false, 0, true},
+		{"always pulls fresh cached image", TaskConfig{PullPolicy: pullPolicyAlways}, true, time.Minute, true},
+		{"if-older-than pulls missing image", ifOlderThanDay, false, 0, true},
+		{"if-older-than keeps fresh image", ifOlderThanDay, true, 23 * time.Hour, false},
+		{"if-older-than pulls expired image", ifOlderThanDay, true, 25 * time.Hour, true},
+	}
+	for _, c := range cases {
+		if got := needsPull(c.tc, c.cached, c.age); got != c.want {
+			t.Fatalf("%s: expected %v, got %v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestSetup_PullsStaleImageBeforeCloning(t *testing.T) {
+	cases := []struct {
+		name     string
+		policy   string
+		maxAge   string
+		age      time.Duration
+		wantPull bool
+	}{
+		{"if-not-present", pullPolicyIfNotPresent, "", 48 * time.Hour, false},
+		{"always", pullPolicyAlways, "", time.Minute, true},
+		{"if-older-than fresh", pullPolicyIfOlderThan, "24h", time.Hour, false},
+		{"if-older-than expired", pullPolicyIfOlderThan, "24h", 48 * time.Hour, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			origAge := cachedImageAge
+			cachedImageAge = func(home, url string) (time.Duration, error) { return tc.age, nil }
+			defer func() { cachedImageAge = origAge }()
+
+			var calls []string
+			orig := execCommandContext
+			execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+				calls = append(calls, args[0])
+				return exec.CommandContext(ctx, "true")
+			}
+			defer func() { execCommandContext = orig }()
+
+			c := NewTartClient(testLogger(t))
+			vmc := VMConfig{
+				TaskConfig:  TaskConfig{URL: "ghcr.io/org/img:latest", PullPolicy: tc.policy, PullMaxAge: tc.maxAge},
+				NomadConfig: &drivers.TaskConfig{AllocID: "alloc-pull"},
+				TartHome:    t.TempDir(),
+			}
+
+			if _, err := c.Setup(context.Background(), vmc); err != nil {
+				t.Fatalf("Setup returned error: %v", err)
+			}
+			pull, clone := slices.Index(calls, "pull"), slices.Index(calls, "clone")
+			if clone < 0 {
+				t.Fatalf("expected the VM to be cloned, got %v", calls)
+			}
+			if tc.wantPull && (pull < 0 || pull > clone) {
+				t.Fatalf("expected the image to be pulled before cloning, got %v", calls)
+			}
+			if !tc.wantPull && pull >= 0 {
+				t.Fatalf("did not expect the image to be pulled, got %v", calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+const (
+	// defaultReadyTimeout bounds a ready_when gate without a configured
+	// timeout.
+	defaultReadyTimeout = 5 * time.Minute
+
+	// defaultReadyInterval is how often the ready_when command is retried
+	// when no interval is configured.
+	defaultReadyInterval = 5 * time.Second
+)
+
+// readinessGate is a validated ready_when task block.
+type readinessGate struct {
+	command  []string
+	exitCode int
+	timeout  time.Duration
+	interval time.Duration
+}
+
+// newReadinessGate validates a ready_when block, returning nil when none is
+// configured.
+func newReadinessGate(config *ReadyWhenConfig) (*readinessGate, error) {
+	if config == nil {
+		return nil, nil
+	}
+	if len(config.Command) == 0 || config.Command[0] == "" {
+		return nil, fmt.Errorf("ready_when command must not be empty")
+	}
+
+	timeout, err := parseReadyDuration("timeout", config.Timeout, defaultReadyTimeout)
+	if err != nil {
+		return nil, err
+	}
+	interval, err := parseReadyDuration("interval", config.Interval, defaultReadyInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	return &readinessGate{
+		command:  config.Command,
+		exitCode: config.ExitCode,
+		timeout:  timeout,
+		interval: interval,
+	}, nil
+}
+
+// parseReadyDuration parses the ready_when setting called name, returning def
+// when it is unset.
+func parseReadyDuration(name, value string, def time.Duration) (time.Duration, error) {
+	if value == "" {
+		return def, nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ready_when %s %q: %v", name, value, err)
+	}
+	if parsed <= 0 {
+		return 0, fmt.Errorf("ready_when %s must be positive, got %s", name, parsed)
+	}
+	return parsed, nil
+}
+
+// waitUntilReady runs the gate's command in the guest over SSH until it exits
+// with the expected code, emitting a ready event once it does. It fails when
+// the command has not passed within the gate's timeout.
+func (d *Driver) waitUntilReady(ctx context.Context, gate *readinessGate, vmConfig VMConfig) error {
+	ctx, cancel := context.WithTimeout(ctx, gate.timeout)
+	defer cancel()
+
+	var last string
+	for {
+		code, err := d.client.Exec(ctx, vmConfig, ExecOptions{Command: gate.command})
+		switch {
+		case err != nil:
+			last = err.Error()
+		case code == gate.exitCode:
+			d.emitReadyEvent(vmConfig.NomadConfig)
+			return nil
+		default:
+			last = fmt.Sprintf("exit code %d", code)
+		}
+		d.logger.Debug("guest is not ready yet", "command", strings.Join(gate.command, " "), "result", last)
+
+		if err := d.clock.Sleep(ctx, gate.interval); err != nil {
+			return fmt.Errorf("guest was not ready within %s, last ready_when result: %s", gate.timeout, last)
+		}
+	}
+}
+
+// emitReadyEvent emits the task event marking the guest as ready.
+func (d *Driver) emitReadyEvent(cfg *drivers.TaskConfig) {
+	d.eventer.EmitEvent(&drivers.TaskEvent{
+		TaskID:      cfg.ID,
+		TaskName:    cfg.Name,
+		AllocID:     cfg.AllocID,
+		Timestamp:   time.Now(),
+		Message:     "VM ready",
+		Annotations: labelAnnotations(cfg, map[string]string{}),
+	})
+}
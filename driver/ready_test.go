@@ -0,0 +1,152 @@
+package driver
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+func TestNewReadinessGate(t *testing.T) {
+	gate, err := newReadinessGate(nil)
+	if err != nil || gate != nil {
+		t.Fatalf("expected no gate without ready_when, got %v, %v", gate, err)
+	}
+
+	gate, err = newReadinessGate(&ReadyWhenConfig{Command: []string{"docker", "info"}})
+	if err != nil {
+		t.Fatalf("newReadinessGate returned error: %v", err)
+	}
+	if gate.timeout != defaultReadyTimeout || gate.interval != defaultReadyInterval {
+		t.Fatalf("unexpected defaults: %+v", gate)
+	}
+
+	for _, config := range []*ReadyWhenConfig{
+		{},
+		{Command: []string{"docker", "info"}, Timeout: "soon"},
+		{Command: []string{"docker", "info"}, Interval: "-1s"},
+	} {
+		if _, err := newReadinessGate(config); err == nil {
+			t.Fatalf("expected an error for %+v", config)
+		}
+	}
+}
+
+func TestWaitUntilReady_PassesAndEmitsEvent(t *testing.T) {
+	attempts := 0
+	d := newTestDriver(t, &fakeClient{
+		execFn: func(ctx context.Context, config VMConfig, opts ExecOptions) (int, error) {
+			attempts++
+			if strings.Join(opts.Command, " ") != "docker info" {
+				t.Errorf("unexpected command: %v", opts.Command)
+			}
+			if attempts < 3 {
+				return 1, nil
+			}
+			return 0, nil
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := d.TaskEvents(ctx)
+	if err != nil {
+		t.Fatalf("TaskEvents returned error: %v", err)
+	}
+
+	gate := &readinessGate{command: []string{"docker", "info"}, timeout: 5 * time.Second, interval: time.Millisecond}
+	vmConfig := VMConfig{NomadConfig: &drivers.TaskConfig{ID: "task-1", AllocID: "alloc-1"}}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- d.waitUntilReady(context.Background(), gate, vmConfig) }()
+
+	select {
+	case ev := <-events:
+		if ev.Message != "VM ready" {
+			t.Fatalf("unexpected event: %q", ev.Message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for the ready event")
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("waitUntilReady returned error: %v", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestWaitUntilReady_TimesOut(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{
+		execFn: func(ctx context.Context, config VMConfig, opts ExecOptions) (int, error) {
+			return 1, nil
+		},
+	})
+
+	gate := &readinessGate{command: []string{"docker", "info"}, timeout: 20 * time.Millisecond, interval: time.Millisecond}
+	err := d.waitUntilReady(context.Background(), gate, VMConfig{NomadConfig: &drivers.TaskConfig{ID: "task-1"}})
+	if err == nil || !strings.Contains(err.Error(), "exit code 1") {
+		t.Fatalf("expected a timeout naming the last exit code, got: %v", err)
+	}
+}
+
+func TestStartTask_ReadyWhenTimeoutFailsStart(t *testing.T) {
+	origSignal := signalProcess
+	signalProcess = func(pid int, sig syscall.Signal) error { return syscall.ESRCH }
+	defer func() { signalProcess = origSignal }()
+
+	var deleted []string
+	d := newTestDriver(t, &fakeClient{
+		execFn: func(ctx context.Context, config VMConfig, opts ExecOptions) (int, error) {
+			if opts.Command[0] == "docker" {
+				return 1, nil
+			}
+			<-ctx.Done()
+			return -1, ctx.Err()
+		},
+		deleteFn: func(ctx context.Context, vmName string) error {
+			deleted = append(deleted, vmName)
+			return nil
+		},
+	})
+	if err := d.SetConfig(pluginConfig(t, &Config{})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+	d.createExecutor = fakeExecutorFactory(newFakeExecutor())
+
+	dir := t.TempDir()
+	cfg := &drivers.TaskConfig{
+		ID:         "task-1",
+		Name:       "vm",
+		AllocID:    "alloc-1",
+		AllocDir:   dir,
+		StdoutPath: filepath.Join(dir, "stdout"),
+		StderrPath: filepath.Join(dir, "stderr"),
+	}
+	taskConfig := TaskConfig{
+		URL:         "ghcr.io/org/img:latest",
+		SSHUser:     "admin",
+		SSHPassword: "admin",
+		ReadyWhen: &ReadyWhenConfig{
+			Command:  []string{"docker", "info"},
+			Timeout:  "50ms",
+			Interval: "10ms",
+		},
+	}
+	if err := cfg.EncodeConcreteDriverConfig(&taskConfig); err != nil {
+		t.Fatalf("failed to encode task config: %v", err)
+	}
+
+	if _, _, err := d.StartTask(cfg); err == nil || !strings.Contains(err.Error(), "not ready") {
+		t.Fatalf("expected StartTask to fail when the guest is not ready, got: %v", err)
+	}
+	if _, ok := d.tasks.Get(cfg.ID); ok {
+		t.Fatalf("task still registered after failing ready_when")
+	}
+	if len(deleted) != 1 || deleted[0] != "nomad-alloc-1" {
+		t.Fatalf("expected the VM to be deleted, got %v", deleted)
+	}
+}
@@ -0,0 +1,65 @@
+package driver
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// validateRegistryMirrors checks that registry_mirrors maps registry hosts,
+// with any port, to other registry hosts.
+func validateRegistryMirrors(mirrors map[string]string) error {
+	for source, mirror := range mirrors {
+		for _, host := range []string{source, mirror} {
+			if host == "" || strings.ContainsAny(host, "/@") || strings.IndexFunc(host, unicode.IsSpace) >= 0 {
+				return fmt.Errorf("invalid registry_mirrors entry %q = %q: both must be registry hosts, e.g. docker.io", source, mirror)
+			}
+		}
+	}
+	return nil
+}
+
+// mirrorImageRef returns image with its registry host replaced by the mirror
+// configured for it, keeping any scheme, credentials, repository, tag and
+// digest. Images from registries without a mirror are returned unchanged.
+func mirrorImageRef(image string, mirrors map[string]string) string {
+	if len(mirrors) == 0 {
+		return image
+	}
+
+	scheme, ref := "", image
+	if i := strings.Index(image, "://"); i >= 0 {
+		scheme, ref = image[:i+len("://")], image[i+len("://"):]
+	}
+	authority, path, found := strings.Cut(ref, "/")
+	if !found {
+		return image
+	}
+	userinfo, host := "", authority
+	if at := strings.LastIndex(authority, "@"); at >= 0 {
+		userinfo, host = authority[:at+1], authority[at+1:]
+	}
+
+	mirror, ok := mirrors[host]
+	if !ok {
+		return image
+	}
+	return scheme + userinfo + mirror + "/" + path
+}
+
+// applyRegistryMirrors points the task's image and extra disks at the plugin's
+// registry_mirrors. Registry credentials are sent to the host of the rewritten
+// URL, so they must be valid for the mirror.
+func (d *Driver) applyRegistryMirrors(taskConfig *TaskConfig) {
+	if d.config == nil || len(d.config.RegistryMirrors) == 0 {
+		return
+	}
+
+	if url := mirrorImageRef(taskConfig.URL, d.config.RegistryMirrors); url != taskConfig.URL {
+		d.logger.Debug("pulling image through registry mirror", "url", redact(taskConfig.URL), "mirror_url", redact(url))
+		taskConfig.URL = url
+	}
+	for i, image := range taskConfig.ExtraDisks {
+		taskConfig.ExtraDisks[i] = mirrorImageRef(image, d.config.RegistryMirrors)
+	}
+}
@@ -0,0 +1,130 @@
+package driver
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// lookupUser is a package-level indirection to allow tests to fake local
+// users. In production it points to user.Lookup.
+var lookupUser = user.Lookup
+
+// resolveRunAsUser looks up the local user a task's tart process runs as.
+func resolveRunAsUser(name string) (*user.User, error) {
+	u, err := lookupUser(name)
+	if err != nil {
+		return nil, fmt.Errorf("user %q does not exist on this host: %v", name, err)
+	}
+	return u, nil
+}
+
+// userTartHome returns the tart home of u, where VMs run as u are stored.
+func userTartHome(u *user.User) string {
+	return filepath.Join(u.HomeDir, ".tart")
+}
+
+// tartHomes records the tart home of VMs stored outside the agent user's own
+// store, keyed by VM name. Entries are registered when a VM is set up and
+// dropped once it is deleted.
+type tartHomes struct {
+	lock  sync.RWMutex
+	homes map[string]string
+}
+
+func newTartHomes() *tartHomes {
+	return &tartHomes{homes: map[string]string{}}
+}
+
+func (t *tartHomes) set(vmName, home string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.homes[vmName] = home
+}
+
+func (t *tartHomes) get(vmName string) (string, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	home, ok := t.homes[vmName]
+	return home, ok
+}
+
+func (t *tartHomes) remove(vmName string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.homes, vmName)
+}
+
+// all returns the distinct registered tart homes in sorted order.
+func (t *tartHomes) all() []string {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	seen := map[string]struct{}{}
+	var homes []string
+	for _, home := range t.homes {
+		if _, ok := seen[home]; ok {
+			continue
+		}
+		seen[home] = struct{}{}
+		homes = append(homes, home)
+	}
+	sort.Strings(homes)
+	return homes
+}
+
+// chownToUser hands path to u so tart can open it once the executor has
+// switched to that user. It only has an effect when the agent runs as root.
+func chownToUser(path string, u *user.User) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf("invalid uid %q for user %s: %v", u.Uid, u.Username, err)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return fmt.Errorf("invalid gid %q for user %s: %v", u.Gid, u.Username, err)
+	}
+	return os.Lchown(path, uid, gid)
+}
+
+// chownVM hands the VM's directory in the tart home, and the store
+// directories above it, to u so tart can open the VM once the executor has
+// switched to that user. It only has an effect when the agent runs as root.
+var chownVM = func(home, vmName string, u *user.User) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf("invalid uid %q for user %s: %v", u.Uid, u.Username, err)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return fmt.Errorf("invalid gid %q for user %s: %v", u.Gid, u.Username, err)
+	}
+
+	if home == "" {
+		home = tartHome()
+	}
+	for _, dir := range []string{home, filepath.Join(home, "vms")} {
+		if err := os.Lchown(dir, uid, gid); err != nil {
+			return err
+		}
+	}
+	return filepath.WalkDir(vmPath(home, vmName), func(path string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, uid, gid)
+	})
+}
@@ -0,0 +1,224 @@
+package driver
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// fakeUser stubs lookupUser so that only a user named name exists, with its
+// home directory at home.
+func fakeUser(t *testing.T, name, home string) *user.User {
+	t.Helper()
+	u := &user.User{
+		Username: name,
+		HomeDir:  home,
+		Uid:      strconv.Itoa(os.Getuid()),
+		Gid:      strconv.Itoa(os.Getgid()),
+	}
+	orig := lookupUser
+	lookupUser = func(username string) (*user.User, error) {
+		if username != name {
+			return nil, user.UnknownUserError(username)
+		}
+		return u, nil
+	}
+	t.Cleanup(func() { lookupUser = orig })
+	return u
+}
+
+func TestResolveRunAsUser(t *testing.T) {
+	fakeUser(t, "alice", "/Users/alice")
+
+	u, err := resolveRunAsUser("alice")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := userTartHome(u); got != filepath.Join("/Users/alice", ".tart") {
+		t.Fatalf("unexpected tart home %s", got)
+	}
+
+	if _, err := resolveRunAsUser("mallory"); err == nil {
+		t.Fatalf("expected an error for an unknown user")
+	}
+}
+
+func TestVMPathFor_UsesRegisteredTartHome(t *testing.T) {
+	t.Setenv("TART_HOME", "/var/tart")
+	c := NewTartClient(testLogger(t))
+	c.homes.set("nomad-runas", "/Users/alice/.tart")
+
+	if got, want := c.vmPathFor("nomad-runas"), filepath.Join("/Users/alice/.tart", "vms", "nomad-runas"); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	if got, want := c.vmPathFor("nomad-other"), filepath.Join("/var/tart", "vms", "nomad-other"); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestTartClient_RunAsVMUsesUserTartHome(t *testing.T) {
+	t.Setenv("TART_HOME", t.TempDir())
+	u := fakeUser(t, "alice", t.TempDir())
+
+	origChown := chownVM
+	var chowned []string
+	chownVM = func(home, vmName string, owner *user.User) error {
+		chowned = append(chowned, vmName+":"+owner.Username)
+		return nil
+	}
+	t.Cleanup(func() { chownVM = origChown })
+
+	cmds := map[string]*exec.Cmd{}
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, "true")
+		cmds[args[0]] = cmd
+		return cmd
+	}
+	t.Cleanup(func() { execCommandContext = orig })
+
+	c := NewTartClient(testLogger(t))
+	vmc := VMConfig{
+		TaskConfig:  TaskConfig{URL: "ghcr.io/org/img:latest"},
+		NomadConfig: &drivers.TaskConfig{AllocID: "alloc-runas"},
+		RunAs:       u,
+		TartHome:    userTartHome(u),
+	}
+	if _, err := c.Setup(context.Background(), vmc); err != nil {
+		t.Fatalf("Setup returned error: %v", err)
+	}
+	if err := c.Stop(context.Background(), "nomad-alloc-runas", time.Second); err != nil {
+		t.Fatalf("Stop returned error: %v", err)
+	}
+
+	want := "TART_HOME=" + userTartHome(u)
+	for _, name := range []string{"clone", "set", "stop"} {
+		cmd, ok := cmds[name]
+		if !ok {
+			t.Fatalf("tart %s was not run", name)
+		}
+		if !slices.Contains(cmd.Env, want) {
+			t.Fatalf("tart %s did not get %s", name, want)
+		}
+	}
+	if !slices.Equal(chowned, []string{"nomad-alloc-runas:alice"}) {
+		t.Fatalf("unexpected chowns: %v", chowned)
+	}
+}
+
+func TestTartClient_ListIncludesRunAsTartHomes(t *testing.T) {
+	t.Setenv("TART_HOME", "/var/tart")
+
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sh", "-c", `if [ -n "$TART_HOME" ] && [ "$TART_HOME" != /var/tart ]; then echo '[{"Name":"nomad-runas","State":"running"}]'; else echo '[{"Name":"nomad-agent","State":"stopped"}]'; fi`)
+	}
+	t.Cleanup(func() { execCommandContext = orig })
+
+	c := NewTartClient(testLogger(t))
+	c.homes.set("nomad-runas", "/Users/alice/.tart")
+	vms, err := c.List(context.Background())
+	if err != nil {
+		t.Fatalf("List returned error: %v", err)
+	}
+
+	var names []string
+	for _, vm := range vms {
+		names = append(names, vm.Name)
+	}
+	if !slices.Equal(names, []string{"nomad-agent", "nomad-runas"}) {
+		t.Fatalf("unexpected VMs: %v", names)
+	}
+	if _, err := c.Status(context.Background(), "nomad-runas"); err != nil {
+		t.Fatalf("expected Status to find the run-as VM: %v", err)
+	}
+}
+
+func TestTartClient_ListSkipsUnreadableTartHome(t *testing.T) {
+	t.Setenv("TART_HOME", "/var/tart")
+
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sh", "-c", `case "$TART_HOME" in
+/gone) echo "no such directory" >&2; exit 1 ;;
+/Users/alice/.tart) echo '[{"Name":"nomad-runas","State":"running"}]' ;;
+*) echo '[{"Name":"nomad-agent","State":"stopped"}]' ;;
+esac`)
+	}
+	t.Cleanup(func() { execCommandContext = orig })
+
+	c := NewTartClient(testLogger(t))
+	c.homes.set("nomad-runas", "/Users/alice/.tart")
+	c.homes.set("nomad-broken", "/gone")
+	vms, err := c.List(context.Background())
+	if err != nil {
+		t.Fatalf("List returned error: %v", err)
+	}
+
+	var names []string
+	for _, vm := range vms {
+		names = append(names, vm.Name)
+	}
+	if !slices.Equal(names, []string{"nomad-agent", "nomad-runas"}) {
+		t.Fatalf("unexpected VMs: %v", names)
+	}
+}
+
+func TestTartClient_DeleteUnregistersTartHome(t *testing.T) {
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "true")
+	}
+	t.Cleanup(func() { execCommandContext = orig })
+
+	c := NewTartClient(testLogger(t))
+	c.homes.set("nomad-runas", "/Users/alice/.tart")
+	if err := c.Delete(context.Background(), "nomad-runas"); err != nil {
+		t.Fatalf("Delete returned error: %v", err)
+	}
+	if _, ok := c.homes.get("nomad-runas"); ok {
+		t.Fatalf("expected the deleted VM's tart home to be unregistered")
+	}
+	if homes := c.homes.all(); len(homes) != 0 {
+		t.Fatalf("expected no tart homes left to list, got %v", homes)
+	}
+}
+
+func TestChownVM_NoopWhenNotRoot(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("running as root")
+	}
+	u := &user.User{Username: "alice", Uid: "not-a-uid", Gid: "20"}
+	if err := chownVM("", "nomad-missing", u); err != nil {
+		t.Fatalf("expected chownVM to do nothing without root, got: %v", err)
+	}
+}
+
+func TestDestroyTask_UnregistersVMTartHome(t *testing.T) {
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		// lsof finds no processes holding the VM's disk.
+		return exec.CommandContext(ctx, "false")
+	}
+	t.Cleanup(func() { execCommandContext = orig })
+
+	client := NewTartClient(testLogger(t))
+	d := newTestDriver(t, client)
+	cfg := registerExitedTask(t, d, newFakeExecutor())
+	client.homes.set("nomad-alloc-1", "/Users/alice/.tart")
+
+	if err := d.DestroyTask(cfg.ID, false); err != nil {
+		t.Fatalf("DestroyTask returned error: %v", err)
+	}
+	if homes := client.homes.all(); len(homes) != 0 {
+		t.Fatalf("expected the destroyed task's tart home to be unregistered, got %v", homes)
+	}
+}
@@ -0,0 +1,62 @@
+package driver
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// scratchDiskFile is the raw disk image created in the task's directory for
+// its scratch_disk. It is kept out of local/, which exit_code_marker shares
+// with the guest.
+const scratchDiskFile = "scratch.img"
+
+// validateScratchDisk checks that scratch_disk, when set, has a size.
+func validateScratchDisk(cfg *ScratchDiskConfig) error {
+	if cfg != nil && cfg.SizeGB <= 0 {
+		return fmt.Errorf("scratch_disk size_gb must be a positive integer, got %d", cfg.SizeGB)
+	}
+	return nil
+}
+
+// scratchDiskPath returns where the task's scratch disk image is kept.
+func scratchDiskPath(cfg *drivers.TaskConfig) string {
+	return filepath.Join(cfg.TaskDir().Dir, scratchDiskFile)
+}
+
+// createScratchDisk creates an empty raw disk image of sizeGB for the task,
+// replacing any left by a previous run so each run starts from a blank disk.
+// The file is sparse, so it only takes up the space the guest writes.
+func createScratchDisk(cfg *drivers.TaskConfig, sizeGB int, runAs *user.User) error {
+	path := scratchDiskPath(cfg)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create task dir: %v", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create scratch disk: %v", err)
+	}
+	defer f.Close()
+	if err := f.Truncate(int64(sizeGB) * bytesPerGB); err != nil {
+		return fmt.Errorf("failed to size scratch disk: %v", err)
+	}
+
+	if runAs != nil {
+		if err := chownToUser(path, runAs); err != nil {
+			return fmt.Errorf("failed to hand scratch disk to %s: %v", runAs.Username, err)
+		}
+	}
+	return nil
+}
+
+// removeScratchDisk deletes the task's scratch disk, if it has one.
+func removeScratchDisk(cfg *drivers.TaskConfig, logger hclog.Logger) {
+	if err := os.Remove(scratchDiskPath(cfg)); err != nil && !os.IsNotExist(err) {
+		logger.Warn("failed to remove scratch disk", "error", err)
+	}
+}
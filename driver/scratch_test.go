@@ -0,0 +1,114 @@
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/drivers/shared/executor"
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+func TestValidateScratchDisk(t *testing.T) {
+	for _, cfg := range []*ScratchDiskConfig{nil, {SizeGB: 1}, {SizeGB: 200}} {
+		if err := validateScratchDisk(cfg); err != nil {
+			t.Fatalf("%+v: unexpected error: %v", cfg, err)
+		}
+	}
+	for _, cfg := range []*ScratchDiskConfig{{}, {SizeGB: -5}} {
+		if err := validateScratchDisk(cfg); err == nil {
+			t.Fatalf("%+v: expected an error", cfg)
+		}
+	}
+}
+
+func TestBuildStartArgs_AttachesScratchDisk(t *testing.T) {
+	c := NewTartClient(testLogger(t))
+	cfg := VMConfig{
+		TaskConfig:  TaskConfig{ScratchDisk: &ScratchDiskConfig{SizeGB: 10}},
+		NomadConfig: &drivers.TaskConfig{AllocID: "alloc-1", Name: "vm", AllocDir: "/allocs/alloc-1"},
+	}
+
+	args, err := c.BuildStartArgs(cfg)
+	if err != nil {
+		t.Fatalf("BuildStartArgs returned error: %v", err)
+	}
+	if want := "--disk=/allocs/alloc-1/vm/" + scratchDiskFile; !slices.Contains(args, want) {
+		t.Fatalf("expected %q in args: %v", want, args)
+	}
+}
+
+func TestScratchDisk_CreatedOnStartAndRemovedOnDestroy(t *testing.T) {
+	origSignal := signalProcess
+	signalProcess = func(pid int, sig syscall.Signal) error { return syscall.ESRCH }
+	defer func() { signalProcess = origSignal }()
+
+	d := newTestDriver(t, &fakeClient{})
+	if err := d.SetConfig(pluginConfig(t, &Config{})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+	fe := newFakeExecutor()
+	d.createExecutor = fakeExecutorFactory(fe)
+
+	dir := t.TempDir()
+	cfg := &drivers.TaskConfig{
+		ID:         "task-1",
+		Name:       "vm",
+		AllocID:    "alloc-1",
+		AllocDir:   dir,
+		StdoutPath: filepath.Join(dir, "stdout"),
+		StderrPath: filepath.Join(dir, "stderr"),
+	}
+	taskConfig := TaskConfig{URL: "ghcr.io/org/img:latest", ScratchDisk: &ScratchDiskConfig{SizeGB: 2}}
+	if err := cfg.EncodeConcreteDriverConfig(&taskConfig); err != nil {
+		t.Fatalf("failed to encode task config: %v", err)
+	}
+
+	// A disk left behind by an earlier run is replaced by a blank one.
+	path := scratchDiskPath(cfg)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("stale data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := d.StartTask(cfg); err != nil {
+		t.Fatalf("StartTask returned error: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("expected a scratch disk at %s: %v", path, err)
+	}
+	head := make([]byte, 64)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Read(head)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 2*bytesPerGB || slices.ContainsFunc(head, func(b byte) bool { return b != 0 }) {
+		t.Fatalf("expected a blank 2 GB scratch disk, got %d bytes starting %q", info.Size(), head)
+	}
+
+	fe.exitCh <- &executor.ProcessState{}
+	h, _ := d.tasks.Get(cfg.ID)
+	select {
+	case <-h.doneCh:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("task did not exit")
+	}
+
+	if err := d.DestroyTask(cfg.ID, false); err != nil {
+		t.Fatalf("DestroyTask returned error: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected the scratch disk to be removed, got %v", err)
+	}
+}
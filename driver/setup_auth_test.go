@@ -58,8 +58,9 @@ func TestHelperProcess(t *testing.T) {
     os.Exit(0)
 }
 
-// Test that when TaskConfig.Auth is provided, we run tart login with the
-// provided credentials and then tart clone, passing through the environment.
+// Test that when TaskConfig.Auth is provided, the credentials are passed to
+// tart clone through its environment, scoped to the registry host, instead of
+// a host-wide tart login, and that the environment is passed through.
 func TestSetup_UsesTaskAuthAndEnv(t *testing.T) {
     t.Setenv("GO_WANT_HELPER_PROCESS", "1")
     t.Setenv("SENTINEL_VAR", "present")
@@ -97,16 +98,15 @@ func TestSetup_UsesTaskAuthAndEnv(t *testing.T) {
         t.Fatalf("reading log: %v", err)
     }
     lines := strings.Split(strings.TrimSpace(string(data)), "\n")
-    var loginRec, cloneRec *cmdRecord
+    var cloneRec *cmdRecord
     for _, ln := range lines {
         var r cmdRecord
         if err := json.Unmarshal([]byte(ln), &r); err != nil {
             t.Fatalf("parse record: %v", err)
         }
         if r.Name == "tart" && len(r.Args) > 0 {
-            if r.Args[0] == "login" && loginRec == nil {
-                rr := r
-                loginRec = &rr
+            if r.Args[0] == "login" {
+                t.Fatalf("did not expect a host-wide tart login: %v", r.Args)
             }
             if r.Args[0] == "clone" && cloneRec == nil {
                 rr := r
@@ -114,29 +114,114 @@ func TestSetup_UsesTaskAuthAndEnv(t *testing.T) {
             }
         }
     }
-    if loginRec == nil {
-        t.Fatalf("expected a login invocation, none found. records: %v", len(lines))
+    if cloneRec == nil {
+        t.Fatalf("expected a clone invocation, none found")
     }
-    // check username flag present
-    foundUser := false
-    for i := 0; i < len(loginRec.Args)-1; i++ {
-        if loginRec.Args[i] == "--username" && loginRec.Args[i+1] == "user1" {
-            foundUser = true
-            break
+    for key, val := range map[string]string{
+        "TART_REGISTRY_HOSTNAME": "ghcr.io",
+        "TART_REGISTRY_USERNAME": "user1",
+        "TART_REGISTRY_PASSWORD": "pass1",
+        "SENTINEL_VAR":           "present",
+    } {
+        if !envContains(cloneRec.Env, key, val) {
+            t.Fatalf("clone env missing %s=%s", key, val)
         }
     }
-    if !foundUser {
-        t.Fatalf("--username user1 not found in login args: %v", loginRec.Args)
+}
+
+// Test that credentials given as files in the task's secrets directory are
+// read at setup and passed to tart clone like inline ones.
+func TestSetup_ReadsTaskAuthFromSecretFiles(t *testing.T) {
+    t.Setenv("GO_WANT_HELPER_PROCESS", "1")
+
+    tmp := t.TempDir()
+    logPath := filepath.Join(tmp, "cmd.log")
+    t.Setenv("CMD_LOG", logPath)
+
+    orig := execCommandContext
+    execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+        ha := append([]string{"-test.run=TestHelperProcess", "--", name}, args...)
+        return exec.CommandContext(ctx, os.Args[0], ha...)
+    }
+    defer func() { execCommandContext = orig }()
+
+    nomadCfg := &drivers.TaskConfig{AllocID: "alloc-456", Name: "vm", AllocDir: tmp}
+    secrets := nomadCfg.TaskDir().SecretsDir
+    if err := os.MkdirAll(secrets, 0o700); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(filepath.Join(secrets, "registry_user"), []byte("fileuser\n"), 0o600); err != nil {
+        t.Fatal(err)
     }
-    // ensure env is passed
-    if !envContains(loginRec.Env, "SENTINEL_VAR", "present") {
-        t.Fatalf("login env missing SENTINEL_VAR=present")
+    if err := os.WriteFile(filepath.Join(secrets, "registry_password"), []byte("filepass\n"), 0o600); err != nil {
+        t.Fatal(err)
+    }
+
+    vmc := VMConfig{
+        TaskConfig: TaskConfig{
+            URL: "ghcr.io/example/private:latest",
+            Auth: Auth{UsernameFile: "registry_user", PasswordFile: "registry_password"},
+        },
+        NomadConfig: nomadCfg,
+    }
+    if err := validateTaskConfig(vmc.TaskConfig); err != nil {
+        t.Fatalf("validateTaskConfig returned error: %v", err)
+    }
+
+    c := NewTartClient(testLogger(t))
+    if _, err := c.Setup(context.Background(), vmc); err != nil {
+        t.Fatalf("Setup returned error: %v", err)
+    }
+
+    data, err := os.ReadFile(logPath)
+    if err != nil {
+        t.Fatalf("reading log: %v", err)
+    }
+    var cloneRec *cmdRecord
+    for _, ln := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+        var r cmdRecord
+        if err := json.Unmarshal([]byte(ln), &r); err != nil {
+            t.Fatalf("parse record: %v", err)
+        }
+        if r.Name == "tart" && len(r.Args) > 0 && r.Args[0] == "clone" && cloneRec == nil {
+            rr := r
+            cloneRec = &rr
+        }
     }
     if cloneRec == nil {
         t.Fatalf("expected a clone invocation, none found")
     }
-    if !envContains(cloneRec.Env, "SENTINEL_VAR", "present") {
-        t.Fatalf("clone env missing SENTINEL_VAR=present")
+    for key, val := range map[string]string{
+        "TART_REGISTRY_USERNAME": "fileuser",
+        "TART_REGISTRY_PASSWORD": "filepass",
+    } {
+        if !envContains(cloneRec.Env, key, val) {
+            t.Fatalf("clone env missing %s=%s", key, val)
+        }
+    }
+}
+
+func TestValidateAuth(t *testing.T) {
+    for _, auth := range []Auth{
+        {},
+        {Username: "u", Password: "p"},
+        {UsernameFile: "user", PasswordFile: "pass"},
+        {Username: "u", PasswordFile: "pass"},
+    } {
+        if err := validateAuth(auth); err != nil {
+            t.Fatalf("%+v: unexpected error: %v", auth, err)
+        }
+    }
+    for _, auth := range []Auth{
+        {Username: "u", UsernameFile: "user", Password: "p"},
+        {Username: "u", Password: "p", PasswordFile: "pass"},
+        {Username: "u"},
+        {UsernameFile: "../user", Password: "p"},
+        {Username: "u", PasswordFile: "/etc/pass"},
+    } {
+        if err := validateAuth(auth); err == nil {
+            t.Fatalf("%+v: expected an error", auth)
+        }
     }
 }
 
@@ -205,6 +290,72 @@ func TestSetup_NoTaskAuth_UsesEnvAndSkipsLogin(t *testing.T) {
     }
 }
 
+func TestSetup_AnonymousPull_StripsRegistryEnv(t *testing.T) {
+    t.Setenv("GO_WANT_HELPER_PROCESS", "1")
+    t.Setenv("SENTINEL_VAR", "present")
+    t.Setenv("TART_REGISTRY_USERNAME", "envuser")
+    t.Setenv("TART_REGISTRY_PASSWORD", "envpass")
+
+    tmp := t.TempDir()
+    logPath := filepath.Join(tmp, "cmd.log")
+    t.Setenv("CMD_LOG", logPath)
+
+    orig := execCommandContext
+    execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+        ha := append([]string{"-test.run=TestHelperProcess", "--", name}, args...)
+        return exec.CommandContext(ctx, os.Args[0], ha...)
+    }
+    defer func() { execCommandContext = orig }()
+
+    vmc := VMConfig{
+        TaskConfig: TaskConfig{
+            URL:           "ghcr.io/example/public:latest",
+            AnonymousPull: true,
+        },
+        NomadConfig: &drivers.TaskConfig{
+            AllocID: "alloc-anon",
+            Env:     map[string]string{"TART_REGISTRY_HOSTNAME": "ghcr.io"},
+        },
+    }
+
+    c := NewTartClient(testLogger(t))
+    if _, err := c.Setup(context.Background(), vmc); err != nil {
+        t.Fatalf("Setup returned error: %v", err)
+    }
+
+    data, err := os.ReadFile(logPath)
+    if err != nil {
+        t.Fatalf("reading log: %v", err)
+    }
+    var cloneRec *cmdRecord
+    for _, ln := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+        var r cmdRecord
+        if err := json.Unmarshal([]byte(ln), &r); err != nil {
+            t.Fatalf("parse record: %v", err)
+        }
+        if r.Name == "tart" && len(r.Args) > 0 {
+            switch r.Args[0] {
+            case "login":
+                t.Fatalf("did not expect a login invocation for an anonymous pull")
+            case "clone":
+                rr := r
+                cloneRec = &rr
+            }
+        }
+    }
+    if cloneRec == nil {
+        t.Fatalf("expected a clone invocation, none found")
+    }
+    for _, kv := range cloneRec.Env {
+        if strings.HasPrefix(kv, "TART_REGISTRY_") {
+            t.Fatalf("clone env kept registry credential %s", kv)
+        }
+    }
+    if !envContains(cloneRec.Env, "SENTINEL_VAR", "present") {
+        t.Fatalf("clone env missing unrelated variables")
+    }
+}
+
 // envContains checks for key=value in env slice
 func envContains(env []string, key, val string) bool {
     want := key + "=" + val
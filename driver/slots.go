@@ -0,0 +1,34 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/nomad/nomad/structs"
+)
+
+// ensureVMSlot fails with a recoverable error when starting vmName would run
+// more VMs than the host allows, less the operator's reserved_slots.
+// Fingerprints only advertise free slots periodically, so a placement can race
+// with another task or a VM started by hand; refusing here lets Nomad retry the
+// task instead of tart run failing with Virtualization.framework's error. The
+// VM of a recovered task is already running and does not count.
+func (d *Driver) ensureVMSlot(ctx context.Context, vmName string) error {
+	vms, err := d.client.List(ctx)
+	if err != nil {
+		d.logger.Warn("failed to count running VMs, starting anyway", "error", err)
+		return nil
+	}
+
+	running := 0
+	for _, vm := range vms {
+		if vm.Status == VMStateRunning && vm.Name != vmName {
+			running++
+		}
+	}
+	if limit := maxVMSlots - d.config.ReservedSlots; running >= limit {
+		return structs.NewRecoverableError(
+			fmt.Errorf("no VM slots available: %d VMs running, %d allowed", running, limit), true)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package driver
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/nomad/nomad/structs"
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+func TestStartTask_RefusesWhenNoVMSlots(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{
+		listFn: func(ctx context.Context) ([]VMInfo, error) {
+			return []VMInfo{
+				{Name: "nomad-alloc-a", Status: VMStateRunning},
+				{Name: "manual", Status: VMStateRunning},
+				{Name: "nomad-alloc-old", Status: VMStateStopped},
+			}, nil
+		},
+		setupFn: func(ctx context.Context, config VMConfig) (SetupResult, error) {
+			t.Fatalf("setup should not clone without a free VM slot")
+			return SetupResult{}, nil
+		},
+	})
+	if err := d.SetConfig(pluginConfig(t, &Config{})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+
+	cfg := &drivers.TaskConfig{ID: "task-1", Name: "vm", AllocID: "alloc-1"}
+	if err := cfg.EncodeConcreteDriverConfig(&TaskConfig{URL: "ghcr.io/org/img:latest"}); err != nil {
+		t.Fatalf("failed to encode task config: %v", err)
+	}
+
+	_, _, err := d.StartTask(cfg)
+	if err == nil || !strings.Contains(err.Error(), "no VM slots available") {
+		t.Fatalf("expected StartTask to refuse, got: %v", err)
+	}
+	if !structs.IsRecoverable(err) {
+		t.Fatalf("expected a recoverable error so Nomad retries the task")
+	}
+}
+
+func TestEnsureVMSlot(t *testing.T) {
+	running := []VMInfo{
+		{Name: "nomad-alloc-1", Status: VMStateRunning},
+		{Name: "manual", Status: VMStateRunning},
+	}
+	d := newTestDriver(t, &fakeClient{
+		listFn: func(ctx context.Context) ([]VMInfo, error) { return running, nil },
+	})
+
+	// A recovered task's own VM does not take a slot from it.
+	if err := d.ensureVMSlot(context.Background(), "nomad-alloc-1"); err != nil {
+		t.Fatalf("unexpected error for a running task's own VM: %v", err)
+	}
+
+	running = running[1:]
+	if err := d.SetConfig(pluginConfig(t, &Config{ReservedSlots: 1})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+	if err := d.ensureVMSlot(context.Background(), "nomad-alloc-2"); err == nil {
+		t.Fatalf("expected the reserved slot to be kept free")
+	}
+}
@@ -0,0 +1,48 @@
+package driver
+
+import (
+	"fmt"
+	"strings"
+)
+
+// hasSSH reports whether the task configures credentials for reaching the
+// guest over SSH. Without them the driver only runs the VM: the guest's syslog
+// is not streamed and the task cannot be exec'd into.
+func (tc TaskConfig) hasSSH() bool {
+	return tc.SSHUser != ""
+}
+
+// sshFeatures returns the task options that run commands in the guest over
+// SSH.
+func sshFeatures(tc TaskConfig) []string {
+	var features []string
+	if tc.Hostname != "" {
+		features = append(features, "hostname")
+	}
+	if tc.ReadyWhen != nil {
+		features = append(features, "ready_when")
+	}
+	if tc.GuestStats {
+		features = append(features, "guest_stats")
+	}
+	if tc.BootScript != nil {
+		features = append(features, "boot_script")
+	}
+	return features
+}
+
+// validateSSHCredentials checks that ssh_user and ssh_password are set
+// together, and that they are set when the task uses an option that needs
+// SSH.
+func validateSSHCredentials(tc TaskConfig) error {
+	if (tc.SSHUser == "") != (tc.SSHPassword == "") {
+		return fmt.Errorf("ssh_user and ssh_password must be set together")
+	}
+	if tc.hasSSH() {
+		return nil
+	}
+	if features := sshFeatures(tc); len(features) > 0 {
+		return fmt.Errorf("ssh_user and ssh_password are required to use %s", strings.Join(features, ", "))
+	}
+	return nil
+}
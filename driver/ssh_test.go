@@ -0,0 +1,61 @@
+package driver
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+func TestValidateSSHCredentials(t *testing.T) {
+	// A run-and-shutdown task never reaches into the guest.
+	if err := validateSSHCredentials(TaskConfig{URL: "ghcr.io/org/img:latest"}); err != nil {
+		t.Fatalf("unexpected error for a task without SSH: %v", err)
+	}
+	if err := validateSSHCredentials(TaskConfig{SSHUser: "admin", SSHPassword: "admin", GuestStats: true}); err != nil {
+		t.Fatalf("unexpected error with credentials: %v", err)
+	}
+
+	cases := []struct {
+		name string
+		tc   TaskConfig
+		want string
+	}{
+		{"hostname", TaskConfig{Hostname: "builder"}, "required to use hostname"},
+		{"ready_when", TaskConfig{ReadyWhen: &ReadyWhenConfig{Command: []string{"true"}}}, "required to use ready_when"},
+		{"guest_stats", TaskConfig{GuestStats: true}, "required to use guest_stats"},
+		{"user without password", TaskConfig{SSHUser: "admin"}, "must be set together"},
+	}
+	for _, tc := range cases {
+		if err := validateSSHCredentials(tc.tc); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: expected an error containing %q, got %v", tc.name, tc.want, err)
+		}
+	}
+}
+
+func TestExecTaskStreaming_RequiresSSHCredentials(t *testing.T) {
+	client := &fakeClient{
+		execFn: func(ctx context.Context, config VMConfig, opts ExecOptions) (int, error) {
+			t.Fatalf("exec should not be attempted without SSH credentials")
+			return 0, nil
+		},
+	}
+	d := newTestDriver(t, client)
+
+	cfg := &drivers.TaskConfig{ID: "task-1", AllocID: "alloc-1"}
+	if err := cfg.EncodeConcreteDriverConfig(&TaskConfig{URL: "ghcr.io/org/img:latest"}); err != nil {
+		t.Fatalf("failed to encode task config: %v", err)
+	}
+	d.tasks.Set("task-1", &taskHandle{taskConfig: cfg})
+	opts := &drivers.ExecOptions{
+		Command: []string{"true"},
+		Stdin:   io.NopCloser(strings.NewReader("")),
+		Stdout:  nopWriteCloser{io.Discard},
+		Stderr:  nopWriteCloser{io.Discard},
+	}
+	if _, err := d.ExecTaskStreaming(context.Background(), "task-1", opts); err == nil || !strings.Contains(err.Error(), "ssh_user") {
+		t.Fatalf("expected exec to fail without SSH credentials, got %v", err)
+	}
+}
@@ -1,6 +1,9 @@
 package driver
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
 // taskStore is an in-memory datastore for taskHandles
 type taskStore struct {
@@ -30,9 +33,74 @@ func (ts *taskStore) Get(id string) (*taskHandle, bool) {
 	return handle, ok
 }
 
+// List returns a snapshot of all stored task handles
+func (ts *taskStore) List() []*taskHandle {
+	ts.lock.RLock()
+	defer ts.lock.RUnlock()
+	handles := make([]*taskHandle, 0, len(ts.store))
+	for _, h := range ts.store {
+		handles = append(handles, h)
+	}
+	return handles
+}
+
 // Delete removes a task handle
 func (ts *taskStore) Delete(id string) {
 	ts.lock.Lock()
 	defer ts.lock.Unlock()
 	delete(ts.store, id)
 }
+
+// startingTasks tracks the tasks whose StartTask is still setting up their VM,
+// which have no taskHandle yet, so a stop can cancel an in-flight image pull.
+type startingTasks struct {
+	starts map[string]*startingTask
+	lock   sync.Mutex
+}
+
+// startingTask is a StartTask in progress
+type startingTask struct {
+	// cancel cancels the start's context
+	cancel context.CancelFunc
+
+	// done is closed once StartTask has returned
+	done chan struct{}
+}
+
+// newStartingTasks returns a new, empty set of starting tasks
+func newStartingTasks() *startingTasks {
+	return &startingTasks{
+		starts: map[string]*startingTask{},
+	}
+}
+
+// Add records the function cancelling a task's start
+func (st *startingTasks) Add(id string, cancel context.CancelFunc) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	st.starts[id] = &startingTask{cancel: cancel, done: make(chan struct{})}
+}
+
+// Delete forgets a task once its start has returned, releasing anyone
+// waiting for it
+func (st *startingTasks) Delete(id string) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	if start, ok := st.starts[id]; ok {
+		close(start.done)
+		delete(st.starts, id)
+	}
+}
+
+// Cancel cancels a task's start, reporting whether it was still starting
+// and returning a channel closed once its StartTask has returned
+func (st *startingTasks) Cancel(id string) (<-chan struct{}, bool) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	start, ok := st.starts[id]
+	if !ok {
+		return nil, false
+	}
+	start.cancel()
+	return start.done, true
+}
@@ -147,3 +147,23 @@ func TestTaskStoreNilHandle(t *testing.T) {
 		t.Error("expected nil handle to be deleted")
 	}
 }
+
+func TestTaskStoreList(t *testing.T) {
+	t.Parallel()
+	ts := newTaskStore()
+	if got := ts.List(); len(got) != 0 {
+		t.Fatalf("expected empty list, got %d handles", len(got))
+	}
+
+	h1, h2 := &taskHandle{}, &taskHandle{}
+	ts.Set("a", h1)
+	ts.Set("b", h2)
+
+	got := ts.List()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 handles, got %d", len(got))
+	}
+	if !((got[0] == h1 && got[1] == h2) || (got[0] == h2 && got[1] == h1)) {
+		t.Fatalf("unexpected handles returned: %v", got)
+	}
+}
@@ -0,0 +1,311 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/nomad/client/lib/cpustats"
+	"github.com/hashicorp/nomad/plugins/device"
+	"github.com/hashicorp/nomad/plugins/drivers"
+	"github.com/hashicorp/nomad/plugins/shared/structs"
+)
+
+const (
+	// guestStatsType is the device type used when reporting the usage seen
+	// inside the guest. It is kept apart from the host-measured CPU and
+	// memory, which already include everything the guest does.
+	guestStatsType = "guest"
+
+	// guestStatsTimeout bounds each sample of the guest's processes.
+	guestStatsTimeout = 5 * time.Second
+
+	// guestStatsInterval is the shortest time between samples of the guest's
+	// processes, however often Nomad collects the task's stats. Samples in
+	// between reuse the last one.
+	guestStatsInterval = 30 * time.Second
+
+	// statsSnapshotTimeout bounds how long TaskStatsSnapshot waits for a
+	// sample.
+	statsSnapshotTimeout = 10 * time.Second
+)
+
+// statsSources are the optional sources collectStats adds to the executor's
+// samples.
+type statsSources struct {
+	// guest is the VM whose processes are sampled over SSH, nil unless the
+	// task enables guest_stats.
+	guest *VMConfig
+}
+
+// collectStats relays executor stats from in to out, combining each sample
+// with the usage of the VM's host processes outside the executor's process
+// tree and, when enabled, the usage reported inside the guest. Network
+// throughput is not reported: the host interfaces VMs use, such as the
+// shared NAT bridge, carry every VM's traffic, so their counters are not the
+// task's own.
+func (d *Driver) collectStats(ctx context.Context, h *taskHandle, sources statsSources, in <-chan *drivers.TaskResourceUsage, out chan<- *drivers.TaskResourceUsage) {
+	defer close(out)
+
+	vmName := d.generateVMName(h.taskConfig.AllocID)
+	tracker := newVMStatsTracker(d.hostCompute())
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case usage, ok := <-in:
+			if !ok {
+				return
+			}
+
+			if usage != nil && usage.ResourceUsage != nil {
+				d.addVMProcessStats(ctx, h, vmName, tracker, usage)
+				d.addDeviceStats(ctx, h, sources, usage)
+			}
+
+			select {
+			case out <- usage:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// addDeviceStats attaches the usage TaskResourceUsage has no fields for, the
+// guest's own view of its usage, as device stats.
+func (d *Driver) addDeviceStats(ctx context.Context, h *taskHandle, sources statsSources, usage *drivers.TaskResourceUsage) {
+	if sources.guest != nil {
+		if guestStats, err := h.guestStats().sample(ctx, d, *sources.guest); err != nil {
+			d.logger.Trace("failed to collect guest stats", "error", err)
+		} else {
+			usage.ResourceUsage.DeviceStats = append(usage.ResourceUsage.DeviceStats, guestStats)
+		}
+	}
+}
+
+// guestSampler samples a task's guest processes over one connection, dialed
+// on first use and again only after it fails, and at most once every
+// guestStatsInterval.
+type guestSampler struct {
+	lock sync.Mutex
+
+	// conn is the connection to the guest, nil until dialed or after it
+	// failed.
+	conn GuestConn
+
+	// last is the latest sample, taken at sampledAt.
+	last      *device.DeviceGroupStats
+	sampledAt time.Time
+
+	// closed is set once the task is destroyed, after which the guest is
+	// not dialed again.
+	closed bool
+}
+
+// sample returns the guest's usage, sampling it again when the last sample is
+// older than guestStatsInterval.
+func (g *guestSampler) sample(ctx context.Context, d *Driver, vmConfig VMConfig) (*device.DeviceGroupStats, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	now := d.clock.Now()
+	if g.last != nil && now.Sub(g.sampledAt) < guestStatsInterval {
+		return g.last, nil
+	}
+	if g.closed {
+		return nil, errors.New("task destroyed")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, guestStatsTimeout)
+	defer cancel()
+
+	if g.conn == nil {
+		conn, err := d.client.DialGuest(ctx, vmConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to the guest: %v", err)
+		}
+		g.conn = conn
+	}
+
+	stats, err := guestDeviceStats(ctx, g.conn)
+	if err != nil {
+		// The connection may be broken, e.g. by a guest reboot; dial
+		// again, looking up the VM's address anew, next time.
+		g.conn.Close()
+		g.conn = nil
+		return nil, err
+	}
+	g.last, g.sampledAt = stats, now
+	return stats, nil
+}
+
+// close closes the connection to the guest for good.
+func (g *guestSampler) close() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.closed = true
+	if g.conn != nil {
+		g.conn.Close()
+		g.conn = nil
+	}
+}
+
+// guestUsage is the usage of all processes in the guest as reported by ps.
+type guestUsage struct {
+	CPUPercent float64
+	RSSBytes   int64
+	Processes  int64
+}
+
+// guestDeviceStats samples the guest's processes with ps over conn and
+// returns their combined usage as device stats.
+func guestDeviceStats(ctx context.Context, conn GuestConn) (*device.DeviceGroupStats, error) {
+	var stdout bytes.Buffer
+	code, err := conn.Run(ctx, []string{"ps", "-A", "-o", "%cpu=,rss="}, &stdout, io.Discard)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run ps in the guest: %v", err)
+	}
+	if code != 0 {
+		return nil, fmt.Errorf("ps exited with code %d in the guest", code)
+	}
+
+	usage, err := parseGuestPS(stdout.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return &device.DeviceGroupStats{
+		Vendor: pluginName,
+		Type:   guestStatsType,
+		Name:   guestStatsType,
+		InstanceStats: map[string]*device.DeviceStats{
+			guestStatsType: {
+				Summary: &structs.StatValue{
+					FloatNumeratorVal: &usage.CPUPercent,
+					Unit:              "%",
+					Desc:              "CPU usage reported by the guest",
+				},
+				Stats: &structs.StatObject{
+					Attributes: map[string]*structs.StatValue{
+						"cpu_percent": {FloatNumeratorVal: &usage.CPUPercent, Unit: "%", Desc: "CPU usage reported by the guest"},
+						"rss_bytes":   {IntNumeratorVal: &usage.RSSBytes, Unit: "bytes", Desc: "Resident memory reported by the guest"},
+						"processes":   {IntNumeratorVal: &usage.Processes, Desc: "Processes running in the guest"},
+					},
+				},
+				Timestamp: time.Now(),
+			},
+		},
+	}, nil
+}
+
+// parseGuestPS sums the "%cpu rss" lines printed by ps in the guest. ps
+// reports RSS in kilobytes.
+func parseGuestPS(out string) (guestUsage, error) {
+	var usage guestUsage
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return guestUsage{}, fmt.Errorf("unexpected ps output line %q", line)
+		}
+
+		cpu, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return guestUsage{}, fmt.Errorf("invalid CPU usage %q: %v", fields[0], err)
+		}
+		rss, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return guestUsage{}, fmt.Errorf("invalid RSS %q: %v", fields[1], err)
+		}
+
+		usage.CPUPercent += cpu
+		usage.RSSBytes += rss * 1024
+		usage.Processes++
+	}
+	if usage.Processes == 0 {
+		return guestUsage{}, fmt.Errorf("ps reported no processes")
+	}
+	return usage, nil
+}
+
+// hostCompute returns the host's CPU compute as detected by Nomad, or the zero
+// value before the agent has passed it to the driver.
+func (d *Driver) hostCompute() cpustats.Compute {
+	if d.nomadConfig == nil || d.nomadConfig.Topology == nil {
+		return cpustats.Compute{}
+	}
+	return d.nomadConfig.Topology.Compute()
+}
+
+// statsPIDs returns the host processes, other than tart, whose usage counts
+// towards the VM's. When the agent may not see which processes hold the VM's
+// disk open, it falls back to the Virtualization.framework process found by
+// name, provided it is the only one on the host and so must be this VM's.
+func (d *Driver) statsPIDs(ctx context.Context, tartPID int, vmDir string) []int {
+	found, err := findVMProcessPIDs(ctx, vmDir)
+	if errors.Is(err, errProcessInfoDenied) {
+		d.warnProcessInfoDenied(err)
+		if byName := virtualizationPIDsByName(ctx); len(byName) == 1 {
+			found = append(found, byName...)
+		}
+	}
+
+	var pids []int
+	for _, pid := range found {
+		if pid != tartPID && !slices.Contains(pids, pid) {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// warnProcessInfoDenied warns, once per driver, that VM stats undercount
+// because the agent may not read other processes' information.
+func (d *Driver) warnProcessInfoDenied(err error) {
+	d.processInfoWarning.Do(func() {
+		d.logger.Warn("cannot read host process information, VM resource usage will be undercounted; "+
+			"run the Nomad agent as root, or as the user tart runs as, to measure the VM's Virtualization.framework process",
+			"error", err)
+	})
+}
+
+// addVMProcessStats merges the usage of the VM's related host processes,
+// excluding the tart process the executor already measures, into usage.
+func (d *Driver) addVMProcessStats(ctx context.Context, h *taskHandle, vmName string, tracker *vmStatsTracker, usage *drivers.TaskResourceUsage) {
+	_, _, tartPID := h.process()
+	pids := d.statsPIDs(ctx, tartPID, vmPath(h.tartHome, vmName))
+	if len(pids) == 0 {
+		return
+	}
+
+	total, perPID := tracker.usage(pids)
+	if err := tracker.deniedErr(); err != nil {
+		d.warnProcessInfoDenied(err)
+	}
+	if usage.ResourceUsage.CpuStats == nil {
+		usage.ResourceUsage.CpuStats = &drivers.CpuStats{}
+	}
+	if usage.ResourceUsage.MemoryStats == nil {
+		usage.ResourceUsage.MemoryStats = &drivers.MemoryStats{}
+	}
+	usage.ResourceUsage.Add(total)
+
+	if usage.Pids == nil {
+		usage.Pids = map[string]*drivers.ResourceUsage{}
+	}
+	for pid, ru := range perPID {
+		usage.Pids[pid] = ru
+	}
+}
@@ -0,0 +1,310 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+func TestCollectStats_OmitsSharedNetworkCounters(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{})
+
+	in := make(chan *drivers.TaskResourceUsage, 1)
+	out := make(chan *drivers.TaskResourceUsage)
+	in <- &drivers.TaskResourceUsage{ResourceUsage: &drivers.ResourceUsage{}}
+	close(in)
+
+	h := &taskHandle{taskConfig: &drivers.TaskConfig{AllocID: "alloc-1"}, pid: 1}
+	go d.collectStats(context.Background(), h, statsSources{}, in, out)
+
+	usage := <-out
+	if got := usage.ResourceUsage.DeviceStats; len(got) != 0 {
+		t.Fatalf("expected no device stats for a VM's shared network interface, got %+v", got)
+	}
+
+	if _, ok := <-out; ok {
+		t.Fatalf("expected output channel to close once input closes")
+	}
+}
+
+func TestParseGuestPS(t *testing.T) {
+	usage, err := parseGuestPS(" 12.5  2048\n  0.0   512\n\n 30.0 10240\n")
+	if err != nil {
+		t.Fatalf("parseGuestPS returned error: %v", err)
+	}
+	if want := (guestUsage{CPUPercent: 42.5, RSSBytes: 12800 * 1024, Processes: 3}); usage != want {
+		t.Fatalf("got %+v, want %+v", usage, want)
+	}
+
+	for _, out := range []string{"", "12.5\n", "busy 2048\n"} {
+		if _, err := parseGuestPS(out); err == nil {
+			t.Fatalf("expected an error for ps output %q", out)
+		}
+	}
+}
+
+func TestTaskStatsSnapshot_IncludesRelatedPIDs(t *testing.T) {
+	origSample := sampleProcess
+	sampleProcess = func(pid int) (*processSample, error) {
+		return &processSample{UserSeconds: 1, RSS: 4096}, nil
+	}
+	defer func() { sampleProcess = origSample }()
+
+	origExec := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		// The Virtualization.framework process holding the VM's disk open.
+		return exec.CommandContext(ctx, "echo", "4243")
+	}
+	defer func() { execCommandContext = origExec }()
+
+	d := newTestDriver(t, &fakeClient{})
+	fe := newFakeExecutor()
+	fe.sample = &drivers.TaskResourceUsage{ResourceUsage: &drivers.ResourceUsage{
+		CpuStats:    &drivers.CpuStats{Percent: 5},
+		MemoryStats: &drivers.MemoryStats{RSS: 1024},
+	}}
+	cfg := &drivers.TaskConfig{ID: "task-1", AllocID: "alloc-1"}
+	if err := cfg.EncodeConcreteDriverConfig(&TaskConfig{URL: "ghcr.io/org/img:latest"}); err != nil {
+		t.Fatalf("failed to encode task config: %v", err)
+	}
+	d.tasks.Set(cfg.ID, &taskHandle{exec: fe, taskConfig: cfg, pid: 4242})
+
+	usage, err := d.TaskStatsSnapshot(cfg.ID)
+	if err != nil {
+		t.Fatalf("TaskStatsSnapshot returned error: %v", err)
+	}
+	if _, ok := usage.Pids["4243"]; !ok {
+		t.Fatalf("expected per-PID usage of the VM process, got %v", usage.Pids)
+	}
+	if got := usage.ResourceUsage.MemoryStats.RSS; got != 1024+4096 {
+		t.Fatalf("expected RSS to include the VM process, got %d", got)
+	}
+
+	if _, err := d.TaskStatsSnapshot("missing"); err != drivers.ErrTaskNotFound {
+		t.Fatalf("expected ErrTaskNotFound, got %v", err)
+	}
+}
+
+func TestTaskStatsSnapshot_FallsBackWhenProcessInfoDenied(t *testing.T) {
+	origSample := sampleProcess
+	sampleProcess = func(pid int) (*processSample, error) {
+		if pid == 6000 {
+			return nil, fmt.Errorf("proc_pidinfo: %w", syscall.EPERM)
+		}
+		return &processSample{UserSeconds: 1, RSS: 4096}, nil
+	}
+	defer func() { sampleProcess = origSample }()
+
+	byName := "5151"
+	origExec := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		if name == "pgrep" {
+			return exec.CommandContext(ctx, "echo", byName)
+		}
+		// lsof refused access to the processes holding the VM's disk open.
+		return exec.CommandContext(ctx, "sh", "-c", `echo "lsof: WARNING: can't stat() vnode: Permission denied" >&2; exit 1`)
+	}
+	defer func() { execCommandContext = origExec }()
+
+	var buf bytes.Buffer
+	d := newTestDriver(t, &fakeClient{})
+	d.logger = hclog.New(&hclog.LoggerOptions{Output: &buf, Level: hclog.Warn})
+	fe := newFakeExecutor()
+	cfg := &drivers.TaskConfig{ID: "task-1", AllocID: "alloc-1"}
+	if err := cfg.EncodeConcreteDriverConfig(&TaskConfig{URL: "ghcr.io/org/img:latest"}); err != nil {
+		t.Fatalf("failed to encode task config: %v", err)
+	}
+	d.tasks.Set(cfg.ID, &taskHandle{exec: fe, taskConfig: cfg, pid: 4242})
+	snapshot := func() *drivers.TaskResourceUsage {
+		t.Helper()
+		fe.sample = &drivers.TaskResourceUsage{ResourceUsage: &drivers.ResourceUsage{
+			CpuStats:    &drivers.CpuStats{Percent: 5},
+			MemoryStats: &drivers.MemoryStats{RSS: 1024},
+		}}
+		usage, err := d.TaskStatsSnapshot(cfg.ID)
+		if err != nil {
+			t.Fatalf("TaskStatsSnapshot returned error: %v", err)
+		}
+		return usage
+	}
+
+	usage := snapshot()
+	if _, ok := usage.Pids["5151"]; !ok || usage.ResourceUsage.MemoryStats.RSS != 1024+4096 {
+		t.Fatalf("expected the VM process found by name to be measured, got %v", usage.Pids)
+	}
+
+	// With two VMs on the host the process found by name could be either's.
+	byName = "5151\n5152"
+	if usage := snapshot(); len(usage.Pids) != 0 {
+		t.Fatalf("expected only tart to be measured when the fallback is ambiguous, got %v", usage.Pids)
+	}
+
+	// A process that cannot be sampled is reported the same way.
+	tracker := newVMStatsTracker(d.hostCompute())
+	tracker.usage([]int{6000})
+	if err := tracker.deniedErr(); !errors.Is(err, errProcessInfoDenied) {
+		t.Fatalf("expected a sampling permission error to be recorded, got %v", err)
+	}
+
+	if n := strings.Count(buf.String(), "cannot read host process information"); n != 1 {
+		t.Fatalf("expected a single warning, got %d:\n%s", n, buf.String())
+	}
+	if !strings.Contains(buf.String(), "run the Nomad agent as root") {
+		t.Fatalf("expected the warning to say how to fix it, got %s", buf.String())
+	}
+}
+
+func TestCollectStats_CombinesHostAndGuestUsage(t *testing.T) {
+	origSample := sampleProcess
+	sampleProcess = func(pid int) (*processSample, error) {
+		return &processSample{UserSeconds: 1, RSS: 4096}, nil
+	}
+	defer func() { sampleProcess = origSample }()
+
+	origExec := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		// The Virtualization.framework process holding the VM's disk open.
+		return exec.CommandContext(ctx, "echo", "4243")
+	}
+	defer func() { execCommandContext = origExec }()
+
+	conn := &fakeGuestConn{output: "25.0 1024\n15.0 1024\n"}
+	d := newTestDriver(t, &fakeClient{
+		dialGuestFn: func(ctx context.Context, config VMConfig) (GuestConn, error) {
+			return conn, nil
+		},
+	})
+
+	in := make(chan *drivers.TaskResourceUsage, 1)
+	out := make(chan *drivers.TaskResourceUsage)
+	in <- &drivers.TaskResourceUsage{ResourceUsage: &drivers.ResourceUsage{
+		CpuStats:    &drivers.CpuStats{Percent: 5},
+		MemoryStats: &drivers.MemoryStats{RSS: 1024},
+	}}
+	close(in)
+
+	h := &taskHandle{taskConfig: &drivers.TaskConfig{AllocID: "alloc-1"}, pid: 4242}
+	sources := statsSources{guest: &VMConfig{NomadConfig: h.taskConfig}}
+	go d.collectStats(context.Background(), h, sources, in, out)
+
+	usage := <-out
+	if len(conn.commands) != 1 || !strings.HasPrefix(conn.commands[0], "ps ") {
+		t.Fatalf("expected ps to run in the guest, got %v", conn.commands)
+	}
+
+	// The host side combines the executor's tart process with the VM process.
+	if got := usage.ResourceUsage.MemoryStats.RSS; got != 1024+4096 {
+		t.Fatalf("expected host RSS to include the VM process, got %d", got)
+	}
+	if _, ok := usage.Pids["4243"]; !ok {
+		t.Fatalf("expected per-PID usage of the VM process, got %v", usage.Pids)
+	}
+
+	// The guest's view is reported separately.
+	if len(usage.ResourceUsage.DeviceStats) != 1 {
+		t.Fatalf("expected one device stats group, got %d", len(usage.ResourceUsage.DeviceStats))
+	}
+	group := usage.ResourceUsage.DeviceStats[0]
+	if group.Type != guestStatsType {
+		t.Fatalf("unexpected device group: %+v", group)
+	}
+	attrs := group.InstanceStats[guestStatsType].Stats.Attributes
+	if got := *attrs["cpu_percent"].FloatNumeratorVal; got != 40 {
+		t.Fatalf("unexpected guest cpu_percent: %v", got)
+	}
+	if got := *attrs["rss_bytes"].IntNumeratorVal; got != 2048*1024 {
+		t.Fatalf("unexpected guest rss_bytes: %d", got)
+	}
+}
+
+// fakeGuestConn is a GuestConn recording the commands it runs, which print
+// output, or fail with err when set.
+type fakeGuestConn struct {
+	output   string
+	err      error
+	commands []string
+	closed   bool
+}
+
+func (c *fakeGuestConn) Run(ctx context.Context, command []string, stdout, stderr io.Writer) (int, error) {
+	c.commands = append(c.commands, strings.Join(command, " "))
+	if c.err != nil {
+		return -1, c.err
+	}
+	io.WriteString(stdout, c.output)
+	return 0, nil
+}
+
+func (c *fakeGuestConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestGuestSampler_ReusesConnectionAndSample(t *testing.T) {
+	var conns []*fakeGuestConn
+	d := newTestDriver(t, &fakeClient{
+		dialGuestFn: func(ctx context.Context, config VMConfig) (GuestConn, error) {
+			conn := &fakeGuestConn{output: "10.0 1024\n"}
+			conns = append(conns, conn)
+			return conn, nil
+		},
+	})
+	clock := newFakeClock()
+	d.clock = clock
+
+	g := &guestSampler{}
+	vmConfig := VMConfig{NomadConfig: &drivers.TaskConfig{AllocID: "alloc-1"}}
+	sample := func() {
+		t.Helper()
+		if _, err := g.sample(context.Background(), d, vmConfig); err != nil {
+			t.Fatalf("sample returned error: %v", err)
+		}
+	}
+
+	// Samples within guestStatsInterval repeat the first one.
+	sample()
+	clock.Advance(guestStatsInterval / 2)
+	sample()
+	if len(conns) != 1 || len(conns[0].commands) != 1 {
+		t.Fatalf("expected one connection running ps once, got %d connections", len(conns))
+	}
+
+	// Later samples run ps again over the same connection.
+	clock.Advance(guestStatsInterval)
+	sample()
+	if len(conns) != 1 || len(conns[0].commands) != 2 {
+		t.Fatalf("expected ps to run again over the first connection, got %d connections", len(conns))
+	}
+
+	// A failed sample drops the connection, and the next one dials again.
+	conns[0].err = errors.New("connection lost")
+	clock.Advance(guestStatsInterval)
+	if _, err := g.sample(context.Background(), d, vmConfig); err == nil {
+		t.Fatalf("expected the failed sample to return an error")
+	}
+	if !conns[0].closed {
+		t.Fatalf("expected the failed connection to be closed")
+	}
+	sample()
+	if len(conns) != 2 {
+		t.Fatalf("expected the guest to be dialed again, got %d connections", len(conns))
+	}
+
+	g.close()
+	if !conns[1].closed {
+		t.Fatalf("expected close to close the connection")
+	}
+	clock.Advance(guestStatsInterval)
+	if _, err := g.sample(context.Background(), d, vmConfig); err == nil || len(conns) != 2 {
+		t.Fatalf("expected a closed sampler not to dial the guest again, got %v", err)
+	}
+}
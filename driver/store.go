@@ -0,0 +1,58 @@
+package driver
+
+import (
+	"io/fs"
+	"path/filepath"
+	"syscall"
+)
+
+// bytesPerGB converts max_image_store_gb into bytes.
+const bytesPerGB = 1 << 30
+
+// tartStoreUsage is a package-level indirection to allow tests to fake how
+// much space a tart home takes up. In production it points to diskUsage.
+var tartStoreUsage = diskUsage
+
+// diskUsage returns the bytes allocated on disk to the files under dir. VM
+// disk images are sparse, so their apparent size would overstate it. Files
+// shared between APFS clones are counted once per clone.
+func diskUsage(dir string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			total += int64(st.Blocks) * 512
+		} else {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+// imageStoreOverQuota reports whether the plugin's tart home takes up more
+// than max_image_store_gb, along with the space it uses.
+func (d *Driver) imageStoreOverQuota() (bool, int64, error) {
+	if d.config.MaxImageStoreGB <= 0 {
+		return false, 0, nil
+	}
+
+	home := d.config.TartHome
+	if home == "" {
+		home = tartHome()
+	}
+	used, err := tartStoreUsage(home)
+	if err != nil {
+		return false, 0, err
+	}
+	return used > int64(d.config.MaxImageStoreGB)*bytesPerGB, used, nil
+}
@@ -0,0 +1,35 @@
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiskUsage_CountsAllocatedBlocks(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "vms", "base"), 0o755); err != nil {
+		t.Fatalf("failed to create VM dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "vms", "base", "nvram.bin"), make([]byte, 64*1024), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	// A sparse disk image takes up next to nothing whatever its size.
+	disk, err := os.Create(filepath.Join(dir, "vms", "base", vmDiskImage))
+	if err != nil {
+		t.Fatalf("failed to create disk: %v", err)
+	}
+	if err := disk.Truncate(1 << 30); err != nil {
+		t.Fatalf("failed to size disk: %v", err)
+	}
+	disk.Close()
+
+	used, err := diskUsage(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if used < 64*1024 || used >= 1<<30 {
+		t.Fatalf("expected the allocated size of the files, got %d bytes", used)
+	}
+}
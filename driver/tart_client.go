@@ -4,32 +4,242 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"net"
 	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
 // execCommandContext is a package-level indirection to allow tests to stub
 // out command execution. In er it points to exec.CommandContext.
 var execCommandContext = exec.CommandContext
 
+// signalProcess is a package-level indirection to allow tests to observe
+// signals sent to VM processes. In production it points to syscall.Kill.
+var signalProcess = syscall.Kill
+
+// ptyModes are the terminal modes of interactive exec sessions. The client's
+// terminal is put in raw mode by nomad alloc exec -t, so the guest's terminal
+// has to echo input, edit lines and turn control characters into signals, as
+// a local shell would.
+var ptyModes = ssh.TerminalModes{
+	ssh.ECHO:          1,     // echo typed characters
+	ssh.ICANON:        1,     // line editing
+	ssh.ISIG:          1,     // ^C and ^Z send signals
+	ssh.ICRNL:         1,     // Enter sends a newline
+	ssh.OPOST:         1,     // output processing
+	ssh.ONLCR:         1,     // newlines return the cursor
+	ssh.TTY_OP_ISPEED: 38400, // input speed = 38.4kbaud
+	ssh.TTY_OP_OSPEED: 38400, // output speed = 38.4kbaud
+}
+
+// sshAuthSockEnv names the variable holding the path of the host's SSH agent
+// socket.
+const sshAuthSockEnv = "SSH_AUTH_SOCK"
+
+// sshDial is a package-level indirection to allow tests to stub out SSH
+// connections to VMs. In production it points to ssh.Dial.
+var sshDial = ssh.Dial
+
+// sshPollInterval is how often WaitForSSH retries connecting to a VM that is
+// still booting.
+const sshPollInterval = 1 * time.Second
+
+// sshDialAttempts is how many times dialVM resolves a VM's address and dials
+// it before giving up.
+const sshDialAttempts = 3
+
+const (
+	// ipPollInitialInterval and ipPollMaxInterval bound the backoff used by
+	// IPAddressWithTimeout while a VM waits for a DHCP lease.
+	ipPollInitialInterval = 250 * time.Millisecond
+	ipPollMaxInterval     = 4 * time.Second
+
+	// ipWaitTimeout is how long callers wait for a freshly booted VM to
+	// acquire an IP address.
+	ipWaitTimeout = 60 * time.Second
+
+	// defaultCommandTimeout bounds short tart commands when command_timeout
+	// is unset. Image pulls during setup are not bounded.
+	defaultCommandTimeout = 2 * time.Minute
+)
+
 // TartClient is a wrapper around the tart CLI that implements the Virtualizer interface
 type TartClient struct {
 	logger hclog.Logger
+
+	// configLock guards the settings below, which SetConfig may change
+	// while commands are running.
+	configLock sync.RWMutex
+
+	// auditLog records the tart operations run for tasks, if configured.
+	auditLog *auditLog
+
+	// commandTimeout bounds short tart commands, such as list or delete,
+	// run with a context that has no deadline of its own.
+	commandTimeout time.Duration
+
+	// now returns the current time and is overridden in tests to measure
+	// durations deterministically.
+	now func() time.Time
+
+	// homes records the VMs kept outside the agent user's tart home, so
+	// commands on them and List find them.
+	homes *tartHomes
+
+	// tasks records the task each VM was set up for, so audit records name
+	// it.
+	tasks *vmTasks
+
+	// memoryMinWarning logs that memory_min is not enforced only once,
+	// rather than for every VM set up.
+	memoryMinWarning sync.Once
 }
 
 // NewTartClient creates a new TartClient
 func NewTartClient(logger hclog.Logger) *TartClient {
 	return &TartClient{
-		logger: logger.Named("tart_client"),
+		logger:         logger.Named("tart_client"),
+		commandTimeout: defaultCommandTimeout,
+		now:            time.Now,
+		homes:          newTartHomes(),
+		tasks:          newVMTasks(),
+	}
+}
+
+// configure applies the plugin settings that govern the client's commands.
+func (c *TartClient) configure(commandTimeout time.Duration, audit *auditLog) {
+	c.configLock.Lock()
+	defer c.configLock.Unlock()
+	c.commandTimeout = commandTimeout
+	c.auditLog = audit
+}
+
+// settings returns the client's command timeout and audit log.
+func (c *TartClient) settings() (time.Duration, *auditLog) {
+	c.configLock.RLock()
+	defer c.configLock.RUnlock()
+	return c.commandTimeout, c.auditLog
+}
+
+// withCommandTimeout returns a context bounding a short tart command by the
+// client's command timeout, unless ctx already has a deadline. A tart process
+// can hang when Virtualization.framework wedges, and many callers pass the
+// driver's long-lived context.
+func (c *TartClient) withCommandTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout, _ := c.settings()
+	if _, ok := ctx.Deadline(); ok || timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// commandErr describes the failure of a command run with ctx, noting when it
+// was killed because ctx timed out.
+func commandErr(ctx context.Context, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out (%v)", err)
+	}
+	return err
+}
+
+// command builds a tart invocation with the given arguments, logging the full
+// command line at debug level with secret flag values and credentials embedded
+// in URLs redacted.
+func (c *TartClient) command(ctx context.Context, args ...string) *exec.Cmd {
+	argv := []string{"tart"}
+	for _, arg := range redactArgs(args) {
+		argv = append(argv, redact(arg))
+	}
+	c.logger.Debug("running tart command", "argv", strings.Join(argv, " "))
+	return execCommandContext(ctx, "tart", args...)
+}
+
+// vmCommand builds a tart invocation that operates on vmName, pointing tart at
+// the store holding the VM when it lives outside the agent user's tart home.
+func (c *TartClient) vmCommand(ctx context.Context, vmName string, args ...string) *exec.Cmd {
+	cmd := c.command(ctx, args...)
+	if home, ok := c.homes.get(vmName); ok {
+		cmd.Env = append(os.Environ(), "TART_HOME="+home)
+	}
+	return cmd
+}
+
+// vmPathFor returns the on-disk directory of the named VM, in whichever tart
+// home it was set up in.
+func (c *TartClient) vmPathFor(vmName string) string {
+	home, _ := c.homes.get(vmName)
+	return vmPath(home, vmName)
+}
+
+// errVMExists indicates that tart refused to clone because a VM with the
+// target name already exists.
+var errVMExists = errors.New("VM already exists")
+
+var (
+	// errVMNotFound indicates that tart has no VM with the given name, e.g.
+	// because it was already deleted.
+	errVMNotFound = errors.New("VM does not exist")
+
+	// errVMNotRunning indicates that tart could not stop a VM because it
+	// was not running.
+	errVMNotRunning = errors.New("VM is not running")
+)
+
+// tartErrors maps the messages tart prints on stderr to the errors they
+// stand for.
+var tartErrors = []struct {
+	message string
+	err     error
+}{
+	{"already exists", errVMExists},
+	{"does not exist", errVMNotFound},
+	{"is not running", errVMNotRunning},
+}
+
+// classifyTartError returns the error tart's stderr reports, or nil when it
+// is not one the driver tells apart.
+func classifyTartError(stderr string) error {
+	lower := strings.ToLower(stderr)
+	for _, e := range tartErrors {
+		if strings.Contains(lower, e.message) {
+			return e.err
+		}
+	}
+	return nil
+}
+
+// vmSourceFile is written into a VM's directory after cloning and records the
+// normalized reference of the image it was cloned from.
+const vmSourceFile = ".nomad-source"
+
+// writeVMSource records the image vmName was cloned from.
+func (c *TartClient) writeVMSource(vmName, url string) error {
+	return os.WriteFile(filepath.Join(c.vmPathFor(vmName), vmSourceFile), []byte(normalizeImageRef(url)), 0o644)
+}
+
+// readVMSource returns the normalized image reference vmName was cloned from.
+func (c *TartClient) readVMSource(vmName string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(c.vmPathFor(vmName), vmSourceFile))
+	if err != nil {
+		return "", err
 	}
+	return strings.TrimSpace(string(data)), nil
 }
 
 // tartVMInfo is the internal struct for parsing tart JSON output
@@ -41,11 +251,22 @@ type tartVMInfo struct {
 	Disk       int    `json:"Disk"`
 	State      string `json:"State"`
 	Source     string `json:"Source"`
+	Accessed   string `json:"Accessed"`
+
+	// Raw is the entry's JSON as printed by tart list.
+	Raw json.RawMessage `json:"-"`
 }
 
+// tartImageSource is the Source tart lists for images cached from an OCI
+// registry, as opposed to "local" for VMs.
+const tartImageSource = "oci"
+
 // Available checks if the tart binary is installed and accessible
 func (c *TartClient) Available(ctx context.Context) (string, error) {
-	cmd := exec.CommandContext(ctx, "tart", "--version")
+	ctx, cancel := c.withCommandTimeout(ctx)
+	defer cancel()
+
+	cmd := c.command(ctx, "--version")
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
@@ -53,7 +274,7 @@ func (c *TartClient) Available(ctx context.Context) (string, error) {
 
 	if err := cmd.Run(); err != nil {
 		return "", fmt.Errorf("tart is not installed or not in PATH: %v (stderr: %s)",
-			err, stderr.String())
+			commandErr(ctx, err), stderr.String())
 	}
 
 	version := strings.TrimSpace(stdout.String())
@@ -62,7 +283,17 @@ func (c *TartClient) Available(ctx context.Context) (string, error) {
 }
 
 // SetupVM creates a new Tart VM from a URL
-func (c *TartClient) Setup(ctx context.Context, config VMConfig) (string, error) {
+func (c *TartClient) Setup(ctx context.Context, config VMConfig) (SetupResult, error) {
+	result, err := c.setup(ctx, config)
+	if err != nil {
+		// Errors may carry the image URL or tart's stderr, either of which can
+		// contain registry credentials.
+		return SetupResult{}, errors.New(redact(err.Error()))
+	}
+	return result, nil
+}
+
+func (c *TartClient) setup(ctx context.Context, config VMConfig) (SetupResult, error) {
 	// Prepare environment for tart commands. Include task-specific
 	// variables so auth credentials are available during
 	// image pulls.
@@ -70,59 +301,290 @@ func (c *TartClient) Setup(ctx context.Context, config VMConfig) (string, error)
 	if config.NomadConfig != nil {
 		env = append(env, config.NomadConfig.EnvList()...)
 	}
+	env = filterEnv(env, config.EnvDenylist)
 
 	// Prefer credentials from task config; otherwise rely on env variables.
-	// Always pass through the environment to tart commands.
-	if config.TaskConfig.Auth.IsValid() {
-		host, err := registryHost(config.TaskConfig.URL)
+	// Task credentials only reach tart through the environment of this
+	// Setup's commands: tart login would store them for the whole host, so
+	// concurrent jobs using different credentials for the same registry
+	// would overwrite each other's.
+	vmName := c.generateVMName(config)
+	if config.NomadConfig != nil {
+		c.tasks.set(vmName, auditTask{AllocID: config.NomadConfig.AllocID, TaskID: config.NomadConfig.ID})
+	}
+	if config.TaskConfig.AnonymousPull {
+		env = filterEnv(env, []string{registryEnvPattern})
+		c.logger.Trace("Anonymous pull requested; dropping registry credentials from env")
+	} else if config.TaskConfig.Auth != (Auth{}) && config.TaskConfig.ImageFile == "" {
+		auth, err := resolveAuth(config.NomadConfig, config.TaskConfig.Auth)
 		if err != nil {
-			return "", fmt.Errorf("failed to parse URL: %v", err)
+			return SetupResult{}, err
 		}
-		loginCmd := execCommandContext(ctx, "tart", "login", host, "--username", config.TaskConfig.Auth.Username, "--password-stdin")
-		loginCmd.Stdin = strings.NewReader(config.TaskConfig.Auth.Password)
-		loginCmd.Env = env
-
-		var stderr bytes.Buffer
-		loginCmd.Stderr = &stderr
-
-		if err := loginCmd.Run(); err != nil {
-			return "", fmt.Errorf("failed to login to container registry: %v (stderr: %s)", err, stderr.String())
+		host, err := registryHost(config.TaskConfig.URL)
+		if err != nil {
+			return SetupResult{}, fmt.Errorf("failed to parse URL: %v", err)
 		}
+		env = append(env, registryAuthEnv(host, auth)...)
+		c.audit("registry_auth", vmName, []string{host}, nil)
 	} else {
 		c.logger.Trace("Auth not provided; relying on env vars for registry access")
 	}
 
-	vmName := c.generateVMName(config.NomadConfig.AllocID)
+	// The task's proxy settings only reach the commands pulling its images,
+	// which are the only ones run with env, so other jobs and the agent keep
+	// their own routing.
+	env = append(env, proxyEnv(config.TaskConfig)...)
+
 	url := config.TaskConfig.URL
 
+	// VMs kept outside the agent's tart home, e.g. in the home of the user a
+	// task runs as, are registered so every later command finds them.
+	if config.TartHome != "" {
+		c.homes.set(vmName, config.TartHome)
+		env = append(env, "TART_HOME="+config.TartHome)
+	} else {
+		c.homes.remove(vmName)
+	}
+
 	c.logger.Trace("Setting up Tart VM", "name", vmName, "url", url)
-	cmd := execCommandContext(ctx, "tart", "clone", url, vmName)
-	cmd.Env = env
 
-	// Configure VM resources before starting it using the Nomad resources block
-	var cpuCores int = 4    // Default to 4 cores
-	var memoryMB int = 4096 // Default to 4GB of memory
-	if config.NomadConfig.Resources != nil && config.NomadConfig.Resources.LinuxResources != nil {
-		// TODO: See if there's a better way of getting the number of cores
-		cpuCores = len(strings.Split(config.NomadConfig.Resources.LinuxResources.CpusetCpus, ","))
-		memoryMB = int(config.NomadConfig.Resources.LinuxResources.MemoryLimitBytes / 1024 / 1024)
+	// Configure VM resources before starting it using the Nomad resources
+	// block. StartTask has already checked them with validateTaskResources.
+	_, cpuCores, memoryMB := allocatedResources(config.NomadConfig)
+
+	// macOS offers no way to pin threads to cores, so an affinity list only
+	// sizes the VM to one vCPU per listed core.
+	if affinity := config.TaskConfig.CPUAffinity; len(affinity) > 0 {
+		cpuCores = len(affinity)
+	}
+
+	memoryMB, err := vmMemoryMB(memoryMB, config.TaskConfig.MemoryMin, config.TaskConfig.MemoryMax)
+	if err != nil {
+		return SetupResult{}, err
+	}
+	if config.TaskConfig.MemoryMin > 0 {
+		// Virtualization.framework always gets a balloon device from tart,
+		// but tart has no way to set its target, so the floor is advisory.
+		c.memoryMinWarning.Do(func() {
+			c.logger.Warn("tart cannot set a memory balloon target; memory_min is not enforced", "name", vmName, "memory_min", config.TaskConfig.MemoryMin)
+		})
 	}
 
 	diskGB := config.TaskConfig.DiskSize
+	if config.TaskConfig.DiskIOPSLimit > 0 {
+		// tart has no disk throttling flag, and the guest's disk I/O is done
+		// by a Virtualization.framework service rather than tart, so host
+		// I/O policies applied to tart would not reach it either.
+		c.logger.Warn("tart cannot throttle VM disk I/O; disk_iops_limit is not enforced", "name", vmName, "disk_iops_limit", config.TaskConfig.DiskIOPSLimit)
+	}
+
+	start := c.now()
+	err = c.create(ctx, config, vmName, env)
+	if errors.Is(err, errVMExists) {
+		err = c.handleExistingVM(ctx, config, vmName, env)
+	}
+	if err != nil {
+		return SetupResult{}, err
+	}
+
+	pullDuration := c.now().Sub(start)
+	c.logger.Debug("Cloned Tart VM", "name", vmName, "duration", pullDuration)
+
+	if expected := config.TaskConfig.ImageDigest; expected != "" {
+		if err := c.verifyImageDigest(ctx, config, vmName, expected); err != nil {
+			return SetupResult{}, err
+		}
+	}
+
+	if expected := config.TaskConfig.Platform; expected != "" {
+		if err := c.verifyPlatform(ctx, vmName, expected); err != nil {
+			return SetupResult{}, err
+		}
+	}
+
+	if err := c.pullExtraDisks(ctx, config, env); err != nil {
+		return SetupResult{}, err
+	}
+
+	if config.TaskConfig.SkipResourceConfig {
+		c.logger.Debug("Leaving VM resources as configured by the image", "name", vmName)
+	} else if err := c.SetVMResources(ctx, vmName, cpuCores, memoryMB, diskGB, config.TaskConfig.ExtraSetArgs...); err != nil {
+		return SetupResult{}, fmt.Errorf("failed to set VM resources: %v", err)
+	}
+
+	if config.RunAs != nil {
+		if err := chownVM(config.TartHome, vmName, config.RunAs); err != nil {
+			return SetupResult{}, fmt.Errorf("failed to hand VM %s to user %s: %v", vmName, config.RunAs.Username, err)
+		}
+	}
+
+	if needsCloudInitSeed(config.TaskConfig.Network) {
+		td := config.NomadConfig.TaskDir()
+		if _, err := writeCloudInitSeed(ctx, td.LocalDir, vmName, config.TaskConfig.Network); err != nil {
+			return SetupResult{}, err
+		}
+	}
+
+	return SetupResult{VMName: vmName, PullDuration: pullDuration}, nil
+}
+
+// create makes vmName from the task's image, importing it from image_file
+// when set and cloning it from the url otherwise.
+func (c *TartClient) create(ctx context.Context, config VMConfig, vmName string, env []string) error {
+	if config.TaskConfig.ImageFile != "" {
+		return c.importImage(ctx, config, vmName, env)
+	}
+	if source, ok := localVMSource(config.TaskConfig.URL); ok {
+		return c.cloneLocalVM(ctx, config, source, vmName)
+	}
+	// tart clone only pulls images missing from its cache, so a stale one
+	// is pulled again first.
+	if c.cachedImageStale(config) {
+		if err := c.refreshImage(ctx, config.TaskConfig.URL, vmName, config.PullConcurrency, env); err != nil {
+			return err
+		}
+	}
+	return c.clone(ctx, config.TaskConfig.URL, vmName, config.PullConcurrency, env)
+}
+
+// importImage creates vmName from the exported image archive at image_file
+// with tart import, for hosts that cannot reach a registry. gzip or zstd
+// compressed archives are decompressed into the task's local directory first.
+func (c *TartClient) importImage(ctx context.Context, config VMConfig, vmName string, env []string) error {
+	path := config.TaskConfig.ImageFile
+
+	scratchDir := ""
+	if config.NomadConfig != nil {
+		scratchDir = config.NomadConfig.TaskDir().LocalDir
+	}
+	archive, cleanup, err := decompressImageArchive(ctx, path, scratchDir)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	cmd := c.vmCommand(ctx, vmName, "import", archive, vmName)
+	cmd.Env = env
 
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("failed to create VM %s from URL %s: %v (stderr: %s)",
+	err = cmd.Run()
+	c.audit("import", vmName, []string{path}, err)
+	if err != nil {
+		if errors.Is(classifyTartError(stderr.String()), errVMExists) {
+			return fmt.Errorf("failed to create VM %s: %w", vmName, errVMExists)
+		}
+		return fmt.Errorf("failed to import VM %s from %s: %v (stderr: %s)",
+			vmName, path, err, stderr.String())
+	}
+
+	if err := c.writeVMSource(vmName, imageSourceRef(config.TaskConfig)); err != nil {
+		c.logger.Warn("failed to record VM source image", "name", vmName, "error", err)
+	}
+	return nil
+}
+
+// registryEnvPattern matches the variables tart reads registry credentials
+// from.
+const registryEnvPattern = "TART_REGISTRY_*"
+
+// registryAuthEnv returns the variables tart reads registry credentials from,
+// scoped to host.
+func registryAuthEnv(host string, auth Auth) []string {
+	return []string{
+		"TART_REGISTRY_HOSTNAME=" + host,
+		"TART_REGISTRY_USERNAME=" + auth.Username,
+		"TART_REGISTRY_PASSWORD=" + auth.Password,
+	}
+}
+
+// cloneArgs returns the tart clone arguments creating vmName from url.
+func cloneArgs(url, vmName string, concurrency int) []string {
+	args := []string{"clone"}
+	if concurrency > 0 {
+		args = append(args, "--concurrency", strconv.Itoa(concurrency))
+	}
+	return append(args, url, vmName)
+}
+
+// pullArgs returns the tart pull arguments for image, downloading up to
+// concurrency layers at once when positive.
+func pullArgs(image string, concurrency int) []string {
+	args := []string{"pull"}
+	if concurrency > 0 {
+		args = append(args, "--concurrency", strconv.Itoa(concurrency))
+	}
+	return append(args, image)
+}
+
+// proxyEnv returns the proxy variables set by the task, in both the upper and
+// lower case spellings tools look for.
+func proxyEnv(taskConfig TaskConfig) []string {
+	var env []string
+	for _, v := range []struct{ name, value string }{
+		{"HTTP_PROXY", taskConfig.HTTPProxy},
+		{"HTTPS_PROXY", taskConfig.HTTPSProxy},
+		{"NO_PROXY", taskConfig.NoProxy},
+	} {
+		if v.value == "" {
+			continue
+		}
+		env = append(env, v.name+"="+v.value, strings.ToLower(v.name)+"="+v.value)
+	}
+	return env
+}
+
+// clone creates vmName from the image at url, recording the image so a later
+// Setup for the same name can tell whether the VM may be reused. It returns an
+// error wrapping errVMExists when a VM with that name is already present.
+// A positive concurrency sets how many layers tart downloads in parallel.
+func (c *TartClient) clone(ctx context.Context, url, vmName string, concurrency int, env []string) error {
+	// tart pulls the image into its cache if needed and then clones it with
+	// APFS copy-on-write, so the VM shares the cached image's blocks and only
+	// diverges on write.
+	cmd := c.vmCommand(ctx, vmName, cloneArgs(url, vmName, concurrency)...)
+	cmd.Env = env
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	c.audit("clone", vmName, []string{url}, err)
+	if err != nil {
+		if errors.Is(classifyTartError(stderr.String()), errVMExists) {
+			return fmt.Errorf("failed to create VM %s: %w", vmName, errVMExists)
+		}
+		return fmt.Errorf("failed to create VM %s from URL %s: %v (stderr: %s)",
 			vmName, url, err, stderr.String())
 	}
 
-	if err := c.SetVMResources(ctx, vmName, cpuCores, memoryMB, diskGB); err != nil {
-		return "", fmt.Errorf("failed to set VM resources: %v", err)
+	if err := c.writeVMSource(vmName, url); err != nil {
+		c.logger.Warn("failed to record VM source image", "name", vmName, "error", err)
+	}
+	return nil
+}
+
+// handleExistingVM deals with a VM left behind under the target name, e.g. by
+// a crash before it could be deleted. A VM cloned from the requested image is
+// reused as is; otherwise it is deleted and re-cloned when the configuration
+// allows replacing existing VMs.
+func (c *TartClient) handleExistingVM(ctx context.Context, config VMConfig, vmName string, env []string) error {
+	ref := imageSourceRef(config.TaskConfig)
+	if source, err := c.readVMSource(vmName); err == nil && source == normalizeImageRef(ref) {
+		c.logger.Info("Reusing existing Tart VM cloned from the requested image", "name", vmName)
+		return nil
+	}
+
+	if !config.ReplaceExistingVM {
+		return fmt.Errorf("VM %s already exists and was not cloned from %s; enable replace_existing_vms to delete and re-clone it", vmName, ref)
 	}
 
-	return vmName, nil
+	c.logger.Info("Replacing existing Tart VM", "name", vmName)
+	if err := c.Delete(ctx, vmName); err != nil {
+		return err
+	}
+	return c.create(ctx, config, vmName, env)
 }
 
 // RunVM starts a Tart VM with the given name
@@ -133,7 +595,7 @@ func (c *TartClient) Start(ctx context.Context, vmName string, headless bool) (i
 	}
 
 	c.logger.Trace("Starting Tart VM", "name", vmName, "headless", headless)
-	cmd := execCommandContext(ctx, "tart", args...)
+	cmd := c.vmCommand(ctx, vmName, args...)
 
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
@@ -152,34 +614,102 @@ func (c *TartClient) Stop(ctx context.Context, vmName string, timeout time.Durat
 	defer cancel()
 
 	c.logger.Trace("Stopping Tart VM", "name", vmName)
-	cmd := exec.CommandContext(ctx, "tart", "stop", vmName)
+	cmd := c.vmCommand(ctx, vmName, "stop", vmName)
 
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to stop VM %s: %v (stderr: %s)", vmName, err, stderr.String())
+	err := cmd.Run()
+	c.audit("stop", vmName, nil, err)
+	if err != nil {
+		if tartErr := classifyTartError(stderr.String()); tartErr != nil {
+			return fmt.Errorf("failed to stop VM %s: %w", vmName, tartErr)
+		}
+		return fmt.Errorf("failed to stop VM %s: %v (stderr: %s)", vmName, commandErr(ctx, err), stderr.String())
+	}
+
+	return nil
+}
+
+// Pause freezes a running VM in place by stopping the host processes that back
+// it. tart has no native pause command, but stopping the Virtualization.framework
+// process halts the guest's vCPUs while keeping its memory intact.
+func (c *TartClient) Pause(ctx context.Context, vmName string) error {
+	c.logger.Trace("Pausing Tart VM", "name", vmName)
+	return c.signalVM(ctx, vmName, syscall.SIGSTOP)
+}
+
+// Resume continues a VM previously frozen with Pause.
+func (c *TartClient) Resume(ctx context.Context, vmName string) error {
+	c.logger.Trace("Resuming Tart VM", "name", vmName)
+	return c.signalVM(ctx, vmName, syscall.SIGCONT)
+}
+
+// signalVM sends sig to every host process backing the VM.
+func (c *TartClient) signalVM(ctx context.Context, vmName string, sig syscall.Signal) error {
+	pids := vmProcessPIDs(ctx, vmName)
+	if len(pids) == 0 {
+		return fmt.Errorf("no running processes found for VM %s", vmName)
 	}
 
+	for _, pid := range pids {
+		if err := signalProcess(pid, sig); err != nil {
+			return fmt.Errorf("failed to send %s to VM %s (pid %d): %v", sig, vmName, pid, err)
+		}
+	}
 	return nil
 }
 
-// ListVMs returns a list of all Tart VMs
+// ListVMs returns a list of all Tart VMs, including those kept in the tart
+// homes of users that tasks run as. A tart home other than the agent's that
+// cannot be listed is logged and skipped, so one broken home does not hide
+// every other VM.
 func (c *TartClient) List(ctx context.Context) ([]VMInfo, error) {
-	cmd := exec.CommandContext(ctx, "tart", "list", "--format", "json")
+	ctx, cancel := c.withCommandTimeout(ctx)
+	defer cancel()
+
+	vms, err := c.listIn(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, home := range c.homes.all() {
+		if home == tartHome() {
+			continue
+		}
+		userVMs, err := c.listIn(ctx, home)
+		if err != nil {
+			c.logger.Warn("failed to list VMs in tart home", "tart_home", home, "error", err)
+			continue
+		}
+		vms = append(vms, userVMs...)
+	}
+	return vms, nil
+}
+
+// listIn lists the VMs in the given tart home, or the agent user's own when
+// home is empty.
+func (c *TartClient) listIn(ctx context.Context, home string) ([]VMInfo, error) {
+	cmd := c.command(ctx, "list", "--format", "json")
+	if home != "" {
+		cmd.Env = append(os.Environ(), "TART_HOME="+home)
+	}
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
 	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("failed to list VMs: %v (stderr: %s)", err, stderr.String())
+		// tart may explain the failure in a JSON object on stdout.
+		if out := bytes.TrimSpace(stdout.Bytes()); len(out) > 0 && out[0] == '{' {
+			return nil, fmt.Errorf("failed to list VMs: %v (%v)", commandErr(ctx, err), tartErrorObject(out))
+		}
+		return nil, fmt.Errorf("failed to list VMs: %v (stderr: %s)", commandErr(ctx, err), stderr.String())
 	}
 
-	// Parse the JSON output from tart
-	var tartVMs []tartVMInfo
-	if err := json.Unmarshal(stdout.Bytes(), &tartVMs); err != nil {
-		return nil, fmt.Errorf("failed to parse VM list: %v", err)
+	tartVMs, err := parseVMList(stdout.Bytes())
+	if err != nil {
+		return nil, err
 	}
 
 	// Convert from tart-specific format to our interface format
@@ -188,12 +718,115 @@ func (c *TartClient) List(ctx context.Context) ([]VMInfo, error) {
 		vms[i] = VMInfo{
 			Name:   vm.Name,
 			Status: convertTartStatus(vm.State),
+			Raw:    vm.Raw,
 		}
 	}
 
 	return vms, nil
 }
 
+// tartListRequiredFields are the tart list JSON fields a VM's status is
+// derived from.
+var tartListRequiredFields = []string{"Name", "State"}
+
+// parseVMList parses tart list JSON output. An entry lacking a field the
+// driver depends on is an error, so that tart renaming a field cannot make
+// every VM silently look stopped.
+func parseVMList(data []byte) ([]tartVMInfo, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		return nil, tartErrorObject(trimmed)
+	}
+
+	var raws []json.RawMessage
+	if err := json.Unmarshal(data, &raws); err != nil {
+		return nil, fmt.Errorf("failed to parse VM list: %v", err)
+	}
+	vms := make([]tartVMInfo, len(raws))
+	for i, raw := range raws {
+		var entry map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return nil, fmt.Errorf("failed to parse VM list: %v", err)
+		}
+		for _, field := range tartListRequiredFields {
+			if _, ok := entry[field]; !ok {
+				return nil, fmt.Errorf("unexpected tart list schema: entry has no %q field (fields: %s)", field, strings.Join(slices.Sorted(maps.Keys(entry)), ", "))
+			}
+		}
+		if err := json.Unmarshal(raw, &vms[i]); err != nil {
+			return nil, fmt.Errorf("failed to parse VM list: %v", err)
+		}
+		vms[i].Raw = raw
+	}
+	return vms, nil
+}
+
+// tartErrorMessageFields are the fields of a JSON object tart may print in
+// place of a list that carry its error message.
+var tartErrorMessageFields = []string{"error", "Error", "message", "Message", "reason", "Reason"}
+
+// tartErrorObject describes the JSON object tart printed instead of a list,
+// which it does for some errors, using tart's own message when it has one.
+func tartErrorObject(data []byte) error {
+	var object map[string]any
+	if err := json.Unmarshal(data, &object); err != nil {
+		return fmt.Errorf("failed to parse VM list: %v", err)
+	}
+	for _, field := range tartErrorMessageFields {
+		if message, ok := object[field].(string); ok && message != "" {
+			return fmt.Errorf("tart list failed: %s", message)
+		}
+	}
+	return fmt.Errorf("tart list returned an object instead of a list: %s", data)
+}
+
+// ListImages returns the OCI images in tart's cache, leaving out VMs.
+func (c *TartClient) ListImages(ctx context.Context) ([]ImageInfo, error) {
+	ctx, cancel := c.withCommandTimeout(ctx)
+	defer cancel()
+
+	cmd := c.command(ctx, "list", "--source", tartImageSource, "--format", "json")
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to list images: %v (stderr: %s)", commandErr(ctx, err), stderr.String())
+	}
+
+	return parseImageList(stdout.Bytes())
+}
+
+// parseImageList converts tart list JSON output into image metadata, skipping
+// entries that are VMs rather than cached images.
+func parseImageList(data []byte) ([]ImageInfo, error) {
+	var entries []tartVMInfo
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse image list: %v", err)
+	}
+
+	images := []ImageInfo{}
+	for _, entry := range entries {
+		if !strings.EqualFold(entry.Source, tartImageSource) {
+			continue
+		}
+
+		name, _ := splitDigest(entry.Name)
+		source, _ := splitTag(name)
+		image := ImageInfo{
+			Name:         entry.Name,
+			Source:       source,
+			SizeGB:       entry.Size,
+			SizeOnDiskGB: entry.SizeOnDisk,
+		}
+		if accessed, err := time.Parse(time.RFC3339, entry.Accessed); err == nil {
+			image.LastAccessed = accessed
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
 // Status returns the status of a specific VM
 func (c *TartClient) Status(ctx context.Context, vmName string) (VMState, error) {
 	vms, err := c.List(ctx)
@@ -210,17 +843,26 @@ func (c *TartClient) Status(ctx context.Context, vmName string) (VMState, error)
 	return "", fmt.Errorf("VM %s not found", vmName)
 }
 
-// CloneVM clones a Tart VM
+// CloneVM clones a local Tart VM. The clone is made in the store targetVM is
+// registered in, which must also hold sourceVM.
 func (c *TartClient) CloneVM(ctx context.Context, sourceVM, targetVM string) error {
+	ctx, cancel := c.withCommandTimeout(ctx)
+	defer cancel()
+
 	c.logger.Trace("Cloning Tart VM", "source", sourceVM, "target", targetVM)
-	cmd := exec.CommandContext(ctx, "tart", "clone", sourceVM, targetVM)
+	cmd := c.vmCommand(ctx, targetVM, "clone", sourceVM, targetVM)
 
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
-	if err := cmd.Run(); err != nil {
+	err := cmd.Run()
+	c.audit("clone", targetVM, []string{localVMScheme + sourceVM}, err)
+	if err != nil {
+		if errors.Is(classifyTartError(stderr.String()), errVMExists) {
+			return fmt.Errorf("failed to create VM %s: %w", targetVM, errVMExists)
+		}
 		return fmt.Errorf("failed to clone VM %s to %s: %v (stderr: %s)",
-			sourceVM, targetVM, err, stderr.String())
+			sourceVM, targetVM, commandErr(ctx, err), stderr.String())
 	}
 
 	return nil
@@ -228,22 +870,88 @@ func (c *TartClient) CloneVM(ctx context.Context, sourceVM, targetVM string) err
 
 // DeleteVM deletes a Tart VM
 func (c *TartClient) Delete(ctx context.Context, vmName string) error {
+	ctx, cancel := c.withCommandTimeout(ctx)
+	defer cancel()
+
 	c.logger.Trace("Deleting Tart VM", "name", vmName)
-	cmd := exec.CommandContext(ctx, "tart", "delete", vmName)
+	cmd := c.vmCommand(ctx, vmName, "delete", vmName)
 
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to delete VM %s: %v (stderr: %s)", vmName, err, stderr.String())
+	err := cmd.Run()
+	c.audit("delete", vmName, nil, err)
+	if err != nil {
+		if errors.Is(classifyTartError(stderr.String()), errVMNotFound) {
+			c.forget(vmName)
+			return fmt.Errorf("failed to delete VM %s: %w", vmName, errVMNotFound)
+		}
+		return fmt.Errorf("failed to delete VM %s: %v (stderr: %s)", vmName, commandErr(ctx, err), stderr.String())
+	}
+
+	c.forget(vmName)
+	return nil
+}
+
+// forget drops what the client recorded about vmName once the VM is gone: its
+// tart home, which no longer needs listing, and its task.
+func (c *TartClient) forget(vmName string) {
+	c.homes.remove(vmName)
+	c.tasks.remove(vmName)
+}
+
+// Rename renames a stopped VM with tart rename, which moves the VM's
+// directory within its store. Unlike a clone and delete, the VM is never
+// present under both names or neither.
+func (c *TartClient) Rename(ctx context.Context, oldName, newName string) error {
+	ctx, cancel := c.withCommandTimeout(ctx)
+	defer cancel()
+
+	c.logger.Trace("Renaming Tart VM", "name", oldName, "new_name", newName)
+	cmd := c.vmCommand(ctx, oldName, "rename", oldName, newName)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	c.audit("rename", oldName, []string{newName}, err)
+	if err != nil {
+		return fmt.Errorf("failed to rename VM %s to %s: %v (stderr: %s)", oldName, newName, commandErr(ctx, err), stderr.String())
+	}
+
+	// The VM stays in the same store under its new name.
+	if home, ok := c.homes.get(oldName); ok {
+		c.homes.set(newName, home)
+		c.homes.remove(oldName)
+	}
+	return nil
+}
+
+// Pull downloads image into tart's cache without creating a VM. Registry
+// credentials come from the agent's environment.
+func (c *TartClient) Pull(ctx context.Context, image, tartHome string, concurrency int) error {
+	cmd := c.command(ctx, pullArgs(image, concurrency)...)
+	if tartHome != "" {
+		cmd.Env = append(os.Environ(), "TART_HOME="+tartHome)
 	}
 
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	c.audit("pull", "", []string{image}, err)
+	if err != nil {
+		return fmt.Errorf("failed to pull image %s: %v (stderr: %s)", redact(image), err, stderr.String())
+	}
 	return nil
 }
 
 // IPAddress returns the IP address of a running VM
 func (c *TartClient) IPAddress(ctx context.Context, vmName string) (string, error) {
-	cmd := exec.CommandContext(ctx, "tart", "ip", vmName)
+	ctx, cancel := c.withCommandTimeout(ctx)
+	defer cancel()
+
+	cmd := c.vmCommand(ctx, vmName, "ip", vmName)
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
@@ -251,43 +959,67 @@ func (c *TartClient) IPAddress(ctx context.Context, vmName string) (string, erro
 
 	if err := cmd.Run(); err != nil {
 		return "", fmt.Errorf("failed to get IP address for VM %s: %v (stderr: %s)",
-			vmName, err, stderr.String())
+			vmName, commandErr(ctx, err), stderr.String())
 	}
 
 	// Trim any whitespace or newlines
 	return strings.TrimSpace(stdout.String()), nil
 }
 
+// IPAddressWithTimeout polls IPAddress with exponential backoff until the VM
+// reports an address, the timeout elapses or the context is cancelled. A VM
+// that has only just started may not have acquired a lease yet.
+func (c *TartClient) IPAddressWithTimeout(ctx context.Context, vmName string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	interval := ipPollInitialInterval
+	for {
+		ip, err := c.IPAddress(ctx, vmName)
+		if err == nil && ip != "" {
+			return ip, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("VM %s has no IP address yet", vmName)
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("timed out waiting for IP address: %v", err)
+		case <-time.After(interval):
+		}
+
+		interval *= 2
+		if interval > ipPollMaxInterval {
+			interval = ipPollMaxInterval
+		}
+	}
+}
+
 // Exec executes an SSH command on the VM using native Go SSH client
 func (c *TartClient) Exec(ctx context.Context, config VMConfig, opts ExecOptions) (int, error) {
 	if len(opts.Command) == 0 {
 		return -1, fmt.Errorf("command is required but was empty")
 	}
 
-	vmName := c.generateVMName(config.NomadConfig.AllocID)
-
-	ip, err := c.IPAddress(ctx, vmName)
-	if err != nil || ip == "" {
-		return -1, fmt.Errorf("failed to get VM IP: %v", err)
-	}
-	// SSH client config with password authentication
-	sshConfig := &ssh.ClientConfig{
-		User: config.TaskConfig.SSHUser,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(config.TaskConfig.SSHPassword),
-		},
-		// TODO: Implement proper host key verification, we can probably just match the IP addresses.
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         30 * time.Second,
-	}
-
-	// Connect to SSH server
-	conn, err := ssh.Dial("tcp", net.JoinHostPort(ip, "22"), sshConfig)
+	conn, err := c.dialVM(ctx, c.generateVMName(config), config)
 	if err != nil {
-		return -1, fmt.Errorf("failed to dial: %v", err)
+		return -1, err
 	}
 	defer conn.Close()
 
+	// A session does not watch ctx, so close the connection when it is
+	// cancelled to unblock long-running commands such as the log stream.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
 	// Create a session
 	session, err := conn.NewSession()
 	if err != nil {
@@ -295,6 +1027,10 @@ func (c *TartClient) Exec(ctx context.Context, config VMConfig, opts ExecOptions
 	}
 	defer session.Close()
 
+	if opts.AgentForward {
+		c.forwardAgent(conn, session)
+	}
+
 	// Set up input/output
 	session.Stdin = opts.Stdin
 	session.Stdout = opts.Stdout
@@ -302,15 +1038,8 @@ func (c *TartClient) Exec(ctx context.Context, config VMConfig, opts ExecOptions
 
 	// Handle TTY if needed
 	if opts.Tty {
-		// Set up terminal modes
-		modes := ssh.TerminalModes{
-			ssh.ECHO:          0,     // disable echoing
-			ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
-			ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
-		}
-
 		// Request pseudo terminal
-		if err := session.RequestPty("xterm", 40, 80, modes); err != nil {
+		if err := session.RequestPty("xterm", 40, 80, ptyModes); err != nil {
 			return -1, fmt.Errorf("request for pseudo terminal failed: %v", err)
 		}
 
@@ -327,6 +1056,9 @@ func (c *TartClient) Exec(ctx context.Context, config VMConfig, opts ExecOptions
 	// Run the command
 	cmd := strings.Join(opts.Command, " ")
 	if err := session.Run(cmd); err != nil {
+		if ctx.Err() != nil {
+			return -1, ctx.Err()
+		}
 		if exitErr, ok := err.(*ssh.ExitError); ok {
 			return exitErr.ExitStatus(), nil
 		}
@@ -336,58 +1068,220 @@ func (c *TartClient) Exec(ctx context.Context, config VMConfig, opts ExecOptions
 	return 0, nil
 }
 
-// SetVMResources modifies CPU cores, memory (MB), and disk size (GB) for a VM.
-func (c *TartClient) SetVMResources(ctx context.Context, vmName string, cpu, memoryMB, diskGB int) error {
-	args := []string{"set", vmName}
-	if cpu > 0 {
-		args = append(args, "--cpu", fmt.Sprintf("%d", cpu))
+// DialGuest opens an SSH connection to the VM that runs each command in a new
+// session. The VM's address is looked up once, when dialing, rather than for
+// every command.
+func (c *TartClient) DialGuest(ctx context.Context, config VMConfig) (GuestConn, error) {
+	conn, err := c.dialVM(ctx, c.generateVMName(config), config)
+	if err != nil {
+		return nil, err
 	}
-	if memoryMB > 0 {
-		args = append(args, "--memory", fmt.Sprintf("%d", memoryMB))
+	return &sshGuestConn{conn: conn}, nil
+}
+
+// sshGuestConn is a GuestConn over an SSH connection.
+type sshGuestConn struct {
+	conn *ssh.Client
+}
+
+func (g *sshGuestConn) Run(ctx context.Context, command []string, stdout, stderr io.Writer) (int, error) {
+	// A session does not watch ctx, so close the connection when it is
+	// cancelled; the caller has to dial again afterwards.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			g.conn.Close()
+		case <-done:
+		}
+	}()
+
+	session, err := g.conn.NewSession()
+	if err != nil {
+		return -1, fmt.Errorf("failed to create session: %v", err)
 	}
-	if diskGB > 0 {
-		args = append(args, "--disk-size", fmt.Sprintf("%d", diskGB))
+	defer session.Close()
+
+	session.Stdout = stdout
+	session.Stderr = stderr
+	if err := session.Run(strings.Join(command, " ")); err != nil {
+		if ctx.Err() != nil {
+			return -1, ctx.Err()
+		}
+		if exitErr, ok := err.(*ssh.ExitError); ok {
+			return exitErr.ExitStatus(), nil
+		}
+		return -1, fmt.Errorf("failed to run command: %v", err)
+	}
+	return 0, nil
+}
+
+func (g *sshGuestConn) Close() error {
+	return g.conn.Close()
+}
+
+// forwardAgent makes the SSH agent at SSH_AUTH_SOCK available to the
+// session's command, so it can authenticate onwards with the host's keys. The
+// command still runs without it when no agent is reachable.
+func (c *TartClient) forwardAgent(conn *ssh.Client, session *ssh.Session) {
+	sock := os.Getenv(sshAuthSockEnv)
+	if sock == "" {
+		c.logger.Warn("SSH agent forwarding requested but " + sshAuthSockEnv + " is not set, continuing without it")
+		return
+	}
+	if err := agent.ForwardToRemote(conn, sock); err != nil {
+		c.logger.Warn("failed to forward SSH agent, continuing without it", "socket", sock, "error", err)
+		return
+	}
+	if err := agent.RequestAgentForwarding(session); err != nil {
+		c.logger.Warn("failed to forward SSH agent, continuing without it", "socket", sock, "error", err)
+	}
+}
+
+// dialVM opens an SSH connection to vmName. The address is looked up again
+// before every attempt, since a guest that rebooted mid-task may have come
+// back with a new DHCP lease.
+func (c *TartClient) dialVM(ctx context.Context, vmName string, config VMConfig) (*ssh.Client, error) {
+	var err error
+	for attempt := 0; attempt < sshDialAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, fmt.Errorf("failed to dial: %v", err)
+			case <-time.After(sshPollInterval):
+			}
+		}
+
+		var ip string
+		ip, err = c.IPAddressWithTimeout(ctx, vmName, ipWaitTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get VM IP: %v", err)
+		}
+
+		var conn *ssh.Client
+		conn, err = sshDial("tcp", net.JoinHostPort(ip, "22"), sshClientConfig(config))
+		if err == nil {
+			return conn, nil
+		}
+		c.logger.Debug("failed to dial VM, re-resolving its IP address", "name", vmName, "ip", ip, "error", err)
 	}
+	return nil, fmt.Errorf("failed to dial: %v", err)
+}
+
+// WaitForSSH blocks until the VM accepts SSH connections with the configured
+// credentials, polling until it succeeds or the context is cancelled.
+func (c *TartClient) WaitForSSH(ctx context.Context, config VMConfig) error {
+	vmName := c.generateVMName(config)
+
+	ticker := time.NewTicker(sshPollInterval)
+	defer ticker.Stop()
+
+	for {
+		ip, err := c.IPAddressWithTimeout(ctx, vmName, ipWaitTimeout)
+		if err == nil {
+			conn, err := sshDial("tcp", net.JoinHostPort(ip, "22"), sshClientConfig(config))
+			if err == nil {
+				conn.Close()
+				return nil
+			}
+			c.logger.Trace("VM not yet accepting SSH connections", "name", vmName, "error", err)
+		} else {
+			c.logger.Trace("VM has no IP address yet", "name", vmName, "error", err)
+		}
 
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// sshClientConfig returns the SSH client configuration used to connect to the
+// VM described by config using password authentication.
+func sshClientConfig(config VMConfig) *ssh.ClientConfig {
+	return &ssh.ClientConfig{
+		User: config.TaskConfig.SSHUser,
+		Auth: []ssh.AuthMethod{
+			ssh.Password(config.TaskConfig.SSHPassword),
+		},
+		// TODO: Implement proper host key verification, we can probably just match the IP addresses.
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         30 * time.Second,
+	}
+}
+
+// SetVMResources modifies CPU cores, memory (MB), and disk size (GB) for a VM,
+// passing any extra flags to the same tart set invocation.
+func (c *TartClient) SetVMResources(ctx context.Context, vmName string, cpu, memoryMB, diskGB int, extra ...string) error {
+	args := append(buildSetResourcesArgs(vmName, cpu, memoryMB, diskGB), extra...)
 	if len(args) == 2 {
 		return nil
 	}
 
+	ctx, cancel := c.withCommandTimeout(ctx)
+	defer cancel()
+
 	c.logger.Trace("Setting VM resources", "name", vmName, "args", args)
-	cmd := execCommandContext(ctx, "tart", args...)
+	cmd := c.vmCommand(ctx, vmName, args...)
 
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to set resources for VM %s: %v (stderr: %s)", vmName, err, stderr.String())
+	err := cmd.Run()
+	c.audit("set", vmName, args[2:], err)
+	if err != nil {
+		return fmt.Errorf("failed to set resources for VM %s: %v (stderr: %s)", vmName, commandErr(ctx, err), stderr.String())
 	}
 	return nil
 }
 
-func (c *TartClient) generateVMName(allocationID string) string {
-	return fmt.Sprintf("nomad-%s", allocationID)
+// generateVMName returns the name of the VM backing the configured allocation.
+func (c *TartClient) generateVMName(config VMConfig) string {
+	return vmNameFor(config.VMNamePrefix, config.NomadConfig.AllocID)
 }
 
 // BuildStartArgs computes the full set of CLI arguments required to start a
 // VM based on the provided configuration. This centralizes tart-specific flag
 // construction away from the driver.
 func (c *TartClient) BuildStartArgs(config VMConfig) ([]string, error) {
-	vmName := c.generateVMName(config.NomadConfig.AllocID)
+	vmName := c.generateVMName(config)
 
 	args := []string{"run", vmName}
 	if !config.TaskConfig.ShowUI {
 		args = append(args, "--no-graphics")
 	}
 
-	// Mount the Nomad task's secrets directory read-only if present
+	// Mount the Nomad task's secrets directory read-only if present, unless
+	// the task opted out with mount_secrets = false
 	if config.NomadConfig != nil {
 		td := config.NomadConfig.TaskDir()
-		if td != nil && td.SecretsDir != "" {
+		if config.TaskConfig.MountSecrets && td != nil && td.SecretsDir != "" {
 			// Ensure that the secrets directory is mounted with a name to ensure
 			// multiple directories can be mounted if needed.
 			args = append(args, fmt.Sprintf("--dir=secrets:%s:ro", td.SecretsDir))
 		}
+
+		// Share the task's local directory writable so the guest can
+		// report its exit code through the exit marker file.
+		if config.TaskConfig.ExitCodeMarker && td != nil && td.LocalDir != "" {
+			args = append(args, fmt.Sprintf("--dir=%s:%s", exitMarkerMountName, td.LocalDir))
+		}
+
+		// tart has no flags for DNS or gateway overrides, so they reach the
+		// guest through the cloud-init seed written during Setup.
+		if needsCloudInitSeed(config.TaskConfig.Network) && td != nil && td.LocalDir != "" {
+			args = append(args, fmt.Sprintf("--disk=%s:ro", filepath.Join(td.LocalDir, cloudInitSeedFile)))
+		}
+
+		if config.TaskConfig.ScratchDisk != nil && td != nil && td.Dir != "" {
+			args = append(args, "--disk="+scratchDiskPath(config.NomadConfig))
+		}
+	}
+
+	if config.ConsolePath != "" {
+		args = append(args, "--serial-path", config.ConsolePath)
 	}
 
 	netArgs, err := buildTartNetworkArgs(config.TaskConfig.Network)
@@ -408,26 +1302,119 @@ func (c *TartClient) BuildStartArgs(config VMConfig) ([]string, error) {
 	args = append(args, netArgs...)
 	args = append(args, rootDiskArgs...)
 	args = append(args, dirArgs...)
+	args = append(args, buildExtraDiskArgs(config)...)
 
 	return args, nil
 }
 
 // NeedsImageDownload returns true when the referenced image is not yet
-// available locally and must be pulled prior to setup.
+// available locally, or is stale under the task's pull_policy, and must be
+// pulled prior to setup.
 func (c *TartClient) NeedsImageDownload(ctx context.Context, config VMConfig) (bool, error) {
-	vms, err := c.List(ctx)
+	// Imported images come from a local file and local VMs are cloned as
+	// is, so neither is ever downloaded.
+	if _, local := localVMSource(config.TaskConfig.URL); local || config.TaskConfig.ImageFile != "" {
+		return false, nil
+	}
+
+	// Images are cached per tart home, so only the task's home counts.
+	listCtx, cancel := c.withCommandTimeout(ctx)
+	defer cancel()
+	vms, err := c.listIn(listCtx, config.TartHome)
 	if err != nil {
 		return false, err
 	}
+	// Tart stores locally downloaded images by their reference, listing
+	// digest-pinned pulls under "repo@sha256:...". Compare normalized forms so
+	// that implicit tags, URL schemes and tag-plus-digest references match.
+	want := normalizeImageRef(config.TaskConfig.URL)
 	for _, vm := range vms {
-		// Tart stores locally downloaded VMs by the URL of the image
-		if vm.Name == config.TaskConfig.URL {
-			return false, nil
+		if normalizeImageRef(vm.Name) == want {
+			return c.cachedImageStale(config), nil
 		}
 	}
 	return true, nil
 }
 
+// vmConfigFile is the file in a VM's directory where tart keeps the VM's
+// settings, including the MAC address it generated when the VM was created.
+const vmConfigFile = "config.json"
+
+// MACAddress returns the MAC address tart assigned to the VM, read from the
+// VM's configuration. The address is fixed when the VM is cloned.
+func (c *TartClient) MACAddress(ctx context.Context, vmName string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(c.vmPathFor(vmName), vmConfigFile))
+	if err != nil {
+		return "", fmt.Errorf("failed to read config of VM %s: %v", vmName, err)
+	}
+	mac, err := parseMACAddress(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to get MAC address of VM %s: %v", vmName, err)
+	}
+	return mac, nil
+}
+
+// parseMACAddress extracts the MAC address from a tart VM config.json,
+// returning it in lowercase colon-separated form.
+func parseMACAddress(data []byte) (string, error) {
+	var config struct {
+		MACAddress string `json:"macAddress"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return "", fmt.Errorf("failed to parse VM config: %v", err)
+	}
+	if config.MACAddress == "" {
+		return "", fmt.Errorf("VM config has no MAC address")
+	}
+
+	mac, err := net.ParseMAC(config.MACAddress)
+	if err != nil {
+		return "", fmt.Errorf("invalid MAC address %q: %v", config.MACAddress, err)
+	}
+	return mac.String(), nil
+}
+
+// VMResources returns the CPU count and memory recorded in the VM's
+// configuration along with the size of its disk, as set by tart set.
+func (c *TartClient) VMResources(ctx context.Context, vmName string) (VMResources, error) {
+	dir := c.vmPathFor(vmName)
+	data, err := os.ReadFile(filepath.Join(dir, vmConfigFile))
+	if err != nil {
+		return VMResources{}, fmt.Errorf("failed to read config of VM %s: %v", vmName, err)
+	}
+	res, err := parseVMResources(data)
+	if err != nil {
+		return VMResources{}, fmt.Errorf("failed to get resources of VM %s: %v", vmName, err)
+	}
+
+	disk, err := os.Stat(filepath.Join(dir, vmDiskImage))
+	if err != nil {
+		return VMResources{}, fmt.Errorf("failed to stat disk of VM %s: %v", vmName, err)
+	}
+	// tart sizes disks in decimal gigabytes.
+	res.DiskGB = int(disk.Size() / 1000 / 1000 / 1000)
+	return res, nil
+}
+
+// parseVMResources extracts the CPU count and memory, in MB, from a tart VM
+// config.json. tart records the memory in bytes.
+func parseVMResources(data []byte) (VMResources, error) {
+	var config struct {
+		CPUCount   int    `json:"cpuCount"`
+		MemorySize uint64 `json:"memorySize"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return VMResources{}, fmt.Errorf("failed to parse VM config: %v", err)
+	}
+	if config.CPUCount == 0 || config.MemorySize == 0 {
+		return VMResources{}, fmt.Errorf("VM config has no CPU count or memory size")
+	}
+	return VMResources{
+		CPU:      config.CPUCount,
+		MemoryMB: int(config.MemorySize / 1024 / 1024),
+	}, nil
+}
+
 // convertTartStatus converts tart status strings to our VMState type
 func convertTartStatus(tartStatus string) VMState {
 	switch strings.ToLower(tartStatus) {
@@ -440,24 +1427,75 @@ func convertTartStatus(tartStatus string) VMState {
 	}
 }
 
-// registryHost extracts the registry host from an image URL. It attempts to
-// parse the URL and, if no host is present, falls back to splitting the string
-// on the first '/'.
+// registryHost extracts the registry host, including any port, from an image
+// reference. References with a scheme are parsed as URLs; schemeless
+// references such as "localhost:5000/image:tag" can't be, since url.Parse
+// would read "localhost" as the scheme, so the host is everything before the
+// first '/'.
 func registryHost(image string) (string, error) {
-	u, err := url.Parse(image)
-	if err != nil {
-		return "", err
-	}
+	// Drop any digest so the "sha256:" colon can't be mistaken for a scheme
+	// or port separator.
+	image, _ = splitDigest(strings.TrimSpace(image))
 
-	if u.Host != "" {
+	if strings.Contains(image, "://") {
+		u, err := url.Parse(image)
+		if err != nil {
+			return "", err
+		}
+		if u.Host == "" {
+			return "", fmt.Errorf("invalid image reference: %s", image)
+		}
 		return u.Host, nil
 	}
 
-	// If the URL didn't have a host (e.g. missing scheme), derive it by
-	// taking everything before the first '/'.
-	parts := strings.SplitN(image, "/", 2)
-	if len(parts) == 0 || parts[0] == "" {
+	host, _, _ := strings.Cut(image, "/")
+	if host == "" {
 		return "", fmt.Errorf("invalid image reference: %s", image)
 	}
-	return parts[0], nil
+	return host, nil
+}
+
+// splitDigest separates an image reference into its name (including any tag)
+// and its "algorithm:hex" digest. The digest is empty for tag-only references.
+func splitDigest(image string) (string, string) {
+	// A digest never contains '/', so an '@' before the last path separator
+	// belongs to URL userinfo rather than a digest.
+	at := strings.LastIndex(image, "@")
+	if at < 0 || at < strings.LastIndex(image, "/") {
+		return image, ""
+	}
+	return image[:at], image[at+1:]
+}
+
+// normalizeImageRef returns a canonical form of an image reference for
+// comparison against the names tart lists: any URL scheme is removed, a
+// digest-pinned reference drops its tag (tart resolves those by digest) and a
+// reference with neither tag nor digest gets tart's implicit "latest" tag.
+func normalizeImageRef(image string) string {
+	ref := strings.TrimSpace(image)
+	if _, rest, found := strings.Cut(ref, "://"); found {
+		ref = rest
+	}
+
+	name, digest := splitDigest(ref)
+	repo, tag := splitTag(name)
+	if digest != "" {
+		return repo + "@" + strings.ToLower(digest)
+	}
+	if tag == "" {
+		tag = "latest"
+	}
+	return repo + ":" + tag
+}
+
+// splitTag separates a digest-free image reference into its repository and
+// tag. Only a colon in the final path component denotes a tag, so a registry
+// port such as "localhost:5000/image" is not mistaken for one.
+func splitTag(name string) (string, string) {
+	slash := strings.LastIndex(name, "/")
+	colon := strings.LastIndex(name, ":")
+	if colon <= slash {
+		return name, ""
+	}
+	return name[:colon], name[colon+1:]
 }
@@ -1,6 +1,28 @@
 package driver
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/hashicorp/nomad/helper/pluginutils/hclutils"
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
 
 func TestConvertTartStatus(t *testing.T) {
 	cases := map[string]VMState{
@@ -22,3 +44,1114 @@ func TestConvertTartStatus(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildStartArgs_ExitCodeMarkerMountsLocalDir(t *testing.T) {
+	c := NewTartClient(testLogger(t))
+	nomadCfg := &drivers.TaskConfig{AllocID: "alloc-1", AllocDir: "/alloc", Name: "task"}
+	localDir := nomadCfg.TaskDir().LocalDir
+	want := "--dir=" + exitMarkerMountName + ":" + localDir
+
+	args, err := c.BuildStartArgs(VMConfig{TaskConfig: TaskConfig{}, NomadConfig: nomadCfg})
+	if err != nil {
+		t.Fatalf("BuildStartArgs returned error: %v", err)
+	}
+	if slices.Contains(args, want) {
+		t.Fatalf("did not expect the local dir to be mounted without exit_code_marker: %v", args)
+	}
+
+	args, err = c.BuildStartArgs(VMConfig{TaskConfig: TaskConfig{ExitCodeMarker: true}, NomadConfig: nomadCfg})
+	if err != nil {
+		t.Fatalf("BuildStartArgs returned error: %v", err)
+	}
+	if !slices.Contains(args, want) {
+		t.Fatalf("expected %q in args: %v", want, args)
+	}
+}
+
+func TestBuildStartArgs_MountSecretsToggle(t *testing.T) {
+	c := NewTartClient(testLogger(t))
+	nomadCfg := &drivers.TaskConfig{AllocID: "alloc-1", AllocDir: "/alloc", Name: "task"}
+	want := "--dir=secrets:" + nomadCfg.TaskDir().SecretsDir + ":ro"
+
+	args, err := c.BuildStartArgs(VMConfig{TaskConfig: TaskConfig{MountSecrets: true}, NomadConfig: nomadCfg})
+	if err != nil {
+		t.Fatalf("BuildStartArgs returned error: %v", err)
+	}
+	if !slices.Contains(args, want) {
+		t.Fatalf("expected %q in args: %v", want, args)
+	}
+
+	args, err = c.BuildStartArgs(VMConfig{TaskConfig: TaskConfig{MountSecrets: false}, NomadConfig: nomadCfg})
+	if err != nil {
+		t.Fatalf("BuildStartArgs returned error: %v", err)
+	}
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--dir=secrets:") {
+			t.Fatalf("did not expect the secrets dir to be mounted: %v", args)
+		}
+	}
+}
+
+func TestTaskConfigSpec_MountSecretsDefaultsToTrue(t *testing.T) {
+	var taskConfig TaskConfig
+	hclutils.NewConfigParser(taskConfigSpec).ParseHCL(t, `
+config {
+  url          = "ghcr.io/org/img:latest"
+  ssh_user     = "admin"
+  ssh_password = "admin"
+}`, &taskConfig)
+	if !taskConfig.MountSecrets {
+		t.Fatalf("expected mount_secrets to default to true")
+	}
+}
+
+func TestSetup_DoesNotLogRegistryPassword(t *testing.T) {
+	t.Setenv("GO_WANT_HELPER_PROCESS", "1")
+
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		ha := append([]string{"-test.run=TestHelperProcess", "--", name}, args...)
+		return exec.CommandContext(ctx, os.Args[0], ha...)
+	}
+	defer func() { execCommandContext = orig }()
+
+	var buf bytes.Buffer
+	logger := hclog.New(&hclog.LoggerOptions{Output: &buf, Level: hclog.Trace})
+
+	vmc := VMConfig{
+		TaskConfig: TaskConfig{
+			URL:  "ghcr.io/example/private:latest",
+			Auth: Auth{Username: "user1", Password: "s3cret-pass"},
+		},
+		NomadConfig: &drivers.TaskConfig{AllocID: "alloc-123"},
+	}
+
+	c := NewTartClient(logger)
+	if _, err := c.Setup(context.Background(), vmc); err != nil {
+		t.Fatalf("Setup returned error: %v", err)
+	}
+
+	if !strings.Contains(buf.String(), "argv=\"tart clone ghcr.io/example/private:latest") {
+		t.Fatalf("expected a debug entry for tart clone, got:\n%s", buf.String())
+	}
+	if strings.Contains(buf.String(), "s3cret-pass") {
+		t.Fatalf("logs leaked the registry password:\n%s", buf.String())
+	}
+}
+
+func TestSetup_ConcurrentSetupsKeepCredentialsApart(t *testing.T) {
+	t.Setenv("TART_HOME", t.TempDir())
+
+	var lock sync.Mutex
+	clones := map[string]*exec.Cmd{}
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, "true")
+		if args[0] == "login" {
+			t.Errorf("did not expect a host-wide tart login")
+		}
+		if args[0] == "clone" {
+			lock.Lock()
+			clones[args[2]] = cmd
+			lock.Unlock()
+		}
+		return cmd
+	}
+	defer func() { execCommandContext = orig }()
+
+	c := NewTartClient(testLogger(t))
+	creds := map[string]Auth{
+		"alloc-a": {Username: "team-a", Password: "pass-a"},
+		"alloc-b": {Username: "team-b", Password: "pass-b"},
+	}
+
+	var wg sync.WaitGroup
+	for allocID, auth := range creds {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			vmc := VMConfig{
+				TaskConfig:  TaskConfig{URL: "ghcr.io/org/private:latest", Auth: auth},
+				NomadConfig: &drivers.TaskConfig{AllocID: allocID},
+			}
+			if _, err := c.Setup(context.Background(), vmc); err != nil {
+				t.Errorf("Setup for %s returned error: %v", allocID, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for allocID, auth := range creds {
+		cmd, ok := clones["nomad-"+allocID]
+		if !ok {
+			t.Fatalf("no clone for %s", allocID)
+		}
+		if !slices.Contains(cmd.Env, "TART_REGISTRY_USERNAME="+auth.Username) || !slices.Contains(cmd.Env, "TART_REGISTRY_PASSWORD="+auth.Password) {
+			t.Fatalf("clone for %s did not get its own credentials", allocID)
+		}
+		for other, otherAuth := range creds {
+			if other != allocID && slices.Contains(cmd.Env, "TART_REGISTRY_PASSWORD="+otherAuth.Password) {
+				t.Fatalf("clone for %s got the credentials of %s", allocID, other)
+			}
+		}
+	}
+}
+
+func TestNeedsImageDownload_MatchesDigestPinnedImages(t *testing.T) {
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "echo", `[{"Name":"ghcr.io/org/img@sha256:abcdef","State":"stopped","Source":"OCI"}]`)
+	}
+	defer func() { execCommandContext = orig }()
+
+	c := NewTartClient(testLogger(t))
+	cases := map[string]bool{
+		"ghcr.io/org/img@sha256:abcdef":     false,
+		"ghcr.io/org/img:1.0@sha256:abcdef": false,
+		"ghcr.io/org/img@sha256:012345":     true,
+		"ghcr.io/org/img:latest":            true,
+	}
+	for url, want := range cases {
+		got, err := c.NeedsImageDownload(context.Background(), VMConfig{TaskConfig: TaskConfig{URL: url}})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", url, err)
+		}
+		if got != want {
+			t.Fatalf("%s: expected needs download %v, got %v", url, want, got)
+		}
+	}
+}
+
+func TestIPAddressWithTimeout_PollsUntilLeaseAcquired(t *testing.T) {
+	var calls int
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		calls++
+		if calls < 3 {
+			// A VM without a lease yet prints nothing.
+			return exec.CommandContext(ctx, "echo", "")
+		}
+		return exec.CommandContext(ctx, "echo", "192.168.64.5")
+	}
+	defer func() { execCommandContext = orig }()
+
+	c := NewTartClient(testLogger(t))
+	ip, err := c.IPAddressWithTimeout(context.Background(), "nomad-alloc", 10*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ip != "192.168.64.5" {
+		t.Fatalf("expected 192.168.64.5, got %q", ip)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 polls, got %d", calls)
+	}
+}
+
+func TestIPAddressWithTimeout_TimesOut(t *testing.T) {
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "echo", "")
+	}
+	defer func() { execCommandContext = orig }()
+
+	c := NewTartClient(testLogger(t))
+	if _, err := c.IPAddressWithTimeout(context.Background(), "nomad-alloc", 100*time.Millisecond); err == nil {
+		t.Fatalf("expected timeout error")
+	}
+}
+
+func TestExec_ReresolvesIPAfterDialFailure(t *testing.T) {
+	// Each tart ip call returns a new address, as after a guest reboot.
+	var steps []string
+	origExec := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		steps = append(steps, "ip")
+		return exec.CommandContext(ctx, "echo", fmt.Sprintf("192.168.64.%d", len(steps)))
+	}
+	defer func() { execCommandContext = origExec }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	origDial := sshDial
+	sshDial = func(network, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+		steps = append(steps, "dial "+addr)
+		if len(steps) == 4 {
+			cancel()
+		}
+		return nil, errors.New("connection refused")
+	}
+	defer func() { sshDial = origDial }()
+
+	c := NewTartClient(testLogger(t))
+	_, err := c.Exec(ctx, VMConfig{NomadConfig: &drivers.TaskConfig{AllocID: "alloc"}}, ExecOptions{Command: []string{"uptime"}})
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("expected the dial error, got: %v", err)
+	}
+
+	want := []string{"ip", "dial 192.168.64.1:22", "ip", "dial 192.168.64.3:22"}
+	if !slices.Equal(steps, want) {
+		t.Fatalf("expected %v, got %v", want, steps)
+	}
+}
+
+// startFakeSSHServer serves SSH on a local port, accepting any password and
+// exiting every command with status 0. It returns the server's address and a
+// channel receiving every session request.
+func startFakeSSHServer(t *testing.T) (string, <-chan *ssh.Request) {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) { return nil, nil },
+	}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	requests := make(chan *ssh.Request, 16)
+	go func() {
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(nc, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for newCh := range chans {
+					ch, chReqs, err := newCh.Accept()
+					if err != nil {
+						continue
+					}
+					for req := range chReqs {
+						requests <- req
+						req.Reply(true, nil)
+						if req.Type == "exec" {
+							ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+							ch.Close()
+						}
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String(), requests
+}
+
+func TestExec_ForwardsSSHAgent(t *testing.T) {
+	// ForwardToRemote checks that the agent is listening before forwarding.
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	agentListener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer agentListener.Close()
+
+	addr, requests := startFakeSSHServer(t)
+	origExec := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "echo", "127.0.0.1")
+	}
+	defer func() { execCommandContext = origExec }()
+	origDial := sshDial
+	sshDial = func(network, _ string, config *ssh.ClientConfig) (*ssh.Client, error) {
+		return ssh.Dial(network, addr, config)
+	}
+	defer func() { sshDial = origDial }()
+
+	c := NewTartClient(testLogger(t))
+	vmc := VMConfig{
+		TaskConfig:  TaskConfig{SSHUser: "admin", SSHPassword: "admin"},
+		NomadConfig: &drivers.TaskConfig{AllocID: "alloc"},
+	}
+	for _, tc := range []struct {
+		name    string
+		forward bool
+		sock    string
+		want    bool
+	}{
+		{"enabled", true, sock, true},
+		{"disabled", false, sock, false},
+		{"no agent", true, "", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(sshAuthSockEnv, tc.sock)
+			code, err := c.Exec(context.Background(), vmc, ExecOptions{Command: []string{"git", "fetch"}, AgentForward: tc.forward})
+			if err != nil || code != 0 {
+				t.Fatalf("Exec returned %d, %v", code, err)
+			}
+
+			var got []string
+			for req := range requests {
+				got = append(got, req.Type)
+				if req.Type == "exec" {
+					break
+				}
+			}
+			if forwarded := slices.Contains(got, "auth-agent-req@openssh.com"); forwarded != tc.want {
+				t.Fatalf("expected agent forwarding requested to be %v, got requests %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestExec_RequestsInteractiveTerminalModes(t *testing.T) {
+	addr, requests := startFakeSSHServer(t)
+	origExec := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "echo", "127.0.0.1")
+	}
+	defer func() { execCommandContext = origExec }()
+	origDial := sshDial
+	sshDial = func(network, _ string, config *ssh.ClientConfig) (*ssh.Client, error) {
+		return ssh.Dial(network, addr, config)
+	}
+	defer func() { sshDial = origDial }()
+
+	c := NewTartClient(testLogger(t))
+	vmc := VMConfig{
+		TaskConfig:  TaskConfig{SSHUser: "admin", SSHPassword: "admin"},
+		NomadConfig: &drivers.TaskConfig{AllocID: "alloc"},
+	}
+	ptyRequest := func(tty bool) *ssh.Request {
+		t.Helper()
+		if _, err := c.Exec(context.Background(), vmc, ExecOptions{Command: []string{"bash"}, Tty: tty}); err != nil {
+			t.Fatalf("Exec returned error: %v", err)
+		}
+		var pty *ssh.Request
+		for req := range requests {
+			if req.Type == "pty-req" {
+				pty = req
+			}
+			if req.Type == "exec" {
+				return pty
+			}
+		}
+		return nil
+	}
+
+	if req := ptyRequest(false); req != nil {
+		t.Fatalf("expected no terminal without Tty")
+	}
+
+	req := ptyRequest(true)
+	if req == nil {
+		t.Fatalf("expected a terminal to be requested")
+	}
+	var pty struct {
+		Term          string
+		Columns, Rows uint32
+		Width, Height uint32
+		Modes         string
+	}
+	if err := ssh.Unmarshal(req.Payload, &pty); err != nil {
+		t.Fatalf("failed to decode pty-req: %v", err)
+	}
+	// Modes are encoded as an opcode byte and a uint32 value, ending with 0.
+	modes := map[uint8]uint32{}
+	for b := []byte(pty.Modes); len(b) >= 5 && b[0] != 0; b = b[5:] {
+		modes[b[0]] = uint32(b[1])<<24 | uint32(b[2])<<16 | uint32(b[3])<<8 | uint32(b[4])
+	}
+	for op, want := range map[uint8]uint32{ssh.ECHO: 1, ssh.ICANON: 1, ssh.ISIG: 1, ssh.TTY_OP_ISPEED: 38400, ssh.TTY_OP_OSPEED: 38400} {
+		if modes[op] != want {
+			t.Fatalf("expected mode %d to be %d, got modes %v", op, want, modes)
+		}
+	}
+}
+
+func TestSetup_ReportsPullDuration(t *testing.T) {
+	t.Setenv("GO_WANT_HELPER_PROCESS", "1")
+
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		ha := append([]string{"-test.run=TestHelperProcess", "--", name}, args...)
+		return exec.CommandContext(ctx, os.Args[0], ha...)
+	}
+	defer func() { execCommandContext = orig }()
+
+	// The clock advances 1.5s per reading, so the clone appears to take
+	// exactly that long.
+	c := NewTartClient(testLogger(t))
+	now := time.Unix(1000, 0)
+	c.now = func() time.Time {
+		now = now.Add(1500 * time.Millisecond)
+		return now
+	}
+
+	vmc := VMConfig{
+		TaskConfig:  TaskConfig{URL: "ghcr.io/org/img:latest"},
+		NomadConfig: &drivers.TaskConfig{AllocID: "alloc-123"},
+	}
+	result, err := c.Setup(context.Background(), vmc)
+	if err != nil {
+		t.Fatalf("Setup returned error: %v", err)
+	}
+	if result.VMName != "nomad-alloc-123" {
+		t.Fatalf("unexpected VM name: %s", result.VMName)
+	}
+	if result.PullDuration != 1500*time.Millisecond {
+		t.Fatalf("expected 1.5s pull duration, got %s", result.PullDuration)
+	}
+
+	d := newTestDriver(t, &fakeClient{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := d.TaskEvents(ctx)
+	if err != nil {
+		t.Fatalf("TaskEvents returned error: %v", err)
+	}
+
+	d.emitDownloadComplete(&drivers.TaskConfig{ID: "task-1", AllocID: "alloc-123"}, vmc.TaskConfig.URL, result.PullDuration)
+
+	select {
+	case ev := <-events:
+		if got := ev.Annotations["pull_duration_ms"]; got != "1500" {
+			t.Fatalf("expected pull_duration_ms=1500, got %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for download event")
+	}
+}
+
+// fakeTartWithExistingVM stubs tart so that cloning fails with tart's "already
+// exists" error until the VM is deleted, recording every invocation.
+func fakeTartWithExistingVM(t *testing.T) *[]string {
+	t.Helper()
+
+	var calls []string
+	exists := true
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		calls = append(calls, strings.Join(args, " "))
+		switch {
+		case len(args) > 0 && args[0] == "clone" && exists:
+			return exec.CommandContext(ctx, "sh", "-c", `echo 'Error: VM "nomad-alloc-1" already exists' >&2; exit 1`)
+		case len(args) > 0 && args[0] == "delete":
+			exists = false
+		}
+		return exec.CommandContext(ctx, "true")
+	}
+	t.Cleanup(func() { execCommandContext = orig })
+	return &calls
+}
+
+func TestSetup_ReusesExistingVMFromSameImage(t *testing.T) {
+	t.Setenv("TART_HOME", t.TempDir())
+	calls := fakeTartWithExistingVM(t)
+
+	if err := os.MkdirAll(vmPath("", "nomad-alloc-1"), 0o755); err != nil {
+		t.Fatalf("failed to create VM dir: %v", err)
+	}
+	if err := NewTartClient(testLogger(t)).writeVMSource("nomad-alloc-1", "ghcr.io/org/img"); err != nil {
+		t.Fatalf("failed to write VM source: %v", err)
+	}
+
+	c := NewTartClient(testLogger(t))
+	vmc := VMConfig{
+		TaskConfig:  TaskConfig{URL: "ghcr.io/org/img:latest"},
+		NomadConfig: &drivers.TaskConfig{AllocID: "alloc-1"},
+	}
+	if _, err := c.Setup(context.Background(), vmc); err != nil {
+		t.Fatalf("Setup returned error: %v", err)
+	}
+
+	for _, call := range *calls {
+		if strings.HasPrefix(call, "delete") {
+			t.Fatalf("did not expect the existing VM to be deleted: %v", *calls)
+		}
+	}
+}
+
+func TestSetup_ReplacesExistingVMFromOtherImage(t *testing.T) {
+	t.Setenv("TART_HOME", t.TempDir())
+	calls := fakeTartWithExistingVM(t)
+
+	if err := os.MkdirAll(vmPath("", "nomad-alloc-1"), 0o755); err != nil {
+		t.Fatalf("failed to create VM dir: %v", err)
+	}
+	if err := NewTartClient(testLogger(t)).writeVMSource("nomad-alloc-1", "ghcr.io/org/other:1.0"); err != nil {
+		t.Fatalf("failed to write VM source: %v", err)
+	}
+
+	c := NewTartClient(testLogger(t))
+	vmc := VMConfig{
+		TaskConfig:  TaskConfig{URL: "ghcr.io/org/img:latest"},
+		NomadConfig: &drivers.TaskConfig{AllocID: "alloc-1"},
+	}
+
+	// Without replace_existing_vms the mismatched VM is left alone.
+	if _, err := c.Setup(context.Background(), vmc); err == nil || !strings.Contains(err.Error(), "replace_existing_vms") {
+		t.Fatalf("expected an error suggesting replace_existing_vms, got: %v", err)
+	}
+
+	*calls = nil
+	vmc.ReplaceExistingVM = true
+	if _, err := c.Setup(context.Background(), vmc); err != nil {
+		t.Fatalf("Setup returned error: %v", err)
+	}
+
+	want := []string{
+		"clone ghcr.io/org/img:latest nomad-alloc-1",
+		"delete nomad-alloc-1",
+		"clone ghcr.io/org/img:latest nomad-alloc-1",
+	}
+	if len(*calls) < len(want) || !slices.Equal((*calls)[:len(want)], want) {
+		t.Fatalf("unexpected tart invocations: %v", *calls)
+	}
+
+	source, err := c.readVMSource("nomad-alloc-1")
+	if err != nil {
+		t.Fatalf("reading VM source: %v", err)
+	}
+	if source != "ghcr.io/org/img:latest" {
+		t.Fatalf("expected the VM source to be updated, got %s", source)
+	}
+}
+
+func TestNeedsImageDownload_ChecksTaskTartHome(t *testing.T) {
+	var homes []string
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sh", "-c", `if [ "$TART_HOME" = /srv/jobs/a ]; then echo '[{"Name":"ghcr.io/org/img:latest","State":"stopped"}]'; else echo '[]'; fi`)
+	}
+	defer func() { execCommandContext = orig }()
+
+	c := NewTartClient(testLogger(t))
+	for _, home := range []string{"/srv/jobs/a", "/srv/jobs/b"} {
+		needs, err := c.NeedsImageDownload(context.Background(), VMConfig{
+			TaskConfig: TaskConfig{URL: "ghcr.io/org/img"},
+			TartHome:   home,
+		})
+		if err != nil {
+			t.Fatalf("NeedsImageDownload returned error: %v", err)
+		}
+		if needs {
+			homes = append(homes, home)
+		}
+	}
+	if !slices.Equal(homes, []string{"/srv/jobs/b"}) {
+		t.Fatalf("expected only /srv/jobs/b to need a download, got %v", homes)
+	}
+}
+
+func TestSetup_StripsDeniedEnvFromClone(t *testing.T) {
+	t.Setenv("TART_HOME", t.TempDir())
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("SENTINEL_VAR", "present")
+
+	cmds := map[string]*exec.Cmd{}
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, "true")
+		cmds[args[0]] = cmd
+		return cmd
+	}
+	defer func() { execCommandContext = orig }()
+
+	c := NewTartClient(testLogger(t))
+	vmc := VMConfig{
+		TaskConfig: TaskConfig{
+			URL:  "ghcr.io/org/img:latest",
+			Auth: Auth{Username: "user1", Password: "pass1"},
+		},
+		NomadConfig: &drivers.TaskConfig{AllocID: "alloc-1", Env: map[string]string{"AWS_ACCESS_KEY_ID": "AKIA"}},
+		EnvDenylist: []string{"AWS_*"},
+	}
+	if _, err := c.Setup(context.Background(), vmc); err != nil {
+		t.Fatalf("Setup returned error: %v", err)
+	}
+
+	cmd, ok := cmds["clone"]
+	if !ok {
+		t.Fatalf("tart clone was not run")
+	}
+	if !slices.Contains(cmd.Env, "SENTINEL_VAR=present") || !slices.Contains(cmd.Env, "TART_REGISTRY_USERNAME=user1") {
+		t.Fatalf("tart clone is missing allowed variables")
+	}
+	for _, kv := range cmd.Env {
+		if strings.HasPrefix(kv, "AWS_") {
+			t.Fatalf("tart clone got denied variable %s", kv)
+		}
+	}
+}
+
+func TestSetup_SetsTaskProxyOnClone(t *testing.T) {
+	t.Setenv("TART_HOME", t.TempDir())
+
+	cmds := map[string]*exec.Cmd{}
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, "true")
+		cmds[args[0]] = cmd
+		return cmd
+	}
+	defer func() { execCommandContext = orig }()
+
+	c := NewTartClient(testLogger(t))
+	vmc := VMConfig{
+		TaskConfig: TaskConfig{
+			URL:        "ghcr.io/org/img:latest",
+			HTTPSProxy: "http://proxy.corp:3128",
+			NoProxy:    "localhost,.corp",
+		},
+		NomadConfig: &drivers.TaskConfig{AllocID: "alloc-1"},
+	}
+	if _, err := c.Setup(context.Background(), vmc); err != nil {
+		t.Fatalf("Setup returned error: %v", err)
+	}
+
+	clone, ok := cmds["clone"]
+	if !ok {
+		t.Fatalf("tart clone was not run")
+	}
+	for _, kv := range []string{
+		"HTTPS_PROXY=http://proxy.corp:3128",
+		"https_proxy=http://proxy.corp:3128",
+		"NO_PROXY=localhost,.corp",
+		"no_proxy=localhost,.corp",
+	} {
+		if !slices.Contains(clone.Env, kv) {
+			t.Fatalf("tart clone is missing %s", kv)
+		}
+	}
+	if slices.ContainsFunc(clone.Env, func(kv string) bool { return strings.HasPrefix(kv, "HTTP_PROXY=") }) {
+		t.Fatalf("tart clone got an unset proxy variable")
+	}
+
+	// Commands that do not pull images keep the agent's environment.
+	if set, ok := cmds["set"]; !ok || slices.Contains(set.Env, "HTTPS_PROXY=http://proxy.corp:3128") {
+		t.Fatalf("tart set should not get the task's proxy")
+	}
+}
+
+func TestParseMACAddress(t *testing.T) {
+	sample := []byte(`{
+  "version": 1,
+  "os": "darwin",
+  "arch": "arm64",
+  "cpuCountMin": 4,
+  "cpuCount": 4,
+  "memorySizeMin": 8589934592,
+  "memorySize": 8589934592,
+  "macAddress": "7E:A1:2B:3C:4D:5E",
+  "display": {"width": 1024, "height": 768}
+}`)
+
+	mac, err := parseMACAddress(sample)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mac != "7e:a1:2b:3c:4d:5e" {
+		t.Fatalf("unexpected MAC address %q", mac)
+	}
+
+	for _, bad := range []string{`{"version": 1}`, `{"macAddress": "not-a-mac"}`, `not json`} {
+		if _, err := parseMACAddress([]byte(bad)); err == nil {
+			t.Fatalf("expected an error for %s", bad)
+		}
+	}
+}
+
+func TestMACAddress_ReadsVMConfig(t *testing.T) {
+	t.Setenv("TART_HOME", t.TempDir())
+	dir := vmPath("", "nomad-alloc-1")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("failed to create VM dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, vmConfigFile), []byte(`{"macAddress":"7e:a1:2b:3c:4d:5e"}`), 0o644); err != nil {
+		t.Fatalf("failed to write VM config: %v", err)
+	}
+
+	c := NewTartClient(testLogger(t))
+	mac, err := c.MACAddress(context.Background(), "nomad-alloc-1")
+	if err != nil {
+		t.Fatalf("MACAddress returned error: %v", err)
+	}
+	if mac != "7e:a1:2b:3c:4d:5e" {
+		t.Fatalf("unexpected MAC address %q", mac)
+	}
+
+	if _, err := c.MACAddress(context.Background(), "nomad-missing"); err == nil {
+		t.Fatalf("expected an error for a VM without a config")
+	}
+}
+
+func TestParseVMResources(t *testing.T) {
+	data := []byte(`{"version":1,"os":"darwin","arch":"arm64","cpuCountMin":4,"cpuCount":6,"memorySizeMin":4294967296,"memorySize":8589934592,"macAddress":"7e:a1:2b:3c:4d:5e","display":{"width":1024,"height":768}}`)
+
+	res, err := parseVMResources(data)
+	if err != nil {
+		t.Fatalf("parseVMResources returned error: %v", err)
+	}
+	if want := (VMResources{CPU: 6, MemoryMB: 8192}); res != want {
+		t.Fatalf("got %+v, want %+v", res, want)
+	}
+
+	if _, err := parseVMResources([]byte(`{"macAddress":"7e:a1:2b:3c:4d:5e"}`)); err == nil {
+		t.Fatalf("expected an error for a config without resources")
+	}
+}
+
+func TestVMResources_ReadsConfigAndDiskSize(t *testing.T) {
+	t.Setenv("TART_HOME", t.TempDir())
+	dir := vmPath("", "nomad-alloc-1")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("failed to create VM dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, vmConfigFile), []byte(`{"cpuCount":4,"memorySize":4294967296}`), 0o644); err != nil {
+		t.Fatalf("failed to write VM config: %v", err)
+	}
+	// A sparse disk takes up no space but reports the size tart set.
+	disk, err := os.Create(filepath.Join(dir, vmDiskImage))
+	if err != nil {
+		t.Fatalf("failed to create disk: %v", err)
+	}
+	if err := disk.Truncate(50 * 1000 * 1000 * 1000); err != nil {
+		t.Fatalf("failed to size disk: %v", err)
+	}
+	disk.Close()
+
+	c := NewTartClient(testLogger(t))
+	res, err := c.VMResources(context.Background(), "nomad-alloc-1")
+	if err != nil {
+		t.Fatalf("VMResources returned error: %v", err)
+	}
+	if want := (VMResources{CPU: 4, MemoryMB: 4096, DiskGB: 50}); res != want {
+		t.Fatalf("got %+v, want %+v", res, want)
+	}
+}
+
+func TestParseImageList(t *testing.T) {
+	data := []byte(`[
+  {"Name":"ghcr.io/cirruslabs/macos-sonoma-base:latest","Source":"OCI","Size":50,"SizeOnDisk":22,"Disk":50,"Accessed":"2024-05-01T10:00:00Z","Running":false,"State":"stopped"},
+  {"Name":"ghcr.io/cirruslabs/macos-sonoma-base@sha256:abc123","Source":"OCI","Size":50,"SizeOnDisk":22,"Disk":50,"Accessed":"not a date","Running":false,"State":"stopped"},
+  {"Name":"nomad-alloc-1","Source":"local","Size":50,"SizeOnDisk":3,"Disk":50,"Accessed":"2024-05-02T10:00:00Z","Running":true,"State":"running"}
+]`)
+
+	images, err := parseImageList(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(images) != 2 {
+		t.Fatalf("expected the local VM to be skipped, got %+v", images)
+	}
+
+	want := ImageInfo{
+		Name:         "ghcr.io/cirruslabs/macos-sonoma-base:latest",
+		Source:       "ghcr.io/cirruslabs/macos-sonoma-base",
+		SizeGB:       50,
+		SizeOnDiskGB: 22,
+		LastAccessed: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+	}
+	if !images[0].LastAccessed.Equal(want.LastAccessed) {
+		t.Fatalf("unexpected access time: %v", images[0].LastAccessed)
+	}
+	images[0].LastAccessed = want.LastAccessed
+	if images[0] != want {
+		t.Fatalf("got %+v, want %+v", images[0], want)
+	}
+
+	if got := images[1].Source; got != "ghcr.io/cirruslabs/macos-sonoma-base" {
+		t.Fatalf("unexpected source for digest reference: %s", got)
+	}
+	if !images[1].LastAccessed.IsZero() {
+		t.Fatalf("expected an unparseable access time to be zero, got %v", images[1].LastAccessed)
+	}
+
+	if _, err := parseImageList([]byte("not json")); err == nil {
+		t.Fatal("expected an error for invalid JSON")
+	}
+}
+
+func TestSetup_PassesExtraSetArgs(t *testing.T) {
+	t.Setenv("TART_HOME", t.TempDir())
+
+	var setArgs []string
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		if args[0] == "set" {
+			setArgs = args
+		}
+		return exec.CommandContext(ctx, "true")
+	}
+	defer func() { execCommandContext = orig }()
+
+	c := NewTartClient(testLogger(t))
+	vmc := VMConfig{
+		TaskConfig: TaskConfig{
+			URL:          "ghcr.io/org/img:latest",
+			ExtraSetArgs: []string{"--display", "1920x1080", "--random-serial"},
+		},
+		NomadConfig: &drivers.TaskConfig{AllocID: "alloc-1"},
+	}
+	if _, err := c.Setup(context.Background(), vmc); err != nil {
+		t.Fatalf("Setup returned error: %v", err)
+	}
+
+	want := []string{"set", "nomad-alloc-1", "--cpu", "4", "--memory", "4096", "--display", "1920x1080", "--random-serial"}
+	if !slices.Equal(setArgs, want) {
+		t.Fatalf("got tart %v, want tart %v", setArgs, want)
+	}
+}
+
+func TestSetup_PassesPullConcurrencyToClone(t *testing.T) {
+	cases := []struct {
+		name        string
+		concurrency int
+		want        []string
+	}{
+		{"configured", 8, []string{"clone", "--concurrency", "8", "ghcr.io/org/img:latest", "nomad-alloc-1"}},
+		{"unset", 0, []string{"clone", "ghcr.io/org/img:latest", "nomad-alloc-1"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("TART_HOME", t.TempDir())
+
+			var cloneArgs []string
+			orig := execCommandContext
+			execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+				if args[0] == "clone" {
+					cloneArgs = args
+				}
+				return exec.CommandContext(ctx, "true")
+			}
+			defer func() { execCommandContext = orig }()
+
+			c := NewTartClient(testLogger(t))
+			vmc := VMConfig{
+				TaskConfig:      TaskConfig{URL: "ghcr.io/org/img:latest"},
+				NomadConfig:     &drivers.TaskConfig{AllocID: "alloc-1"},
+				PullConcurrency: tc.concurrency,
+			}
+			if _, err := c.Setup(context.Background(), vmc); err != nil {
+				t.Fatalf("Setup returned error: %v", err)
+			}
+
+			if !slices.Equal(cloneArgs, tc.want) {
+				t.Fatalf("got tart %v, want tart %v", cloneArgs, tc.want)
+			}
+		})
+	}
+}
+
+func TestSetup_SkipResourceConfig(t *testing.T) {
+	for _, skip := range []bool{false, true} {
+		t.Setenv("TART_HOME", t.TempDir())
+
+		var setRun bool
+		orig := execCommandContext
+		execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+			if args[0] == "set" {
+				setRun = true
+			}
+			return exec.CommandContext(ctx, "true")
+		}
+
+		c := NewTartClient(testLogger(t))
+		vmc := VMConfig{
+			TaskConfig:  TaskConfig{URL: "ghcr.io/org/img:latest", DiskSize: 80, SkipResourceConfig: skip},
+			NomadConfig: &drivers.TaskConfig{AllocID: "alloc-1"},
+		}
+		_, err := c.Setup(context.Background(), vmc)
+		execCommandContext = orig
+		if err != nil {
+			t.Fatalf("skip %v: Setup returned error: %v", skip, err)
+		}
+
+		if setRun == skip {
+			t.Fatalf("skip %v: expected tart set to run: %v, ran: %v", skip, !skip, setRun)
+		}
+	}
+}
+
+func TestDelete_TimesOutHungCommand(t *testing.T) {
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sleep", "30")
+	}
+	defer func() { execCommandContext = orig }()
+
+	c := NewTartClient(testLogger(t))
+	c.commandTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	err := c.Delete(context.Background(), "nomad-alloc-1")
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected a timeout error, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("expected the hung command to be killed, took %s", elapsed)
+	}
+}
+
+func TestNeedsImageDownload_TimesOutHungList(t *testing.T) {
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sleep", "30")
+	}
+	defer func() { execCommandContext = orig }()
+
+	c := NewTartClient(testLogger(t))
+	c.configure(100*time.Millisecond, nil)
+
+	start := time.Now()
+	vmc := VMConfig{TaskConfig: TaskConfig{URL: "ghcr.io/org/img:latest"}}
+	if _, err := c.NeedsImageDownload(context.Background(), vmc); err == nil {
+		t.Fatalf("expected the hung tart list to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("expected the hung command to be killed, took %s", elapsed)
+	}
+}
+
+func TestClassifyTartError(t *testing.T) {
+	cases := map[string]error{
+		`Error: the specified VM "nomad-alloc-1" does not exist`: errVMNotFound,
+		`Error: VM "nomad-alloc-1" is not running`:               errVMNotRunning,
+		`Error: VM "nomad-alloc-1" already exists`:               errVMExists,
+		`Error: Operation not permitted`:                         nil,
+		``:                                                       nil,
+	}
+	for stderr, want := range cases {
+		if got := classifyTartError(stderr); got != want {
+			t.Fatalf("%q: expected %v, got %v", stderr, want, got)
+		}
+	}
+}
+
+func TestStopAndDelete_MissingVMIsNotFound(t *testing.T) {
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sh", "-c", `echo 'Error: the specified VM "nomad-alloc-1" does not exist' >&2; exit 1`)
+	}
+	defer func() { execCommandContext = orig }()
+
+	c := NewTartClient(testLogger(t))
+	if err := c.Stop(context.Background(), "nomad-alloc-1", time.Second); !errors.Is(err, errVMNotFound) {
+		t.Fatalf("expected Stop to fail with errVMNotFound, got: %v", err)
+	}
+	if err := c.Delete(context.Background(), "nomad-alloc-1"); !errors.Is(err, errVMNotFound) {
+		t.Fatalf("expected Delete to fail with errVMNotFound, got: %v", err)
+	}
+
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sh", "-c", `echo 'Error: disk I/O error' >&2; exit 1`)
+	}
+	err := c.Delete(context.Background(), "nomad-alloc-1")
+	if err == nil || errors.Is(err, errVMNotFound) || !strings.Contains(err.Error(), "disk I/O error") {
+		t.Fatalf("expected other failures to keep tart's message, got: %v", err)
+	}
+}
+
+func TestRename_RunsTartRenameInTheVMsStore(t *testing.T) {
+	home := t.TempDir()
+
+	var cmds []*exec.Cmd
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, "true")
+		cmd.Args = append([]string{name}, args...)
+		cmds = append(cmds, cmd)
+		return cmd
+	}
+	defer func() { execCommandContext = orig }()
+
+	c := NewTartClient(testLogger(t))
+	c.homes.set("warm-1", home)
+	if err := c.Rename(context.Background(), "warm-1", "nomad-alloc-1"); err != nil {
+		t.Fatalf("Rename returned error: %v", err)
+	}
+
+	if len(cmds) != 1 {
+		t.Fatalf("expected a single tart command, got %d", len(cmds))
+	}
+	if want := []string{"tart", "rename", "warm-1", "nomad-alloc-1"}; !slices.Equal(cmds[0].Args, want) {
+		t.Fatalf("got %v, want %v", cmds[0].Args, want)
+	}
+	if !slices.Contains(cmds[0].Env, "TART_HOME="+home) {
+		t.Fatalf("expected tart rename to run in the VM's store")
+	}
+
+	if got, ok := c.homes.get("nomad-alloc-1"); !ok || got != home {
+		t.Fatalf("expected the renamed VM to stay in %s, got %q", home, got)
+	}
+	if _, ok := c.homes.get("warm-1"); ok {
+		t.Fatalf("expected the old name to be forgotten")
+	}
+}
+
+func TestWithCommandTimeout_KeepsCallerDeadline(t *testing.T) {
+	c := NewTartClient(testLogger(t))
+	c.commandTimeout = time.Millisecond
+
+	parent, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	ctx, cancel := c.withCommandTimeout(parent)
+	defer cancel()
+
+	want, _ := parent.Deadline()
+	if got, ok := ctx.Deadline(); !ok || !got.Equal(want) {
+		t.Fatalf("expected the caller's deadline %v, got %v", want, got)
+	}
+}
+
+func TestParseVMList_RejectsUnexpectedSchema(t *testing.T) {
+	vms, err := parseVMList([]byte(`[{"Name":"nomad-alloc-1","State":"running","Source":"local"}]`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(vms) != 1 || convertTartStatus(vms[0].State) != VMStateRunning {
+		t.Fatalf("unexpected VMs: %+v", vms)
+	}
+
+	// A renamed State field must not make the VM look stopped.
+	_, err = parseVMList([]byte(`[{"Name":"nomad-alloc-1","Status":"running","Source":"local"}]`))
+	if err == nil || !strings.Contains(err.Error(), "unexpected tart list schema") {
+		t.Fatalf("expected a schema error, got: %v", err)
+	}
+
+	if vms, err := parseVMList([]byte(`[]`)); err != nil || len(vms) != 0 {
+		t.Fatalf("expected an empty list to parse, got %v, %v", vms, err)
+	}
+}
+
+func TestParseVMList_SurfacesTartErrorObject(t *testing.T) {
+	_, err := parseVMList([]byte(`{"error":"Failed to lock VM storage: resource temporarily unavailable"}` + "\n"))
+	if err == nil || err.Error() != "tart list failed: Failed to lock VM storage: resource temporarily unavailable" {
+		t.Fatalf("expected tart's error message, got: %v", err)
+	}
+
+	_, err = parseVMList([]byte(`{"code":3}`))
+	if err == nil || !strings.Contains(err.Error(), "object instead of a list") {
+		t.Fatalf("expected an error describing the object, got: %v", err)
+	}
+}
+
+func TestList_SurfacesTartErrorObjectOnFailure(t *testing.T) {
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sh", "-c", `echo '{"error":"storage is locked"}'; exit 1`)
+	}
+	defer func() { execCommandContext = orig }()
+
+	_, err := NewTartClient(testLogger(t)).List(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "storage is locked") {
+		t.Fatalf("expected tart's error message, got: %v", err)
+	}
+}
@@ -2,7 +2,9 @@ package driver
 
 import (
 	"context"
+	"encoding/json"
 	"io"
+	"os/user"
 	"time"
 
 	"github.com/hashicorp/nomad/plugins/drivers"
@@ -24,6 +26,53 @@ const (
 type VMInfo struct {
 	Name   string  `json:"name"`
 	Status VMState `json:"status"`
+	// Raw is the VM's record exactly as the virtualizer listed it.
+	Raw json.RawMessage `json:"-"`
+}
+
+// ImageInfo describes a base image cached locally by the virtualizer, as
+// opposed to a VM cloned from one.
+type ImageInfo struct {
+	// Name is the image reference, e.g. ghcr.io/org/image:tag or
+	// ghcr.io/org/image@sha256:...
+	Name string `json:"name"`
+	// Source is where the image was pulled from, the reference without any
+	// tag or digest.
+	Source string `json:"source"`
+	// SizeGB is the size of the image's disk and SizeOnDiskGB the space it
+	// actually takes up.
+	SizeGB       int `json:"size_gb"`
+	SizeOnDiskGB int `json:"size_on_disk_gb"`
+	// LastAccessed is when the image was last pulled or cloned from. tart
+	// does not record the original pull date. It is zero when unknown.
+	LastAccessed time.Time `json:"last_accessed"`
+}
+
+// VMResources is the CPU, memory and disk a VM is configured with.
+type VMResources struct {
+	CPU      int `json:"cpu"`
+	MemoryMB int `json:"memory_mb"`
+	DiskGB   int `json:"disk_gb"`
+}
+
+// vmNameFor returns the name of the VM backing an allocation. Both the driver
+// and the virtualization client derive names through it so that a VM created
+// by the client is always the one the driver stops, signals and monitors. The
+// default prefix is used when prefix is empty.
+func vmNameFor(prefix, allocID string) string {
+	if prefix == "" {
+		prefix = defaultVMNamePrefix
+	}
+	return prefix + "-" + allocID
+}
+
+// SetupResult describes a VM prepared by Setup.
+type SetupResult struct {
+	// VMName is the name of the VM that was created.
+	VMName string
+	// PullDuration is how long cloning the image took, including any
+	// download of the image.
+	PullDuration time.Duration
 }
 
 type VMConfig struct {
@@ -31,6 +80,27 @@ type VMConfig struct {
 	TaskConfig TaskConfig
 	// The configuration that is shared with Nomad
 	NomadConfig *drivers.TaskConfig
+	// VMNamePrefix is prepended to the allocation ID to name the VM. The
+	// default prefix is used when empty.
+	VMNamePrefix string
+	// ReplaceExistingVM allows Setup to delete and re-clone a VM that already
+	// exists under the target name but was cloned from a different image.
+	ReplaceExistingVM bool
+	// RunAs is the local user tart runs as. The VM is handed to this user
+	// after it is cloned. tart runs as the agent user when nil.
+	RunAs *user.User
+	// TartHome is the tart home holding the VM and its image. The agent
+	// user's tart home is used when empty.
+	TartHome string
+	// EnvDenylist lists environment variable names or globs removed from
+	// the environment tart commands run with.
+	EnvDenylist []string
+	// PullConcurrency is passed to tart clone as --concurrency when
+	// positive.
+	PullConcurrency int
+	// ConsolePath is the file tart writes the VM's serial console to. The
+	// console is not captured when empty.
+	ConsolePath string
 }
 
 type ExecOptions struct {
@@ -40,6 +110,19 @@ type ExecOptions struct {
 	Stdout   io.WriteCloser
 	Stderr   io.WriteCloser
 	ResizeCh <-chan drivers.TerminalSize
+
+	// AgentForward forwards the host's SSH agent into the session.
+	AgentForward bool
+}
+
+// GuestConn is an open connection to a VM's guest.
+type GuestConn interface {
+	// Run runs command in the guest and returns its exit code. The
+	// connection may no longer be usable when an error is returned.
+	Run(ctx context.Context, command []string, stdout, stderr io.Writer) (int, error)
+
+	// Close closes the connection.
+	Close() error
 }
 
 // VirtualizationClient defines the interface for interacting with virtual machines
@@ -50,7 +133,7 @@ type VirtualizationClient interface {
 	// Setup creates or prepares a virtual machine.
 	// For example, this might involve downloading an image if 'source' is a URL,
 	// or preparing a pre-existing image.
-	Setup(ctx context.Context, config VMConfig) (string, error)
+	Setup(ctx context.Context, config VMConfig) (SetupResult, error)
 
 	// Start starts a virtual machine.
 	// The 'headless' parameter suggests whether to run with a GUI.
@@ -61,12 +144,26 @@ type VirtualizationClient interface {
 	// 'timeout' specifies how long to wait for a graceful shutdown.
 	Stop(ctx context.Context, vmName string, timeout time.Duration) error
 
+	// Pause freezes a running virtual machine without shutting it down.
+	Pause(ctx context.Context, vmName string) error
+
+	// Resume continues a virtual machine previously frozen with Pause.
+	Resume(ctx context.Context, vmName string) error
+
 	// Status returns the current state of a specific VM.
 	Status(ctx context.Context, vmName string) (VMState, error)
 
 	// Delete deletes a virtual machine.
 	Delete(ctx context.Context, vmName string) error
 
+	// Rename gives the stopped VM oldName the name newName.
+	Rename(ctx context.Context, oldName, newName string) error
+
+	// Pull downloads image into the cache of tartHome, or the agent's tart
+	// home when empty, without creating a VM. A positive concurrency sets how
+	// many layers are downloaded in parallel.
+	Pull(ctx context.Context, image, tartHome string, concurrency int) error
+
 	// List returns a list of all virtual machines.
 	List(ctx context.Context) ([]VMInfo, error)
 
@@ -75,6 +172,14 @@ type VirtualizationClient interface {
 	// Returns the command output or an error.
 	Exec(ctx context.Context, config VMConfig, opts ExecOptions) (int, error)
 
+	// DialGuest opens a connection to the VM's guest that runs commands
+	// until it is closed, for callers running commands repeatedly.
+	DialGuest(ctx context.Context, config VMConfig) (GuestConn, error)
+
+	// WaitForSSH blocks until the VM accepts SSH connections or the context
+	// is cancelled.
+	WaitForSSH(ctx context.Context, config VMConfig) error
+
 	// BuildStartArgs returns the CLI args needed to start the VM for the
 	// provided config (e.g., networking, disk, mounts). The returned slice
 	// should be suitable for passing to `tart` (or the underlying tool).
@@ -85,4 +190,15 @@ type VirtualizationClient interface {
 	// must be pulled/downloaded before Setup, allowing the caller to emit
 	// progress events appropriately.
 	NeedsImageDownload(ctx context.Context, config VMConfig) (bool, error)
+
+	// MACAddress returns the MAC address assigned to the VM's network
+	// interface.
+	MACAddress(ctx context.Context, vmName string) (string, error)
+
+	// VMResources returns the CPU, memory and disk the VM is configured
+	// with.
+	VMResources(ctx context.Context, vmName string) (VMResources, error)
+
+	// ListImages returns the base images cached locally, excluding VMs.
+	ListImages(ctx context.Context) ([]ImageInfo, error)
 }
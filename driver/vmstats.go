@@ -0,0 +1,279 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/nomad/client/lib/cpustats"
+	"github.com/hashicorp/nomad/plugins/drivers"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// vmDiskImage is the file inside a tart VM directory that is held open by the
+// Virtualization.framework process running the guest.
+const vmDiskImage = "disk.img"
+
+var (
+	// vmCPUMeasured and vmMemoryMeasured list the fields populated from host
+	// process samples.
+	vmCPUMeasured    = []string{"System Mode", "User Mode", "Percent"}
+	vmMemoryMeasured = []string{"RSS"}
+)
+
+// processSample is a point-in-time reading of a host process's cumulative CPU
+// time (in seconds) and resident memory.
+type processSample struct {
+	UserSeconds   float64
+	SystemSeconds float64
+	RSS           uint64
+}
+
+// sampleProcess is a package-level indirection to allow tests to inject
+// process readings. In production it reads from gopsutil.
+var sampleProcess = func(pid int) (*processSample, error) {
+	p, err := process.NewProcess(int32(pid))
+	if err != nil {
+		return nil, err
+	}
+
+	times, err := p.Times()
+	if err != nil {
+		return nil, err
+	}
+
+	mem, err := p.MemoryInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	return &processSample{
+		UserSeconds:   times.User,
+		SystemSeconds: times.System,
+		RSS:           mem.RSS,
+	}, nil
+}
+
+// tartHome returns the directory tart stores its VMs and images in, honoring
+// the TART_HOME environment variable.
+func tartHome() string {
+	if home := os.Getenv("TART_HOME"); home != "" {
+		return home
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".tart"
+	}
+	return filepath.Join(home, ".tart")
+}
+
+// vmPath returns the on-disk directory of the named local VM in the given
+// tart home, or the agent user's own when home is empty.
+func vmPath(home, vmName string) string {
+	if home == "" {
+		home = tartHome()
+	}
+	return filepath.Join(home, "vms", vmName)
+}
+
+// relatedPIDs returns the tart PID along with the PIDs of any other host
+// processes holding the disk image of the VM in vmDir open. The guest itself
+// runs inside a Virtualization.framework XPC service that is not a child of
+// tart, so it is invisible to the executor's process tree.
+func relatedPIDs(ctx context.Context, tartPID int, vmDir string) []int {
+	pids := []int{tartPID}
+	for _, pid := range vmProcessPIDs(ctx, vmDir) {
+		if pid != tartPID {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// vmProcessPIDs returns the PIDs of the host processes holding the disk image
+// of the VM in vmDir open.
+func vmProcessPIDs(ctx context.Context, vmDir string) []int {
+	pids, _ := findVMProcessPIDs(ctx, vmDir)
+	return pids
+}
+
+// errProcessInfoDenied indicates that the agent may not read other host
+// processes' open files or usage, which macOS only allows root and the
+// processes' own user.
+var errProcessInfoDenied = errors.New("not permitted to read host process information")
+
+// findVMProcessPIDs returns the PIDs of the host processes holding the disk
+// image of the VM in vmDir open, and errProcessInfoDenied when lsof was
+// refused access to some processes, as the list may then be missing the VM's.
+func findVMProcessPIDs(ctx context.Context, vmDir string) ([]int, error) {
+	// lsof exits non-zero when no process has the file open, so errors simply
+	// mean there is nothing to report unless it says it was refused.
+	cmd := execCommandContext(ctx, "lsof", "-t", filepath.Join(vmDir, vmDiskImage))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if permissionDenied(stderr.String()) {
+			return parsePIDs(out), fmt.Errorf("lsof: %w: %s", errProcessInfoDenied, strings.TrimSpace(stderr.String()))
+		}
+		return nil, nil
+	}
+	return parsePIDs(out), nil
+}
+
+// permissionDenied reports whether a command's stderr says it was refused
+// access.
+func permissionDenied(stderr string) bool {
+	lower := strings.ToLower(stderr)
+	return strings.Contains(lower, "permission denied") || strings.Contains(lower, "operation not permitted")
+}
+
+// parsePIDs returns the PIDs listed one per line, as printed by lsof -t and
+// pgrep.
+func parsePIDs(out []byte) []int {
+	var pids []int
+	for _, field := range strings.Fields(string(out)) {
+		pid, err := strconv.Atoi(field)
+		if err != nil {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	return pids
+}
+
+// virtualizationProcessName is the name of the Virtualization.framework XPC
+// service each running VM's guest lives in.
+const virtualizationProcessName = "com.apple.Virtualization.VirtualMachine"
+
+// virtualizationPIDsByName returns the PIDs of every Virtualization.framework
+// VM process on the host, found by name, which needs no special privileges.
+// The processes do not say which VM they run.
+func virtualizationPIDsByName(ctx context.Context) []int {
+	// pgrep exits non-zero when nothing matches.
+	out, err := execCommandContext(ctx, "pgrep", "-x", virtualizationProcessName).Output()
+	if err != nil {
+		return nil
+	}
+	return parsePIDs(out)
+}
+
+// cpuPercent returns the CPU usage between two cumulative CPU time readings
+// (in seconds) taken wall apart. It follows the convention of Nomad's cpustats
+// Tracker and top: 100 is one fully busy core, so a process using several
+// cores reports more than 100.
+func cpuPercent(prev, cur float64, wall time.Duration) float64 {
+	if wall <= 0 || cur <= prev {
+		return 0
+	}
+	return (cur - prev) / wall.Seconds() * 100
+}
+
+// ticksConsumed converts a CPU percentage, where 100 is one busy core, into
+// the MHz Nomad reports as TotalTicks, as the executor's cpustats Trackers do.
+// It is zero when the host's compute is unknown.
+func ticksConsumed(percent float64, compute cpustats.Compute) float64 {
+	if compute.NumCores <= 0 {
+		return 0
+	}
+	return (percent / 100) * float64(compute.TotalCompute) / float64(compute.NumCores)
+}
+
+// cpuReading is a cumulative CPU time reading and the time it was taken.
+type cpuReading struct {
+	user   float64
+	system float64
+	at     time.Time
+}
+
+// vmStatsTracker converts cumulative per-process CPU times into usage
+// percentages, and the ticks they amount to on the host, across successive
+// samples.
+type vmStatsTracker struct {
+	lock    sync.Mutex
+	now     func() time.Time
+	compute cpustats.Compute
+	prev    map[int]cpuReading
+
+	// denied is the last error from a process the agent may not sample
+	denied error
+}
+
+// newVMStatsTracker returns a tracker with no previous readings for a host
+// with the given compute.
+func newVMStatsTracker(compute cpustats.Compute) *vmStatsTracker {
+	return &vmStatsTracker{
+		now:     time.Now,
+		compute: compute,
+		prev:    map[int]cpuReading{},
+	}
+}
+
+// usage samples each PID and returns the aggregated resource usage along with
+// the usage of each individual PID. PIDs that cannot be sampled are skipped.
+// The first sample of a PID reports 0% CPU as there is no previous reading.
+func (t *vmStatsTracker) usage(pids []int) (*drivers.ResourceUsage, map[string]*drivers.ResourceUsage) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	total := &drivers.ResourceUsage{
+		CpuStats:    &drivers.CpuStats{Measured: vmCPUMeasured},
+		MemoryStats: &drivers.MemoryStats{Measured: vmMemoryMeasured},
+	}
+	perPID := map[string]*drivers.ResourceUsage{}
+
+	now := t.now()
+	seen := map[int]struct{}{}
+	for _, pid := range pids {
+		sample, err := sampleProcess(pid)
+		if err != nil {
+			if errors.Is(err, os.ErrPermission) {
+				t.denied = fmt.Errorf("sampling pid %d: %w: %v", pid, errProcessInfoDenied, err)
+			}
+			continue
+		}
+		seen[pid] = struct{}{}
+
+		cpu := &drivers.CpuStats{Measured: vmCPUMeasured}
+		if prev, ok := t.prev[pid]; ok {
+			wall := now.Sub(prev.at)
+			cpu.UserMode = cpuPercent(prev.user, sample.UserSeconds, wall)
+			cpu.SystemMode = cpuPercent(prev.system, sample.SystemSeconds, wall)
+			cpu.Percent = cpu.UserMode + cpu.SystemMode
+			cpu.TotalTicks = ticksConsumed(cpu.Percent, t.compute)
+		}
+		t.prev[pid] = cpuReading{user: sample.UserSeconds, system: sample.SystemSeconds, at: now}
+
+		ru := &drivers.ResourceUsage{
+			CpuStats:    cpu,
+			MemoryStats: &drivers.MemoryStats{RSS: sample.RSS, Measured: vmMemoryMeasured},
+		}
+		perPID[strconv.Itoa(pid)] = ru
+		total.Add(ru)
+	}
+
+	// Forget processes that have gone away so a recycled PID starts fresh.
+	for pid := range t.prev {
+		if _, ok := seen[pid]; !ok {
+			delete(t.prev, pid)
+		}
+	}
+
+	return total, perPID
+}
+
+// deniedErr returns the error of the last process the tracker was refused
+// access to, if any.
+func (t *vmStatsTracker) deniedErr() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.denied
+}
@@ -0,0 +1,155 @@
+package driver
+
+import (
+	"context"
+	"math"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/client/lib/cpustats"
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+func TestCPUPercent_PerCoreScaling(t *testing.T) {
+	cases := []struct {
+		name      string
+		prev, cur float64
+		wall      time.Duration
+		want      float64
+	}{
+		{"one busy core", 10, 11, time.Second, 100},
+		{"half a core", 10, 11, 2 * time.Second, 50},
+		{"four busy cores", 0, 20, 5 * time.Second, 400},
+		{"no wall time", 0, 1, 0, 0},
+		{"counter reset", 5, 1, time.Second, 0},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := cpuPercent(tc.prev, tc.cur, tc.wall); math.Abs(got-tc.want) > 1e-9 {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestVMStatsTracker_Usage(t *testing.T) {
+	samples := map[int]*processSample{
+		100: {UserSeconds: 10, SystemSeconds: 2, RSS: 1024},
+		200: {UserSeconds: 50, SystemSeconds: 5, RSS: 4096},
+	}
+	orig := sampleProcess
+	sampleProcess = func(pid int) (*processSample, error) {
+		s := *samples[pid]
+		return &s, nil
+	}
+	defer func() { sampleProcess = orig }()
+
+	now := time.Unix(1000, 0)
+	tracker := newVMStatsTracker(cpustats.Compute{TotalCompute: 8000, NumCores: 4})
+	tracker.now = func() time.Time { return now }
+
+	// The first sample only establishes a baseline.
+	total, _ := tracker.usage([]int{100, 200})
+	if total.CpuStats.Percent != 0 {
+		t.Fatalf("expected 0%% on first sample, got %v", total.CpuStats.Percent)
+	}
+	if total.MemoryStats.RSS != 5120 {
+		t.Fatalf("unexpected RSS: %d", total.MemoryStats.RSS)
+	}
+
+	// Over two seconds pid 100 uses 2s user + 1s system (150%) and pid 200
+	// uses 6s user (300%), as top would report on a multi-core host.
+	now = now.Add(2 * time.Second)
+	samples[100] = &processSample{UserSeconds: 12, SystemSeconds: 3, RSS: 1024}
+	samples[200] = &processSample{UserSeconds: 56, SystemSeconds: 5, RSS: 4096}
+
+	total, perPID := tracker.usage([]int{100, 200})
+	if got := perPID["100"].CpuStats.Percent; math.Abs(got-150) > 1e-9 {
+		t.Fatalf("unexpected pid 100 percent: %v", got)
+	}
+	if got := perPID["100"].CpuStats.SystemMode; math.Abs(got-50) > 1e-9 {
+		t.Fatalf("unexpected pid 100 system percent: %v", got)
+	}
+	if got := perPID["200"].CpuStats.UserMode; math.Abs(got-300) > 1e-9 {
+		t.Fatalf("unexpected pid 200 user percent: %v", got)
+	}
+	if got := total.CpuStats.Percent; math.Abs(got-450) > 1e-9 {
+		t.Fatalf("unexpected total percent: %v", got)
+	}
+	// 4.5 busy cores of a 4 core, 8000 MHz host.
+	if got := total.CpuStats.TotalTicks; math.Abs(got-9000) > 1e-9 {
+		t.Fatalf("unexpected total ticks: %v", got)
+	}
+}
+
+func TestVMStatsTracker_CPUTimeUnits(t *testing.T) {
+	readings := []*processSample{
+		{UserSeconds: 10, SystemSeconds: 4},
+		{UserSeconds: 11, SystemSeconds: 4.5},
+	}
+	orig := sampleProcess
+	sampleProcess = func(pid int) (*processSample, error) {
+		s := *readings[0]
+		return &s, nil
+	}
+	defer func() { sampleProcess = orig }()
+
+	now := time.Unix(1000, 0)
+	tracker := newVMStatsTracker(cpustats.Compute{TotalCompute: 8000, NumCores: 4})
+	tracker.now = func() time.Time { return now }
+	tracker.usage([]int{100})
+
+	// One second of user time and half a second of system time over two
+	// seconds are 50% and 25%, not nanosecond-scaled CPU times.
+	readings = readings[1:]
+	now = now.Add(2 * time.Second)
+	total, _ := tracker.usage([]int{100})
+
+	cpu := total.CpuStats
+	if math.Abs(cpu.UserMode-50) > 1e-9 || math.Abs(cpu.SystemMode-25) > 1e-9 {
+		t.Fatalf("unexpected user/system percent: %v/%v", cpu.UserMode, cpu.SystemMode)
+	}
+	if math.Abs(cpu.TotalTicks-1500) > 1e-9 {
+		t.Fatalf("unexpected total ticks: %v", cpu.TotalTicks)
+	}
+}
+
+func TestVMPath_HonorsTartHome(t *testing.T) {
+	t.Setenv("TART_HOME", "/var/tart")
+	if got, want := vmPath("", "nomad-abc"), filepath.Join("/var/tart", "vms", "nomad-abc"); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	if got, want := vmPath("/srv/jobs/a", "nomad-abc"), filepath.Join("/srv/jobs/a", "vms", "nomad-abc"); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestVMProcesses_UsesTasksTartHome(t *testing.T) {
+	t.Setenv("TART_HOME", "/var/tart")
+
+	var lsofPath string
+	orig := execCommandContext
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		lsofPath = args[len(args)-1]
+		return exec.CommandContext(ctx, "echo", "4243")
+	}
+	defer func() { execCommandContext = orig }()
+
+	d := newTestDriver(t, &fakeClient{})
+	h := &taskHandle{
+		taskConfig: &drivers.TaskConfig{ID: "task-1", AllocID: "isolated"},
+		state:      drivers.TaskStateRunning,
+		pid:        4242,
+		tartHome:   "/srv/jobs/a",
+	}
+	pids := d.vmProcesses(h, "nomad-isolated")
+	if want := filepath.Join("/srv/jobs/a", "vms", "nomad-isolated", vmDiskImage); lsofPath != want {
+		t.Fatalf("expected lsof on %s, got %s", want, lsofPath)
+	}
+	if len(pids) != 2 || pids[1] != 4243 {
+		t.Fatalf("unexpected pids: %v", pids)
+	}
+}
@@ -3,29 +3,135 @@ package driver
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/hashicorp/nomad/plugins/drivers"
 )
 
+const (
+	// defaultWaitPollInterval is how often a running VM's status is polled
+	// when wait_poll_interval is not configured.
+	defaultWaitPollInterval = 5 * time.Second
+
+	// minWaitPollInterval is the smallest accepted wait_poll_interval, to
+	// avoid spawning tart processes in a tight loop.
+	minWaitPollInterval = 1 * time.Second
+
+	// waitRecheckDivisor derives the delay before re-checking a VM that was
+	// observed not running from the poll interval.
+	waitRecheckDivisor = 5
+
+	// defaultWaitFailureThreshold is how many consecutive failed status
+	// checks conclude that a VM is gone when wait_failure_threshold is unset.
+	defaultWaitFailureThreshold = 3
+)
+
+// handleWait waits for the task's run loop to observe the executor exiting and
+// forwards the recorded exit result to ch.
 func (d *Driver) handleWait(ctx context.Context, handle *taskHandle, ch chan *drivers.ExitResult) {
 	defer close(ch)
 
-	var result *drivers.ExitResult
-	ps, err := handle.exec.Wait(ctx)
-	if err != nil {
-		result = &drivers.ExitResult{
-			Err: fmt.Errorf("executor: error waiting on process: %v", err),
-		}
-	} else {
-		result = &drivers.ExitResult{
-			ExitCode: ps.ExitCode,
-			Signal:   ps.Signal,
-		}
+	select {
+	case <-ctx.Done():
+		return
+	case <-d.ctx.Done():
+		return
+	case <-handle.doneCh:
 	}
 
 	select {
 	case <-ctx.Done():
 	case <-d.ctx.Done():
-	case ch <- result:
+	case ch <- handle.ExitResult():
+	}
+}
+
+// pollIntervals returns how often the VM monitor polls a VM's status and how
+// long it waits before re-checking a VM that was observed not running. The
+// re-check delay scales with the poll interval.
+func (d *Driver) pollIntervals() (time.Duration, time.Duration) {
+	interval := d.waitPollInterval
+	if interval <= 0 {
+		interval = defaultWaitPollInterval
+	}
+	return interval, interval / waitRecheckDivisor
+}
+
+// monitorVM polls the VM's status while the task runs so that a VM which
+// powers off or disappears without the tart process exiting is still noticed.
+// A single failed check is not conclusive, as `tart list` can fail transiently
+// on a busy host, so the VM is re-checked until the failure threshold of
+// consecutive failures is reached. When the VM is confirmed gone the executor
+// is shut down so the task's run loop completes with the monitor's result.
+func (d *Driver) monitorVM(ctx context.Context, h *taskHandle, vmName string) {
+	interval, recheck := d.pollIntervals()
+	threshold := d.waitFailureThreshold
+	if threshold <= 0 {
+		threshold = defaultWaitFailureThreshold
+	}
+
+	ticker := d.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.doneCh:
+			return
+		case <-ticker.C():
+		}
+
+		state, err := d.vmStatus(ctx, vmName)
+		if err == nil {
+			failures = 0
+			ticker.Reset(interval)
+			continue
+		}
+
+		failures++
+		if failures < threshold {
+			d.logger.Debug("VM status check failed, re-checking", "vm", vmName, "failures", failures, "error", err)
+			ticker.Reset(recheck)
+			continue
+		}
+
+		result := &drivers.ExitResult{}
+		if state != VMStateStopped {
+			result.ExitCode = 1
+			result.Err = err
+		}
+
+		d.logger.Warn("VM is no longer running, stopping task", "vm", vmName, "error", err)
+		d.endExitedVM(h, vmName, result)
+		return
+	}
+}
+
+// endExitedVM records result as the outcome of a task whose VM has stopped or
+// disappeared and shuts down the executor so the task's run loop completes.
+func (d *Driver) endExitedVM(h *taskHandle, vmName string, result *drivers.ExitResult) {
+	h.markVMExited(result)
+	exec, _, _ := h.process()
+	if err := exec.Shutdown("", 0); err != nil {
+		d.logger.Error("failed to shut down executor for exited VM", "vm", vmName, "error", err)
+	}
+}
+
+// vmStatus returns the VM's state, with a non-nil error when the VM has
+// stopped or its status could not be determined. A paused VM is still alive
+// and is not treated as an error.
+func (d *Driver) vmStatus(ctx context.Context, vmName string) (VMState, error) {
+	state, err := d.client.Status(ctx, vmName)
+	if err != nil {
+		return "", fmt.Errorf("failed to get VM status: %v", err)
+	}
+
+	switch state {
+	case VMStateRunning, VMStatePaused:
+		return state, nil
+	default:
+		return state, fmt.Errorf("VM %s is %s", vmName, state)
 	}
 }
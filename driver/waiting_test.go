@@ -0,0 +1,226 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/drivers/shared/executor"
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+func TestWaitTask_ReturnsHandleExitResult(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{})
+
+	exec := newFakeExecutor()
+	h := &taskHandle{
+		exec:       exec,
+		taskConfig: &drivers.TaskConfig{ID: "task-1"},
+		state:      drivers.TaskStateRunning,
+		doneCh:     make(chan struct{}),
+	}
+	d.tasks.Set("task-1", h)
+	go h.run()
+
+	ch, err := d.WaitTask(context.Background(), "task-1")
+	if err != nil {
+		t.Fatalf("WaitTask returned error: %v", err)
+	}
+
+	exec.exitCh <- &executor.ProcessState{ExitCode: 7, Time: time.Now()}
+
+	select {
+	case res := <-ch:
+		if res == nil || res.ExitCode != 7 {
+			t.Fatalf("unexpected exit result: %#v", res)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for exit result")
+	}
+}
+
+func TestWaitTask_UnknownTask(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{})
+	if _, err := d.WaitTask(context.Background(), "missing"); err != drivers.ErrTaskNotFound {
+		t.Fatalf("expected ErrTaskNotFound, got %v", err)
+	}
+}
+
+func TestSetConfig_WaitPollInterval(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{})
+
+	if err := d.SetConfig(pluginConfig(t, &Config{Enabled: true, WaitPollInterval: "2s"})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+	interval, recheck := d.pollIntervals()
+	if interval != 2*time.Second {
+		t.Fatalf("unexpected poll interval: %v", interval)
+	}
+	if recheck != 400*time.Millisecond {
+		t.Fatalf("unexpected recheck interval: %v", recheck)
+	}
+
+	if err := d.SetConfig(pluginConfig(t, &Config{Enabled: true, WaitPollInterval: "500ms"})); err == nil {
+		t.Fatalf("expected an error for a poll interval below the minimum")
+	}
+	if err := d.SetConfig(pluginConfig(t, &Config{Enabled: true, WaitPollInterval: "soon"})); err == nil {
+		t.Fatalf("expected an error for an unparseable poll interval")
+	}
+}
+
+func TestPollIntervals_Default(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{})
+	interval, recheck := d.pollIntervals()
+	if interval != defaultWaitPollInterval || recheck != time.Second {
+		t.Fatalf("unexpected default intervals: %v, %v", interval, recheck)
+	}
+}
+
+func TestMonitorVM_StoppedVMEndsTask(t *testing.T) {
+	client := &fakeClient{
+		statusFn: func(ctx context.Context, vmName string) (VMState, error) {
+			return VMStateStopped, nil
+		},
+	}
+	d := newTestDriver(t, client)
+	d.waitPollInterval = 10 * time.Millisecond
+
+	exec := newFakeExecutor()
+	h := &taskHandle{
+		exec:       exec,
+		taskConfig: &drivers.TaskConfig{ID: "task-1", AllocID: "alloc-1"},
+		state:      drivers.TaskStateRunning,
+		doneCh:     make(chan struct{}),
+	}
+	go h.run()
+	go d.monitorVM(context.Background(), h, "nomad-alloc-1")
+
+	// The fake executor exits once it has been shut down by the monitor.
+	if !waitForShutdown(exec, 5*time.Second) {
+		t.Fatalf("timed out waiting for the monitor to shut down the executor")
+	}
+	exec.exitCh <- &executor.ProcessState{ExitCode: 137, Time: time.Now()}
+	<-h.doneCh
+
+	if res := h.ExitResult(); res == nil || res.ExitCode != 0 || res.Err != nil {
+		t.Fatalf("expected a clean exit for a powered-off VM, got %#v", res)
+	}
+}
+
+func TestMonitorVM_DebouncesTransientErrors(t *testing.T) {
+	var lock sync.Mutex
+	calls := 0
+	client := &fakeClient{
+		statusFn: func(ctx context.Context, vmName string) (VMState, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			calls++
+			if calls == 1 {
+				return "", errors.New("tart list failed")
+			}
+			return VMStateRunning, nil
+		},
+	}
+	d := newTestDriver(t, client)
+	d.waitPollInterval = 5 * time.Millisecond
+
+	exec := newFakeExecutor()
+	h := &taskHandle{exec: exec, taskConfig: &drivers.TaskConfig{ID: "task-1"}, doneCh: make(chan struct{})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.monitorVM(ctx, h, "nomad-alloc-1")
+
+	if waitForShutdown(exec, 200*time.Millisecond) {
+		t.Fatalf("a single transient error should not end the task")
+	}
+}
+
+func TestMonitorVM_ConsecutiveErrorsEndTask(t *testing.T) {
+	client := &fakeClient{
+		statusFn: func(ctx context.Context, vmName string) (VMState, error) {
+			return "", errors.New("tart list failed")
+		},
+	}
+	d := newTestDriver(t, client)
+	d.waitPollInterval = 5 * time.Millisecond
+	d.waitFailureThreshold = 3
+
+	exec := newFakeExecutor()
+	h := &taskHandle{exec: exec, taskConfig: &drivers.TaskConfig{ID: "task-1"}, doneCh: make(chan struct{})}
+	go h.run()
+	go d.monitorVM(context.Background(), h, "nomad-alloc-1")
+
+	if !waitForShutdown(exec, 5*time.Second) {
+		t.Fatalf("expected consecutive errors to end the task")
+	}
+	exec.exitCh <- &executor.ProcessState{ExitCode: 137, Time: time.Now()}
+	<-h.doneCh
+
+	if res := h.ExitResult(); res == nil || res.ExitCode != 1 || res.Err == nil {
+		t.Fatalf("expected an error exit result, got %#v", res)
+	}
+}
+
+func TestSetConfig_WaitFailureThreshold(t *testing.T) {
+	d := newTestDriver(t, &fakeClient{})
+
+	if err := d.SetConfig(pluginConfig(t, &Config{Enabled: true})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+	if d.waitFailureThreshold != defaultWaitFailureThreshold {
+		t.Fatalf("unexpected default threshold: %d", d.waitFailureThreshold)
+	}
+
+	if err := d.SetConfig(pluginConfig(t, &Config{Enabled: true, WaitFailureThreshold: 5})); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+	if d.waitFailureThreshold != 5 {
+		t.Fatalf("unexpected threshold: %d", d.waitFailureThreshold)
+	}
+
+	if err := d.SetConfig(pluginConfig(t, &Config{Enabled: true, WaitFailureThreshold: -1})); err == nil {
+		t.Fatalf("expected an error for a negative threshold")
+	}
+}
+
+// waitForShutdown reports whether the executor was shut down within timeout.
+func waitForShutdown(exec *fakeExecutor, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for {
+		exec.lock.Lock()
+		n := len(exec.shutdowns)
+		exec.lock.Unlock()
+		if n > 0 {
+			return true
+		}
+		select {
+		case <-deadline:
+			return false
+		case <-time.After(2 * time.Millisecond):
+		}
+	}
+}
+
+func TestMonitorVM_PausedVMKeepsTaskRunning(t *testing.T) {
+	client := &fakeClient{
+		statusFn: func(ctx context.Context, vmName string) (VMState, error) {
+			return VMStatePaused, nil
+		},
+	}
+	d := newTestDriver(t, client)
+	d.waitPollInterval = 5 * time.Millisecond
+
+	exec := newFakeExecutor()
+	h := &taskHandle{exec: exec, taskConfig: &drivers.TaskConfig{ID: "task-1"}, doneCh: make(chan struct{})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.monitorVM(ctx, h, "nomad-alloc-1")
+
+	if waitForShutdown(exec, 200*time.Millisecond) {
+		t.Fatalf("a paused VM should not end the task")
+	}
+}
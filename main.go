@@ -1,13 +1,18 @@
 package main
 
 import (
+	"time"
+
 	"github.com/hashicorp/go-hclog"
+	metrics "github.com/hashicorp/go-metrics/compat"
 	"github.com/hashicorp/nomad/plugins"
 
 	"github.com/brianmichel/nomad-driver-tart/driver"
 )
 
 func main() {
+	setupMetrics()
+
 	// Serve the plugin
 	plugins.Serve(factory)
 }
@@ -16,3 +21,17 @@ func main() {
 func factory(logger hclog.Logger) interface{} {
 	return driver.NewTartDriver(logger)
 }
+
+// setupMetrics keeps the driver's metrics in memory. The plugin runs in its
+// own process, out of reach of the agent's telemetry sinks, so the metrics are
+// written to the plugin's stderr, which ends up in the agent's log, on
+// SIGUSR1.
+func setupMetrics() {
+	inm := metrics.NewInmemSink(10*time.Second, time.Minute)
+	metrics.DefaultInmemSignal(inm)
+
+	cfg := metrics.DefaultConfig("")
+	cfg.EnableHostname = false
+	cfg.EnableRuntimeMetrics = false
+	metrics.NewGlobal(cfg, inm)
+}